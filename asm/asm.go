@@ -0,0 +1,445 @@
+// Package asm is a small two-pass 6502 assembler, meant for test programs
+// and patches rather than whole games. It shares the opcode table with the
+// CPU, so anything it assembles the CPU can decode.
+//
+// Statements are separated by newlines or semicolons and comments start with
+// "//". Supported are labels ("loop:"), constants ("ptr = $10"), the
+// directives .org, .byte and .word, and every official addressing mode:
+//
+//	.org $8000
+//	reset:  LDX #$00
+//	loop:   LDA message,X ; BEQ done ; STA (ptr),Y ; INX ; BNE loop
+//	done:   JMP (vector)
+//	message: .byte "HI", 0
+//
+// Expressions take $hex, %binary, decimal and 'c' numbers, symbols, * for
+// the current address, the unary operators - ~ < (low byte) > (high byte),
+// the binary operators * / % + - << >> & ^ | and parentheses.
+package asm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// Program is the output of the assembler
+type Program struct {
+	Origin  uint16 // address of Code[0], the first .org or $8000
+	Code    []uint8
+	Symbols map[string]uint16
+}
+
+// Error is an assembly error at a line of the source
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+const DEFAULT_ORIGIN uint16 = 0x8000
+
+type statement struct {
+	line int
+	text string
+}
+
+type assembler struct {
+	pass    int
+	symbols map[string]uint16
+
+	pc      int
+	origin  int
+	started bool // true once the origin is fixed by .org or the first byte
+	code    []uint8
+
+	// addressing mode chosen for each instruction in pass 1, so that a
+	// forward reference can't change an instruction's size in pass 2
+	modes []hardware.AddressingMode
+	instr int
+}
+
+// Assemble assembles source into a program
+func Assemble(source string) (*Program, error) {
+	statements := split(source)
+	a := &assembler{symbols: map[string]uint16{}}
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.pc = int(DEFAULT_ORIGIN)
+		a.origin = int(DEFAULT_ORIGIN)
+		a.started = false
+		a.code = nil
+		a.instr = 0
+		for _, s := range statements {
+			if err := a.statement(s.text); err != nil {
+				return nil, &Error{Line: s.line, Msg: err.Error()}
+			}
+		}
+	}
+	return &Program{Origin: uint16(a.origin), Code: a.code, Symbols: a.symbols}, nil
+}
+
+// MustAssemble is Assemble for sources known to be correct, such as those in
+// tests. It panics on errors.
+func MustAssemble(source string) *Program {
+	p, err := Assemble(source)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// split breaks source into statements, dropping comments
+func split(source string) []statement {
+	var statements []statement
+	for i, line := range strings.Split(source, "\n") {
+		quoted := byte(0)
+		start := 0
+		for j := 0; j <= len(line); j++ {
+			if j < len(line) {
+				ch := line[j]
+				if quoted != 0 {
+					if ch == quoted {
+						quoted = 0
+					}
+					continue
+				}
+				if ch == '"' || ch == '\'' {
+					quoted = ch
+					continue
+				}
+				if ch == '/' && j+1 < len(line) && line[j+1] == '/' {
+					line = line[:j]
+				} else if ch != ';' {
+					continue
+				}
+			}
+			if text := strings.TrimSpace(line[start:j]); text != "" {
+				statements = append(statements, statement{line: i + 1, text: text})
+			}
+			start = j + 1
+		}
+	}
+	return statements
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || ch == '@' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+func isIdentChar(ch byte) bool {
+	return isIdentStart(ch) || ch >= '0' && ch <= '9'
+}
+
+func identLength(text string) int {
+	n := 0
+	if len(text) > 0 && isIdentStart(text[0]) {
+		for n < len(text) && isIdentChar(text[n]) {
+			n++
+		}
+	}
+	return n
+}
+
+func (a *assembler) statement(text string) error {
+	// labels, possibly followed by an instruction on the same statement
+	for {
+		n := identLength(text)
+		if n == 0 || n >= len(text) || text[n] != ':' {
+			break
+		}
+		if err := a.define(text[:n], a.pc); err != nil {
+			return err
+		}
+		text = strings.TrimSpace(text[n+1:])
+		if text == "" {
+			return nil
+		}
+	}
+
+	// constants
+	if n := identLength(text); n > 0 {
+		if rest := strings.TrimSpace(text[n:]); strings.HasPrefix(rest, "=") {
+			value, known, err := a.eval(rest[1:])
+			if err != nil || !known {
+				// a constant using a later label gets its value in pass 2
+				return err
+			}
+			return a.define(text[:n], value)
+		}
+	}
+
+	word, operand := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		word, operand = text[:i], strings.TrimSpace(text[i:])
+	}
+	if strings.HasPrefix(word, ".") {
+		return a.directive(strings.ToLower(word), operand)
+	}
+	return a.instruction(strings.ToUpper(word), operand)
+}
+
+func (a *assembler) define(name string, value int) error {
+	if value < -0x8000 || value > 0xFFFF {
+		return fmt.Errorf("%s = %d is out of range", name, value)
+	}
+	if old, ok := a.symbols[name]; ok {
+		if a.pass == 1 {
+			return fmt.Errorf("%s is already defined", name)
+		}
+		if old != uint16(value) {
+			return fmt.Errorf("%s moved from $%04X to $%04X between passes", name, old, uint16(value))
+		}
+	}
+	a.symbols[name] = uint16(value)
+	return nil
+}
+
+func (a *assembler) directive(name, operand string) error {
+	switch name {
+	case ".org":
+		value, known, err := a.eval(operand)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return fmt.Errorf(".org needs a value known in the first pass")
+		}
+		if value < 0 || value > 0xFFFF {
+			return fmt.Errorf(".org $%X is out of range", value)
+		}
+		if !a.started {
+			a.origin = value
+			a.started = true
+		} else if value < a.origin {
+			return fmt.Errorf(".org $%04X is below the origin $%04X", value, a.origin)
+		}
+		a.pc = value
+	case ".byte", ".db":
+		for _, item := range splitOperands(operand) {
+			if len(item) >= 2 && item[0] == '"' && item[len(item)-1] == '"' {
+				for _, ch := range []byte(item[1 : len(item)-1]) {
+					if err := a.emit(ch); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			value, err := a.byteValue(item)
+			if err != nil {
+				return err
+			}
+			if err := a.emit(value); err != nil {
+				return err
+			}
+		}
+	case ".word", ".dw":
+		for _, item := range splitOperands(operand) {
+			value, err := a.wordValue(item)
+			if err != nil {
+				return err
+			}
+			if err := a.emit(uint8(value), uint8(value>>8)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown directive %s", name)
+	}
+	return nil
+}
+
+// splitOperands splits a list on commas outside quotes and parentheses
+func splitOperands(text string) []string {
+	var items []string
+	depth := 0
+	quoted := byte(0)
+	start := 0
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) {
+			ch := text[i]
+			switch {
+			case quoted != 0:
+				if ch == quoted {
+					quoted = 0
+				}
+				continue
+			case ch == '"' || ch == '\'':
+				quoted = ch
+				continue
+			case ch == '(':
+				depth++
+				continue
+			case ch == ')':
+				depth--
+				continue
+			case ch != ',' || depth > 0:
+				continue
+			}
+		}
+		items = append(items, strings.TrimSpace(text[start:i]))
+		start = i + 1
+	}
+	return items
+}
+
+// emit writes bytes at the current address, only the second pass keeps them
+func (a *assembler) emit(bytes ...uint8) error {
+	if !a.started {
+		a.origin = a.pc
+		a.started = true
+	}
+	for _, b := range bytes {
+		if a.pc > 0xFFFF {
+			return fmt.Errorf("code runs past $FFFF")
+		}
+		if a.pass == 2 {
+			offset := a.pc - a.origin
+			for len(a.code) <= offset {
+				a.code = append(a.code, 0)
+			}
+			a.code[offset] = b
+		}
+		a.pc++
+	}
+	return nil
+}
+
+// byteValue evaluates an 8 bit operand, accepting -128 to 255
+func (a *assembler) byteValue(text string) (uint8, error) {
+	value, known, err := a.eval(text)
+	if err != nil {
+		return 0, err
+	}
+	if known && (value < -0x80 || value > 0xFF) {
+		return 0, fmt.Errorf("%s = $%X doesn't fit in a byte", text, value)
+	}
+	return uint8(value), nil
+}
+
+func (a *assembler) wordValue(text string) (uint16, error) {
+	value, known, err := a.eval(text)
+	if err != nil {
+		return 0, err
+	}
+	if known && (value < -0x8000 || value > 0xFFFF) {
+		return 0, fmt.Errorf("%s = $%X doesn't fit in a word", text, value)
+	}
+	return uint16(value), nil
+}
+
+func (a *assembler) instruction(mnemonic, operand string) error {
+	if _, ok := hardware.FindOpcode(mnemonic, hardware.ModeRelative); ok {
+		return a.branch(mnemonic, operand)
+	}
+
+	var mode hardware.AddressingMode
+	var value int
+	var known bool
+	var err error
+	upper := strings.ToUpper(operand)
+	switch {
+	case operand == "" || upper == "A":
+		mode = hardware.ModeNoneAddressing
+		if _, ok := hardware.FindOpcode(mnemonic, hardware.ModeAccumulator); ok {
+			mode = hardware.ModeAccumulator
+		}
+		if operand != "" && mode != hardware.ModeAccumulator {
+			return fmt.Errorf("%s doesn't take the accumulator", mnemonic)
+		}
+	case operand[0] == '#':
+		mode = hardware.ModeImmediate
+		value, known, err = a.eval(operand[1:])
+	case strings.HasPrefix(upper, "(") && strings.HasSuffix(upper, ",X)"):
+		mode = hardware.ModeIndirectX
+		value, known, err = a.eval(operand[1 : len(operand)-3])
+	case strings.HasPrefix(upper, "(") && strings.HasSuffix(upper, "),Y"):
+		mode = hardware.ModeIndirectY
+		value, known, err = a.eval(operand[1 : len(operand)-3])
+	case mnemonic == "JMP" && strings.HasPrefix(operand, "(") && strings.HasSuffix(operand, ")"):
+		mode = hardware.ModeIndirect
+		value, known, err = a.eval(operand[1 : len(operand)-1])
+	default:
+		zp, abs := hardware.ModeZeroPage, hardware.ModeAbsolute
+		expr := operand
+		if items := splitOperands(operand); len(items) == 2 {
+			expr = items[0]
+			switch strings.ToUpper(items[1]) {
+			case "X":
+				zp, abs = hardware.ModeZeroPageX, hardware.ModeAbsoluteX
+			case "Y":
+				zp, abs = hardware.ModeZeroPageY, hardware.ModeAbsoluteY
+			default:
+				return fmt.Errorf("bad index register %q", items[1])
+			}
+		}
+		value, known, err = a.eval(expr)
+		mode = abs
+		if _, ok := hardware.FindOpcode(mnemonic, zp); ok && known && value >= 0 && value <= 0xFF {
+			mode = zp
+		}
+		if _, ok := hardware.FindOpcode(mnemonic, mode); !ok {
+			if _, ok := hardware.FindOpcode(mnemonic, zp); ok {
+				mode = zp // only a zero page form exists, such as STX zp,Y
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// the first pass picks the mode, the second keeps it
+	if a.pass == 1 {
+		a.modes = append(a.modes, mode)
+	} else {
+		mode = a.modes[a.instr]
+	}
+	a.instr++
+
+	opcode, ok := hardware.FindOpcode(mnemonic, mode)
+	if !ok {
+		if !mnemonicExists(mnemonic) {
+			return fmt.Errorf("unknown instruction %s", mnemonic)
+		}
+		return fmt.Errorf("%s doesn't support the addressing mode of %q", mnemonic, operand)
+	}
+	switch mode.OperandSize() {
+	case 0:
+		return a.emit(opcode)
+	case 1:
+		if known && (value < -0x80 || value > 0xFF) {
+			return fmt.Errorf("%s = $%X doesn't fit in a byte", operand, value)
+		}
+		return a.emit(opcode, uint8(value))
+	default:
+		if known && (value < -0x8000 || value > 0xFFFF) {
+			return fmt.Errorf("%s = $%X doesn't fit in a word", operand, value)
+		}
+		return a.emit(opcode, uint8(value), uint8(value>>8))
+	}
+}
+
+func mnemonicExists(mnemonic string) bool {
+	for opcode := 0; opcode < 256; opcode++ {
+		if op, ok := hardware.LookupOpcode(uint8(opcode)); ok && op.Mnemonic == mnemonic {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *assembler) branch(mnemonic, operand string) error {
+	opcode, _ := hardware.FindOpcode(mnemonic, hardware.ModeRelative)
+	target, known, err := a.eval(operand)
+	if err != nil {
+		return err
+	}
+	offset := target - (a.pc + 2)
+	if known && (offset < -128 || offset > 127) {
+		return fmt.Errorf("branch to $%04X is out of range", target)
+	}
+	return a.emit(opcode, uint8(offset))
+}
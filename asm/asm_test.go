@@ -0,0 +1,147 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/disasm"
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// every official opcode disassembles to text that assembles back to the
+// same bytes
+func TestRoundTrip(t *testing.T) {
+	count := 0
+	for opcode := 0; opcode < 256; opcode++ {
+		op, ok := hardware.LookupOpcode(uint8(opcode))
+		if !ok {
+			continue
+		}
+		count++
+		code := []uint8{uint8(opcode), 0x34, 0x12}[:1+op.Mode.OperandSize()]
+		text := disasm.DecodeBytes(code, DEFAULT_ORIGIN).String()
+		p, err := Assemble(text)
+		if err != nil {
+			t.Errorf("$%02X %s: %v", opcode, text, err)
+			continue
+		}
+		if !bytes.Equal(p.Code, code) {
+			t.Errorf("$%02X %s assembled to % X, want % X", opcode, text, p.Code, code)
+		}
+	}
+	if count != 151 {
+		t.Errorf("%d official opcodes, want 151", count)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []uint8
+	}{
+		{"LDA #$80", []uint8{0xA9, 0x80}},
+		{"LDA $10", []uint8{0xA5, 0x10}},
+		{"LDA $0010", []uint8{0xA5, 0x10}},
+		{"ptr = $10 ; STA (ptr),Y", []uint8{0x91, 0x10}},
+		{"LDA later ; later: .byte 1", []uint8{0xAD, 0x03, 0x80, 0x01}},
+		{"ASL ; ROL A", []uint8{0x0A, 0x2A}},
+		{"loop: DEX ; BNE loop", []uint8{0xCA, 0xD0, 0xFD}},
+		{"JMP ($1234)", []uint8{0x6C, 0x34, 0x12}},
+		{".word $1234, <$5678 ; .byte 'A', \"BC\", >$5678", []uint8{0x34, 0x12, 0x78, 0x00, 0x41, 0x42, 0x43, 0x56}},
+		{".org $C000 ; JSR * + 3 // comment", []uint8{0x20, 0x03, 0xC0}},
+	}
+	for _, tt := range tests {
+		p, err := Assemble(tt.source)
+		if err != nil {
+			t.Errorf("%q: %v", tt.source, err)
+			continue
+		}
+		if !bytes.Equal(p.Code, tt.want) {
+			t.Errorf("%q assembled to % X, want % X", tt.source, p.Code, tt.want)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, source := range []string{
+		"LDA",
+		"FOO #1",
+		"LDA #$100",
+		"STA #1",
+		"BNE far ; .org $9000 ; far: NOP",
+		"LDA missing",
+		"x = 1 ; x = 2",
+	} {
+		_, err := Assemble(source)
+		var asmErr *Error
+		if !errors.As(err, &asmErr) {
+			t.Errorf("%q: error %v, want an *Error", source, err)
+		}
+	}
+}
+
+// run assembles source at $0600 for a bare CPU and steps it until it
+// reaches a BRK
+func run(t *testing.T, source string) hardware.Registers {
+	t.Helper()
+	p, err := Assemble(".org $0600\n" + source + "\nBRK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	brk := p.Origin + uint16(len(p.Code)) - 1
+	cpu := hardware.NewCPU()
+	cpu.Load(p.Origin, p.Code)
+	for i := 0; cpu.Registers().PC != brk; i++ {
+		if i > 10000 {
+			t.Fatalf("%q didn't reach BRK, registers %v", source, cpu.Registers())
+		}
+		cpu.Step()
+	}
+	return cpu.Registers()
+}
+
+func TestRunLDA(t *testing.T) {
+	r := run(t, "LDA #$80")
+	if r.A != 0x80 || !r.Flag(hardware.N) || r.Flag(hardware.Z) {
+		t.Errorf("LDA #$80: %v, want A:80 with N set and Z clear", r)
+	}
+	r = run(t, "LDA #0")
+	if r.Flag(hardware.N) || !r.Flag(hardware.Z) {
+		t.Errorf("LDA #0: %v, want Z set and N clear", r)
+	}
+}
+
+func TestRunSubroutine(t *testing.T) {
+	r := run(t, `
+		LDX #1
+		JSR double
+		JSR double
+		JMP done
+	double:
+		TXA
+		ASL
+		TAX
+		RTS
+	done:
+		LDY #$42`)
+	if r.X != 4 || r.Y != 0x42 || r.SP != hardware.STACK_RESET {
+		t.Errorf("%v, want X:04 Y:42 and the stack back at $%02X", r, hardware.STACK_RESET)
+	}
+}
+
+func TestRunLoop(t *testing.T) {
+	// sum 10 + 9 + ... + 1 with a BNE loop
+	r := run(t, `
+		LDA #0
+		LDX #10
+		CLC
+	loop:
+		STX $00
+		ADC $00
+		DEX
+		BNE loop`)
+	if r.A != 55 || r.X != 0 || !r.Flag(hardware.Z) {
+		t.Errorf("%v, want A:37 X:00 with Z set", r)
+	}
+}
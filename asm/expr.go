@@ -0,0 +1,189 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exprParser evaluates an operand expression. Symbols that aren't defined yet
+// make the result unknown during the first pass and are an error in the second.
+type exprParser struct {
+	a     *assembler
+	text  string
+	pos   int
+	known bool
+}
+
+// binary operators by precedence, loosest first
+var exprLevels = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (a *assembler) eval(text string) (value int, known bool, err error) {
+	p := &exprParser{a: a, text: strings.TrimSpace(text), known: true}
+	if p.text == "" {
+		return 0, false, fmt.Errorf("missing operand")
+	}
+	value, err = p.binary(0)
+	if err == nil && p.skipSpace() < len(p.text) {
+		err = fmt.Errorf("unexpected %q in %q", p.text[p.pos:], p.text)
+	}
+	return value, p.known, err
+}
+
+func (p *exprParser) skipSpace() int {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+	return p.pos
+}
+
+func (p *exprParser) binary(level int) (int, error) {
+	if level == len(exprLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		p.skipSpace()
+		op := ""
+		for _, candidate := range exprLevels[level] {
+			if strings.HasPrefix(p.text[p.pos:], candidate) {
+				op = candidate
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		p.pos += len(op)
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				if !p.known {
+					left = 0
+					continue
+				}
+				return 0, fmt.Errorf("division by zero in %q", p.text)
+			}
+			if op == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+func (p *exprParser) unary() (int, error) {
+	p.skipSpace()
+	if p.pos == len(p.text) {
+		return 0, fmt.Errorf("incomplete expression %q", p.text)
+	}
+	switch ch := p.text[p.pos]; ch {
+	case '-', '~', '<', '>', '+':
+		p.pos++
+		value, err := p.unary()
+		switch ch {
+		case '-':
+			value = -value
+		case '~':
+			value = ^value & 0xFFFF
+		case '<':
+			value &= 0xFF
+		case '>':
+			value = value >> 8 & 0xFF
+		}
+		return value, err
+	case '(':
+		p.pos++
+		value, err := p.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		if p.skipSpace() == len(p.text) || p.text[p.pos] != ')' {
+			return 0, fmt.Errorf("missing ) in %q", p.text)
+		}
+		p.pos++
+		return value, nil
+	case '*':
+		p.pos++
+		return p.a.pc, nil
+	case '\'':
+		if p.pos+2 >= len(p.text) || p.text[p.pos+2] != '\'' {
+			return 0, fmt.Errorf("bad character constant in %q", p.text)
+		}
+		p.pos += 3
+		return int(p.text[p.pos-2]), nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (int, error) {
+	rest := p.text[p.pos:]
+	if n := identLength(rest); n > 0 {
+		name := rest[:n]
+		p.pos += n
+		value, ok := p.a.symbols[name]
+		if !ok {
+			if p.a.pass == 2 {
+				return 0, fmt.Errorf("undefined symbol %s", name)
+			}
+			p.known = false
+		}
+		return int(value), nil
+	}
+
+	base, digits := 10, "0123456789"
+	switch {
+	case strings.HasPrefix(rest, "$"):
+		base, digits, rest = 16, "0123456789abcdefABCDEF", rest[1:]
+		p.pos++
+	case strings.HasPrefix(rest, "0x") || strings.HasPrefix(rest, "0X"):
+		base, digits, rest = 16, "0123456789abcdefABCDEF", rest[2:]
+		p.pos += 2
+	case strings.HasPrefix(rest, "%"):
+		base, digits, rest = 2, "01", rest[1:]
+		p.pos++
+	}
+	n := 0
+	for n < len(rest) && strings.IndexByte(digits, rest[n]) >= 0 {
+		n++
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("bad expression %q", p.text)
+	}
+	value, err := strconv.ParseInt(rest[:n], base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", rest[:n])
+	}
+	p.pos += n
+	return int(value), nil
+}
@@ -0,0 +1,106 @@
+// Package audio writes the console's sound output to disk as WAV files.
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// the APU is sampled at this rate unless asked otherwise
+const SampleRate = 44100
+
+// WAV stores sizes in 32 bits
+const maxWAVSize = 1<<32 - 1
+
+var ErrTooLarge = errors.New("WAV file would exceed 4 GiB")
+
+// WAVWriter writes 16 bit mono PCM. The sizes in the header are filled in
+// by Close.
+type WAVWriter struct {
+	file    io.WriteSeeker
+	rate    int
+	samples int
+	buf     []uint8
+}
+
+// CreateWAV creates a WAV file for samples at the given rate
+func CreateWAV(path string, rate int) (*WAVWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWAVWriter(file, rate)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func NewWAVWriter(file io.WriteSeeker, rate int) (*WAVWriter, error) {
+	w := &WAVWriter{file: file, rate: rate}
+	if err := w.writeHeader(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// header is the RIFF header, the format chunk and the start of the data
+// chunk, sized for the samples written so far
+func (w *WAVWriter) header() []uint8 {
+	dataSize := uint32(w.samples * 2)
+	b := append([]uint8("RIFF"), binary.LittleEndian.AppendUint32(nil, 36+dataSize)...)
+	b = append(b, "WAVEfmt "...)
+	b = binary.LittleEndian.AppendUint32(b, 16)
+	b = binary.LittleEndian.AppendUint16(b, 1) // PCM
+	b = binary.LittleEndian.AppendUint16(b, 1) // channels
+	b = binary.LittleEndian.AppendUint32(b, uint32(w.rate))
+	b = binary.LittleEndian.AppendUint32(b, uint32(w.rate*2)) // bytes a second
+	b = binary.LittleEndian.AppendUint16(b, 2)                // bytes a sample
+	b = binary.LittleEndian.AppendUint16(b, 16)
+	b = append(b, "data"...)
+	return binary.LittleEndian.AppendUint32(b, dataSize)
+}
+
+func (w *WAVWriter) writeHeader() error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := w.file.Write(w.header())
+	return err
+}
+
+// WriteSamples appends samples between -1 and 1, louder ones are clipped
+func (w *WAVWriter) WriteSamples(samples []float32) error {
+	if int64(44+(w.samples+len(samples))*2) > maxWAVSize {
+		return ErrTooLarge
+	}
+	w.buf = w.buf[:0]
+	for _, s := range samples {
+		s = max(-1, min(1, s))
+		w.buf = binary.LittleEndian.AppendUint16(w.buf, uint16(int16(s*32767)))
+	}
+	if _, err := w.file.Write(w.buf); err != nil {
+		return err
+	}
+	w.samples += len(samples)
+	return nil
+}
+
+// Samples returns the number of samples written
+func (w *WAVWriter) Samples() int {
+	return w.samples
+}
+
+// Close writes the final sizes and closes the file if it is an io.Closer
+func (w *WAVWriter) Close() error {
+	err := w.writeHeader()
+	if closer, ok := w.file.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,187 @@
+package cheats
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/asm"
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		code string
+		want Cheat
+	}{
+		{"SXIOPO", Cheat{Kind: GameGenie, Address: 0x91D9, Value: 0xAD}},
+		{"sxiopo", Cheat{Kind: GameGenie, Address: 0x91D9, Value: 0xAD}},
+		{"YEUZUGAA", Cheat{Kind: GameGenie, Address: 0xACB3, Value: 0x07, Compare: 0x00, HasCompare: true}},
+		{"0075:09", Cheat{Kind: Freeze, Address: 0x0075, Value: 0x09}},
+		{"75:9", Cheat{Kind: Freeze, Address: 0x0075, Value: 0x09}},
+		{"0075FF", Cheat{Kind: Freeze, Address: 0x0075, Value: 0xFF}},
+		// hex, but every letter is also Game Genie
+		{"AEAEAE", Cheat{Kind: GameGenie, Address: 0x8088, Value: 0x08}},
+	}
+	for _, tt := range tests {
+		c, err := Parse(tt.code)
+		if err != nil {
+			t.Errorf("%s: %v", tt.code, err)
+			continue
+		}
+		if !c.Enabled {
+			t.Errorf("%s: parsed disabled", tt.code)
+		}
+		c.Code, c.Enabled = "", false
+		if *c != tt.want {
+			t.Errorf("%s: %v, want %v", tt.code, c, &tt.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, code := range []string{"", "SXIOP", "SXIOPOA", "SXIOPQ", "8000:01", "0075:100", "0075:", "G075:01"} {
+		if c, err := Parse(code); err == nil {
+			t.Errorf("%q: parsed as %v", code, c)
+		}
+	}
+}
+
+// cheatProgram copies two ROM bytes the codes above patch to RAM, over and
+// over
+const cheatProgram = `
+.org $8000
+reset:	LDA #$11 ; STA $0300
+loop:	LDA $91D9 ; STA $10
+	LDA $ACB3 ; STA $11
+	JMP loop
+.org $91D9
+	.byte $C6
+.org $ACB3
+	.byte $00
+.org $FFFA
+	.word loop, reset, loop
+`
+
+// newTestConsole runs cheatProgram, with the byte the 8 letter code
+// compares against changed to compare
+func newTestConsole(t *testing.T, compare uint8) *hardware.Console {
+	t.Helper()
+	p := asm.MustAssemble(cheatProgram)
+	prg := make([]uint8, 0x8000)
+	copy(prg[p.Origin-0x8000:], p.Code)
+	prg[0xACB3-0x8000] = compare
+	cart, err := hardware.NewCartridge(prg, make([]uint8, 0x2000), 0, hardware.MirrorVertical, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console := hardware.NewConsole(cart)
+	console.StepFrame()
+	return console
+}
+
+func TestGameGenie(t *testing.T) {
+	console := newTestConsole(t, 0x00)
+	e := New(console)
+	for _, code := range []string{"SXIOPO", "YEUZUGAA"} {
+		if _, err := e.Add(code, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	console.StepFrame()
+	if got := console.Peek(0x10); got != 0xAD {
+		t.Errorf("program read $%02X from $91D9, want $AD", got)
+	}
+	if got := console.Peek(0x11); got != 0x07 {
+		t.Errorf("program read $%02X from $ACB3, want $07", got)
+	}
+	// the debugger's view of ROM isn't patched
+	if got := console.Peek(0x91D9); got != 0xC6 {
+		t.Errorf("Peek $91D9 = $%02X, want the ROM's $C6", got)
+	}
+
+	if err := e.SetEnabled(0, false); err != nil {
+		t.Fatal(err)
+	}
+	console.StepFrame()
+	if got := console.Peek(0x10); got != 0xC6 {
+		t.Errorf("program read $%02X from $91D9 with the code off, want $C6", got)
+	}
+	e.Clear()
+	if e.intercept != 0 {
+		t.Error("intercept hook left installed with no cheats")
+	}
+	console.StepFrame()
+	if got := console.Peek(0x11); got != 0x00 {
+		t.Errorf("program read $%02X from $ACB3 after Clear, want $00", got)
+	}
+}
+
+func TestGameGenieCompareMiss(t *testing.T) {
+	console := newTestConsole(t, 0x05)
+	e := New(console)
+	if _, err := e.Add("YEUZUGAA", ""); err != nil {
+		t.Fatal(err)
+	}
+	console.StepFrame()
+	if got := console.Peek(0x11); got != 0x05 {
+		t.Errorf("program read $%02X from $ACB3 holding $05, want it unpatched", got)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	console := newTestConsole(t, 0x00)
+	e := New(console)
+	if _, err := e.Add("0300:99", "frozen"); err != nil {
+		t.Fatal(err)
+	}
+	if got := console.Peek(0x0300); got != 0x99 {
+		t.Errorf("$0300 = $%02X as the code is added, want $99", got)
+	}
+	// the value is written again before the first instruction of every frame
+	for frame := 0; frame < 2; frame++ {
+		console.Poke(0x0300, 0x00)
+		console.StepFrame()
+		console.Step()
+		if got := console.Peek(0x0300); got != 0x99 {
+			t.Errorf("frame %d: $0300 = $%02X, want $99", frame, got)
+		}
+	}
+
+	if err := e.Remove(0); err != nil {
+		t.Fatal(err)
+	}
+	if e.exec != 0 {
+		t.Error("exec hook left installed with no freeze cheats")
+	}
+	console.Poke(0x0300, 0x00)
+	console.StepFrame()
+	console.Step()
+	if got := console.Peek(0x0300); got != 0x00 {
+		t.Errorf("$0300 = $%02X after Remove, want it left alone", got)
+	}
+	if err := e.Remove(0); err == nil {
+		t.Error("removed a cheat from an empty list")
+	}
+}
+
+func TestCheatFile(t *testing.T) {
+	const file = "# Super Mario Bros.\nSXIOPO Infinite lives\n\n-0075:09 Start in world 9\n"
+	e := New(newTestConsole(t, 0x00))
+	if err := e.Load(bytes.NewBufferString(file)); err != nil {
+		t.Fatal(err)
+	}
+	cheats := e.Cheats()
+	if len(cheats) != 2 || !cheats[0].Enabled || cheats[1].Enabled || cheats[1].Name != "Start in world 9" {
+		t.Fatalf("loaded %v", cheats)
+	}
+	var saved bytes.Buffer
+	if err := e.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if want := "SXIOPO Infinite lives\n-0075:09 Start in world 9\n"; saved.String() != want {
+		t.Errorf("saved %q, want %q", saved.String(), want)
+	}
+	if err := e.Load(bytes.NewBufferString("QQQQQQ bad")); err == nil {
+		t.Error("loaded a bad code")
+	}
+}
@@ -0,0 +1,106 @@
+// Package cheats applies Game Genie codes, which patch what the CPU reads
+// from cartridge ROM, and Pro Action Replay style codes, which freeze a RAM
+// address to a value by writing it again every frame.
+package cheats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Kind int
+
+const (
+	GameGenie Kind = iota
+	Freeze
+)
+
+func (k Kind) String() string {
+	if k == GameGenie {
+		return "game genie"
+	}
+	return "freeze"
+}
+
+// Cheat is a decoded code. A Game Genie cheat replaces Value for reads of
+// Address, only when the ROM holds Compare if HasCompare is set.
+type Cheat struct {
+	Code       string // as entered, upper case
+	Name       string
+	Enabled    bool
+	Kind       Kind
+	Address    uint16
+	Value      uint8
+	Compare    uint8
+	HasCompare bool
+}
+
+func (c *Cheat) String() string {
+	s := fmt.Sprintf("%s %v $%04X = $%02X", c.Code, c.Kind, c.Address, c.Value)
+	if c.HasCompare {
+		s += fmt.Sprintf(" if $%02X", c.Compare)
+	}
+	if c.Name != "" {
+		s += " " + c.Name
+	}
+	return s
+}
+
+// the Game Genie alphabet, each letter stands for its index
+const genieLetters = "APZLGITYEOXUKSVN"
+
+// Parse decodes a 6 or 8 letter Game Genie code, or a freeze code written
+// as AAAA:VV or AAAAVV in hex. Six letters that are both valid hex and
+// valid Game Genie, such as AEAEAE, are taken as Game Genie.
+func Parse(code string) (*Cheat, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if address, value, ok := strings.Cut(code, ":"); ok {
+		return parseFreeze(code, address, value)
+	}
+	isHex := strings.Trim(code, "0123456789ABCDEF") == ""
+	if len(code) == 6 && isHex && strings.Trim(code, genieLetters) != "" {
+		return parseFreeze(code, code[:4], code[4:])
+	}
+	return parseGenie(code)
+}
+
+func parseFreeze(code, address, value string) (*Cheat, error) {
+	a, err := strconv.ParseUint(address, 16, 16)
+	if err != nil || a >= 0x8000 {
+		return nil, fmt.Errorf("bad freeze address %q in %q", address, code)
+	}
+	v, err := strconv.ParseUint(value, 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("bad freeze value %q in %q", value, code)
+	}
+	return &Cheat{Code: code, Enabled: true, Kind: Freeze, Address: uint16(a), Value: uint8(v)}, nil
+}
+
+// parseGenie unscrambles a Game Genie code
+// https://www.nesdev.org/wiki/Game_Genie
+func parseGenie(code string) (*Cheat, error) {
+	if len(code) != 6 && len(code) != 8 {
+		return nil, fmt.Errorf("bad code %q: want 6 or 8 Game Genie letters or AAAA:VV", code)
+	}
+	var n [8]uint16
+	for i, letter := range code {
+		index := strings.IndexRune(genieLetters, letter)
+		if index < 0 {
+			return nil, fmt.Errorf("bad Game Genie letter %q in %q", letter, code)
+		}
+		n[i] = uint16(index)
+	}
+	c := &Cheat{Code: code, Enabled: true, Kind: GameGenie}
+	c.Address = 0x8000 | (n[3]&7)<<12 | (n[5]&7)<<8 | (n[4]&8)<<8 |
+		(n[2]&7)<<4 | (n[1]&8)<<4 | n[4]&7 | n[3]&8
+	value := (n[1]&7)<<4 | (n[0]&8)<<4 | n[0]&7
+	if len(code) == 6 {
+		c.Value = uint8(value | n[5]&8)
+		return c, nil
+	}
+	c.Value = uint8(value | n[7]&8)
+	c.Compare = uint8((n[7]&7)<<4 | (n[6]&8)<<4 | n[6]&7 | n[5]&8)
+	c.HasCompare = true
+	return c, nil
+}
@@ -0,0 +1,126 @@
+package cheats
+
+import (
+	"fmt"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// Engine applies a list of cheats to a console. Cheats take effect as soon
+// as they are added or enabled, and the engine only hooks the bus while an
+// enabled cheat needs it.
+type Engine struct {
+	console *hardware.Console
+	cheats  []*Cheat
+
+	patches   map[uint16][]*Cheat // enabled Game Genie cheats by address
+	intercept hardware.HookID     // 0 while no Game Genie cheat is enabled
+	freezes   []*Cheat            // enabled freeze cheats
+	exec      hardware.HookID     // 0 while no freeze cheat is enabled
+	frame     uint64              // frame the freezes were last applied in
+}
+
+func New(console *hardware.Console) *Engine {
+	return &Engine{console: console}
+}
+
+// Add decodes a code and appends it to the list, enabled
+func (e *Engine) Add(code, name string) (*Cheat, error) {
+	c, err := Parse(code)
+	if err != nil {
+		return nil, err
+	}
+	c.Name = name
+	e.cheats = append(e.cheats, c)
+	e.update()
+	return c, nil
+}
+
+// Cheats returns the list in the order cheats were added
+func (e *Engine) Cheats() []*Cheat {
+	return e.cheats
+}
+
+// Remove deletes the cheat at index i of Cheats
+func (e *Engine) Remove(i int) error {
+	if i < 0 || i >= len(e.cheats) {
+		return fmt.Errorf("no cheat %d", i)
+	}
+	e.cheats = append(e.cheats[:i], e.cheats[i+1:]...)
+	e.update()
+	return nil
+}
+
+// SetEnabled turns the cheat at index i of Cheats on or off
+func (e *Engine) SetEnabled(i int, enabled bool) error {
+	if i < 0 || i >= len(e.cheats) {
+		return fmt.Errorf("no cheat %d", i)
+	}
+	e.cheats[i].Enabled = enabled
+	e.update()
+	return nil
+}
+
+// Clear removes every cheat and unhooks the engine from the console
+func (e *Engine) Clear() {
+	e.cheats = nil
+	e.update()
+}
+
+// Apply writes the values of enabled freeze cheats. It runs by itself at the
+// start of every frame.
+func (e *Engine) Apply() {
+	for _, c := range e.freezes {
+		e.console.Poke(c.Address, c.Value)
+	}
+}
+
+// update rebuilds the lookup tables and installs or removes bus hooks
+func (e *Engine) update() {
+	e.patches = map[uint16][]*Cheat{}
+	e.freezes = nil
+	for _, c := range e.cheats {
+		switch {
+		case !c.Enabled:
+		case c.Kind == GameGenie:
+			e.patches[c.Address] = append(e.patches[c.Address], c)
+		default:
+			e.freezes = append(e.freezes, c)
+		}
+	}
+
+	hooks := e.console.Hooks()
+	switch {
+	case len(e.patches) > 0 && e.intercept == 0:
+		e.intercept = hooks.Intercept(0x8000, 0xFFFF, e.patch)
+	case len(e.patches) == 0 && e.intercept != 0:
+		hooks.Remove(e.intercept)
+		e.intercept = 0
+	}
+	switch {
+	case len(e.freezes) > 0 && e.exec == 0:
+		// a frame boundary is noticed at the first instruction after it
+		e.frame = e.console.FrameCount()
+		e.exec = hooks.OnExec(0x0000, 0xFFFF, e.onExec)
+	case len(e.freezes) == 0 && e.exec != 0:
+		hooks.Remove(e.exec)
+		e.exec = 0
+	}
+	e.Apply()
+}
+
+func (e *Engine) patch(address uint16, value uint8) uint8 {
+	for _, c := range e.patches[address] {
+		if !c.HasCompare || c.Compare == value {
+			return c.Value
+		}
+	}
+	return value
+}
+
+func (e *Engine) onExec(kind hardware.AccessKind, address uint16, opcode uint8) {
+	if frame := e.console.FrameCount(); frame != e.frame {
+		e.frame = frame
+		e.Apply()
+	}
+}
@@ -0,0 +1,85 @@
+package cheats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Cheat files hold one code per line, optionally followed by a name. A code
+// starting with - is disabled, # starts a comment line:
+//
+//	# Super Mario Bros.
+//	SXIOPO Infinite lives
+//	-0075:09 Start in world 9
+//
+// A ROM's cheats live next to it with the extension changed to .cht.
+
+// PathFor returns the cheat file for a ROM
+func PathFor(romPath string) string {
+	return strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".cht"
+}
+
+// Load adds the cheats in a cheat file to the engine
+func (e *Engine) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		code, name, _ := strings.Cut(text, " ")
+		enabled := !strings.HasPrefix(code, "-")
+		c, err := Parse(strings.TrimPrefix(code, "-"))
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		c.Name = strings.TrimSpace(name)
+		c.Enabled = enabled
+		e.cheats = append(e.cheats, c)
+	}
+	e.update()
+	return scanner.Err()
+}
+
+// Save writes the engine's cheats in the cheat file format
+func (e *Engine) Save(w io.Writer) error {
+	for _, c := range e.cheats {
+		prefix := ""
+		if !c.Enabled {
+			prefix = "-"
+		}
+		line := strings.TrimSpace(prefix + c.Code + " " + c.Name)
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := e.Load(file); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+func (e *Engine) SaveFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := e.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tejasdeepakmasne/nesemu-go/dap"
+)
+
+const dapUsage = `usage: nesemu-go dap [flags]
+
+Runs a Debug Adapter Protocol server for editors such as VS Code. Without
+-listen the protocol is spoken over stdin and stdout. The ROM flags apply to
+every program launched by the editor, and what an FDS game writes to its disk
+is saved when the session ends, as with the other commands.
+
+VS Code needs the extension in editors/vscode for the nesemu debug type.
+Other DAP clients start this command as a stdio adapter, or connect to the
+-listen address, and send a launch request with these arguments:
+
+  program      ROM to debug
+  debugInfo    ca65 debug info, defaults to the ROM with a .dbg extension
+  labels       list of label files
+  stopOnEntry  stop at the reset vector instead of running
+
+`
+
+func runDAP(args []string) int {
+	fs := flag.NewFlagSet("dap", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), dapUsage)
+		fs.PrintDefaults()
+	}
+	listen := fs.String("listen", "", "accept editor connections on this address, e.g. 127.0.0.1:4711")
+	romOpts := addROMFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitError
+	}
+
+	server := &dap.Server{Load: romOpts.load, Unload: romOpts.saveDisk}
+	var err error
+	if *listen != "" {
+		fmt.Fprintf(os.Stderr, "waiting for the editor on %s\n", *listen)
+		err = server.ListenAndServe(*listen)
+	} else {
+		err = server.Serve(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitPass
+}
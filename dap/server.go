@@ -0,0 +1,743 @@
+// Package dap is a Debug Adapter Protocol server, letting editors such as
+// VS Code launch a ROM under the debugger. With ca65 debug info breakpoints
+// are set on source lines and steps advance a source line at a time.
+// https://microsoft.github.io/debug-adapter-protocol/
+package dap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tejasdeepakmasne/nesemu-go/disasm"
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// the only thread, the CPU
+const threadID = 1
+
+// variablesReference values of the scopes
+const (
+	refRegisters = iota + 1
+	refFlags
+	refZeroPage
+	refStack
+)
+
+// maxLineSteps bounds a source line step, so a line that loops forever
+// still returns control
+const maxLineSteps = 1000000
+
+// Server runs debug sessions
+type Server struct {
+	// Load builds the cartridge named by a launch request, nil reads the
+	// file with hardware.ParseROM
+	Load func(path string) (*hardware.Cartridge, error)
+	// Unload is called with the cartridge of each launched program when its
+	// session ends, e.g. to save what was written to a disk. nil does nothing.
+	Unload func(path string, cart *hardware.Cartridge) error
+}
+
+// ListenAndServe accepts editor connections on a TCP address one after the
+// other, running a debug session for each
+func (srv *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		err = srv.Serve(c)
+		c.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Serve runs one debug session over rw, for example stdin and stdout when
+// the editor starts the adapter itself. It returns after a disconnect request
+// or when the stream ends.
+func (srv *Server) Serve(rw io.ReadWriter) (err error) {
+	s := &session{t: newTransport(rw), load: srv.load, unload: srv.Unload, breakpoints: map[string][]int{}}
+	defer func() {
+		if unloadErr := s.end(); err == nil {
+			err = unloadErr
+		}
+	}()
+	for {
+		m, err := s.t.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if m.Type != "request" {
+			continue
+		}
+		body, err := s.handle(m)
+		r := &response{Type: "response", RequestSeq: m.Seq, Command: m.Command, Success: err == nil, Body: body}
+		if err != nil {
+			r.Message = err.Error()
+		}
+		if err := s.t.write(r); err != nil {
+			return err
+		}
+		if s.after != nil {
+			s.after()
+			s.after = nil
+		}
+		if m.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+func (srv *Server) load(path string) (*hardware.Cartridge, error) {
+	if srv.Load != nil {
+		return srv.Load(path)
+	}
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cart, err := hardware.ParseROM(rom, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cart, nil
+}
+
+type session struct {
+	t      *transport
+	load   func(path string) (*hardware.Cartridge, error)
+	unload func(path string, cart *hardware.Cartridge) error
+	wait   sync.WaitGroup // the goroutine running the target
+
+	// mu guards the console while the target runs on its own goroutine
+	mu          sync.Mutex
+	running     bool
+	paused      atomic.Bool
+	debugger    *hardware.Debugger
+	program     string // path the debugger's cartridge was loaded from
+	symbols     *disasm.Symbols
+	info        *disasm.DebugInfo
+	sourceDir   string // relative paths in the debug info start here
+	stopOnEntry bool
+	breakpoints map[string][]int // source path -> debugger breakpoint ids
+	instruction []int            // breakpoints from setInstructionBreakpoints
+
+	// after runs once the response to the current request is sent, for
+	// events that must follow it
+	after func()
+}
+
+func (s *session) event(name string, body interface{}) {
+	s.t.write(&event{Type: "event", Event: name, Body: body})
+}
+
+// end stops the target and unloads its program
+func (s *session) end() error {
+	s.pause()
+	return s.unloadProgram()
+}
+
+// unloadProgram hands the stopped target's cartridge to unload
+func (s *session) unloadProgram() error {
+	if s.debugger == nil || s.unload == nil {
+		return nil
+	}
+	err := s.unload(s.program, s.debugger.Console().Cartridge())
+	s.debugger = nil
+	return err
+}
+
+// pause stops a running target and waits for it
+func (s *session) pause() {
+	s.paused.Store(true)
+	if s.debugger != nil {
+		s.debugger.Interrupt()
+	}
+	s.wait.Wait()
+}
+
+func (s *session) handle(m *message) (interface{}, error) {
+	decode := func(args interface{}) error {
+		if len(m.Arguments) == 0 {
+			return nil
+		}
+		return json.Unmarshal(m.Arguments, args)
+	}
+
+	switch m.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsSetVariable":              true,
+			"supportsReadMemoryRequest":        true,
+			"supportsWriteMemoryRequest":       true,
+			"supportsDisassembleRequest":       true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsSteppingGranularity":      true,
+			"supportsEvaluateForHovers":        true,
+		}, nil
+	case "disconnect", "terminate":
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{"threads": []map[string]interface{}{{"id": threadID, "name": "CPU"}}}, nil
+	case "pause":
+		s.paused.Store(true)
+		if s.debugger != nil {
+			s.debugger.Interrupt()
+		}
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return nil, fmt.Errorf("%s: the target is running", m.Command)
+	}
+	if m.Command != "launch" && s.debugger == nil {
+		return nil, fmt.Errorf("%s: no program launched", m.Command)
+	}
+
+	switch m.Command {
+	case "launch":
+		var args struct {
+			Program     string   `json:"program"`
+			DebugInfo   string   `json:"debugInfo"`
+			Labels      []string `json:"labels"`
+			StopOnEntry bool     `json:"stopOnEntry"`
+		}
+		if err := decode(&args); err != nil {
+			return nil, err
+		}
+		if err := s.launch(args.Program, args.DebugInfo, args.Labels); err != nil {
+			return nil, err
+		}
+		s.stopOnEntry = args.StopOnEntry
+		// configuration requests such as setBreakpoints follow this event
+		s.after = func() { s.event("initialized", nil) }
+		return nil, nil
+	case "configurationDone":
+		if s.stopOnEntry {
+			s.after = func() { s.stopped(hardware.Stop{Reason: hardware.StopStep}, "entry") }
+		} else {
+			s.resume(s.continueRun, "")
+		}
+		return nil, nil
+	case "setBreakpoints":
+		var args struct {
+			Source      source             `json:"source"`
+			Breakpoints []sourceBreakpoint `json:"breakpoints"`
+		}
+		if err := decode(&args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"breakpoints": s.setBreakpoints(args.Source, args.Breakpoints)}, nil
+	case "setInstructionBreakpoints":
+		var args struct {
+			Breakpoints []struct {
+				InstructionReference string `json:"instructionReference"`
+				Offset               int    `json:"offset"`
+				Condition            string `json:"condition"`
+			} `json:"breakpoints"`
+		}
+		if err := decode(&args); err != nil {
+			return nil, err
+		}
+		for _, id := range s.instruction {
+			s.debugger.Delete(id)
+		}
+		s.instruction = nil
+		results := []breakpoint{}
+		for _, b := range args.Breakpoints {
+			address, err := parseReference(b.InstructionReference)
+			if err != nil {
+				results = append(results, breakpoint{Message: err.Error()})
+				continue
+			}
+			bp, err := s.debugger.AddBreakpoint(address+uint16(b.Offset), b.Condition)
+			if err != nil {
+				results = append(results, breakpoint{Message: err.Error()})
+				continue
+			}
+			s.instruction = append(s.instruction, bp.ID)
+			results = append(results, breakpoint{ID: bp.ID, Verified: true})
+		}
+		return map[string]interface{}{"breakpoints": results}, nil
+	case "setExceptionBreakpoints":
+		return map[string]interface{}{"breakpoints": []breakpoint{}}, nil
+	case "continue":
+		s.resume(s.continueRun, "")
+		return map[string]interface{}{"allThreadsContinued": true}, nil
+	case "next", "stepIn", "stepOut":
+		var args struct {
+			Granularity string `json:"granularity"`
+		}
+		if err := decode(&args); err != nil {
+			return nil, err
+		}
+		step := map[string]func() hardware.Stop{
+			"next":    s.debugger.StepOver,
+			"stepIn":  s.debugger.StepIn,
+			"stepOut": s.debugger.StepOut,
+		}[m.Command]
+		if args.Granularity != "instruction" && m.Command != "stepOut" {
+			step = s.lineStep(step)
+		}
+		s.resume(step, "step")
+		return nil, nil
+	case "stackTrace":
+		return s.stackTrace(), nil
+	case "scopes":
+		return map[string]interface{}{"scopes": []scope{
+			{Name: "Registers", VariablesReference: refRegisters},
+			{Name: "Zero page", VariablesReference: refZeroPage},
+			{Name: "Stack", VariablesReference: refStack},
+		}}, nil
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := decode(&args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"variables": s.variables(args.VariablesReference)}, nil
+	case "setVariable":
+		var args struct {
+			VariablesReference int    `json:"variablesReference"`
+			Name               string `json:"name"`
+			Value              string `json:"value"`
+		}
+		if err := decode(&args); err != nil {
+			return nil, err
+		}
+		if args.VariablesReference != refRegisters && args.VariablesReference != refFlags {
+			return nil, fmt.Errorf("only registers and flags can be set")
+		}
+		value, err := s.eval(args.Value)
+		if err == nil {
+			err = s.debugger.SetRegister(args.Name, uint16(value))
+		}
+		if err != nil {
+			return nil, err
+		}
+		v, _ := s.debugger.Register(args.Name)
+		return map[string]interface{}{"value": formatRegister(args.Name, v)}, nil
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+		}
+		if err := decode(&args); err != nil {
+			return nil, err
+		}
+		return s.evaluate(args.Expression)
+	case "readMemory":
+		var args struct {
+			MemoryReference string `json:"memoryReference"`
+			Offset          int    `json:"offset"`
+			Count           int    `json:"count"`
+		}
+		if err := decode(&args); err != nil {
+			return nil, err
+		}
+		base, err := parseReference(args.MemoryReference)
+		if err != nil {
+			return nil, err
+		}
+		address := base + uint16(args.Offset)
+		if args.Count > 0x10000 {
+			args.Count = 0x10000
+		}
+		data := make([]uint8, args.Count)
+		for i := range data {
+			data[i] = s.debugger.Console().Peek(address + uint16(i))
+		}
+		return map[string]interface{}{
+			"address": fmt.Sprintf("0x%04X", address),
+			"data":    base64.StdEncoding.EncodeToString(data),
+		}, nil
+	case "writeMemory":
+		var args struct {
+			MemoryReference string `json:"memoryReference"`
+			Offset          int    `json:"offset"`
+			Data            string `json:"data"`
+		}
+		if err := decode(&args); err != nil {
+			return nil, err
+		}
+		base, err := parseReference(args.MemoryReference)
+		if err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(args.Data)
+		if err != nil {
+			return nil, err
+		}
+		address := base + uint16(args.Offset)
+		for i, b := range data {
+			s.debugger.Console().Poke(address+uint16(i), b)
+		}
+		return map[string]interface{}{"bytesWritten": len(data)}, nil
+	case "disassemble":
+		var args struct {
+			MemoryReference   string `json:"memoryReference"`
+			Offset            int    `json:"offset"`
+			InstructionOffset int    `json:"instructionOffset"`
+			InstructionCount  int    `json:"instructionCount"`
+		}
+		if err := decode(&args); err != nil {
+			return nil, err
+		}
+		base, err := parseReference(args.MemoryReference)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"instructions": s.disassemble(base+uint16(args.Offset), args.InstructionOffset, args.InstructionCount),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported request %q", m.Command)
+}
+
+// launch loads the ROM and its symbols. Debug info defaults to the ROM's
+// name with a .dbg extension, when such a file exists.
+func (s *session) launch(program, debugInfo string, labels []string) error {
+	cart, err := s.load(program)
+	if err != nil {
+		return err
+	}
+	s.symbols = disasm.NewSymbols()
+	s.symbols.SetPRGSize(len(cart.PRG))
+
+	if debugInfo == "" {
+		candidate := strings.TrimSuffix(program, filepath.Ext(program)) + ".dbg"
+		if _, err := os.Stat(candidate); err == nil {
+			debugInfo = candidate
+		}
+	}
+	if debugInfo != "" {
+		file, err := os.Open(debugInfo)
+		if err != nil {
+			return err
+		}
+		s.info, err = disasm.ParseDebugInfo(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", debugInfo, err)
+		}
+		s.symbols.Merge(s.info.Symbols())
+		s.sourceDir, _ = filepath.Abs(filepath.Dir(debugInfo))
+	}
+	for _, path := range labels {
+		if err := s.symbols.LoadFile(path); err != nil {
+			return err
+		}
+	}
+	// a program launched before in this session is done with
+	if err := s.unloadProgram(); err != nil {
+		return err
+	}
+	s.debugger = hardware.NewDebugger(hardware.NewConsole(cart))
+	s.program = program
+	return nil
+}
+
+// resume runs the target on its own goroutine once the response to the
+// current request is sent, and reports where it stopped. reason overrides
+// the reason of a stop that ended normally.
+func (s *session) resume(run func() hardware.Stop, reason string) {
+	s.running = true
+	s.paused.Store(false)
+	s.wait.Add(1)
+	s.after = func() {
+		go func() {
+			defer s.wait.Done()
+			stop := run()
+			s.mu.Lock()
+			s.running = false
+			s.mu.Unlock()
+			s.stopped(stop, reason)
+		}()
+	}
+}
+
+// continueRun runs until something stops the target. It runs in slices so a
+// pause that arrives before the debugger starts isn't lost.
+func (s *session) continueRun() hardware.Stop {
+	for {
+		if s.paused.Load() {
+			return hardware.Stop{Reason: hardware.StopInterrupted}
+		}
+		if stop := s.debugger.Continue(10000); stop.Reason != hardware.StopLimit {
+			return stop
+		}
+	}
+}
+
+func (s *session) stopped(stop hardware.Stop, reason string) {
+	body := map[string]interface{}{"threadId": threadID, "allThreadsStopped": true}
+	switch stop.Reason {
+	case hardware.StopBreakpoint:
+		reason = "breakpoint"
+		body["hitBreakpointIds"] = []int{stop.ID}
+	case hardware.StopWatchpoint:
+		reason = "data breakpoint"
+		body["description"] = stop.String()
+	case hardware.StopInterrupted:
+		reason = "pause"
+	case hardware.StopHalted:
+		reason = "exception"
+		body["description"] = stop.String()
+	}
+	if reason == "" {
+		reason = "step"
+	}
+	body["reason"] = reason
+	s.event("stopped", body)
+}
+
+// lineStep repeats an instruction step until the PC reaches a different
+// source line. Without debug info it is a single step.
+func (s *session) lineStep(step func() hardware.Stop) func() hardware.Stop {
+	if s.info == nil {
+		return step
+	}
+	pc := func() uint16 { return s.debugger.Console().CPU().Registers().PC }
+	return func() hardware.Stop {
+		file, line, _ := s.info.AddressLine(pc())
+		var stop hardware.Stop
+		for i := 0; i < maxLineSteps; i++ {
+			if s.paused.Load() {
+				return hardware.Stop{Reason: hardware.StopInterrupted}
+			}
+			if stop = step(); stop.Reason != hardware.StopStep {
+				return stop
+			}
+			if f, l, ok := s.info.AddressLine(pc()); ok && (f != file || l != line) {
+				return stop
+			}
+		}
+		return stop
+	}
+}
+
+func (s *session) setBreakpoints(src source, requested []sourceBreakpoint) []breakpoint {
+	for _, id := range s.breakpoints[src.Path] {
+		s.debugger.Delete(id)
+	}
+	s.breakpoints[src.Path] = nil
+
+	results := []breakpoint{}
+	for _, b := range requested {
+		result := breakpoint{Source: &src, Line: b.Line}
+		var addresses []uint16
+		if s.info != nil {
+			addresses = s.info.LineAddresses(src.Path, b.Line)
+		}
+		if len(addresses) == 0 {
+			result.Message = "no code at this line"
+		}
+		for _, address := range addresses {
+			bp, err := s.debugger.AddBreakpoint(address, b.Condition)
+			if err != nil {
+				result.Message = err.Error()
+				break
+			}
+			s.breakpoints[src.Path] = append(s.breakpoints[src.Path], bp.ID)
+			result.ID = bp.ID
+			result.Verified = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (s *session) stackTrace() interface{} {
+	var frames []stackFrame
+	for i, pc := range callStack(s.debugger.Console()) {
+		f := stackFrame{
+			ID:                          i + 1,
+			Name:                        fmt.Sprintf("$%04X", pc),
+			InstructionPointerReference: fmt.Sprintf("0x%04X", pc),
+		}
+		if name, offset, ok := s.symbols.Nearest(pc, 0x1000); ok {
+			f.Name = name
+			if offset != 0 {
+				f.Name += fmt.Sprintf("+%d", offset)
+			}
+		}
+		if s.info != nil {
+			if file, line, ok := s.info.AddressLine(pc); ok {
+				f.Source = s.source(file)
+				f.Line, f.Column = line, 1
+			}
+		}
+		frames = append(frames, f)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+// source describes a file named in the debug info for the editor
+func (s *session) source(file string) *source {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(s.sourceDir, file)
+	}
+	return &source{Name: filepath.Base(file), Path: file}
+}
+
+func formatRegister(name string, value uint16) string {
+	switch strings.ToUpper(name) {
+	case "PC":
+		return fmt.Sprintf("$%04X", value)
+	case "A", "X", "Y", "P", "SP":
+		return fmt.Sprintf("$%02X", value)
+	}
+	return strconv.Itoa(int(value))
+}
+
+func (s *session) variables(ref int) []variable {
+	console := s.debugger.Console()
+	vars := []variable{}
+	switch ref {
+	case refRegisters:
+		for _, name := range []string{"A", "X", "Y", "P", "SP", "PC"} {
+			value, _ := s.debugger.Register(name)
+			v := variable{Name: name, Value: formatRegister(name, value)}
+			if name == "P" {
+				v.VariablesReference = refFlags
+			}
+			vars = append(vars, v)
+		}
+	case refFlags:
+		for _, name := range []string{"N", "V", "D", "I", "Z", "C"} {
+			value, _ := s.debugger.Register(name)
+			vars = append(vars, variable{Name: name, Value: formatRegister(name, value)})
+		}
+	case refZeroPage, refStack:
+		start := uint16(0)
+		if ref == refStack {
+			start = hardware.STACK_START
+		}
+		for row := start; row < start+0x100; row += 16 {
+			var bytes []string
+			for i := uint16(0); i < 16; i++ {
+				bytes = append(bytes, fmt.Sprintf("%02X", console.Peek(row+i)))
+			}
+			vars = append(vars, variable{
+				Name:            fmt.Sprintf("$%04X", row),
+				Value:           strings.Join(bytes, " "),
+				MemoryReference: fmt.Sprintf("0x%04X", row),
+			})
+		}
+	}
+	return vars
+}
+
+// eval evaluates a debugger expression, where a bare symbol name stands for
+// its address
+func (s *session) eval(text string) (int, error) {
+	text = strings.TrimSpace(text)
+	if address, ok := s.symbols.Address(text); ok {
+		return int(address), nil
+	}
+	expr, err := hardware.ParseExpr(text)
+	if err != nil {
+		return 0, err
+	}
+	return expr.Eval(s.debugger.Console()), nil
+}
+
+func (s *session) evaluate(text string) (interface{}, error) {
+	// a symbol shows the byte it labels
+	if address, ok := s.symbols.Address(strings.TrimSpace(text)); ok {
+		return map[string]interface{}{
+			"result":             fmt.Sprintf("$%02X", s.debugger.Console().Peek(address)),
+			"memoryReference":    fmt.Sprintf("0x%04X", address),
+			"variablesReference": 0,
+		}, nil
+	}
+	value, err := s.eval(text)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"result":             fmt.Sprintf("$%X (%d)", value, value),
+		"variablesReference": 0,
+	}, nil
+}
+
+// disassemble returns count instructions starting offset instructions away
+// from address. Going backwards decodes from a few bytes earlier and keeps
+// the instructions that line up with address.
+func (s *session) disassemble(address uint16, offset, count int) []map[string]interface{} {
+	console := s.debugger.Console()
+	start := address
+	if offset < 0 {
+		var before []uint16
+		for a := int(address) + 3*offset; a < int(address); {
+			if a < 0 {
+				a++
+				continue
+			}
+			before = append(before, uint16(a))
+			a += disasm.Decode(console.Peek, uint16(a)).Size()
+		}
+		if len(before) >= -offset {
+			start = before[len(before)+offset]
+		} else if len(before) > 0 {
+			start = before[0]
+		}
+		offset = 0
+	}
+	for ; offset > 0; offset-- {
+		start += uint16(disasm.Decode(console.Peek, start).Size())
+	}
+
+	var out []map[string]interface{}
+	for a := start; len(out) < count; {
+		in := disasm.Decode(console.Peek, a)
+		entry := map[string]interface{}{
+			"address":          fmt.Sprintf("0x%04X", a),
+			"instructionBytes": strings.TrimSpace(in.HexBytes()),
+			"instruction":      in.Format(s.symbols),
+		}
+		if name, ok := s.symbols.Lookup(a); ok {
+			entry["symbol"] = name
+		}
+		if s.info != nil {
+			if file, line, ok := s.info.AddressLine(a); ok {
+				entry["location"] = s.source(file)
+				entry["line"] = line
+			}
+		}
+		out = append(out, entry)
+		a += uint16(in.Size())
+	}
+	return out
+}
+
+// parseReference parses a memory or instruction reference, "0xC000" or "$C000"
+func parseReference(text string) (uint16, error) {
+	value, err := strconv.ParseUint(strings.Replace(text, "$", "0x", 1), 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad memory reference %q", text)
+	}
+	return uint16(value), nil
+}
@@ -0,0 +1,245 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// client plays the editor's side of a session over one end of a pipe
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *textproto.Reader
+	seq    int
+	events []map[string]interface{} // read while waiting for a response
+}
+
+func newSession(t *testing.T, srv *Server) (*client, <-chan error) {
+	t.Helper()
+	server, editor := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(server)
+		server.Close()
+	}()
+	t.Cleanup(func() { editor.Close() })
+	return &client{t: t, conn: editor, r: textproto.NewReader(bufio.NewReader(editor))}, done
+}
+
+func (c *client) read() map[string]interface{} {
+	c.t.Helper()
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	body := make([]uint8, length)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		c.t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		c.t.Fatal(err)
+	}
+	return m
+}
+
+// call sends a request and returns the body of its response, or the
+// response message as an error
+func (c *client) call(command string, args interface{}) (map[string]interface{}, error) {
+	c.t.Helper()
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command}
+	if args != nil {
+		req["arguments"] = args
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		m := c.read()
+		if m["type"] == "event" {
+			c.events = append(c.events, m)
+			continue
+		}
+		if m["request_seq"] != float64(c.seq) || m["command"] != command {
+			c.t.Fatalf("%s: unexpected response %v", command, m)
+		}
+		if m["success"] != true {
+			msg, _ := m["message"].(string)
+			return nil, errors.New(msg)
+		}
+		body, _ := m["body"].(map[string]interface{})
+		return body, nil
+	}
+}
+
+func (c *client) mustCall(command string, args interface{}) map[string]interface{} {
+	c.t.Helper()
+	body, err := c.call(command, args)
+	if err != nil {
+		c.t.Fatalf("%s: %v", command, err)
+	}
+	return body
+}
+
+// event returns the body of the next event, which must be name
+func (c *client) event(name string) map[string]interface{} {
+	c.t.Helper()
+	var m map[string]interface{}
+	if len(c.events) > 0 {
+		m, c.events = c.events[0], c.events[1:]
+	} else {
+		m = c.read()
+	}
+	if m["type"] != "event" || m["event"] != name {
+		c.t.Fatalf("got %v, want a %s event", m, name)
+	}
+	body, _ := m["body"].(map[string]interface{})
+	return body
+}
+
+func (c *client) stopped(reason string) {
+	c.t.Helper()
+	if got := c.event("stopped")["reason"]; got != reason {
+		c.t.Errorf("stopped for %v, want %s", got, reason)
+	}
+}
+
+func TestSession(t *testing.T) {
+	c, done := newSession(t, &Server{})
+	if body := c.mustCall("initialize", map[string]interface{}{"adapterID": "nesemu"}); body["supportsConfigurationDoneRequest"] != true {
+		t.Errorf("initialize: %v", body)
+	}
+	c.mustCall("launch", map[string]interface{}{"program": "../hardware/nestest.nes", "stopOnEntry": true})
+	c.event("initialized")
+	body := c.mustCall("setInstructionBreakpoints", map[string]interface{}{
+		"breakpoints": []map[string]interface{}{{"instructionReference": "0xC72D"}},
+	})
+	if bps := body["breakpoints"].([]interface{}); len(bps) != 1 || bps[0].(map[string]interface{})["verified"] != true {
+		t.Errorf("setInstructionBreakpoints: %v", body)
+	}
+	c.mustCall("configurationDone", nil)
+	c.stopped("entry")
+
+	// nestest's automated mode starts at $C000
+	body = c.mustCall("setVariable", map[string]interface{}{"variablesReference": refRegisters, "name": "PC", "value": "$C000"})
+	if body["value"] != "$C000" {
+		t.Errorf("setVariable PC: %v", body)
+	}
+	if body := c.mustCall("readMemory", map[string]interface{}{"memoryReference": "0xC000", "count": 3}); body["data"] != base64.StdEncoding.EncodeToString([]uint8{0x4C, 0xF5, 0xC5}) {
+		t.Errorf("readMemory: %v", body)
+	}
+
+	c.mustCall("continue", map[string]interface{}{"threadId": threadID})
+	c.stopped("breakpoint")
+	// JSR $C72D at $C5FD is the only call so far
+	var frames []string
+	for _, f := range c.mustCall("stackTrace", map[string]interface{}{"threadId": threadID})["stackFrames"].([]interface{}) {
+		frames = append(frames, f.(map[string]interface{})["instructionPointerReference"].(string))
+	}
+	if fmt.Sprint(frames) != "[0xC72D 0xC5FD]" {
+		t.Errorf("call stack %v, want [0xC72D 0xC5FD]", frames)
+	}
+	registers := map[string]string{}
+	for _, v := range c.mustCall("variables", map[string]interface{}{"variablesReference": refRegisters})["variables"].([]interface{}) {
+		v := v.(map[string]interface{})
+		registers[v["name"].(string)] = v["value"].(string)
+	}
+	if registers["PC"] != "$C72D" || registers["SP"] != "$FB" {
+		t.Errorf("registers %v, want PC $C72D and SP $FB", registers)
+	}
+	if body := c.mustCall("evaluate", map[string]interface{}{"expression": "SP + 1"}); body["result"] != "$FC (252)" {
+		t.Errorf("evaluate: %v", body)
+	}
+
+	c.mustCall("next", map[string]interface{}{"threadId": threadID, "granularity": "instruction"})
+	c.stopped("step")
+
+	// without breakpoints nestest runs on to its first undocumented opcode
+	c.mustCall("setInstructionBreakpoints", map[string]interface{}{"breakpoints": []interface{}{}})
+	c.mustCall("continue", map[string]interface{}{"threadId": threadID})
+	c.stopped("exception")
+	if _, err := c.call("bogus", nil); err == nil {
+		t.Error("an unknown request succeeded")
+	}
+
+	c.mustCall("disconnect", nil)
+	if err := <-done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
+
+func TestLaunchUsesLoad(t *testing.T) {
+	var loaded []string
+	srv := &Server{Load: func(path string) (*hardware.Cartridge, error) {
+		loaded = append(loaded, path)
+		return nil, errors.New("no such ROM")
+	}}
+	c, done := newSession(t, srv)
+	c.mustCall("initialize", nil)
+	if _, err := c.call("launch", map[string]interface{}{"program": "game.nes"}); err == nil || err.Error() != "no such ROM" {
+		t.Errorf("launch error %v, want the one from Load", err)
+	}
+	if fmt.Sprint(loaded) != "[game.nes]" {
+		t.Errorf("Load called with %v", loaded)
+	}
+	if _, err := c.call("stackTrace", nil); err == nil {
+		t.Error("stackTrace succeeded without a program")
+	}
+	c.mustCall("disconnect", nil)
+	if err := <-done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
+
+func TestDisconnectUnloads(t *testing.T) {
+	type unloaded struct {
+		path string
+		cart *hardware.Cartridge
+	}
+	var got []unloaded
+	var launched []*hardware.Cartridge
+	srv := &Server{
+		Load: func(path string) (*hardware.Cartridge, error) {
+			cart, err := (&Server{}).load(path)
+			launched = append(launched, cart)
+			return cart, err
+		},
+		Unload: func(path string, cart *hardware.Cartridge) error {
+			got = append(got, unloaded{path, cart})
+			return errors.New("disk full")
+		},
+	}
+	c, done := newSession(t, srv)
+	c.mustCall("initialize", nil)
+	c.mustCall("launch", map[string]interface{}{"program": "../hardware/nestest.nes"})
+	c.event("initialized")
+	if len(got) != 0 {
+		t.Fatalf("unloaded %v before the session ended", got)
+	}
+	c.mustCall("disconnect", nil)
+	if err := <-done; err == nil || err.Error() != "disk full" {
+		t.Errorf("Serve returned %v, want the error from Unload", err)
+	}
+	if len(got) != 1 || got[0].path != "../hardware/nestest.nes" || got[0].cart != launched[0] {
+		t.Errorf("unloaded %v, want the launched cartridge", got)
+	}
+}
@@ -0,0 +1,23 @@
+package dap
+
+import "github.com/tejasdeepakmasne/nesemu-go/hardware"
+
+// callStack returns the PC followed by the address of each JSR found on the
+// stack page, innermost first. The 6502 keeps no frame pointers, so any pair
+// of bytes above SP that is the return address of a JSR is taken as a call:
+// JSR pushes the address of its own last byte, high byte first, leaving the
+// low byte at the lower address.
+func callStack(console *hardware.Console) []uint16 {
+	regs := console.CPU().Registers()
+	pcs := []uint16{regs.PC}
+	for sp := int(regs.SP) + 1; sp < 0xFF; sp++ {
+		low := console.Peek(hardware.STACK_START + uint16(sp))
+		high := console.Peek(hardware.STACK_START + uint16(sp+1))
+		jsr := (uint16(low) | uint16(high)<<8) - 2
+		if jsr >= 0x8000 && console.Peek(jsr) == 0x20 {
+			pcs = append(pcs, jsr)
+			sp++ // both bytes belong to this frame
+		}
+	}
+	return pcs
+}
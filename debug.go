@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/cheats"
+	"github.com/tejasdeepakmasne/nesemu-go/disasm"
+	"github.com/tejasdeepakmasne/nesemu-go/gdbstub"
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+const debugHelp = `commands:
+  s, step [n]            execute n instructions (default 1)
+  n, next                step over a JSR
+  out, finish            run until the current subroutine returns
+  c, continue            run until a breakpoint, watchpoint or Ctrl-C
+  frame                  run until the end of the frame
+  rewind [n]             go back n frames (default 1) from the end of the last one
+  b, break ADDR [if EXPR]
+                         break before executing ADDR, optionally only when EXPR holds
+  w, watch ADDR[..END] [rwx]
+                         stop on reads, writes or execution in a range (default rw)
+  d, delete ID           remove a breakpoint or watchpoint
+  i, info                list breakpoints and watchpoints
+  r, regs                show registers
+  set REG VALUE          set A, X, Y, P, SP, PC or a flag C, Z, I, D, V, N
+  x ADDR [LEN]           dump memory
+  l, list [ADDR] [N]     disassemble N instructions (default 10 from PC)
+  cheat [list]           list cheats
+  cheat add CODE [NAME]  add a Game Genie or AAAA:VV freeze code
+  cheat on|off|del N     enable, disable or remove cheat N
+  cheat save FILE        write the cheats to a cheat file
+  disk [N|eject]         list FDS disk sides, insert side N or eject the disk
+  reset                  press the reset button
+  q, quit                exit
+Addresses and values are expressions, e.g. $8000, PC+3 or [$FFFC]
+`
+
+func runDebug(args []string) int {
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: nesemu-go debug [flags] rom.nes")
+		fs.PrintDefaults()
+	}
+	labels := fs.String("labels", "", "comma separated label files (.nl, .mlb or ca65 .dbg)")
+	cheatFile := fs.String("cheats", "", "cheat file to load, by default the ROM's .cht file if there is one")
+	gdb := fs.String("gdb", "", "serve the GDB remote protocol on this address, e.g. 127.0.0.1:6502, instead of the command line")
+	romOpts := addROMFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError
+	}
+	cart, err := romOpts.load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	sym, err := loadSymbols(*labels, cart)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	d := hardware.NewDebugger(hardware.NewConsole(cart))
+	d.EnableRewind(hardware.DefaultRewindConfig)
+	engine := cheats.New(d.Console())
+	if *cheatFile == "" {
+		if path := cheats.PathFor(fs.Arg(0)); isFile(path) {
+			*cheatFile = path
+		}
+	}
+	if *cheatFile != "" {
+		if err := engine.LoadFile(*cheatFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+
+	// Ctrl-C breaks into a running program instead of quitting
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+			d.Interrupt()
+		}
+	}()
+
+	if *gdb != "" {
+		fmt.Fprintf(os.Stderr, "waiting for gdb on %s\n", *gdb)
+		if err := gdbstub.NewServer(d).ListenAndServe(*gdb); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	} else {
+		repl(d, sym, engine, os.Stdin, os.Stdout)
+	}
+	if err := romOpts.saveDisk(fs.Arg(0), cart); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitPass
+}
+
+func repl(d *hardware.Debugger, sym *disasm.Symbols, engine *cheats.Engine, in io.Reader, out io.Writer) {
+	printRegisters(d, sym, out)
+	scanner := bufio.NewScanner(in)
+	last := ""
+	for {
+		fmt.Fprint(out, "(nesdbg) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		// an empty line repeats the previous command, like gdb
+		if line == "" {
+			line = last
+		}
+		last = line
+		if line == "" {
+			continue
+		}
+		if quit := debugCommand(d, sym, engine, line, out); quit {
+			return
+		}
+	}
+}
+
+func debugCommand(d *hardware.Debugger, sym *disasm.Symbols, engine *cheats.Engine, line string, out io.Writer) bool {
+	cmd, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+	console := d.Console()
+
+	showStop := func(stop hardware.Stop) {
+		if stop.Reason != hardware.StopStep {
+			fmt.Fprintln(out, stop)
+		}
+		printRegisters(d, sym, out)
+	}
+
+	switch cmd {
+	case "q", "quit":
+		return true
+	case "h", "help", "?":
+		fmt.Fprint(out, debugHelp)
+	case "s", "step":
+		count := 1
+		if rest != "" {
+			n, err := strconv.Atoi(rest)
+			if err != nil || n < 1 {
+				fmt.Fprintf(out, "bad count %q\n", rest)
+				return false
+			}
+			count = n
+		}
+		stop := hardware.Stop{}
+		for i := 0; i < count && stop.Reason == hardware.StopStep; i++ {
+			stop = d.StepIn()
+		}
+		showStop(stop)
+	case "n", "next":
+		showStop(d.StepOver())
+	case "out", "finish":
+		showStop(d.StepOut())
+	case "c", "continue":
+		showStop(d.Continue(0))
+	case "frame":
+		showStop(d.ContinueFrame())
+	case "rewind":
+		count := 1
+		if rest != "" {
+			n, err := strconv.Atoi(rest)
+			if err != nil || n < 1 {
+				fmt.Fprintf(out, "bad count %q\n", rest)
+				return false
+			}
+			count = n
+		}
+		if done := d.Rewind(count); done < count {
+			fmt.Fprintf(out, "went back %d of %d frames, the history ends there\n", done, count)
+		}
+		printRegisters(d, sym, out)
+	case "b", "break":
+		addrExpr, cond, _ := strings.Cut(rest, " if ")
+		address, err := evalDebugExpr(console, addrExpr)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return false
+		}
+		bp, err := d.AddBreakpoint(uint16(address), strings.TrimSpace(cond))
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return false
+		}
+		fmt.Fprintf(out, "breakpoint %d at $%04X\n", bp.ID, bp.Address)
+	case "w", "watch":
+		start, end, kind, err := parseWatch(console, rest)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return false
+		}
+		wp := d.AddWatchpoint(uint16(start), uint16(end), kind)
+		fmt.Fprintf(out, "watchpoint %d on $%04X-$%04X %v\n", wp.ID, wp.Start, wp.End, wp.Kind)
+	case "d", "delete":
+		id, err := strconv.Atoi(rest)
+		if err != nil || !d.Delete(id) {
+			fmt.Fprintf(out, "no breakpoint or watchpoint %q\n", rest)
+		}
+	case "i", "info":
+		for _, bp := range d.Breakpoints() {
+			fmt.Fprintf(out, "%3d break $%04X", bp.ID, bp.Address)
+			if bp.Condition != nil {
+				fmt.Fprintf(out, " if %v", bp.Condition)
+			}
+			fmt.Fprintln(out)
+		}
+		for _, wp := range d.Watchpoints() {
+			fmt.Fprintf(out, "%3d watch $%04X-$%04X %v\n", wp.ID, wp.Start, wp.End, wp.Kind)
+		}
+	case "r", "regs":
+		printRegisters(d, sym, out)
+	case "set":
+		name, valueExpr, _ := strings.Cut(rest, " ")
+		value, err := evalDebugExpr(console, valueExpr)
+		if err == nil {
+			err = d.SetRegister(name, uint16(value))
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return false
+		}
+		printRegisters(d, sym, out)
+	case "x":
+		addrExpr, lenExpr, _ := strings.Cut(rest, " ")
+		address, err := evalDebugExpr(console, addrExpr)
+		length := 64
+		if err == nil && lenExpr != "" {
+			length, err = evalDebugExpr(console, lenExpr)
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return false
+		}
+		dumpMemory(console, uint16(address), length, out)
+	case "l", "list":
+		addrExpr, countExpr, _ := strings.Cut(rest, " ")
+		address, count := int(console.CPU().Registers().PC), 10
+		var err error
+		if addrExpr != "" {
+			address, err = evalDebugExpr(console, addrExpr)
+		}
+		if err == nil && countExpr != "" {
+			count, err = evalDebugExpr(console, countExpr)
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return false
+		}
+		printInstructions(console, sym, uint16(address), count, out)
+	case "cheat":
+		cheatCommand(engine, rest, out)
+	case "disk":
+		diskCommand(console.Cartridge(), rest, out)
+	case "reset":
+		console.Reset()
+		printRegisters(d, sym, out)
+	default:
+		fmt.Fprintf(out, "unknown command %q, try help\n", cmd)
+	}
+	return false
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func cheatCommand(engine *cheats.Engine, args string, out io.Writer) {
+	sub, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+	switch sub {
+	case "", "list":
+		for i, c := range engine.Cheats() {
+			state := "on "
+			if !c.Enabled {
+				state = "off"
+			}
+			fmt.Fprintf(out, "%3d %s %v\n", i+1, state, c)
+		}
+	case "add":
+		code, name, _ := strings.Cut(rest, " ")
+		c, err := engine.Add(code, strings.TrimSpace(name))
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return
+		}
+		fmt.Fprintf(out, "cheat %d: %v\n", len(engine.Cheats()), c)
+	case "on", "off", "del":
+		n, err := strconv.Atoi(rest)
+		if err == nil {
+			if sub == "del" {
+				err = engine.Remove(n - 1)
+			} else {
+				err = engine.SetEnabled(n-1, sub == "on")
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(out, "no cheat %q\n", rest)
+		}
+	case "save":
+		if rest == "" {
+			fmt.Fprintln(out, "usage: cheat save FILE")
+			return
+		}
+		if err := engine.SaveFile(rest); err != nil {
+			fmt.Fprintln(out, err)
+		}
+	default:
+		fmt.Fprintf(out, "unknown cheat command %q, try help\n", sub)
+	}
+}
+
+// parseWatch parses the arguments of watch: an address or an inclusive
+// START..END range, then optionally the access kinds. Expressions may
+// contain spaces, so kinds are only taken from a last word made of r, w
+// and x alone.
+func parseWatch(console *hardware.Console, args string) (start, end int, kind hardware.AccessKind, err error) {
+	kind = hardware.AccessRead | hardware.AccessWrite
+	rangeExpr := strings.TrimSpace(args)
+	if i := strings.LastIndexAny(rangeExpr, " \t"); i >= 0 {
+		if kinds := rangeExpr[i+1:]; strings.Trim(kinds, "rwx") == "" {
+			rangeExpr = rangeExpr[:i]
+			kind = 0
+			for _, letter := range kinds {
+				switch letter {
+				case 'r':
+					kind |= hardware.AccessRead
+				case 'w':
+					kind |= hardware.AccessWrite
+				case 'x':
+					kind |= hardware.AccessExec
+				}
+			}
+		}
+	}
+	startExpr, endExpr, isRange := strings.Cut(rangeExpr, "..")
+	if start, err = evalDebugExpr(console, startExpr); err != nil {
+		return
+	}
+	end = start
+	if isRange {
+		if end, err = evalDebugExpr(console, endExpr); err != nil {
+			return
+		}
+	}
+	if end < start {
+		err = fmt.Errorf("watch range $%04X..$%04X ends before it starts", start, end)
+	}
+	return
+}
+
+func evalDebugExpr(console *hardware.Console, source string) (int, error) {
+	expr, err := hardware.ParseExpr(strings.TrimSpace(source))
+	if err != nil {
+		return 0, err
+	}
+	return expr.Eval(console), nil
+}
+
+func printRegisters(d *hardware.Debugger, sym *disasm.Symbols, out io.Writer) {
+	console := d.Console()
+	regs := console.CPU().Registers()
+	flags := []uint8("nv-bdizc")
+	for i, flag := range []hardware.Flags{hardware.N, hardware.V, hardware.X, hardware.B, hardware.D, hardware.I, hardware.Z, hardware.C} {
+		if regs.Flag(flag) && flags[i] != '-' {
+			flags[i] -= 'a' - 'A'
+		}
+	}
+	fmt.Fprintf(out, "PC:%04X A:%02X X:%02X Y:%02X P:%02X SP:%02X %s  depth:%d cycle:%d\n",
+		regs.PC, regs.A, regs.X, regs.Y, regs.P, regs.SP, flags, d.Depth(), console.Cycles())
+	printInstructions(console, sym, regs.PC, 1, out)
+}
+
+// printInstructions disassembles count instructions starting at address
+func printInstructions(console *hardware.Console, sym *disasm.Symbols, address uint16, count int, out io.Writer) {
+	for i := 0; i < count; i++ {
+		if name, ok := sym.Lookup(address); ok {
+			fmt.Fprintf(out, "%s:\n", name)
+		}
+		in := disasm.Decode(console.Peek, address)
+		fmt.Fprintf(out, "  $%04X  %s  %s\n", address, in.HexBytes(), in.Format(sym))
+		address += uint16(in.Size())
+	}
+}
+
+func dumpMemory(console *hardware.Console, address uint16, length int, out io.Writer) {
+	for row := 0; row < length; row += 16 {
+		fmt.Fprintf(out, "%04X:", address+uint16(row))
+		for col := 0; col < 16 && row+col < length; col++ {
+			fmt.Fprintf(out, " %02X", console.Peek(address+uint16(row+col)))
+		}
+		fmt.Fprintln(out)
+	}
+}
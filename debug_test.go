@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+func TestParseWatch(t *testing.T) {
+	rom, err := os.ReadFile("hardware/nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := hardware.ParseROM(rom, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console := hardware.NewConsole(cart)
+	regs := console.CPU().Registers()
+	regs.PC = 0xC000
+	console.CPU().SetRegisters(regs)
+
+	rw := hardware.AccessRead | hardware.AccessWrite
+	tests := []struct {
+		args       string
+		start, end int
+		kind       hardware.AccessKind
+	}{
+		{"$10", 0x10, 0x10, rw},
+		{"$10 w", 0x10, 0x10, hardware.AccessWrite},
+		{"$0200..$02FF", 0x200, 0x2FF, rw},
+		{"$0200..$02FF rx", 0x200, 0x2FF, hardware.AccessRead | hardware.AccessExec},
+		{"PC-3", 0xBFFD, 0xBFFD, rw},
+		{"PC - 3 x", 0xBFFD, 0xBFFD, hardware.AccessExec},
+		{"PC-3..PC+3", 0xBFFD, 0xC003, rw},
+		{"$0300-$10 .. $0300+$10 w", 0x2F0, 0x310, hardware.AccessWrite},
+		{"[$FFFC]..[$FFFC]+1", 0x04, 0x05, rw},
+	}
+	for _, tt := range tests {
+		start, end, kind, err := parseWatch(console, tt.args)
+		if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+		if start != tt.start || end != tt.end || kind != tt.kind {
+			t.Errorf("%q: got $%04X..$%04X %v, want $%04X..$%04X %v", tt.args, start, end, kind, tt.start, tt.end, tt.kind)
+		}
+	}
+	for _, args := range []string{"", "$20..$10", "$10..", "..$10", "$10...$20", "$10 q"} {
+		if _, _, _, err := parseWatch(console, args); err == nil {
+			t.Errorf("%q: no error", args)
+		}
+	}
+}
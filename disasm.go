@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/disasm"
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+const disasmUsage = `usage: nesemu-go disasm [flags] rom.nes
+
+Disassembles PRG-ROM as mapped at $8000. With -cdl only bytes the code/data
+log saw executing are decoded, the rest is listed as data.
+
+`
+
+func runDisasm(args []string) int {
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), disasmUsage)
+		fs.PrintDefaults()
+	}
+	labels := fs.String("labels", "", "comma separated label files (.nl, .mlb or ca65 .dbg)")
+	cdlPath := fs.String("cdl", "", "FCEUX code/data log for code/data separation")
+	startText := fs.String("start", "$8000", "first address to list")
+	endText := fs.String("end", "$FFFF", "last address to list")
+	romOpts := addROMFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError
+	}
+
+	cart, err := romOpts.load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	start, err := parseAddress(*startText)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	end, err := parseAddress(*endText)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	sym, err := loadSymbols(*labels, cart)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	var cdl *disasm.CodeDataLog
+	if *cdlPath != "" {
+		if cdl, err = disasm.ReadCDLFile(*cdlPath, cart); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	err = disasm.Listing(out, cart.PRG, start, end, cdl, sym)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitPass
+}
+
+// loadSymbols reads a comma separated list of label files, returning nil
+// when there are none
+func loadSymbols(paths string, cart *hardware.Cartridge) (*disasm.Symbols, error) {
+	if paths == "" {
+		return nil, nil
+	}
+	sym := disasm.NewSymbols()
+	sym.SetPRGSize(len(cart.PRG))
+	for _, path := range strings.Split(paths, ",") {
+		if err := sym.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return sym, nil
+}
+
+// parseAddress accepts $C000, 0xC000 or decimal
+func parseAddress(text string) (uint16, error) {
+	value, err := strconv.ParseUint(strings.Replace(text, "$", "0x", 1), 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", text)
+	}
+	return uint16(value), nil
+}
@@ -0,0 +1,124 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// flags of a PRG-ROM byte in an FCEUX code/data log
+const (
+	CDLCode         = 0x01
+	CDLData         = 0x02
+	CDLBankMask     = 0x0C // (CPU address >> 13) & 3 the byte was seen at
+	CDLIndirectCode = 0x10
+	CDLIndirectData = 0x20
+	CDLPCMData      = 0x40
+)
+
+// CodeDataLog records how each ROM byte was used, one flag byte per byte of
+// PRG-ROM followed by one per byte of CHR-ROM, the layout FCEUX uses for .cdl files
+type CodeDataLog struct {
+	PRG []uint8
+	CHR []uint8
+}
+
+// ReadCDL reads a code/data log for a ROM with the given PRG and CHR sizes
+func ReadCDL(r io.Reader, prgSize, chrSize int) (*CodeDataLog, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != prgSize+chrSize {
+		return nil, fmt.Errorf("code/data log is %d bytes, the ROM needs %d", len(data), prgSize+chrSize)
+	}
+	return &CodeDataLog{PRG: data[:prgSize], CHR: data[prgSize:]}, nil
+}
+
+// Listing writes a disassembly of PRG-ROM between start and end inclusive,
+// PRG being mapped at $8000 and mirrored as on NROM.
+//
+// Without a log every byte is decoded as code. With one, bytes logged as code
+// become instructions and everything else is emitted as .byte lines, with
+// bytes that never ran or were never read marked as unknown.
+func Listing(w io.Writer, prg []uint8, start, end uint16, cdl *CodeDataLog, sym *Symbols) error {
+	if len(prg) == 0 {
+		return fmt.Errorf("empty PRG-ROM")
+	}
+	offset := func(address uint16) int {
+		return int(address-0x8000) % len(prg)
+	}
+	read := func(address uint16) uint8 {
+		return prg[offset(address)]
+	}
+	flags := func(address uint16) uint8 {
+		if cdl == nil {
+			return CDLCode
+		}
+		return cdl.PRG[offset(address)]
+	}
+	if start < 0x8000 {
+		return fmt.Errorf("$%04X is not in PRG-ROM", start)
+	}
+
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	label := func(address uint16) {
+		if name, ok := sym.Lookup(address); ok {
+			printf("%s:\n", name)
+		}
+	}
+
+	address := int(start)
+	for address <= int(end) && err == nil {
+		a := uint16(address)
+		label(a)
+		f := flags(a)
+		if f&CDLCode != 0 {
+			in := Decode(read, a)
+			// an instruction running past end or into non-code bytes is cut short
+			fits := address+in.Size()-1 <= int(end)
+			for i := 1; i < in.Size() && fits; i++ {
+				if flags(a+uint16(i))&CDLCode == 0 && cdl != nil {
+					fits = false
+				}
+			}
+			if in.Valid() && fits {
+				printf("  $%04X  %s  %s\n", a, in.HexBytes(), in.Format(sym))
+				address += in.Size()
+				continue
+			}
+		}
+
+		// group data bytes of the same kind, at most 8 a line. A code byte
+		// that didn't decode stands alone so the next instruction still lines up.
+		code := f & CDLCode
+		unknown := f&(CDLCode|CDLData) == 0
+		limit := 8
+		if code != 0 {
+			limit = 1
+		}
+		var bytes []string
+		for len(bytes) < limit && address <= int(end) {
+			b := uint16(address)
+			if len(bytes) > 0 {
+				next := flags(b)
+				if _, labeled := sym.Lookup(b); labeled || next&CDLCode != code || (next&(CDLCode|CDLData) == 0) != unknown {
+					break
+				}
+			}
+			bytes = append(bytes, fmt.Sprintf("$%02X", read(b)))
+			address++
+		}
+		comment := ""
+		if unknown {
+			comment = " ; unknown"
+		}
+		printf("  $%04X  .byte %s%s\n", a, strings.Join(bytes, ","), comment)
+	}
+	return err
+}
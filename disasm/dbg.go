@@ -0,0 +1,257 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DebugInfo holds the parts of a ca65/ld65 debug file (ld65 --dbgfile) needed
+// to map between addresses, symbols and source lines. Records look like
+//
+//	file	id=0,name="main.s",size=1234,mtime=0x5F0C1A2B,mod=0
+//	seg	id=0,name="CODE",start=0x008000,size=0x0123,addrsize=absolute,type=ro,oname="game.nes",ooffs=16
+//	span	id=0,seg=0,start=0,size=3
+//	line	id=0,file=0,line=12,span=0
+//	sym	id=0,name="reset",addrsize=absolute,scope=0,def=1,val=0x8000,seg=0,type=lab
+type DebugInfo struct {
+	Files    []DebugFile
+	Segments []DebugSegment
+	Spans    []DebugSpan
+	Lines    []DebugLine
+	Syms     []DebugSymbol
+
+	lineAt map[uint16]int // address -> index into Lines
+}
+
+type DebugFile struct {
+	ID   int
+	Name string
+}
+
+type DebugSegment struct {
+	ID    int
+	Name  string
+	Start int // CPU address the segment is linked at
+	Size  int
+}
+
+type DebugSpan struct {
+	ID    int
+	Seg   int
+	Start int // offset into the segment
+	Size  int
+}
+
+type DebugLine struct {
+	ID    int
+	File  int
+	Line  int
+	Type  int // 0 for assembler source, 1 for C source, 2 for macro expansions
+	Spans []int
+}
+
+type DebugSymbol struct {
+	ID    int
+	Name  string
+	Value int
+	Type  string // "lab" for labels, "equ" for equates
+}
+
+// splitDebugFields splits key=value pairs on commas outside of quotes
+func splitDebugFields(text string) map[string]string {
+	fields := map[string]string{}
+	quoted := false
+	start := 0
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) {
+			if text[i] == '"' {
+				quoted = !quoted
+			}
+			if text[i] != ',' || quoted {
+				continue
+			}
+		}
+		key, value, _ := strings.Cut(text[start:i], "=")
+		fields[key] = strings.Trim(value, `"`)
+		start = i + 1
+	}
+	return fields
+}
+
+func debugInt(fields map[string]string, key string) (int, error) {
+	text, ok := fields[key]
+	if !ok {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(text, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s=%q: %w", key, text, err)
+	}
+	return int(value), nil
+}
+
+// ParseDebugInfo reads an ld65 debug file
+func ParseDebugInfo(r io.Reader) (*DebugInfo, error) {
+	info := &DebugInfo{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]uint8, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		kind, rest, found := strings.Cut(scanner.Text(), "\t")
+		if !found {
+			continue
+		}
+		fields := splitDebugFields(rest)
+		var ints [6]int
+		var err error
+		for i, key := range []string{"id", "seg", "start", "size", "file", "line"} {
+			if ints[i], err = debugInt(fields, key); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		}
+		id, seg, start, size, file, line := ints[0], ints[1], ints[2], ints[3], ints[4], ints[5]
+
+		switch kind {
+		case "file":
+			info.Files = append(info.Files, DebugFile{ID: id, Name: fields["name"]})
+		case "seg":
+			info.Segments = append(info.Segments, DebugSegment{ID: id, Name: fields["name"], Start: start, Size: size})
+		case "span":
+			info.Spans = append(info.Spans, DebugSpan{ID: id, Seg: seg, Start: start, Size: size})
+		case "line":
+			typ, _ := debugInt(fields, "type")
+			l := DebugLine{ID: id, File: file, Line: line, Type: typ}
+			if spans := fields["span"]; spans != "" {
+				for _, s := range strings.Split(spans, "+") {
+					span, err := strconv.Atoi(s)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: bad span list %q", lineNo, spans)
+					}
+					l.Spans = append(l.Spans, span)
+				}
+			}
+			info.Lines = append(info.Lines, l)
+		case "sym":
+			value, err := debugInt(fields, "val")
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			info.Syms = append(info.Syms, DebugSymbol{ID: id, Name: fields["name"], Value: value, Type: fields["type"]})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// records are written in id order, but don't rely on it
+	sort.Slice(info.Segments, func(i, j int) bool { return info.Segments[i].ID < info.Segments[j].ID })
+	sort.Slice(info.Spans, func(i, j int) bool { return info.Spans[i].ID < info.Spans[j].ID })
+	sort.Slice(info.Files, func(i, j int) bool { return info.Files[i].ID < info.Files[j].ID })
+	sort.Slice(info.Lines, func(i, j int) bool { return info.Lines[i].ID < info.Lines[j].ID })
+	info.indexLines()
+	return info, nil
+}
+
+func (info *DebugInfo) segment(id int) (DebugSegment, bool) {
+	i := sort.Search(len(info.Segments), func(i int) bool { return info.Segments[i].ID >= id })
+	if i < len(info.Segments) && info.Segments[i].ID == id {
+		return info.Segments[i], true
+	}
+	return DebugSegment{}, false
+}
+
+// SpanRange returns the CPU address and size a span covers
+func (info *DebugInfo) SpanRange(id int) (uint16, int, bool) {
+	i := sort.Search(len(info.Spans), func(i int) bool { return info.Spans[i].ID >= id })
+	if i == len(info.Spans) || info.Spans[i].ID != id {
+		return 0, 0, false
+	}
+	span := info.Spans[i]
+	seg, ok := info.segment(span.Seg)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint16(seg.Start + span.Start), span.Size, true
+}
+
+// FileName returns the source file name of a file id
+func (info *DebugInfo) FileName(id int) string {
+	i := sort.Search(len(info.Files), func(i int) bool { return info.Files[i].ID >= id })
+	if i < len(info.Files) && info.Files[i].ID == id {
+		return info.Files[i].Name
+	}
+	return ""
+}
+
+// indexLines maps every address covered by a span to its source line,
+// preferring real source lines over macro expansions
+func (info *DebugInfo) indexLines() {
+	info.lineAt = map[uint16]int{}
+	for pass := 0; pass < 2; pass++ {
+		for i, line := range info.Lines {
+			if (pass == 0) != (line.Type != 2) {
+				continue
+			}
+			for _, span := range line.Spans {
+				start, size, ok := info.SpanRange(span)
+				if !ok {
+					continue
+				}
+				for offset := 0; offset < size; offset++ {
+					if _, taken := info.lineAt[start+uint16(offset)]; !taken {
+						info.lineAt[start+uint16(offset)] = i
+					}
+				}
+			}
+		}
+	}
+}
+
+// AddressLine finds the source file and line that generated the byte at address
+func (info *DebugInfo) AddressLine(address uint16) (file string, line int, ok bool) {
+	i, ok := info.lineAt[address]
+	if !ok {
+		return "", 0, false
+	}
+	l := info.Lines[i]
+	return info.FileName(l.File), l.Line, true
+}
+
+// LineAddresses returns the addresses of the code generated by a source line.
+// The file matches on its full name or its base name.
+func (info *DebugInfo) LineAddresses(file string, line int) []uint16 {
+	var addresses []uint16
+	for _, l := range info.Lines {
+		if l.Line != line {
+			continue
+		}
+		name := info.FileName(l.File)
+		if name != file && filepath.Base(name) != filepath.Base(file) {
+			continue
+		}
+		for _, span := range l.Spans {
+			if start, size, ok := info.SpanRange(span); ok && size > 0 {
+				addresses = append(addresses, start)
+			}
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	return addresses
+}
+
+// Symbols returns the labels of the debug file as a symbol table
+func (info *DebugInfo) Symbols() *Symbols {
+	s := NewSymbols()
+	for _, sym := range info.Syms {
+		if sym.Type == "lab" && sym.Value >= 0 && sym.Value <= 0xFFFF {
+			s.Add(uint16(sym.Value), sym.Name)
+		}
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+// Package disasm turns 6502 machine code into assembly. It decodes with
+// hardware.LookupOpcode, the table the CPU builds its dispatch from, so an
+// opcode disassembles as exactly the instruction the CPU executes for it.
+// Addresses are shown as symbols when a label file is loaded.
+package disasm
+
+import (
+	"fmt"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// Instruction is one decoded instruction
+type Instruction struct {
+	Address  uint16
+	Bytes    []uint8 // opcode followed by its operand
+	Mnemonic string  // empty for undocumented opcodes
+	Mode     hardware.AddressingMode
+	Operand  uint16 // operand as encoded, 8 or 16 bits
+	Target   uint16 // resolved destination of branches
+}
+
+// Valid reports whether the opcode is a documented instruction
+func (in Instruction) Valid() bool {
+	return in.Mnemonic != ""
+}
+
+func (in Instruction) Size() int {
+	return len(in.Bytes)
+}
+
+// Decode reads the instruction at address using read, which must not have
+// side effects (hardware.Console.Peek for example)
+func Decode(read func(address uint16) uint8, address uint16) Instruction {
+	opcode := read(address)
+	in := Instruction{Address: address, Bytes: []uint8{opcode}}
+	op, ok := hardware.LookupOpcode(opcode)
+	if !ok {
+		return in
+	}
+	in.Mnemonic = op.Mnemonic
+	in.Mode = op.Mode
+	for i := 1; i <= op.Mode.OperandSize(); i++ {
+		b := read(address + uint16(i))
+		in.Bytes = append(in.Bytes, b)
+		in.Operand |= uint16(b) << (8 * (i - 1))
+	}
+	if in.Mode == hardware.ModeRelative {
+		// branch offsets are signed and relative to the next instruction
+		in.Target = address + 2 + uint16(int8(in.Operand))
+	}
+	return in
+}
+
+// DecodeBytes decodes an instruction from a byte slice located at address.
+// Bytes past the end of code read as zero.
+func DecodeBytes(code []uint8, address uint16) Instruction {
+	return Decode(func(a uint16) uint8 {
+		if i := int(a - address); i < len(code) {
+			return code[i]
+		}
+		return 0
+	}, address)
+}
+
+// Format renders the instruction as assembly, replacing addresses with
+// symbols from sym when it is not nil
+func (in Instruction) Format(sym *Symbols) string {
+	if !in.Valid() {
+		return fmt.Sprintf(".byte $%02X", in.Bytes[0])
+	}
+	addr8 := func() string {
+		if name, ok := sym.Lookup(in.Operand); ok {
+			return name
+		}
+		return fmt.Sprintf("$%02X", in.Operand)
+	}
+	addr16 := func(address uint16) string {
+		if name, ok := sym.Lookup(address); ok {
+			return name
+		}
+		return fmt.Sprintf("$%04X", address)
+	}
+
+	var operand string
+	switch in.Mode {
+	case hardware.ModeImmediate:
+		operand = fmt.Sprintf("#$%02X", in.Operand)
+	case hardware.ModeZeroPage:
+		operand = addr8()
+	case hardware.ModeZeroPageX:
+		operand = addr8() + ",X"
+	case hardware.ModeZeroPageY:
+		operand = addr8() + ",Y"
+	case hardware.ModeAbsolute:
+		operand = addr16(in.Operand)
+	case hardware.ModeAbsoluteX:
+		operand = addr16(in.Operand) + ",X"
+	case hardware.ModeAbsoluteY:
+		operand = addr16(in.Operand) + ",Y"
+	case hardware.ModeIndirectX:
+		operand = "(" + addr8() + ",X)"
+	case hardware.ModeIndirectY:
+		operand = "(" + addr8() + "),Y"
+	case hardware.ModeIndirect:
+		operand = "(" + addr16(in.Operand) + ")"
+	case hardware.ModeRelative:
+		operand = addr16(in.Target)
+	case hardware.ModeAccumulator:
+		operand = "A"
+	default:
+		return in.Mnemonic
+	}
+	return in.Mnemonic + " " + operand
+}
+
+func (in Instruction) String() string {
+	return in.Format(nil)
+}
+
+// HexBytes renders the instruction bytes padded to a fixed width, as used
+// in listings and traces
+func (in Instruction) HexBytes() string {
+	out := ""
+	for i := 0; i < 3; i++ {
+		if i < len(in.Bytes) {
+			out += fmt.Sprintf("%02X ", in.Bytes[i])
+		} else {
+			out += "   "
+		}
+	}
+	return out[:8]
+}
@@ -0,0 +1,54 @@
+package disasm
+
+import (
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// an opcode decodes as valid exactly when the CPU executes it, and the CPU
+// moves past the number of bytes the disassembler decoded
+func TestDecodeAgreesWithCPU(t *testing.T) {
+	jumps := map[string]bool{"JMP": true, "JSR": true, "RTS": true, "RTI": true, "BRK": true}
+	for opcode := 0; opcode < 256; opcode++ {
+		// a branch offset of 0 lands on the next instruction either way
+		code := []uint8{uint8(opcode), 0x00, 0x02}
+		in := DecodeBytes(code, 0x0600)
+		cpu := hardware.NewCPU()
+		cpu.Load(0x0600, code)
+		cpu.Step()
+		if halted := cpu.Halted() != nil; halted == in.Valid() {
+			t.Errorf("$%02X: decodes as %q but the CPU halted=%v", opcode, in, halted)
+			continue
+		}
+		if in.Valid() && !jumps[in.Mnemonic] {
+			if pc := cpu.Registers().PC; pc != 0x0600+uint16(in.Size()) {
+				t.Errorf("$%02X %s: CPU moved to $%04X, decoded size %d", opcode, in, pc, in.Size())
+			}
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		code []uint8
+		want string
+	}{
+		{[]uint8{0xA9, 0x80}, "LDA #$80"},
+		{[]uint8{0xB5, 0x10}, "LDA $10,X"},
+		{[]uint8{0xB6, 0x10}, "LDX $10,Y"},
+		{[]uint8{0x9D, 0x00, 0x02}, "STA $0200,X"},
+		{[]uint8{0xB1, 0x20}, "LDA ($20),Y"},
+		{[]uint8{0xA1, 0x20}, "LDA ($20,X)"},
+		{[]uint8{0x6C, 0xFC, 0xFF}, "JMP ($FFFC)"},
+		{[]uint8{0xD0, 0xFD}, "BNE $C000"},
+		{[]uint8{0x0A}, "ASL A"},
+		{[]uint8{0xEA}, "NOP"},
+		{[]uint8{0x02}, ".byte $02"},
+	}
+	for _, tt := range tests {
+		if got := DecodeBytes(tt.code, 0xC001).String(); got != tt.want {
+			t.Errorf("% X: got %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
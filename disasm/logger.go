@@ -0,0 +1,153 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// flags of a CHR-ROM byte in a code/data log
+const (
+	CDLDrawn = 0x01 // fetched by the PPU while rendering
+	CDLRead  = 0x02 // read by the CPU through $2007
+)
+
+// NewCodeDataLog returns an empty log for a cartridge. CHR-RAM has no
+// entries.
+func NewCodeDataLog(cart *hardware.Cartridge) *CodeDataLog {
+	chrSize := len(cart.CHR)
+	if cart.HasCHRRAM() {
+		chrSize = 0
+	}
+	return &CodeDataLog{PRG: make([]uint8, len(cart.PRG)), CHR: make([]uint8, chrSize)}
+}
+
+// ReadCDLFile reads the code/data log for a cartridge from a .cdl file
+func ReadCDLFile(path string, cart *hardware.Cartridge) (*CodeDataLog, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	empty := NewCodeDataLog(cart)
+	cdl, err := ReadCDL(file, len(empty.PRG), len(empty.CHR))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cdl, nil
+}
+
+// Write saves the log in the FCEUX .cdl layout
+func (cdl *CodeDataLog) Write(w io.Writer) error {
+	if _, err := w.Write(cdl.PRG); err != nil {
+		return err
+	}
+	_, err := w.Write(cdl.CHR)
+	return err
+}
+
+func (cdl *CodeDataLog) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := cdl.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// CodeDataLogger fills a code/data log from a running console. Every byte of
+// an executed instruction is code, other reads of PRG-ROM are data, and data
+// reached through (zp,X) or (zp),Y is also indirect data. The target of a
+// JMP ($nnnn) is indirect code. DMC sample fetches are PCM data.
+type CodeDataLogger struct {
+	console *hardware.Console
+	log     *CodeDataLog
+	hooks   []hardware.HookID
+
+	pc       uint16 // instruction being executed
+	size     int
+	indirect bool // the instruction reads data through a pointer
+	jumped   bool // the previous instruction was JMP ($nnnn)
+}
+
+// NewCodeDataLogger starts logging into log, which may already hold
+// results from earlier runs. A nil log starts empty.
+func NewCodeDataLogger(console *hardware.Console, log *CodeDataLog) *CodeDataLogger {
+	if log == nil {
+		log = NewCodeDataLog(console.Cartridge())
+	}
+	l := &CodeDataLogger{console: console, log: log}
+	hooks := console.Hooks()
+	l.hooks = []hardware.HookID{
+		hooks.OnExec(0x0000, 0xFFFF, l.onExec),
+		hooks.OnRead(0x8000, 0xFFFF, l.onRead),
+		hooks.OnCHRRead(l.onCHRRead),
+	}
+	return l
+}
+
+func (l *CodeDataLogger) Log() *CodeDataLog {
+	return l.log
+}
+
+// Stop detaches the logger from the console
+func (l *CodeDataLogger) Stop() {
+	for _, id := range l.hooks {
+		l.console.Hooks().Remove(id)
+	}
+	l.hooks = nil
+}
+
+func (l *CodeDataLogger) mark(address uint16, flags uint8) {
+	if offset, ok := l.console.Cartridge().PRGOffset(address); ok {
+		l.log.PRG[offset] |= flags | uint8(address>>13&3)<<2
+	}
+}
+
+func (l *CodeDataLogger) onExec(kind hardware.AccessKind, address uint16, opcode uint8) {
+	l.pc, l.size, l.indirect = address, 1, false
+	if op, ok := hardware.LookupOpcode(opcode); ok {
+		l.size += op.Mode.OperandSize()
+		l.indirect = op.Mode == hardware.ModeIndirectX || op.Mode == hardware.ModeIndirectY
+	}
+	if l.jumped {
+		l.mark(address, CDLIndirectCode)
+	}
+	l.jumped = opcode == 0x6C
+	for i := 0; i < l.size; i++ {
+		l.mark(address+uint16(i), CDLCode)
+	}
+}
+
+func (l *CodeDataLogger) onRead(kind hardware.AccessKind, address uint16, value uint8) {
+	if kind&hardware.AccessDMC != 0 {
+		l.mark(address, CDLData|CDLPCMData)
+		return
+	}
+	// operand fetches were logged as code with their instruction
+	if int(address-l.pc) < l.size {
+		return
+	}
+	flags := uint8(CDLData)
+	if l.indirect {
+		flags |= CDLIndirectData
+	}
+	l.mark(address, flags)
+}
+
+func (l *CodeDataLogger) onCHRRead(address uint16, drawn bool) {
+	offset, ok := l.console.Cartridge().CHROffset(address)
+	if !ok {
+		return
+	}
+	if drawn {
+		l.log.CHR[offset] |= CDLDrawn
+	} else {
+		l.log.CHR[offset] |= CDLRead
+	}
+}
@@ -0,0 +1,124 @@
+package disasm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/asm"
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// cdlProgram touches PRG-ROM and CHR-ROM in every way the logger tells apart
+const cdlProgram = `
+.org $C000
+reset:	LDA data		// data
+	LDA #<table ; STA $10
+	LDA #>table ; STA $11
+	LDY #1
+	LDA ($10),Y		// indirect data at table+1
+	JMP (vector)		// indirect code at target
+.org $C100
+target:	LDA #$00 ; STA $2006
+	LDA #$10 ; STA $2006
+	LDA $2007 ; LDA $2007	// CHR $0010 and $0011 read through $2007
+	LDA #$0F ; STA $4010
+	LDA #(sample-$C000)/64 ; STA $4012
+	LDA #$00 ; STA $4013	// a one byte sample
+	LDA #$10 ; STA $4015
+	LDA #$18 ; STA $2001	// render tile 0
+loop:	JMP loop
+.org $C200
+data:	.byte $AA
+table:	.byte $00, $BB
+vector:	.word target
+.org $D000
+sample:	.byte $55
+.org $FFFA
+	.word loop, reset, loop
+`
+
+func TestCodeDataLogger(t *testing.T) {
+	p := asm.MustAssemble(cdlProgram)
+	prg := make([]uint8, 0x4000)
+	copy(prg[p.Origin-0xC000:], p.Code)
+	cart, err := hardware.NewCartridge(prg, make([]uint8, 0x2000), 0, hardware.MirrorVertical, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console := hardware.NewConsole(cart)
+	logger := NewCodeDataLogger(console, nil)
+	for i := 0; i < 3; i++ {
+		console.StepFrame()
+	}
+	logger.Stop()
+	log := logger.Log()
+
+	// $C000-$DFFF is bank 2 of the CPU's 8 KiB windows
+	const bank = 2 << 2
+	prgTests := []struct {
+		name   string
+		symbol string
+		offset int
+		want   uint8
+	}{
+		{"code", "reset", 0, CDLCode | bank},
+		{"operand", "reset", 1, CDLCode | bank},
+		{"data", "data", 0, CDLData | bank},
+		{"indirect data", "table", 1, CDLData | CDLIndirectData | bank},
+		{"indirect code", "target", 0, CDLCode | CDLIndirectCode | bank},
+		{"PCM data", "sample", 0, CDLData | CDLPCMData | bank},
+		{"past the sample", "sample", 1, 0},
+	}
+	for _, tt := range prgTests {
+		offset := int(p.Symbols[tt.symbol]-0xC000) + tt.offset
+		if got := log.PRG[offset]; got != tt.want {
+			t.Errorf("%s: PRG $%04X flags $%02X, want $%02X", tt.name, offset, got, tt.want)
+		}
+	}
+
+	chrTests := []struct {
+		name   string
+		offset int
+		want   uint8
+	}{
+		{"drawn", 0x0000, CDLDrawn},
+		{"read", 0x0010, CDLRead},
+		{"read ahead", 0x0011, CDLRead},
+		{"unused", 0x1FFF, 0},
+	}
+	for _, tt := range chrTests {
+		if got := log.CHR[tt.offset]; got != tt.want {
+			t.Errorf("%s: CHR $%04X flags $%02X, want $%02X", tt.name, tt.offset, got, tt.want)
+		}
+	}
+
+	// a stopped logger sees nothing more
+	before := append([]uint8(nil), log.PRG...)
+	console.Reset()
+	console.StepFrame()
+	if !bytes.Equal(before, log.PRG) {
+		t.Error("the log changed after Stop")
+	}
+}
+
+func TestCodeDataLogFile(t *testing.T) {
+	log := &CodeDataLog{PRG: []uint8{CDLCode, CDLData, 0}, CHR: []uint8{CDLDrawn, CDLRead}}
+	var buf bytes.Buffer
+	if err := log.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// FCEUX writes the PRG-ROM flags followed by the CHR-ROM flags
+	if want := []uint8{CDLCode, CDLData, 0, CDLDrawn, CDLRead}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("wrote % X, want % X", buf.Bytes(), want)
+	}
+	read, err := ReadCDL(bytes.NewReader(buf.Bytes()), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read.PRG, log.PRG) || !bytes.Equal(read.CHR, log.CHR) {
+		t.Errorf("read back PRG % X CHR % X", read.PRG, read.CHR)
+	}
+	if _, err := ReadCDL(bytes.NewReader(buf.Bytes()), 4, 2); err == nil {
+		t.Error("read a log of the wrong size")
+	}
+}
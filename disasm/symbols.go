@@ -0,0 +1,215 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Symbols maps addresses to names. Labels on PRG-ROM are kept by ROM offset
+// and resolved with the NROM layout, PRG mirrored from $8000 up.
+type Symbols struct {
+	labels  map[uint16]string // labels on CPU addresses such as RAM and registers
+	prg     map[int]string    // labels on PRG-ROM offsets
+	prgSize int
+}
+
+func NewSymbols() *Symbols {
+	return &Symbols{labels: map[uint16]string{}, prg: map[int]string{}}
+}
+
+// SetPRGSize tells the table how big PRG-ROM is, so PRG offsets can be
+// matched against CPU addresses
+func (s *Symbols) SetPRGSize(size int) {
+	s.prgSize = size
+}
+
+func (s *Symbols) Add(address uint16, name string) {
+	s.labels[address] = name
+}
+
+func (s *Symbols) AddPRG(offset int, name string) {
+	s.prg[offset] = name
+}
+
+func (s *Symbols) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.labels) + len(s.prg)
+}
+
+// Lookup returns the name of an address, it is safe to call on a nil table
+func (s *Symbols) Lookup(address uint16) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	if name, ok := s.labels[address]; ok {
+		return name, true
+	}
+	if address >= 0x8000 && s.prgSize > 0 {
+		name, ok := s.prg[int(address-0x8000)%s.prgSize]
+		return name, ok
+	}
+	return "", false
+}
+
+// Nearest finds the closest label at or below address, within limit bytes,
+// for naming code inside a routine as Name+offset
+func (s *Symbols) Nearest(address uint16, limit int) (name string, offset int, ok bool) {
+	for offset = 0; offset <= limit && offset <= int(address); offset++ {
+		if name, ok = s.Lookup(address - uint16(offset)); ok {
+			return name, offset, true
+		}
+	}
+	return "", 0, false
+}
+
+// Address finds the address of a name. PRG labels resolve to their first
+// mirror at or above $8000.
+func (s *Symbols) Address(name string) (uint16, bool) {
+	if s == nil {
+		return 0, false
+	}
+	best, found := uint16(0), false
+	for address, label := range s.labels {
+		// several addresses may share a name, pick the lowest so the answer is stable
+		if label == name && (!found || address < best) {
+			best, found = address, true
+		}
+	}
+	if found {
+		return best, true
+	}
+	for offset, label := range s.prg {
+		if label == name && offset < 0x8000 && (!found || offset < int(best-0x8000)) {
+			best, found = 0x8000+uint16(offset), true
+		}
+	}
+	return best, found
+}
+
+// Merge copies every symbol of other into s
+func (s *Symbols) Merge(other *Symbols) {
+	for address, name := range other.labels {
+		s.labels[address] = name
+	}
+	for offset, name := range other.prg {
+		s.prg[offset] = name
+	}
+}
+
+// LoadFile reads a label file, picking the format from its extension:
+// .nl (FCEUX name list), .mlb (Mesen label file) or .dbg (ca65/ld65 debug info)
+func (s *Symbols) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".nl":
+		err = s.LoadNL(file)
+	case ".mlb":
+		err = s.LoadMLB(file)
+	case ".dbg":
+		var info *DebugInfo
+		if info, err = ParseDebugInfo(file); err == nil {
+			s.Merge(info.Symbols())
+		}
+	default:
+		err = fmt.Errorf("unknown label file format")
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadNL reads an FCEUX name list, lines of the form
+//
+//	$C000#Reset#comment
+//	$0300/10#Buffer#an array of 16 bytes
+//
+// Addresses are CPU addresses, FCEUX keeps one file per PRG bank.
+func (s *Symbols) LoadNL(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "$") {
+			continue
+		}
+		fields := strings.SplitN(line[1:], "#", 3)
+		if len(fields) < 2 {
+			return fmt.Errorf("line %d: missing name", lineNo)
+		}
+		addressText, countText, isArray := strings.Cut(fields[0], "/")
+		address, err := strconv.ParseUint(addressText, 16, 16)
+		if err != nil {
+			return fmt.Errorf("line %d: bad address %q", lineNo, fields[0])
+		}
+		name := strings.TrimSpace(fields[1])
+		if name == "" {
+			continue
+		}
+		s.Add(uint16(address), name)
+		if isArray {
+			count, err := strconv.ParseUint(countText, 16, 16)
+			if err != nil {
+				return fmt.Errorf("line %d: bad array size %q", lineNo, countText)
+			}
+			for i := uint64(1); i < count; i++ {
+				s.Add(uint16(address+i), fmt.Sprintf("%s+%d", name, i))
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// LoadMLB reads a Mesen label file. Both the Mesen 1 single letter memory
+// types and the Mesen 2 names are understood:
+//
+//	P:0010:Reset:comment        NesPrgRom:0010:Reset
+//	R:0300:Buffer               NesInternalRam:0300:Buffer
+func (s *Symbols) LoadMLB(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 4)
+		if len(fields) < 3 {
+			return fmt.Errorf("line %d: malformed label %q", lineNo, line)
+		}
+		name := strings.TrimSpace(fields[2])
+		if name == "" {
+			continue // comment only
+		}
+		// a range such as 0300-030F labels its first byte
+		startText, _, _ := strings.Cut(fields[1], "-")
+		value, err := strconv.ParseUint(startText, 16, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: bad address %q", lineNo, fields[1])
+		}
+		switch fields[0] {
+		case "P", "NesPrgRom":
+			s.AddPRG(int(value), name)
+		case "R", "NesInternalRam", "G", "NesMemory", "Register":
+			s.Add(uint16(value), name)
+		case "S", "NesSaveRam", "W", "NesWorkRam":
+			s.Add(0x6000+uint16(value), name)
+		}
+		// other memory types (CHR, palette...) have no CPU address
+	}
+	return scanner.Err()
+}
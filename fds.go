@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+	"github.com/tejasdeepakmasne/nesemu-go/patch"
+)
+
+// Famicom Disk System games need the BIOS, which is looked for in
+// $NESEMU_FDS_BIOS, next to the disk image and in the user config directory
+const fdsBIOSName = "disksys.rom"
+
+func findFDSBIOS(romPath string) ([]uint8, error) {
+	candidates := []string{os.Getenv("NESEMU_FDS_BIOS"), filepath.Join(filepath.Dir(romPath), fdsBIOSName)}
+	if dir, err := os.UserConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, "nesemu-go", fdsBIOSName))
+	}
+	for _, path := range candidates {
+		if path != "" && isFile(path) {
+			return os.ReadFile(path)
+		}
+	}
+	return nil, fmt.Errorf("the FDS BIOS %s was not found, put it next to the disk image or set NESEMU_FDS_BIOS", fdsBIOSName)
+}
+
+// disk writes are kept as an IPS patch against the image, game.fds ->
+// game.save.ips, so the image itself is never changed
+func diskSavePath(romPath string) string {
+	return strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".save.ips"
+}
+
+// saveDisk writes what the game saved to an FDS disk, if anything. The save
+// is made against the disk image with its patches applied.
+func (o *romOptions) saveDisk(romPath string, cart *hardware.Cartridge) error {
+	disk := cart.Disk()
+	if disk == nil || !disk.Changed() {
+		return nil
+	}
+	original, err := o.read(romPath)
+	if err != nil {
+		return err
+	}
+	current, err := disk.Image()
+	if err != nil {
+		return err
+	}
+	ips, err := patch.CreateIPS(original, current)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(diskSavePath(romPath), ips, 0o644)
+}
+
+// parseDiskSwaps parses the headless -disk flag, frame=side pairs where
+// side counts from 1 or is "eject"
+func parseDiskSwaps(list string) (map[int]int, error) {
+	pairs, err := parseFramePairs(list, "side")
+	if err != nil {
+		return nil, err
+	}
+	swaps := map[int]int{}
+	for frame, value := range pairs {
+		if value == "eject" {
+			swaps[frame] = -1
+			continue
+		}
+		side, err := strconv.Atoi(value)
+		if err != nil || side < 1 {
+			return nil, fmt.Errorf("bad disk side %q, want a number from 1 or eject", value)
+		}
+		swaps[frame] = side - 1
+	}
+	return swaps, nil
+}
+
+// changeDisk inserts a side, counting from 0, or ejects the disk for -1
+func changeDisk(cart *hardware.Cartridge, side int) error {
+	disk := cart.Disk()
+	if disk == nil {
+		return errors.New("not a Famicom Disk System game")
+	}
+	if side < 0 {
+		disk.Eject()
+		return nil
+	}
+	return disk.Insert(side)
+}
+
+func diskCommand(cart *hardware.Cartridge, args string, out io.Writer) {
+	disk := cart.Disk()
+	if disk == nil {
+		fmt.Fprintln(out, "not a Famicom Disk System game")
+		return
+	}
+	switch args {
+	case "":
+		for i := 0; i < disk.Sides(); i++ {
+			state := ""
+			if i == disk.Side() {
+				state = " (inserted)"
+			}
+			fmt.Fprintf(out, "%3d %s%s\n", i+1, hardware.SideName(i), state)
+		}
+		if disk.Side() < 0 {
+			fmt.Fprintln(out, "the drive is empty")
+		}
+	case "eject":
+		disk.Eject()
+	default:
+		n, err := strconv.Atoi(args)
+		if err == nil {
+			err = disk.Insert(n - 1)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "no disk side %q\n", args)
+		}
+	}
+}
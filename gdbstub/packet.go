@@ -0,0 +1,131 @@
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// interruptByte is sent by gdb outside of a packet to stop the target
+const interruptByte = 0x03
+
+// conn frames packets of the remote serial protocol, $data#checksum, over a
+// stream. Reads happen on one goroutine, writes may come from any.
+type conn struct {
+	r     *bufio.Reader
+	w     io.Writer
+	mu    sync.Mutex // guards w and noAck
+	noAck bool
+}
+
+func checksum(data string) uint8 {
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// escape applies the binary escaping rule of the protocol to a reply
+func escape(data string) string {
+	out := make([]uint8, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		switch ch := data[i]; ch {
+		case '$', '#', '}', '*':
+			out = append(out, '}', ch^0x20)
+		default:
+			out = append(out, ch)
+		}
+	}
+	return string(out)
+}
+
+func (c *conn) send(data string) error {
+	data = escape(data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := fmt.Fprintf(c.w, "$%s#%02x", data, checksum(data))
+	return err
+}
+
+// stopAcks turns acknowledgements off after QStartNoAckMode
+func (c *conn) stopAcks() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.noAck = true
+}
+
+func (c *conn) ack(ok bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.noAck {
+		return nil
+	}
+	reply := []uint8{'+'}
+	if !ok {
+		reply[0] = '-'
+	}
+	_, err := c.w.Write(reply)
+	return err
+}
+
+// receive returns the next packet, or interrupt when gdb sent a break.
+// Acknowledgements from gdb are skipped.
+func (c *conn) receive() (packet string, interrupt bool, err error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+		switch b {
+		case interruptByte:
+			return "", true, nil
+		case '$':
+		default:
+			continue // '+', '-' and line noise
+		}
+
+		// the checksum covers the data as sent, before unescaping
+		var data []uint8
+		var sum uint8
+		for {
+			if b, err = c.r.ReadByte(); err != nil {
+				return "", false, err
+			}
+			if b == '#' {
+				break
+			}
+			sum += b
+			if b == '}' {
+				if b, err = c.r.ReadByte(); err != nil {
+					return "", false, err
+				}
+				sum += b
+				b ^= 0x20
+			}
+			data = append(data, b)
+		}
+		var digits [2]uint8
+		if _, err = io.ReadFull(c.r, digits[:]); err != nil {
+			return "", false, err
+		}
+		want, err := strconv.ParseUint(string(digits[:]), 16, 8)
+		if err != nil || uint8(want) != sum {
+			if err := c.ack(false); err != nil {
+				return "", false, err
+			}
+			continue
+		}
+		if err := c.ack(true); err != nil {
+			return "", false, err
+		}
+		return string(data), false, nil
+	}
+}
+
+func hexByte(b uint8) string {
+	return hex.EncodeToString([]uint8{b})
+}
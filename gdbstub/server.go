@@ -0,0 +1,430 @@
+// Package gdbstub serves the debugger over the GDB remote serial protocol,
+// so gdb with the 6502 target description below, or an IDE speaking the
+// protocol, can drive the emulator.
+// https://sourceware.org/gdb/onlinedocs/gdb/Remote-Protocol.html
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// targetXML describes the registers in the order of the g packet
+const targetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.nesemu.6502.core">
+    <flags id="status" size="1">
+      <field name="C" start="0" end="0"/>
+      <field name="Z" start="1" end="1"/>
+      <field name="I" start="2" end="2"/>
+      <field name="D" start="3" end="3"/>
+      <field name="B" start="4" end="4"/>
+      <field name="V" start="6" end="6"/>
+      <field name="N" start="7" end="7"/>
+    </flags>
+    <reg name="a" bitsize="8" type="uint8" regnum="0"/>
+    <reg name="x" bitsize="8" type="uint8"/>
+    <reg name="y" bitsize="8" type="uint8"/>
+    <reg name="p" bitsize="8" type="status"/>
+    <reg name="sp" bitsize="8" type="uint8"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>
+`
+
+// signals reported in stop replies
+const (
+	sigINT  = 0x02
+	sigILL  = 0x04
+	sigTRAP = 0x05
+)
+
+// errKilled ends the server when gdb kills the target
+var errKilled = errors.New("killed by gdb")
+
+type watchKey struct {
+	kind    hardware.AccessKind
+	address uint16
+	length  int
+}
+
+// Server exposes a debugger to one gdb connection at a time
+type Server struct {
+	debugger    *hardware.Debugger
+	breakpoints map[uint16]int // address of a gdb breakpoint -> debugger id
+	watchpoints map[watchKey]int
+	last        hardware.Stop
+}
+
+func NewServer(d *hardware.Debugger) *Server {
+	return &Server{
+		debugger:    d,
+		breakpoints: map[uint16]int{},
+		watchpoints: map[watchKey]int{},
+		last:        hardware.Stop{Reason: hardware.StopStep},
+	}
+}
+
+// ListenAndServe accepts gdb connections on a TCP address, such as
+// "127.0.0.1:6502", one after the other. It returns when gdb kills the target.
+func (s *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	return s.Accept(listener)
+}
+
+// Accept serves connections from listener until gdb kills the target
+func (s *Server) Accept(listener net.Listener) error {
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		err = s.Serve(c)
+		c.Close()
+		if err == errKilled {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type incoming struct {
+	packet string
+	err    error
+}
+
+// Serve runs a session over rw until gdb detaches, kills the target or the
+// connection closes. Breakpoints set by the session are removed when it ends.
+func (s *Server) Serve(rw io.ReadWriter) error {
+	c := &conn{r: bufio.NewReader(rw), w: rw}
+	defer s.clear()
+
+	// gdb's break byte has to be seen while the target runs, so reading
+	// happens on its own goroutine
+	packets := make(chan incoming)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			packet, interrupt, err := c.receive()
+			if interrupt {
+				s.debugger.Interrupt()
+				continue
+			}
+			select {
+			case packets <- incoming{packet, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for in := range packets {
+		if in.err == io.EOF {
+			return nil
+		}
+		if in.err != nil {
+			return in.err
+		}
+		reply, err := s.handle(c, in.packet)
+		if err != nil {
+			if err == io.EOF {
+				return nil // detached
+			}
+			return err
+		}
+		// gdb stops acknowledging once it sees the reply, and so must we,
+		// before the reader gets to its next packet
+		if in.packet == "QStartNoAckMode" {
+			c.stopAcks()
+		}
+		if err := c.send(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clear removes the breakpoints and watchpoints gdb left behind
+func (s *Server) clear() {
+	for address, id := range s.breakpoints {
+		s.debugger.Delete(id)
+		delete(s.breakpoints, address)
+	}
+	for key, id := range s.watchpoints {
+		s.debugger.Delete(id)
+		delete(s.watchpoints, key)
+	}
+}
+
+// handle answers a packet. An empty reply tells gdb the packet isn't
+// supported. io.EOF ends the session after detaching.
+func (s *Server) handle(c *conn, packet string) (string, error) {
+	if packet == "" {
+		return "", nil
+	}
+	console := s.debugger.Console()
+	cpu := console.CPU()
+	args := packet[1:]
+
+	switch packet[0] {
+	case '?':
+		return s.stopReply(s.last), nil
+	case 'g':
+		r := cpu.Registers()
+		return hex.EncodeToString([]uint8{r.A, r.X, r.Y, r.P, r.SP, uint8(r.PC), uint8(r.PC >> 8)}), nil
+	case 'G':
+		b, err := hex.DecodeString(args)
+		if err != nil || len(b) != 7 {
+			return "E01", nil
+		}
+		cpu.SetRegisters(hardware.Registers{A: b[0], X: b[1], Y: b[2], P: b[3], SP: b[4], PC: uint16(b[5]) | uint16(b[6])<<8})
+		return "OK", nil
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || n > 5 {
+			return "E01", nil
+		}
+		r := cpu.Registers()
+		if n == 5 {
+			return hexByte(uint8(r.PC)) + hexByte(uint8(r.PC>>8)), nil
+		}
+		return hexByte([]uint8{r.A, r.X, r.Y, r.P, r.SP}[n]), nil
+	case 'P':
+		numText, valueText, _ := strings.Cut(args, "=")
+		n, err := strconv.ParseUint(numText, 16, 8)
+		b, err2 := hex.DecodeString(valueText)
+		if err != nil || err2 != nil || n > 5 || len(b) == 0 {
+			return "E01", nil
+		}
+		r := cpu.Registers()
+		switch n {
+		case 0:
+			r.A = b[0]
+		case 1:
+			r.X = b[0]
+		case 2:
+			r.Y = b[0]
+		case 3:
+			r.P = b[0]
+		case 4:
+			r.SP = b[0]
+		case 5:
+			if len(b) != 2 {
+				return "E01", nil
+			}
+			r.PC = uint16(b[0]) | uint16(b[1])<<8
+		}
+		cpu.SetRegisters(r)
+		return "OK", nil
+	case 'm':
+		address, length, ok := parseRange(args)
+		if !ok {
+			return "E01", nil
+		}
+		out := make([]uint8, length)
+		for i := range out {
+			out[i] = console.Peek(address + uint16(i))
+		}
+		return hex.EncodeToString(out), nil
+	case 'M':
+		rangeText, data, _ := strings.Cut(args, ":")
+		address, length, ok := parseRange(rangeText)
+		b, err := hex.DecodeString(data)
+		if !ok || err != nil || len(b) != length {
+			return "E01", nil
+		}
+		for i, value := range b {
+			console.Poke(address+uint16(i), value)
+		}
+		return "OK", nil
+	case 'c', 's':
+		if args != "" {
+			address, err := strconv.ParseUint(args, 16, 16)
+			if err != nil {
+				return "E01", nil
+			}
+			r := cpu.Registers()
+			r.PC = uint16(address)
+			cpu.SetRegisters(r)
+		}
+		return s.resume(packet[0] == 's'), nil
+	case 'Z', 'z':
+		return s.breakpoint(packet[0] == 'Z', args), nil
+	case 'H', 'T':
+		return "OK", nil // there is a single thread
+	case 'D':
+		if err := c.send("OK"); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	case 'k':
+		return "", errKilled
+	case 'v':
+		switch {
+		case packet == "vCont?":
+			return "vCont;c;C;s;S", nil
+		case strings.HasPrefix(packet, "vCont;"):
+			// a single thread, so the first action is the only one that matters
+			action := strings.TrimPrefix(packet, "vCont;")
+			return s.resume(action[0] == 's' || action[0] == 'S'), nil
+		}
+		return "", nil
+	case 'q', 'Q':
+		return s.query(packet), nil
+	}
+	return "", nil
+}
+
+func (s *Server) query(packet string) string {
+	switch {
+	case strings.HasPrefix(packet, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+"
+	case packet == "QStartNoAckMode":
+		return "OK"
+	case packet == "qAttached":
+		return "1"
+	case packet == "qC":
+		return "QC1"
+	case packet == "qfThreadInfo":
+		return "m1"
+	case packet == "qsThreadInfo":
+		return "l"
+	case packet == "qOffsets":
+		return "Text=0;Data=0;Bss=0"
+	case strings.HasPrefix(packet, "qXfer:features:read:target.xml:"):
+		offset, length, ok := parseRange(strings.TrimPrefix(packet, "qXfer:features:read:target.xml:"))
+		if !ok {
+			return "E01"
+		}
+		if int(offset) >= len(targetXML) {
+			return "l"
+		}
+		chunk := targetXML[offset:]
+		if len(chunk) > length {
+			return "m" + chunk[:length]
+		}
+		return "l" + chunk
+	case strings.HasPrefix(packet, "qRcmd,"):
+		// monitor commands
+		command, err := hex.DecodeString(strings.TrimPrefix(packet, "qRcmd,"))
+		if err != nil {
+			return "E01"
+		}
+		switch strings.TrimSpace(string(command)) {
+		case "reset":
+			s.debugger.Console().Reset()
+			return "OK"
+		}
+		return hex.EncodeToString([]uint8("unknown monitor command, try reset\n"))
+	}
+	return ""
+}
+
+func (s *Server) resume(step bool) string {
+	if step {
+		s.last = s.debugger.StepIn()
+	} else {
+		s.last = s.debugger.Continue(0)
+	}
+	return s.stopReply(s.last)
+}
+
+func (s *Server) stopReply(stop hardware.Stop) string {
+	switch stop.Reason {
+	case hardware.StopInterrupted:
+		return fmt.Sprintf("S%02x", sigINT)
+	case hardware.StopHalted:
+		return fmt.Sprintf("S%02x", sigILL)
+	case hardware.StopWatchpoint:
+		kind := ""
+		switch {
+		case stop.Kind&hardware.AccessWrite != 0:
+			kind = "watch"
+		case stop.Kind&hardware.AccessRead != 0:
+			kind = "rwatch"
+		}
+		if kind != "" {
+			return fmt.Sprintf("T%02x%s:%04x;", sigTRAP, kind, stop.Address)
+		}
+	}
+	return fmt.Sprintf("S%02x", sigTRAP)
+}
+
+// breakpoint handles Z and z packets: type,address,kind
+func (s *Server) breakpoint(insert bool, args string) string {
+	fields := strings.Split(args, ",")
+	if len(fields) < 3 {
+		return "E01"
+	}
+	address, err := strconv.ParseUint(fields[1], 16, 16)
+	length, err2 := strconv.ParseUint(fields[2], 16, 16)
+	if err != nil || err2 != nil {
+		return "E01"
+	}
+
+	switch fields[0] {
+	case "0", "1": // software and hardware breakpoints are the same thing here
+		id, exists := s.breakpoints[uint16(address)]
+		if insert && !exists {
+			bp, err := s.debugger.AddBreakpoint(uint16(address), "")
+			if err != nil {
+				return "E01"
+			}
+			s.breakpoints[uint16(address)] = bp.ID
+		} else if !insert && exists {
+			s.debugger.Delete(id)
+			delete(s.breakpoints, uint16(address))
+		}
+		return "OK"
+	case "2", "3", "4":
+		kind := map[string]hardware.AccessKind{
+			"2": hardware.AccessWrite,
+			"3": hardware.AccessRead,
+			"4": hardware.AccessRead | hardware.AccessWrite,
+		}[fields[0]]
+		if length == 0 {
+			length = 1
+		}
+		key := watchKey{kind, uint16(address), int(length)}
+		id, exists := s.watchpoints[key]
+		if insert && !exists {
+			wp := s.debugger.AddWatchpoint(uint16(address), uint16(address+length-1), kind)
+			s.watchpoints[key] = wp.ID
+		} else if !insert && exists {
+			s.debugger.Delete(id)
+			delete(s.watchpoints, key)
+		}
+		return "OK"
+	}
+	return ""
+}
+
+// parseRange parses the "address,length" argument of several packets
+func parseRange(text string) (uint16, int, bool) {
+	addressText, lengthText, found := strings.Cut(text, ",")
+	address, err := strconv.ParseUint(addressText, 16, 32)
+	length, err2 := strconv.ParseUint(lengthText, 16, 32)
+	if !found || err != nil || err2 != nil || length > 0x10000 {
+		return 0, 0, false
+	}
+	return uint16(address), int(length), true
+}
@@ -0,0 +1,166 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// client plays gdb's side of a session over one end of a pipe
+type client struct {
+	t     *testing.T
+	conn  net.Conn
+	r     *bufio.Reader
+	noAck bool
+}
+
+func (c *client) readByte() uint8 {
+	c.t.Helper()
+	b, err := c.r.ReadByte()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return b
+}
+
+// exchange sends a packet and returns the reply
+func (c *client) exchange(packet string) string {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", packet, checksum(packet)); err != nil {
+		c.t.Fatal(err)
+	}
+	if !c.noAck {
+		if b := c.readByte(); b != '+' {
+			c.t.Fatalf("%s: got %q instead of an ack", packet, b)
+		}
+	}
+	if b := c.readByte(); b != '$' {
+		c.t.Fatalf("%s: reply starts with %q", packet, b)
+	}
+	reply, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	reply = strings.TrimSuffix(reply, "#")
+	sum := string([]uint8{c.readByte(), c.readByte()})
+	if want := fmt.Sprintf("%02x", checksum(reply)); sum != want {
+		c.t.Errorf("%s: reply checksum %s, want %s", packet, sum, want)
+	}
+	// after D the stub may hang up before it reads the ack
+	if !c.noAck && packet != "D" {
+		if _, err := c.conn.Write([]uint8{'+'}); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	return reply
+}
+
+// kill sends k, which gets no reply
+func (c *client) kill() {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "$k#%02x", checksum("k")); err != nil {
+		c.t.Fatal(err)
+	}
+	if b := c.readByte(); b != '+' {
+		c.t.Fatalf("k: got %q instead of an ack", b)
+	}
+}
+
+func (c *client) expect(packet, want string) {
+	c.t.Helper()
+	if got := c.exchange(packet); got != want {
+		c.t.Errorf("%s: got %q, want %q", packet, got, want)
+	}
+}
+
+func newSession(t *testing.T) (*client, <-chan error) {
+	t.Helper()
+	rom, err := os.ReadFile("../hardware/nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := hardware.ParseROM(rom, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console := hardware.NewConsole(cart)
+	// nestest's automated mode
+	console.CPU().SetRegisters(hardware.Registers{P: 0x24, SP: 0xFD, PC: 0xC000})
+
+	server, gdb := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(hardware.NewDebugger(console)).Serve(server)
+		server.Close()
+	}()
+	t.Cleanup(func() { gdb.Close() })
+	return &client{t: t, conn: gdb, r: bufio.NewReader(gdb)}, done
+}
+
+func TestSession(t *testing.T) {
+	c, done := newSession(t)
+	if reply := c.exchange("qSupported:multiprocess+;swbreak+"); !strings.Contains(reply, "QStartNoAckMode+") {
+		t.Errorf("qSupported: %q doesn't offer no ack mode", reply)
+	}
+	c.expect("g", "00000024fd00c0")
+	c.expect("mc000,3", "4cf5c5") // JMP $C5F5
+	c.expect("Z0,c72d,1", "OK")
+	c.expect("c", "S05")
+	c.expect("g", "00000026fb2dc7")
+	c.expect("z0,c72d,1", "OK")
+
+	c.expect("QStartNoAckMode", "OK")
+	c.noAck = true
+	c.expect("s", "S05")
+	c.expect("p5", "2ec7")
+	c.expect("M0300,1:aa", "OK")
+	c.expect("m0300,1", "aa")
+	c.expect("D", "OK")
+	if err := <-done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
+
+func TestSessionInterrupt(t *testing.T) {
+	c, done := newSession(t)
+	c.expect("M0300,3:4c0003", "OK") // JMP $0300
+	// the break byte has to arrive while the target runs, keep sending it
+	// until the stop reply comes back
+	stopped := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				c.conn.Write([]uint8{interruptByte})
+			}
+		}
+	}()
+	c.expect("c0300", "S02")
+	close(stopped)
+	c.expect("p5", "0003")
+	c.kill()
+	if err := <-done; err != errKilled {
+		t.Errorf("Serve returned %v, want errKilled", err)
+	}
+}
+
+func TestSessionHalt(t *testing.T) {
+	c, done := newSession(t)
+	// nestest moves on to undocumented opcodes at $C6BD
+	c.expect("c", "S04")
+	c.expect("p5", "bdc6")
+	c.expect("D", "OK")
+	if err := <-done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
@@ -1,3 +1,5 @@
 module github.com/tejasdeepakmasne/nesemu-go
 
 go 1.21.6
+
+require github.com/yuin/gopher-lua v1.1.1
@@ -0,0 +1,615 @@
+package hardware
+
+// Audio Processing Unit https://www.nesdev.org/wiki/APU
+// Two pulse channels, a triangle, a noise channel and the delta modulation
+// channel, sequenced by the frame counter and mixed with the nonlinear
+// formulas of the real DAC. Timing follows NTSC.
+
+var lengthTable = [32]uint8{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+var dutyTable = [4][8]uint8{
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+var triangleTable = [32]uint8{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+var noisePeriods = [16]uint16{
+	4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068,
+}
+
+var dmcPeriods = [16]uint16{
+	428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54,
+}
+
+// frame counter steps in CPU cycles since the sequence started
+// https://www.nesdev.org/wiki/APU_Frame_Counter
+const (
+	frameStep1     = 7457
+	frameStep2     = 14913
+	frameStep3     = 22371
+	frameStep4     = 29829 // last step of the 4-step sequence, which raises the IRQ
+	frameStep5     = 37281 // last step of the 5-step sequence
+	framePeriod4   = 29830
+	framePeriod5   = 37282
+	highPassCutoff = 37.0 // Hz, removes the DC offset of the mixer output
+)
+
+// envelope is the volume unit shared by the pulse and noise channels
+type envelope struct {
+	start    bool
+	loop     bool // also halts the length counter
+	constant bool
+	period   uint8 // constant volume or decay period
+	divider  uint8
+	decay    uint8
+}
+
+func (e *envelope) clock() {
+	if e.start {
+		e.start = false
+		e.decay = 15
+		e.divider = e.period
+		return
+	}
+	if e.divider > 0 {
+		e.divider--
+		return
+	}
+	e.divider = e.period
+	if e.decay > 0 {
+		e.decay--
+	} else if e.loop {
+		e.decay = 15
+	}
+}
+
+func (e *envelope) volume() uint8 {
+	if e.constant {
+		return e.period
+	}
+	return e.decay
+}
+
+type pulse struct {
+	envelope envelope
+	second   bool // pulse 2 negates its sweep in two's complement
+	enabled  bool
+	duty     uint8
+	step     uint8
+	timer    uint16
+	period   uint16
+	length   uint8
+
+	sweepEnabled bool
+	sweepPeriod  uint8
+	sweepNegate  bool
+	sweepShift   uint8
+	sweepReload  bool
+	sweepDivider uint8
+}
+
+func (p *pulse) write(register uint16, data uint8) {
+	switch register {
+	case 0:
+		p.duty = data >> 6
+		p.envelope.loop = data&0x20 != 0
+		p.envelope.constant = data&0x10 != 0
+		p.envelope.period = data & 0x0F
+	case 1:
+		p.sweepEnabled = data&0x80 != 0
+		p.sweepPeriod = (data >> 4) & 0x07
+		p.sweepNegate = data&0x08 != 0
+		p.sweepShift = data & 0x07
+		p.sweepReload = true
+	case 2:
+		p.period = p.period&0x700 | uint16(data)
+	case 3:
+		p.period = p.period&0x0FF | uint16(data&0x07)<<8
+		if p.enabled {
+			p.length = lengthTable[data>>3]
+		}
+		p.step = 0
+		p.envelope.start = true
+	}
+}
+
+func (p *pulse) clockTimer() {
+	if p.timer == 0 {
+		p.timer = p.period
+		p.step = (p.step + 1) & 7
+	} else {
+		p.timer--
+	}
+}
+
+func (p *pulse) sweepTarget() uint16 {
+	change := p.period >> p.sweepShift
+	if !p.sweepNegate {
+		return p.period + change
+	}
+	if p.second {
+		return p.period - change
+	}
+	return p.period - change - 1
+}
+
+func (p *pulse) muted() bool {
+	return p.period < 8 || (!p.sweepNegate && p.sweepTarget() > 0x7FF)
+}
+
+func (p *pulse) clockSweep() {
+	if p.sweepDivider == 0 && p.sweepEnabled && p.sweepShift > 0 && !p.muted() {
+		p.period = p.sweepTarget()
+	}
+	if p.sweepDivider == 0 || p.sweepReload {
+		p.sweepDivider = p.sweepPeriod
+		p.sweepReload = false
+	} else {
+		p.sweepDivider--
+	}
+}
+
+func (p *pulse) clockLength() {
+	if p.length > 0 && !p.envelope.loop {
+		p.length--
+	}
+}
+
+func (p *pulse) output() uint8 {
+	if p.length == 0 || p.muted() || dutyTable[p.duty][p.step] == 0 {
+		return 0
+	}
+	return p.envelope.volume()
+}
+
+type triangle struct {
+	enabled       bool
+	control       bool // halts the length counter and keeps reloading the linear counter
+	linearPeriod  uint8
+	linear        uint8
+	linearReload  bool
+	timer, period uint16
+	step          uint8
+	length        uint8
+}
+
+func (t *triangle) write(register uint16, data uint8) {
+	switch register {
+	case 0:
+		t.control = data&0x80 != 0
+		t.linearPeriod = data & 0x7F
+	case 2:
+		t.period = t.period&0x700 | uint16(data)
+	case 3:
+		t.period = t.period&0x0FF | uint16(data&0x07)<<8
+		if t.enabled {
+			t.length = lengthTable[data>>3]
+		}
+		t.linearReload = true
+	}
+}
+
+func (t *triangle) clockTimer() {
+	if t.timer > 0 {
+		t.timer--
+		return
+	}
+	t.timer = t.period
+	// periods below 2 are ultrasonic, holding the step avoids the aliasing
+	if t.length > 0 && t.linear > 0 && t.period >= 2 {
+		t.step = (t.step + 1) & 31
+	}
+}
+
+func (t *triangle) clockLinear() {
+	if t.linearReload {
+		t.linear = t.linearPeriod
+	} else if t.linear > 0 {
+		t.linear--
+	}
+	if !t.control {
+		t.linearReload = false
+	}
+}
+
+func (t *triangle) clockLength() {
+	if t.length > 0 && !t.control {
+		t.length--
+	}
+}
+
+func (t *triangle) output() uint8 {
+	return triangleTable[t.step]
+}
+
+type noise struct {
+	envelope      envelope
+	enabled       bool
+	short         bool // mode flag, a 93 step sequence
+	timer, period uint16
+	shift         uint16
+	length        uint8
+}
+
+func (n *noise) write(register uint16, data uint8) {
+	switch register {
+	case 0:
+		n.envelope.loop = data&0x20 != 0
+		n.envelope.constant = data&0x10 != 0
+		n.envelope.period = data & 0x0F
+	case 2:
+		n.short = data&0x80 != 0
+		n.period = noisePeriods[data&0x0F]
+	case 3:
+		if n.enabled {
+			n.length = lengthTable[data>>3]
+		}
+		n.envelope.start = true
+	}
+}
+
+func (n *noise) clockTimer() {
+	if n.timer > 0 {
+		n.timer--
+		return
+	}
+	n.timer = n.period
+	tap := uint16(1)
+	if n.short {
+		tap = 6
+	}
+	feedback := (n.shift ^ n.shift>>tap) & 1
+	n.shift = n.shift>>1 | feedback<<14
+}
+
+func (n *noise) clockLength() {
+	if n.length > 0 && !n.envelope.loop {
+		n.length--
+	}
+}
+
+func (n *noise) output() uint8 {
+	if n.length == 0 || n.shift&1 != 0 {
+		return 0
+	}
+	return n.envelope.volume()
+}
+
+type dmc struct {
+	irqEnabled    bool
+	loop          bool
+	irq           bool
+	timer, period uint16
+	level         uint8
+
+	sampleAddress uint16
+	sampleLength  uint16
+	address       uint16 // next byte to fetch
+	remaining     uint16 // bytes left to fetch
+
+	buffer      uint8
+	bufferFull  bool
+	shift       uint8
+	bitsLeft    uint8
+	silent      bool
+	fetchCycles int // CPU cycles the last fetch stalled for, taken by the CPU
+}
+
+func (d *dmc) write(register uint16, data uint8) {
+	switch register {
+	case 0:
+		d.irqEnabled = data&0x80 != 0
+		d.loop = data&0x40 != 0
+		d.period = dmcPeriods[data&0x0F]
+		if !d.irqEnabled {
+			d.irq = false
+		}
+	case 1:
+		d.level = data & 0x7F
+	case 2:
+		d.sampleAddress = 0xC000 | uint16(data)<<6
+	case 3:
+		d.sampleLength = uint16(data)<<4 | 1
+	}
+}
+
+func (d *dmc) restart() {
+	d.address = d.sampleAddress
+	d.remaining = d.sampleLength
+}
+
+// fetch refills the sample buffer through the CPU bus
+func (d *dmc) fetch(read func(address uint16) uint8) {
+	if d.bufferFull || d.remaining == 0 {
+		return
+	}
+	d.buffer = read(d.address)
+	d.bufferFull = true
+	d.fetchCycles += 4
+	d.address++
+	if d.address == 0 {
+		d.address = 0x8000
+	}
+	d.remaining--
+	if d.remaining == 0 {
+		if d.loop {
+			d.restart()
+		} else if d.irqEnabled {
+			d.irq = true
+		}
+	}
+}
+
+func (d *dmc) clockTimer(read func(address uint16) uint8) {
+	d.fetch(read)
+	if d.timer > 0 {
+		d.timer--
+		return
+	}
+	d.timer = d.period - 1
+	if !d.silent {
+		if d.shift&1 != 0 {
+			if d.level <= 125 {
+				d.level += 2
+			}
+		} else if d.level >= 2 {
+			d.level -= 2
+		}
+	}
+	d.shift >>= 1
+	if d.bitsLeft > 0 {
+		d.bitsLeft--
+	}
+	if d.bitsLeft == 0 {
+		d.bitsLeft = 8
+		d.silent = !d.bufferFull
+		if d.bufferFull {
+			d.shift = d.buffer
+			d.bufferFull = false
+		}
+		d.fetch(read)
+	}
+}
+
+// APU is the sound half of the 2A03
+type APU struct {
+	pulse    [2]pulse
+	triangle triangle
+	noise    noise
+	dmc      dmc
+
+	fiveStep   bool
+	irqInhibit bool
+	frameIRQ   bool
+	frameCycle int
+	odd        bool // pulse and noise timers run at half the CPU rate
+
+	read      func(address uint16) uint8 // DMC sample fetches
+	expansion func() float64             // level of the cartridge's sound, nil without one
+
+	// audio output, not part of the machine state
+	sampleRate  int
+	sampleCycle float64 // CPU cycles into the current output sample
+	sum         float64
+	count       int
+	highPassIn  float64
+	highPassOut float64
+	samples     []float32
+}
+
+// NewAPU returns an APU in its power on state, fetching DMC samples with read
+func NewAPU(read func(address uint16) uint8) *APU {
+	a := &APU{read: read}
+	a.pulse[1].second = true
+	a.noise.shift = 1
+	a.dmc.period = dmcPeriods[0]
+	a.dmc.bitsLeft = 8
+	a.dmc.silent = true
+	return a
+}
+
+// reset is the reset button: the channels are silenced and the frame
+// counter restarts
+func (a *APU) reset() {
+	a.writeRegister(0x4015, 0)
+	a.frameCycle = 0
+	a.frameIRQ = false
+	a.dmc.irq = false
+}
+
+func (a *APU) writeRegister(address uint16, data uint8) {
+	switch {
+	case address < 0x4004:
+		a.pulse[0].write(address-0x4000, data)
+	case address < 0x4008:
+		a.pulse[1].write(address-0x4004, data)
+	case address < 0x400C:
+		a.triangle.write(address-0x4008, data)
+	case address < 0x4010:
+		a.noise.write(address-0x400C, data)
+	case address < 0x4014:
+		a.dmc.write(address-0x4010, data)
+	case address == 0x4015:
+		for i := range a.pulse {
+			a.pulse[i].enabled = data&(1<<i) != 0
+			if !a.pulse[i].enabled {
+				a.pulse[i].length = 0
+			}
+		}
+		a.triangle.enabled = data&0x04 != 0
+		if !a.triangle.enabled {
+			a.triangle.length = 0
+		}
+		a.noise.enabled = data&0x08 != 0
+		if !a.noise.enabled {
+			a.noise.length = 0
+		}
+		a.dmc.irq = false
+		if data&0x10 == 0 {
+			a.dmc.remaining = 0
+		} else if a.dmc.remaining == 0 {
+			a.dmc.restart()
+		}
+	case address == 0x4017:
+		a.fiveStep = data&0x80 != 0
+		a.irqInhibit = data&0x40 != 0
+		if a.irqInhibit {
+			a.frameIRQ = false
+		}
+		a.frameCycle = 0
+		if a.fiveStep {
+			a.quarterFrame()
+			a.halfFrame()
+		}
+	}
+}
+
+// readStatus reads $4015, which acknowledges the frame IRQ
+func (a *APU) readStatus() uint8 {
+	var status uint8
+	for i := range a.pulse {
+		if a.pulse[i].length > 0 {
+			status |= 1 << i
+		}
+	}
+	if a.triangle.length > 0 {
+		status |= 0x04
+	}
+	if a.noise.length > 0 {
+		status |= 0x08
+	}
+	if a.dmc.remaining > 0 {
+		status |= 0x10
+	}
+	if a.frameIRQ {
+		status |= 0x40
+	}
+	if a.dmc.irq {
+		status |= 0x80
+	}
+	a.frameIRQ = false
+	return status
+}
+
+func (a *APU) irq() bool {
+	return a.frameIRQ || a.dmc.irq
+}
+
+func (a *APU) quarterFrame() {
+	a.pulse[0].envelope.clock()
+	a.pulse[1].envelope.clock()
+	a.noise.envelope.clock()
+	a.triangle.clockLinear()
+}
+
+func (a *APU) halfFrame() {
+	for i := range a.pulse {
+		a.pulse[i].clockLength()
+		a.pulse[i].clockSweep()
+	}
+	a.triangle.clockLength()
+	a.noise.clockLength()
+}
+
+func (a *APU) clockFrameCounter() {
+	a.frameCycle++
+	switch a.frameCycle {
+	case frameStep1, frameStep3:
+		a.quarterFrame()
+	case frameStep2:
+		a.quarterFrame()
+		a.halfFrame()
+	case frameStep4:
+		if !a.fiveStep {
+			a.quarterFrame()
+			a.halfFrame()
+			if !a.irqInhibit {
+				a.frameIRQ = true
+			}
+		}
+	case frameStep5:
+		a.quarterFrame()
+		a.halfFrame()
+	}
+	if (!a.fiveStep && a.frameCycle >= framePeriod4) || a.frameCycle >= framePeriod5 {
+		a.frameCycle = 0
+	}
+}
+
+// step advances the APU by a number of CPU cycles and returns the cycles
+// the CPU is stalled for by DMC sample fetches
+func (a *APU) step(cpuCycles int) int {
+	for i := 0; i < cpuCycles; i++ {
+		a.clockFrameCounter()
+		a.triangle.clockTimer()
+		a.dmc.clockTimer(a.read)
+		if a.odd {
+			a.pulse[0].clockTimer()
+			a.pulse[1].clockTimer()
+			a.noise.clockTimer()
+		}
+		a.odd = !a.odd
+		if a.sampleRate > 0 {
+			a.mix()
+		}
+	}
+	stall := a.dmc.fetchCycles
+	a.dmc.fetchCycles = 0
+	return stall
+}
+
+// output returns the mixed level of the channels, between 0 and about 1
+// https://www.nesdev.org/wiki/APU_Mixer
+func (a *APU) output() float64 {
+	var out float64
+	if pulses := float64(a.pulse[0].output()) + float64(a.pulse[1].output()); pulses > 0 {
+		out = 95.88 / (8128/pulses + 100)
+	}
+	tnd := float64(a.triangle.output())/8227 + float64(a.noise.output())/12241 + float64(a.dmc.level)/22638
+	if tnd > 0 {
+		out += 159.79 / (1/tnd + 100)
+	}
+	if a.expansion != nil {
+		out += a.expansion()
+	}
+	return out
+}
+
+// setSampleRate starts sampling the output afresh, 0 turns it off. The
+// high-pass filter starts settled on the current level, so the output
+// doesn't begin with a click.
+func (a *APU) setSampleRate(rate int) {
+	a.sampleRate = rate
+	a.samples = nil
+	a.sampleCycle, a.sum, a.count = 0, 0, 0
+	a.highPassIn, a.highPassOut = a.output(), 0
+}
+
+// mix averages the output over each sample period and removes the DC offset
+func (a *APU) mix() {
+	a.sum += a.output()
+	a.count++
+	a.sampleCycle++
+	period := float64(CPU_FREQUENCY) / float64(a.sampleRate)
+	if a.sampleCycle < period {
+		return
+	}
+	a.sampleCycle -= period
+	level := a.sum / float64(a.count)
+	a.sum, a.count = 0, 0
+
+	rc := 1 / (2 * 3.141592653589793 * highPassCutoff)
+	alpha := rc / (rc + 1/float64(a.sampleRate))
+	a.highPassOut = alpha * (a.highPassOut + level - a.highPassIn)
+	a.highPassIn = level
+	a.samples = append(a.samples, float32(a.highPassOut))
+}
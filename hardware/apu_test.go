@@ -0,0 +1,93 @@
+package hardware
+
+import "testing"
+
+func newTestAPU() *APU {
+	return NewAPU(func(address uint16) uint8 { return 0xAA })
+}
+
+func TestAPULengthCounter(t *testing.T) {
+	a := newTestAPU()
+	// a disabled channel ignores length loads
+	a.writeRegister(0x4003, 0x08)
+	if got := a.readStatus(); got&0x01 != 0 {
+		t.Fatalf("status $%02X with pulse 1 disabled", got)
+	}
+	a.writeRegister(0x4015, 0x0F)
+	a.writeRegister(0x4003, 0x08) // index 1, 254 half frames
+	a.writeRegister(0x400B, 0x18) // index 3, 2 half frames
+	if got := a.readStatus(); got&0x05 != 0x05 {
+		t.Fatalf("status $%02X, want pulse 1 and triangle playing", got)
+	}
+	// the 4-step sequence clocks the length counters twice
+	a.step(framePeriod4)
+	if got := a.readStatus(); got&0x05 != 0x01 {
+		t.Errorf("status $%02X after two half frames, want only pulse 1 playing", got)
+	}
+	if a.pulse[0].length != 252 {
+		t.Errorf("pulse 1 length %d, want 252", a.pulse[0].length)
+	}
+	a.writeRegister(0x4015, 0)
+	if got := a.readStatus(); got&0x0F != 0 {
+		t.Errorf("status $%02X after disabling every channel", got)
+	}
+}
+
+func TestAPUFrameIRQ(t *testing.T) {
+	a := newTestAPU()
+	a.step(frameStep4 - 1)
+	if a.irq() {
+		t.Fatal("frame IRQ before the last step")
+	}
+	a.step(1)
+	if !a.irq() {
+		t.Fatal("no frame IRQ at the last step")
+	}
+	if got := a.readStatus(); got&0x40 == 0 {
+		t.Errorf("status $%02X, want the frame IRQ flag", got)
+	}
+	if a.irq() {
+		t.Error("reading the status didn't acknowledge the frame IRQ")
+	}
+
+	// the 5-step sequence and the inhibit flag never raise it
+	for _, mode := range []uint8{0x80, 0x40} {
+		a.writeRegister(0x4017, mode)
+		a.step(2 * framePeriod5)
+		if a.irq() {
+			t.Errorf("frame IRQ with $4017 = $%02X", mode)
+		}
+	}
+}
+
+func TestAPUDMC(t *testing.T) {
+	a := newTestAPU()
+	a.writeRegister(0x4010, 0x8F) // IRQ, fastest rate
+	a.writeRegister(0x4012, 0x00) // $C000
+	a.writeRegister(0x4013, 0x00) // 1 byte
+	a.writeRegister(0x4015, 0x10)
+	if stall := a.step(1); stall != 4 {
+		t.Errorf("fetch stalled the CPU %d cycles, want 4", stall)
+	}
+	if !a.dmc.irq || a.readStatus()&0x90 != 0x80 {
+		t.Error("no DMC IRQ after the last byte was fetched")
+	}
+	a.writeRegister(0x4015, 0)
+	if a.irq() {
+		t.Error("writing $4015 didn't acknowledge the DMC IRQ")
+	}
+}
+
+func TestAPUSamples(t *testing.T) {
+	a := newTestAPU()
+	a.setSampleRate(48000)
+	a.step(CPU_FREQUENCY / 10)
+	if got := len(a.samples); got < 4799 || got > 4801 {
+		t.Errorf("%d samples in a tenth of a second, want 4800", got)
+	}
+	for _, s := range a.samples {
+		if s > 1e-6 || s < -1e-6 {
+			t.Fatalf("sample %f from a silent APU", s)
+		}
+	}
+}
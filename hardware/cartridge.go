@@ -0,0 +1,349 @@
+package hardware
+
+import (
+	"errors"
+	"fmt"
+)
+
+// iNES header layout https://www.nesdev.org/wiki/INES
+const INES_HEADER_SIZE = 16
+const TRAINER_SIZE = 512
+const PRG_BANK_SIZE = 0x4000 // 16 KiB
+const CHR_BANK_SIZE = 0x2000 // 8 KiB
+
+var inesMagic = [4]uint8{'N', 'E', 'S', 0x1A}
+
+type Mirroring uint8
+
+const (
+	MirrorHorizontal Mirroring = iota
+	MirrorVertical
+	MirrorSingleLower
+	MirrorSingleUpper
+	MirrorFourScreen
+)
+
+var mirroringNames = []string{"horizontal", "vertical", "single screen, lower", "single screen, upper", "four screen"}
+
+func (m Mirroring) String() string {
+	if int(m) < len(mirroringNames) {
+		return mirroringNames[m]
+	}
+	return fmt.Sprintf("Mirroring(%d)", uint8(m))
+}
+
+// Region is the TV system a game was made for, numbered as in NES 2.0
+type Region uint8
+
+const (
+	RegionNTSC Region = iota
+	RegionPAL
+	RegionMulti // runs on both
+	RegionDendy
+)
+
+var regionNames = []string{"NTSC", "PAL", "NTSC and PAL", "Dendy"}
+
+func (r Region) String() string {
+	if int(r) < len(regionNames) {
+		return regionNames[r]
+	}
+	return fmt.Sprintf("Region(%d)", uint8(r))
+}
+
+// mapper is the interface every cartridge board implements.
+// PRG accesses cover $6000-$FFFF, CHR accesses cover $0000-$1FFF of PPU space.
+type mapper interface {
+	readPRG(address uint16) uint8
+	writePRG(address uint16, data uint8)
+	readCHR(address uint16) uint8
+	writeCHR(address uint16, data uint8)
+
+	// offsets into PRG and CHR of the bytes currently mapped at an address,
+	// -1 when no ROM is mapped there
+	prgOffset(address uint16) int
+	chrOffset(address uint16) int
+
+	// registers, banks and on-board RAM for save states
+	saveState(w *stateWriter)
+	loadState(r *stateReader)
+}
+
+// expansion is implemented by boards that do more than map memory: they
+// have registers in $4020-$5FFF, count CPU cycles or raise IRQs
+type expansion interface {
+	// readRegister reports false for addresses the board doesn't drive
+	readRegister(address uint16) (uint8, bool)
+	writeRegister(address uint16, data uint8)
+	clock(cycles int)
+	irq() bool
+}
+
+// soundBoard is implemented by boards with sound channels of their own,
+// which the APU mixes into its output
+type soundBoard interface {
+	sound() float64
+}
+
+// ramBoard is implemented by boards with PRG-RAM, which a battery keeps
+// through a power cycle
+type ramBoard interface {
+	ram() []uint8
+}
+
+type Cartridge struct {
+	PRG       []uint8 // PRG-ROM banks
+	CHR       []uint8 // CHR-ROM, or CHR-RAM when the header declares no CHR banks
+	MapperID  uint8
+	Mirroring Mirroring
+	Battery   bool   // battery backed PRG-RAM at $6000-$7FFF
+	Board     string // UNIF board name, empty for other formats
+	Submapper uint8
+	Region    Region
+	Input     uint8  // NES 2.0 default expansion device, 0 when unspecified
+	Title     string // from a UNIF NAME chunk or the ROM database
+	chrRAM    bool
+
+	mapper    mapper
+	expansion expansion // the mapper, when it implements expansion
+	disk      *Disk     // the disk in the drive of an FDS
+	nsf       *NSF      // tags of a music rip, which has no iNES mapper
+}
+
+var ErrNotINES = errors.New("not an iNES image")
+
+// ParseINES builds a cartridge from the contents of a .nes file
+func ParseINES(data []uint8) (*Cartridge, error) {
+	return parseINES(data, nil)
+}
+
+func parseINES(data []uint8, fix func(*Cartridge)) (*Cartridge, error) {
+	if len(data) < INES_HEADER_SIZE || [4]uint8(data[0:4]) != inesMagic {
+		return nil, ErrNotINES
+	}
+	prgSize := int(data[4]) * PRG_BANK_SIZE
+	chrSize := int(data[5]) * CHR_BANK_SIZE
+	flags6 := data[6]
+	flags7 := data[7]
+
+	cart := &Cartridge{
+		MapperID: (flags7 & 0xF0) | (flags6 >> 4),
+		Battery:  extractBit(flags6, 1) == 1,
+	}
+	// NES 2.0 headers add the submapper, region, input device and the high
+	// bits of the ROM sizes
+	if flags7&0x0C == 0x08 {
+		if data[8]&0x0F != 0 {
+			return nil, fmt.Errorf("unsupported mapper %d", int(data[8]&0x0F)<<8|int(cart.MapperID))
+		}
+		var err error
+		if prgSize, err = nes2Size(data[4], data[9]&0x0F, PRG_BANK_SIZE); err != nil {
+			return nil, err
+		}
+		if chrSize, err = nes2Size(data[5], data[9]>>4, CHR_BANK_SIZE); err != nil {
+			return nil, err
+		}
+		cart.Submapper = data[8] >> 4
+		cart.Region = Region(data[12] & 0x03)
+		cart.Input = data[15] & 0x3F
+	}
+	switch {
+	case extractBit(flags6, 3) == 1:
+		cart.Mirroring = MirrorFourScreen
+	case extractBit(flags6, 0) == 1:
+		cart.Mirroring = MirrorVertical
+	default:
+		cart.Mirroring = MirrorHorizontal
+	}
+
+	offset := INES_HEADER_SIZE
+	if extractBit(flags6, 2) == 1 {
+		offset += TRAINER_SIZE
+	}
+	if prgSize == 0 {
+		return nil, errors.New("iNES image has no PRG-ROM")
+	}
+	if len(data) < offset+prgSize+chrSize {
+		return nil, fmt.Errorf("truncated iNES image: need %d bytes, have %d", offset+prgSize+chrSize, len(data))
+	}
+	cart.PRG = data[offset : offset+prgSize]
+	if chrSize == 0 {
+		cart.CHR = make([]uint8, CHR_BANK_SIZE)
+		cart.chrRAM = true
+	} else {
+		cart.CHR = data[offset+prgSize : offset+prgSize+chrSize]
+	}
+	if err := cart.attach(fix); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// NewCartridge builds a cartridge from separate PRG and CHR images, as a
+// homebrew linker writes them. An empty chr gives 8 KiB of CHR-RAM.
+func NewCartridge(prg, chr []uint8, mapperID uint8, mirroring Mirroring, battery bool) (*Cartridge, error) {
+	cart, err := newCartridge(prg, chr, mapperID, mirroring, battery)
+	if err != nil {
+		return nil, err
+	}
+	if err := cart.attachMapper(); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// newCartridge is NewCartridge without a board attached yet
+func newCartridge(prg, chr []uint8, mapperID uint8, mirroring Mirroring, battery bool) (*Cartridge, error) {
+	if len(prg) == 0 || len(prg)%0x2000 != 0 {
+		return nil, fmt.Errorf("PRG-ROM is %d bytes, want a multiple of 8 KiB", len(prg))
+	}
+	if len(chr)%CHR_BANK_SIZE != 0 {
+		return nil, fmt.Errorf("CHR-ROM is %d bytes, want a multiple of 8 KiB", len(chr))
+	}
+	cart := &Cartridge{
+		PRG:       prg,
+		CHR:       chr,
+		MapperID:  mapperID,
+		Mirroring: mirroring,
+		Battery:   battery,
+	}
+	if len(chr) == 0 {
+		cart.CHR = make([]uint8, CHR_BANK_SIZE)
+		cart.chrRAM = true
+	}
+	return cart, nil
+}
+
+// nes2Size decodes an NES 2.0 ROM size from its low byte and high nibble:
+// a number of units, or 2^E*(MM*2+1) bytes for EEEEEEMM when the nibble is $F
+func nes2Size(low, high uint8, unit int) (int, error) {
+	if high != 0x0F {
+		return (int(high)<<8 | int(low)) * unit, nil
+	}
+	exponent := low >> 2
+	if exponent > 30 {
+		return 0, fmt.Errorf("ROM size 2^%d is too large", exponent)
+	}
+	return 1 << exponent * (int(low&0x03)*2 + 1), nil
+}
+
+// ParseROM builds a cartridge from an iNES or UNIF image or an NSF rip,
+// telling them apart by their magic numbers. FDS disks need the BIOS and go
+// through ParseFDS. fix, when not nil, is called on iNES and UNIF cartridges
+// before the board is attached, to correct what the header says, e.g. from
+// a ROM database.
+func ParseROM(data []uint8, fix func(*Cartridge)) (*Cartridge, error) {
+	switch {
+	case IsNSF(data):
+		return ParseNSF(data)
+	case IsUNIF(data):
+		return parseUNIF(data, fix)
+	}
+	return parseINES(data, fix)
+}
+
+// attach corrects the cartridge with fix, if any, and attaches its board
+func (cart *Cartridge) attach(fix func(*Cartridge)) error {
+	if fix != nil {
+		fix(cart)
+	}
+	return cart.attachMapper()
+}
+
+// Disk returns the disk of a Famicom Disk System, nil for other cartridges
+func (cart *Cartridge) Disk() *Disk {
+	return cart.disk
+}
+
+// HasCHRRAM reports whether CHR is writable RAM rather than ROM from the image
+func (cart *Cartridge) HasCHRRAM() bool {
+	return cart.chrRAM
+}
+
+// PRGOffset returns where in PRG-ROM the byte the CPU sees at address
+// comes from, with the current bank mapping
+func (cart *Cartridge) PRGOffset(address uint16) (int, bool) {
+	offset := cart.mapper.prgOffset(address)
+	return offset, offset >= 0
+}
+
+// CHROffset returns where in CHR-ROM the PPU reads address from. It reports
+// false for CHR-RAM.
+func (cart *Cartridge) CHROffset(address uint16) (int, bool) {
+	if cart.chrRAM {
+		return 0, false
+	}
+	offset := cart.mapper.chrOffset(address)
+	return offset, offset >= 0
+}
+
+func (cart *Cartridge) attachMapper() error {
+	if cart.nsf != nil {
+		// music rips have no mapper number
+		m := newNSFMapper(cart)
+		cart.mapper, cart.expansion = m, m
+		return nil
+	}
+	switch cart.MapperID {
+	case 0:
+		cart.mapper = newNROM(cart)
+	case MAPPER_FDS:
+		if cart.disk == nil {
+			return errors.New("mapper 20 is the Famicom Disk System, load the .fds disk image instead")
+		}
+		cart.mapper = newFDS(cart)
+	default:
+		return fmt.Errorf("unsupported mapper %d", cart.MapperID)
+	}
+	cart.expansion, _ = cart.mapper.(expansion)
+	return nil
+}
+
+// NROM (mapper 0): 16 or 32 KiB of fixed PRG, 8 KiB of CHR and 8 KiB of PRG-RAM
+type nrom struct {
+	cart   *Cartridge
+	prgRAM [0x2000]uint8
+}
+
+func newNROM(cart *Cartridge) *nrom {
+	return &nrom{cart: cart}
+}
+
+func (m *nrom) readPRG(address uint16) uint8 {
+	if address < 0x8000 {
+		return m.prgRAM[address-0x6000]
+	}
+	// a single 16 KiB bank is mirrored into $C000-$FFFF
+	return m.cart.PRG[int(address-0x8000)%len(m.cart.PRG)]
+}
+
+func (m *nrom) prgOffset(address uint16) int {
+	if address < 0x8000 {
+		return -1
+	}
+	return int(address-0x8000) % len(m.cart.PRG)
+}
+
+func (m *nrom) chrOffset(address uint16) int {
+	return int(address & 0x1FFF)
+}
+
+func (m *nrom) ram() []uint8 {
+	return m.prgRAM[:]
+}
+
+func (m *nrom) writePRG(address uint16, data uint8) {
+	if address < 0x8000 {
+		m.prgRAM[address-0x6000] = data
+	}
+}
+
+func (m *nrom) readCHR(address uint16) uint8 {
+	return m.cart.CHR[address]
+}
+
+func (m *nrom) writeCHR(address uint16, data uint8) {
+	if m.cart.chrRAM {
+		m.cart.CHR[address] = data
+	}
+}
@@ -0,0 +1,53 @@
+package hardware
+
+import "testing"
+
+func TestINESSizes(t *testing.T) {
+	tests := []struct {
+		name             string
+		header           [16]uint8
+		prgSize, chrSize int
+	}{
+		{"iNES", [16]uint8{4: 2, 5: 1}, 0x8000, 0x2000},
+		{"iNES CHR-RAM", [16]uint8{4: 1}, 0x4000, 0},
+		{"NES 2.0", [16]uint8{4: 2, 5: 1, 7: 0x08}, 0x8000, 0x2000},
+		// the high nibbles of byte 9 extend the bank counts
+		{"NES 2.0 MSB", [16]uint8{4: 0x00, 5: 0x02, 7: 0x08, 9: 0x11}, 0x100 * PRG_BANK_SIZE, 0x102 * CHR_BANK_SIZE},
+		// $F selects 2^E*(MM*2+1): 2^15*1 and 2^12*3
+		{"NES 2.0 exponent", [16]uint8{4: 15 << 2, 5: 12<<2 | 1, 7: 0x08, 9: 0xFF}, 0x8000, 0x3000},
+	}
+	for _, tt := range tests {
+		header := tt.header
+		copy(header[:], inesMagic[:])
+		data := append(header[:], make([]uint8, tt.prgSize+tt.chrSize)...)
+		cart, err := ParseINES(data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		chrSize := len(cart.CHR)
+		if cart.chrRAM {
+			chrSize = 0
+		}
+		if len(cart.PRG) != tt.prgSize || chrSize != tt.chrSize {
+			t.Errorf("%s: PRG %#x CHR %#x, want %#x and %#x", tt.name, len(cart.PRG), chrSize, tt.prgSize, tt.chrSize)
+		}
+		if _, err := ParseINES(data[:len(data)-1]); err == nil {
+			t.Errorf("%s: loaded a truncated image", tt.name)
+		}
+	}
+}
+
+func TestINESRejects(t *testing.T) {
+	tests := map[string][16]uint8{
+		"no PRG":     {5: 1},
+		"huge":       {4: 31 << 2, 7: 0x08, 9: 0x0F},
+		"mapper 256": {4: 1, 7: 0x08, 8: 0x01},
+	}
+	for name, header := range tests {
+		copy(header[:], inesMagic[:])
+		if _, err := ParseINES(append(header[:], make([]uint8, 0x8000)...)); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package hardware
+
+import "image"
+
+// Console wires the CPU, PPU, APU, cartridge and controllers into one machine
+type Console struct {
+	cpu     CPU
+	ppu     *PPU
+	apu     *APU
+	cart    *Cartridge
+	joypads [2]*Joypad
+	hooks   *Hooks
+
+	ramPolicy RAMPolicy
+}
+
+func NewConsole(cart *Cartridge) *Console {
+	n := &Console{
+		cpu:     NewCPU(),
+		ppu:     NewPPU(cart),
+		cart:    cart,
+		joypads: [2]*Joypad{NewJoypad(), NewJoypad()},
+	}
+	n.apu = NewAPU(n.dmcRead)
+	n.hooks = newHooks(&n.cpu, n.ppu)
+	n.connect()
+	n.Reset()
+	return n
+}
+
+// connect attaches the devices to the CPU bus
+func (n *Console) connect() {
+	n.cpu.ppu = n.ppu
+	n.cpu.apu = n.apu
+	n.cpu.cart = n.cart
+	n.cpu.joypads = n.joypads
+	n.apu.expansion = nil
+	if board, ok := n.cart.mapper.(soundBoard); ok {
+		n.apu.expansion = board.sound
+	}
+}
+
+// Reset behaves like pressing the reset button on the console
+func (n *Console) Reset() {
+	n.ppu.reset()
+	n.apu.reset()
+	n.cpu.reset()
+}
+
+// SetRAMPolicy chooses how internal RAM is filled at power on. It takes
+// effect at the next PowerCycle.
+func (n *Console) SetRAMPolicy(policy RAMPolicy) {
+	n.ramPolicy = policy
+}
+
+// PowerCycle turns the console off and on again, filling RAM according to the
+// RAM policy and clearing PPU state and the cartridge's RAM. Battery backed
+// PRG-RAM keeps its contents.
+func (n *Console) PowerCycle() {
+	cart := n.cart
+	if cart.chrRAM {
+		clear(cart.CHR)
+	}
+	old := cart.mapper
+	cart.attachMapper()
+	if from, ok := old.(ramBoard); ok && cart.Battery {
+		if to, ok := cart.mapper.(ramBoard); ok {
+			copy(to.ram(), from.ram())
+		}
+	}
+	*n.ppu = *NewPPU(cart)
+	rate := n.apu.sampleRate
+	*n.apu = *NewAPU(n.dmcRead)
+	n.apu.setSampleRate(rate)
+	for _, j := range n.joypads {
+		*j = Joypad{}
+	}
+	n.cpu = NewCPU()
+	// hooks outlive the power cycle
+	n.hooks.update()
+	n.cpu.fillRAM(n.ramPolicy)
+	n.connect()
+	n.Reset()
+}
+
+// dmcRead fetches DMC samples through the CPU bus, where hooks see them as
+// reads marked AccessDMC
+func (n *Console) dmcRead(address uint16) uint8 {
+	data := n.cpu.bus_read(address)
+	if n.cpu.hooks != nil {
+		n.cpu.hooks.onAccess(AccessRead|AccessDMC, address, data)
+	}
+	return data
+}
+
+// SetAudioRate sets the rate in Hz the APU output is sampled at, 0 turns
+// sampling off. Samples collect until ReadAudio drains them.
+func (n *Console) SetAudioRate(rate int) {
+	n.apu.setSampleRate(rate)
+}
+
+// ReadAudio returns the mono samples produced since the last call, between
+// -1 and 1
+func (n *Console) ReadAudio() []float32 {
+	samples := n.apu.samples
+	n.apu.samples = nil
+	return samples
+}
+
+// Idle stalls the CPU for a number of cycles while the other devices keep
+// running, like a program waiting in a loop would
+func (n *Console) Idle(cycles int) {
+	n.cpu.stall += cycles
+	n.cpu.Step()
+}
+
+// Step executes a single CPU instruction and returns the cycles it took
+func (n *Console) Step() int {
+	return n.cpu.Step()
+}
+
+// StepFrame runs the machine until the PPU finishes the next frame
+func (n *Console) StepFrame() {
+	frame := n.ppu.frame
+	for n.ppu.frame == frame {
+		n.cpu.Step()
+	}
+}
+
+func (n *Console) Cartridge() *Cartridge {
+	return n.cart
+}
+
+// Joypad returns the controller plugged into port 0 or 1
+func (n *Console) Joypad(port int) *Joypad {
+	return n.joypads[port]
+}
+
+func (n *Console) Frame() *FrameBuffer {
+	return n.ppu.Frame()
+}
+
+// FrameImage converts the most recent frame to RGB, applying the colour
+// emphasis each line was drawn with
+func (n *Console) FrameImage(pal *Palette) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, SCREEN_WIDTH, SCREEN_HEIGHT))
+	for y := 0; y < SCREEN_HEIGHT; y++ {
+		emphasis := n.ppu.Emphasis(y)
+		for x := 0; x < SCREEN_WIDTH; x++ {
+			img.SetRGBA(x, y, pal.Color(n.ppu.buffer[y*SCREEN_WIDTH+x], emphasis))
+		}
+	}
+	return img
+}
+
+// LineEmphasis returns the PPUMASK colour emphasis bits line y of the most
+// recent frame was drawn with
+func (n *Console) LineEmphasis(y int) uint8 {
+	return n.ppu.Emphasis(y)
+}
+
+// LinePhase returns the colour subcarrier phase line y of the most recent
+// frame started at, see PPU.Phase
+func (n *Console) LinePhase(y int) uint8 {
+	return n.ppu.Phase(y)
+}
+
+func (n *Console) FrameCount() uint64 {
+	return n.ppu.FrameCount()
+}
+
+// Cycles returns the number of CPU cycles executed since power on
+func (n *Console) Cycles() uint64 {
+	return n.cpu.cycles
+}
+
+// Hooks returns the registry of bus observers
+func (n *Console) Hooks() *Hooks {
+	return n.hooks
+}
+
+// CPU gives access to the console's processor, for inspecting and changing
+// registers and memory
+func (n *Console) CPU() *CPU {
+	return &n.cpu
+}
+
+// Peek reads CPU address space without triggering register side effects.
+// Memory mapped registers read back as 0.
+func (n *Console) Peek(address uint16) uint8 {
+	return n.cpu.Peek(address)
+}
+
+// Poke writes CPU RAM or cartridge RAM without side effects, see CPU.Poke
+func (n *Console) Poke(address uint16, value uint8) {
+	n.cpu.Poke(address, value)
+}
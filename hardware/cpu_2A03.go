@@ -1,9 +1,6 @@
 package hardware
 
-import (
-	"fmt"
-	"os"
-)
+import "fmt"
 
 // Constants for stack start address and stack reset value
 // The reason the NES stack ends at 253 bytes (0x01FD) rather than 256 bytes (0x01FF) is due to a hardware limitation.
@@ -16,6 +13,9 @@ const NMI uint16 = 0xFFFA // Non Maskable Interrupt
 const RES uint16 = 0xFFFC // Reset Interrupt
 const IRQ uint16 = 0xFFFE // Ordinary Interrupt Request
 
+// NTSC CPU clock in Hz
+const CPU_FREQUENCY = 1789773
+
 // general helpful functions
 func extractBit(val uint8, pos uint8) uint8 {
 	return (val & (1 << pos)) >> pos
@@ -32,6 +32,24 @@ type CPU struct {
 
 	//memory
 	memory []uint8
+
+	//devices on the bus, nil when running bare code placed with load
+	ppu     *PPU
+	apu     *APU
+	cart    *Cartridge
+	joypads [2]*Joypad
+
+	cycles   uint64 // cycles executed since power on
+	stall    int    // cycles the CPU is halted for, e.g. by OAM DMA
+	halt     *HaltError
+	open_bus uint8 // last value on the data bus, what unmapped addresses read as
+
+	// state of the instruction being executed
+	operand      uint16 // address of its operand bytes
+	crossed      bool   // indexing crossed a page
+	extra_cycles int    // taken branches and page crossings
+
+	hooks *Hooks // nil unless something is registered, see Hooks
 }
 
 type Flags uint8
@@ -65,12 +83,96 @@ const (
 	modeNoneAddressing
 )
 
-// helper functions to read and write memory
+// helper functions to read and write memory, observed by hooks
 func (c *CPU) mem_read(address uint16) uint8 {
-	return c.memory[address]
+	data := c.bus_read(address)
+	if c.hooks != nil {
+		c.hooks.onAccess(AccessRead, address, data)
+	}
+	return data
 }
 func (c *CPU) mem_write(address uint16, data uint8) {
-	c.memory[address] = data
+	if c.hooks != nil {
+		c.hooks.onAccess(AccessWrite, address, data)
+	}
+	c.bus_write(address, data)
+}
+
+// bus_read and bus_write route an access to the device mapped at the address
+// https://www.nesdev.org/wiki/CPU_memory_map
+func (c *CPU) bus_read(address uint16) uint8 {
+	data := c.device_read(address)
+	c.open_bus = data
+	if c.hooks != nil && len(c.hooks.intercepts) > 0 {
+		data = c.hooks.intercept(address, data)
+	}
+	return data
+}
+func (c *CPU) device_read(address uint16) uint8 {
+	switch {
+	case address < 0x2000:
+		// 2 KiB of internal RAM mirrored four times
+		return c.memory[address&0x07FF]
+	case address >= 0x2000 && address < 0x4000 && c.ppu != nil:
+		return c.ppu.readRegister(0x2000 + address&0x0007)
+	case address == 0x4015 && c.apu != nil:
+		// bit 5 is not driven and keeps the open bus value
+		return c.apu.readStatus() | c.open_bus&0x20
+	case address == 0x4016 || address == 0x4017:
+		if j := c.joypads[address-0x4016]; j != nil {
+			return j.read()
+		}
+	case address >= 0x4020 && address < 0x6000 && c.cart != nil && c.cart.expansion != nil:
+		if data, ok := c.cart.expansion.readRegister(address); ok {
+			return data
+		}
+	case address >= 0x6000 && c.cart != nil:
+		return c.cart.mapper.readPRG(address)
+	}
+	// a bare CPU has flat RAM everywhere else
+	if c.cart == nil {
+		return c.memory[address]
+	}
+	return c.open_bus
+}
+func (c *CPU) bus_write(address uint16, data uint8) {
+	c.open_bus = data
+	switch {
+	case address < 0x2000:
+		c.memory[address&0x07FF] = data
+		return
+	case address >= 0x2000 && address < 0x4000 && c.ppu != nil:
+		c.ppu.writeRegister(0x2000+address&0x0007, data)
+		return
+	case address == 0x4014 && c.ppu != nil:
+		// the DMA unit reads the page through the bus like the CPU would
+		page := uint16(data) << 8
+		var buf [0x100]uint8
+		for i := range buf {
+			buf[i] = c.mem_read(page + uint16(i))
+		}
+		c.ppu.writeDMA(buf[:])
+		c.stall += 513 + int(c.cycles%2)
+		return
+	case address >= 0x4000 && address < 0x4018 && address != 0x4016 && c.apu != nil:
+		c.apu.writeRegister(address, data)
+		return
+	case address == 0x4016:
+		// a single strobe line is shared by both controller ports
+		for _, j := range c.joypads {
+			if j != nil {
+				j.write(data)
+			}
+		}
+	case address >= 0x4020 && address < 0x6000 && c.cart != nil && c.cart.expansion != nil:
+		c.cart.expansion.writeRegister(address, data)
+	case address >= 0x6000 && c.cart != nil:
+		c.cart.mapper.writePRG(address, data)
+		return
+	}
+	if c.cart == nil {
+		c.memory[address] = data
+	}
 }
 
 // 2A03 follows the little endian model to store 16 bit numbers
@@ -88,81 +190,81 @@ func (c *CPU) mem_write_16(address uint16, data uint16) {
 	c.mem_write(address+1, msb)
 }
 
-// stack functions
+// stack functions, the stack lives in page 1 and grows down
 func (c *CPU) push(data uint8) {
-	c.memory[STACK_START+uint16(c.stack_pointer)] = data
+	c.mem_write(STACK_START+uint16(c.stack_pointer), data)
 	c.stack_pointer--
 }
 
 func (c *CPU) pop() uint8 {
-	top := c.memory[STACK_START+uint16(c.stack_pointer)]
 	c.stack_pointer++
-	return top
+	return c.mem_read(STACK_START + uint16(c.stack_pointer))
 }
 
+// 16 bit values are pushed high byte first, leaving them little endian in
+// memory
 func (c *CPU) push_16(data uint16) {
-	lsb := uint8(data & 0xFF)
-	msb := uint8(data >> 8)
-	c.memory[STACK_START+uint16(c.stack_pointer)] = lsb
-	c.stack_pointer--
-	c.memory[STACK_START+uint16(c.stack_pointer)] = msb
-	c.stack_pointer--
+	c.push(uint8(data >> 8))
+	c.push(uint8(data & 0xFF))
 }
 
 func (c *CPU) pop_16() uint16 {
-	msb := uint16(c.memory[c.stack_pointer])
-	c.stack_pointer++
-	lsb := uint16(c.memory[c.stack_pointer])
-	c.stack_pointer++
+	lsb := uint16(c.pop())
+	msb := uint16(c.pop())
 	return (msb << 8) | lsb
 }
 
-// Helper function to calculate the operand address based on addressing mode
+// pageCrossed reports whether base and address are in different pages
+func pageCrossed(base, address uint16) bool {
+	return base&0xFF00 != address&0xFF00
+}
+
+// Helper function to calculate the operand address based on addressing mode.
+// The operand bytes are at c.operand.
 func (c *CPU) address_operand(mode AddressingMode) uint16 {
 	var address uint16
 	switch mode {
-	case modeImmediate:
-		address = c.program_counter
+	case modeImmediate, modeRelative:
+		address = c.operand
 	case modeZeroPage:
-		address = uint16(c.mem_read(c.program_counter))
+		address = uint16(c.mem_read(c.operand))
 	case modeAbsolute:
-		address = c.mem_read_16(c.program_counter)
+		address = c.mem_read_16(c.operand)
 	case modeZeroPageX:
-		base_addr := c.mem_read(c.program_counter)
+		base_addr := c.mem_read(c.operand)
 		address = uint16(base_addr + c.index_x)
 	case modeZeroPageY:
-		base_addr := c.mem_read(c.program_counter)
+		base_addr := c.mem_read(c.operand)
 		address = uint16(base_addr + c.index_y)
 	case modeAbsoluteX:
-		base_addr := c.mem_read_16(c.program_counter)
+		base_addr := c.mem_read_16(c.operand)
 		address = base_addr + uint16(c.index_x)
+		c.crossed = pageCrossed(base_addr, address)
 	case modeAbsoluteY:
-		base_addr := c.mem_read_16(c.program_counter)
+		base_addr := c.mem_read_16(c.operand)
 		address = base_addr + uint16(c.index_y)
+		c.crossed = pageCrossed(base_addr, address)
 	case modeIndirectX:
-		base := c.mem_read(c.program_counter)
+		base := c.mem_read(c.operand)
 		var offset uint8 = base + c.index_x
 		lsb := c.mem_read(uint16(offset))
 		msb := c.mem_read(uint16(offset + 1))
 		address = (uint16(msb) << 8) | uint16(lsb)
 	case modeIndirectY:
-		base := c.mem_read(c.program_counter)
-		var offset uint8 = base + c.index_y
+		// the pointer wraps around the zero page, Y is added to what it
+		// points at
+		offset := c.mem_read(c.operand)
 		lsb := c.mem_read(uint16(offset))
 		msb := c.mem_read(uint16(offset + 1))
-		address = (uint16(msb) << 8) | uint16(lsb)
-	case modeRelative:
-		address = c.program_counter
+		base_addr := (uint16(msb) << 8) | uint16(lsb)
+		address = base_addr + uint16(c.index_y)
+		c.crossed = pageCrossed(base_addr, address)
 	case modeIndirect:
-		lsb := uint16(c.mem_read(c.program_counter))
-		msb := uint16(c.mem_read(c.program_counter + 1))
-		indirectVector := (msb << 8) | lsb
+		indirectVector := c.mem_read_16(c.operand)
 		address_lsb := uint16(c.mem_read(indirectVector))
-		address_msb := uint16(c.mem_read(indirectVector + 1))
-		if indirectVector&0x00ff == 0x00ff {
-			address_msb = address_lsb & 0xff00
-		}
-
+		// the 6502 doesn't carry into the high byte of the vector, JMP
+		// ($10FF) reads $10FF and $1000
+		address_msb := uint16(c.mem_read(indirectVector&0xFF00 | uint16(uint8(indirectVector)+1)))
 		address = (address_msb << 8) | address_lsb
 	}
 
@@ -204,28 +306,41 @@ func (c *CPU) updateZandN(val uint8) {
 	}
 
 	//check for negative
-	if val&128 == 128 {
-		c.setFlags(Z)
-	} else {
-		c.resetFlags(Z)
-	}
+	c.setFlagValue(N, extractBit(val, 7))
 }
 
-// INSTRUCTIONS
-func (c *CPU) adc(mode AddressingMode) {
-	address := c.address_operand(mode)
-	value := c.mem_read(address)
-	res := c.accumulator + value + c.getFlagValue(C)
-	if res > 255 {
-		c.setFlags(C)
-	}
-	if res > 127 {
-		c.setFlags(V)
+// the status byte as pushed by PHP and BRK, which set B, or by interrupts,
+// which clear it. The unused bit always reads back as 1.
+func (c *CPU) pushedStatus(brk bool) uint8 {
+	status := c.status | 1<<X
+	if brk {
+		return status | 1<<B
 	}
+	return status &^ (1 << B)
+}
+
+// pulling the status leaves B clear and the unused bit set, they don't
+// exist in the register
+func (c *CPU) pullStatus() {
+	c.status = c.pop()&^(1<<B) | 1<<X
+}
 
+// INSTRUCTIONS
+// addWithCarry adds value and the carry to the accumulator, SBC adds the
+// complement. The 2A03 has no decimal mode.
+func (c *CPU) addWithCarry(value uint8) {
+	sum := uint16(c.accumulator) + uint16(value) + uint16(c.getFlagValue(C))
+	res := uint8(sum)
+	c.setFlagValue(C, uint8(sum>>8))
+	// overflow when both inputs have the same sign and the result doesn't
+	c.setFlagValue(V, extractBit((c.accumulator^res)&(value^res), 7))
 	c.accumulator = res
 	c.updateZandN(c.accumulator)
+}
 
+func (c *CPU) adc(mode AddressingMode) {
+	address := c.address_operand(mode)
+	c.addWithCarry(c.mem_read(address))
 }
 
 func (c *CPU) and(mode AddressingMode) {
@@ -239,39 +354,43 @@ func (c *CPU) asl(mode AddressingMode) {
 	if mode == modeAccumulator {
 		c.setFlagValue(C, extractBit(c.accumulator, 7))
 		c.accumulator = c.accumulator << 1
+		c.updateZandN(c.accumulator)
 	} else {
 		address := c.address_operand(mode)
 		value := c.mem_read(address)
 		c.setFlagValue(C, extractBit(value, 7))
 		value = value << 1
 		c.mem_write(address, value)
+		c.updateZandN(value)
 	}
+}
 
-	c.updateZandN(c.status)
+// branch jumps by the signed offset in the operand when taken, which costs
+// a cycle, and one more when the target is in another page
+func (c *CPU) branch(taken bool) {
+	if !taken {
+		return
+	}
+	address := c.address_operand(modeRelative)
+	offset := int8(c.mem_read(address))
+	target := c.program_counter + uint16(offset)
+	c.extra_cycles++
+	if pageCrossed(c.program_counter, target) {
+		c.extra_cycles++
+	}
+	c.program_counter = target
 }
 
 func (c *CPU) bcc() {
-	if c.getFlagValue(C) == 0 {
-		address := c.address_operand(modeRelative)
-		value := c.mem_read(address)
-		c.program_counter += uint16(value)
-	}
+	c.branch(c.getFlagValue(C) == 0)
 }
 
 func (c *CPU) bcs() {
-	if c.getFlagValue(C) == 1 {
-		address := c.address_operand(modeRelative)
-		value := c.mem_read(address)
-		c.program_counter += uint16(value)
-	}
+	c.branch(c.getFlagValue(C) == 1)
 }
 
 func (c *CPU) beq() {
-	if c.getFlagValue(Z) == 1 {
-		address := c.address_operand(modeRelative)
-		value := c.mem_read(address)
-		c.program_counter += uint16(value)
-	}
+	c.branch(c.getFlagValue(Z) == 1)
 }
 
 func (c *CPU) bit(mode AddressingMode) {
@@ -283,55 +402,40 @@ func (c *CPU) bit(mode AddressingMode) {
 	} else {
 		c.resetFlags(Z)
 	}
-	c.setFlagValue(V, extractBit(res, 6))
-	c.setFlagValue(N, extractBit(res, 7))
+	// V and N are copied from memory, not from the result
+	c.setFlagValue(V, extractBit(value, 6))
+	c.setFlagValue(N, extractBit(value, 7))
 }
 
 func (c *CPU) bmi() {
-	if c.getFlagValue(N) == 1 {
-		address := c.address_operand(modeRelative)
-		value := c.mem_read(address)
-		c.program_counter += uint16(value)
-	}
+	c.branch(c.getFlagValue(N) == 1)
 }
 
 func (c *CPU) bne() {
-	if c.getFlagValue(C) == 0 {
-		address := c.address_operand(modeRelative)
-		value := c.mem_read(address)
-		c.program_counter += uint16(value)
-	}
+	c.branch(c.getFlagValue(Z) == 0)
 }
 
 func (c *CPU) bpl() {
-	if c.getFlagValue(N) == 0 {
-		address := c.address_operand(modeRelative)
-		value := c.mem_read(address)
-		c.program_counter += uint16(value)
-	}
+	c.branch(c.getFlagValue(N) == 0)
 }
 
 func (c *CPU) brk() {
-	c.push_16(c.program_counter)
-	c.push(c.status)
+	if c.hooks != nil {
+		c.hooks.onInterrupt(InterruptBRK)
+	}
+	// BRK is followed by a padding byte the return address skips
+	c.push_16(c.program_counter + 1)
+	c.push(c.pushedStatus(true))
+	c.setFlags(I)
 	c.program_counter = c.mem_read_16(IRQ)
-	c.setFlags(B)
 }
 
 func (c *CPU) bvc() {
-	if c.getFlagValue(V) == 0 {
-		address := c.address_operand(modeRelative)
-		value := c.mem_read(address)
-		c.program_counter += uint16(value)
-	}
+	c.branch(c.getFlagValue(V) == 0)
 }
 
 func (c *CPU) bvs() {
-	if c.getFlagValue(V) == 1 {
-		address := c.address_operand(modeRelative)
-		value := c.mem_read(address)
-		c.program_counter += uint16(value)
-	}
+	c.branch(c.getFlagValue(V) == 1)
 }
 
 func (c *CPU) clc() {
@@ -350,36 +454,28 @@ func (c *CPU) clv() {
 	c.resetFlags(V)
 }
 
-func (c *CPU) cmp(mode AddressingMode) {
+// compare sets the flags as for register - value, without storing it
+func (c *CPU) compare(register uint8, mode AddressingMode) {
 	address := c.address_operand(mode)
 	value := c.mem_read(address)
-	res := c.accumulator - value
-	if res >= uint8(0) {
+	if register >= value {
 		c.setFlags(C)
+	} else {
+		c.resetFlags(C)
 	}
-	c.updateZandN(res)
+	c.updateZandN(register - value)
 }
 
-func (c *CPU) cpx(mode AddressingMode) {
-	address := c.address_operand(mode)
-	value := c.mem_read(address)
-	res := c.index_x - value
-	if res >= uint8(0) {
-		c.setFlags(C)
-	}
-	c.updateZandN(res)
+func (c *CPU) cmp(mode AddressingMode) {
+	c.compare(c.accumulator, mode)
+}
 
+func (c *CPU) cpx(mode AddressingMode) {
+	c.compare(c.index_x, mode)
 }
 
 func (c *CPU) cpy(mode AddressingMode) {
-	address := c.address_operand(mode)
-	value := c.mem_read(address)
-	res := c.index_y - value
-	if res >= uint8(0) {
-		c.setFlags(C)
-	}
-	c.updateZandN(res)
-
+	c.compare(c.index_y, mode)
 }
 
 func (c *CPU) dec(mode AddressingMode) {
@@ -432,8 +528,9 @@ func (c *CPU) jmp(mode AddressingMode) {
 }
 
 func (c *CPU) jsr() {
-	c.push_16(c.program_counter - 1)
 	address := c.address_operand(modeAbsolute)
+	// the return address pushed is that of the last byte of the JSR
+	c.push_16(c.program_counter - 1)
 	c.program_counter = address
 }
 
@@ -486,7 +583,7 @@ func (c *CPU) pha() {
 }
 
 func (c *CPU) php() {
-	c.push(c.status)
+	c.push(c.pushedStatus(true))
 }
 
 func (c *CPU) pla() {
@@ -495,20 +592,18 @@ func (c *CPU) pla() {
 }
 
 func (c *CPU) plp() {
-	c.status = c.pop()
-	c.updateZandN(c.status)
+	c.pullStatus()
 }
 
 func (c *CPU) rol(mode AddressingMode) {
+	prevCarry := c.getFlagValue(C)
 	if mode == modeAccumulator {
-		prevCarry := extractBit(c.status, 0)
 		c.setFlagValue(C, extractBit(c.accumulator, 7))
 		c.accumulator = (c.accumulator << 1) | prevCarry
 		c.updateZandN(c.accumulator)
 	} else {
 		address := c.address_operand(mode)
 		value := c.mem_read(address)
-		prevCarry := extractBit(c.status, 0)
 		c.setFlagValue(C, extractBit(value, 7))
 		value = (value << 1) | prevCarry
 		c.mem_write(address, value)
@@ -517,16 +612,15 @@ func (c *CPU) rol(mode AddressingMode) {
 }
 
 func (c *CPU) ror(mode AddressingMode) {
+	prevCarry := c.getFlagValue(C)
 	if mode == modeAccumulator {
-		prevCarry := extractBit(c.status, 0)
 		c.setFlagValue(C, extractBit(c.accumulator, 0))
 		c.accumulator = (c.accumulator >> 1) | (prevCarry << 7)
 		c.updateZandN(c.accumulator)
 	} else {
 		address := c.address_operand(mode)
 		value := c.mem_read(address)
-		prevCarry := extractBit(c.status, 0)
-		c.setFlagValue(C, extractBit(c.accumulator, 0))
+		c.setFlagValue(C, extractBit(value, 0))
 		value = (value >> 1) | (prevCarry << 7)
 		c.mem_write(address, value)
 		c.updateZandN(value)
@@ -534,28 +628,17 @@ func (c *CPU) ror(mode AddressingMode) {
 }
 
 func (c *CPU) rti() {
-	c.status = c.pop()
+	c.pullStatus()
 	c.program_counter = c.pop_16()
 }
 
 func (c *CPU) rts() {
-	c.program_counter = c.pop_16()
+	c.program_counter = c.pop_16() + 1
 }
 
 func (c *CPU) sbc(mode AddressingMode) {
 	address := c.address_operand(mode)
-	value := c.mem_read(address)
-	res := c.accumulator - value - (1 - c.getFlagValue(C))
-	if res > 255 {
-		c.setFlags(C)
-	}
-	if res > 127 {
-		c.setFlags(V)
-	}
-
-	c.accumulator = res
-	c.updateZandN(c.accumulator)
-
+	c.addWithCarry(^c.mem_read(address))
 }
 
 func (c *CPU) sec() {
@@ -596,7 +679,7 @@ func (c *CPU) tay() {
 }
 
 func (c *CPU) tsx() {
-	c.index_x = c.status
+	c.index_x = c.stack_pointer
 	c.updateZandN(c.index_x)
 }
 
@@ -605,9 +688,9 @@ func (c *CPU) txa() {
 	c.updateZandN(c.accumulator)
 }
 
+// TXS is the one transfer that leaves the flags alone
 func (c *CPU) txs() {
-	c.status = c.index_x
-	c.updateZandN(c.index_x)
+	c.stack_pointer = c.index_x
 }
 
 func (c *CPU) tya() {
@@ -622,489 +705,242 @@ func (c *CPU) load(instructions []uint8) {
 	c.mem_write_16(0xFFFC, 0x8000) // set the reset vector https://en.wikipedia.org/wiki/Reset_vector
 }
 
+// Load places a program at origin, points the reset vector at it and resets
+// the CPU, ready to Step. It is meant for bare CPUs without a cartridge, such
+// as those running code from the asm package in tests.
+func (c *CPU) Load(origin uint16, program []uint8) {
+	for i, val := range program {
+		c.memory[origin+uint16(i)] = val
+	}
+	c.mem_write_16(RES, origin)
+	c.reset()
+}
+
 func (c *CPU) reset() {
 	c.accumulator = 0
 	c.index_x = 0
 	c.index_y = 0
 	c.status = 0b00100100
 	c.stack_pointer = STACK_RESET
+	c.halt = nil
+	if c.hooks != nil {
+		c.hooks.onInterrupt(InterruptReset)
+	}
 	c.program_counter = c.mem_read_16(0xFFFC)
 
 }
 
+// HaltError reports that the CPU stopped on an opcode it doesn't implement.
+// Undocumented opcodes are not emulated, some of them jam a real 6502 too.
+type HaltError struct {
+	Opcode  uint8
+	Address uint16
+}
+
+func (e *HaltError) Error() string {
+	return fmt.Sprintf("CPU halted on undocumented opcode $%02X at $%04X", e.Opcode, e.Address)
+}
+
+// Halted returns why the CPU stopped executing, nil while it runs. A halted
+// CPU only counts cycles until the next reset.
+func (c *CPU) Halted() error {
+	if c.halt == nil {
+		return nil
+	}
+	return c.halt
+}
+
+// base cycle count of every opcode, page crossing and taken branch
+// penalties are added by execute
+// https://www.nesdev.org/wiki/6502_cycle_times
+var opcodeCycles = [256]uint8{
+	7, 6, 2, 8, 3, 3, 5, 5, 3, 2, 2, 2, 4, 4, 6, 6,
+	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
+	6, 6, 2, 8, 3, 3, 5, 5, 4, 2, 2, 2, 4, 4, 6, 6,
+	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
+	6, 6, 2, 8, 3, 3, 5, 5, 3, 2, 2, 2, 3, 4, 6, 6,
+	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
+	6, 6, 2, 8, 3, 3, 5, 5, 4, 2, 2, 2, 5, 4, 6, 6,
+	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
+	2, 6, 2, 6, 3, 3, 3, 3, 2, 2, 2, 2, 4, 4, 4, 4,
+	2, 6, 2, 6, 4, 4, 4, 4, 2, 5, 2, 5, 5, 5, 5, 5,
+	2, 6, 2, 6, 3, 3, 3, 3, 2, 2, 2, 2, 4, 4, 4, 4,
+	2, 5, 2, 5, 4, 4, 4, 4, 2, 4, 2, 4, 4, 4, 4, 4,
+	2, 6, 2, 8, 3, 3, 5, 5, 2, 2, 2, 2, 4, 4, 6, 6,
+	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
+	2, 6, 2, 8, 3, 3, 5, 5, 2, 2, 2, 2, 4, 4, 6, 6,
+	2, 5, 2, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7,
+}
+
+// nmi services a non maskable interrupt raised by the PPU
+func (c *CPU) nmi() int {
+	if c.hooks != nil {
+		c.hooks.onInterrupt(InterruptNMI)
+	}
+	c.push_16(c.program_counter)
+	c.push(c.pushedStatus(false))
+	c.setFlags(I)
+	c.program_counter = c.mem_read_16(NMI)
+	return 7
+}
+
+// irq services an interrupt request from the cartridge or the APU
+func (c *CPU) irq() int {
+	if c.hooks != nil {
+		c.hooks.onInterrupt(InterruptIRQ)
+	}
+	c.push_16(c.program_counter)
+	c.push(c.pushedStatus(false))
+	c.setFlags(I)
+	c.program_counter = c.mem_read_16(IRQ)
+	return 7
+}
+
+// Step executes a single instruction, or services a pending interrupt,
+// and returns the number of cycles it took. Attached devices are advanced
+// by the same number of cycles.
+func (c *CPU) Step() int {
+	cycles := c.step()
+	c.cycles += uint64(cycles)
+	if c.ppu != nil {
+		c.ppu.step(cycles)
+	}
+	if c.apu != nil {
+		// DMC sample fetches stall the CPU
+		c.stall += c.apu.step(cycles)
+	}
+	if c.cart != nil && c.cart.expansion != nil {
+		c.cart.expansion.clock(cycles)
+	}
+	return cycles
+}
+
+func (c *CPU) step() int {
+	if c.stall > 0 {
+		stalled := c.stall
+		c.stall = 0
+		return stalled
+	}
+	if c.halt != nil {
+		return 2
+	}
+	if c.ppu != nil && c.ppu.nmi {
+		c.ppu.nmi = false
+		return c.nmi()
+	}
+	if c.cart != nil && c.cart.expansion != nil && c.cart.expansion.irq() && c.getFlagValue(I) == 0 {
+		return c.irq()
+	}
+	if c.apu != nil && c.apu.irq() && c.getFlagValue(I) == 0 {
+		return c.irq()
+	}
+
+	// the opcode fetch is an execution, not a data read
+	opcode := c.bus_read(c.program_counter)
+	if c.hooks != nil {
+		c.hooks.onAccess(AccessExec, c.program_counter, opcode)
+	}
+	return c.execute(opcode)
+}
+
 func (c *CPU) Interpret() {
-	for {
-		opcode := c.memory[c.program_counter]
-		c.program_counter++
-		switch opcode {
-
-		case 0x69:
-			c.adc(modeImmediate)
-			c.program_counter++
-		case 0x65:
-			c.adc(modeZeroPage)
-			c.program_counter++
-		case 0x75:
-			c.adc(modeZeroPageX)
-			c.program_counter++
-		case 0x6D:
-			c.adc(modeAbsolute)
-			c.program_counter += 2
-		case 0x7d:
-			c.adc(modeAbsoluteX)
-			c.program_counter += 2
-		case 0x79:
-			c.adc(modeAbsoluteY)
-			c.program_counter += 2
-		case 0x61:
-			c.adc(modeIndirectX)
-			c.program_counter++
-		case 0x71:
-			c.adc(modeIndirectY)
-			c.program_counter++
-
-		case 0x29:
-			c.and(modeImmediate)
-			c.program_counter++
-		case 0x25:
-			c.and(modeZeroPage)
-			c.program_counter++
-		case 0x35:
-			c.and(modeZeroPageX)
-			c.program_counter++
-		case 0x2d:
-			c.and(modeAbsolute)
-			c.program_counter += 2
-		case 0x3d:
-			c.and(modeAbsoluteX)
-			c.program_counter += 2
-		case 0x39:
-			c.and(modeAbsoluteY)
-			c.program_counter += 2
-		case 0x21:
-			c.and(modeIndirectX)
-			c.program_counter++
-		case 0x31:
-			c.and(modeIndirectY)
-			c.program_counter++
-
-		case 0x0a:
-			c.asl(modeAccumulator)
-		case 0x06:
-			c.asl(modeZeroPage)
-			c.program_counter++
-		case 0x16:
-			c.asl(modeZeroPageX)
-			c.program_counter++
-		case 0x0e:
-			c.asl(modeAbsolute)
-			c.program_counter += 2
-		case 0x1e:
-			c.asl(modeAbsoluteX)
-			c.program_counter += 2
-
-		case 0x90:
-			c.bcc()
-			c.program_counter++
-
-		case 0xb0:
-			c.bcs()
-			c.program_counter++
-
-		case 0xf0:
-			c.beq()
-			c.program_counter++
-
-		case 0x24:
-			c.bit(modeZeroPage)
-			c.program_counter++
-		case 0x2c:
-			c.bit(modeAbsolute)
-			c.program_counter += 2
-
-		case 0x30:
-			c.bmi()
-			c.program_counter++
-
-		case 0xd0:
-			c.bne()
-			c.program_counter++
-
-		case 0x10:
-			c.bpl()
-			c.program_counter++
-
-		case 0x00:
-			c.brk()
-
-		case 0x50:
-			c.bvc()
-			c.program_counter++
-
-		case 0x70:
-			c.bvs()
-			c.program_counter++
-
-		case 0x18:
-			c.clc()
-		case 0xd8:
-			c.cld()
-		case 0x58:
-			c.cli()
-		case 0xb8:
-			c.clv()
-
-		case 0xc9:
-			c.cmp(modeImmediate)
-			c.program_counter++
-		case 0xc5:
-			c.cmp(modeZeroPage)
-			c.program_counter++
-		case 0xd5:
-			c.cmp(modeZeroPageX)
-			c.program_counter++
-		case 0xcd:
-			c.cmp(modeAbsolute)
-			c.program_counter += 2
-		case 0xdd:
-			c.cmp(modeAbsoluteX)
-			c.program_counter += 2
-		case 0xd9:
-			c.cmp(modeAbsoluteY)
-			c.program_counter += 2
-		case 0xc1:
-			c.cmp(modeIndirectX)
-			c.program_counter++
-		case 0xd1:
-			c.cmp(modeIndirectY)
-			c.program_counter++
-
-		case 0xe0:
-			c.cpx(modeImmediate)
-			c.program_counter++
-		case 0xe4:
-			c.cpx(modeZeroPage)
-			c.program_counter++
-		case 0xec:
-			c.cpx(modeAbsolute)
-			c.program_counter += 2
-
-		case 0xc0:
-			c.cpy(modeImmediate)
-			c.program_counter++
-
-		case 0xc4:
-			c.cpy(modeZeroPage)
-			c.program_counter++
-		case 0xcc:
-			c.cpy(modeAbsolute)
-			c.program_counter += 2
-
-		case 0xc6:
-			c.dec(modeZeroPage)
-			c.program_counter++
-		case 0xd6:
-			c.dec(modeZeroPageX)
-			c.program_counter++
-		case 0xce:
-			c.dec(modeAbsolute)
-			c.program_counter += 2
-		case 0xde:
-			c.dec(modeAbsoluteX)
-			c.program_counter += 2
-
-		case 0xca:
-			c.dex()
-		case 0x88:
-			c.dey()
-
-		case 0x49:
-			c.eor(modeImmediate)
-			c.program_counter++
-		case 0x45:
-			c.eor(modeZeroPage)
-			c.program_counter++
-		case 0x55:
-			c.eor(modeZeroPageX)
-			c.program_counter++
-		case 0x4d:
-			c.eor(modeAbsolute)
-			c.program_counter += 2
-		case 0x5d:
-			c.eor(modeAbsoluteX)
-			c.program_counter += 2
-		case 0x59:
-			c.eor(modeAbsoluteY)
-			c.program_counter += 2
-		case 0x41:
-			c.eor(modeIndirectX)
-			c.program_counter++
-		case 0x51:
-			c.eor(modeIndirectY)
-			c.program_counter++
-
-		case 0xe6:
-			c.inc(modeZeroPage)
-			c.program_counter++
-		case 0xf6:
-			c.inc(modeZeroPageX)
-			c.program_counter++
-		case 0xee:
-			c.inc(modeAbsolute)
-			c.program_counter += 2
-		case 0xfe:
-			c.inc(modeAbsoluteX)
-			c.program_counter += 2
-
-		case 0xe8:
-			c.inx()
-		case 0xc8:
-			c.iny()
-
-		case 0x4c:
-			c.jmp(modeAbsolute)
-			c.program_counter += 2
-		case 0x6c:
-			c.jmp(modeIndirect)
-			c.program_counter += 2
-
-		case 0x20:
-			c.jsr()
-			c.program_counter += 2
-
-		case 0xa9:
-			c.lda(modeImmediate)
-			c.program_counter++
-		case 0xa5:
-			c.lda(modeZeroPage)
-			c.program_counter++
-		case 0xb5:
-			c.lda(modeZeroPageX)
-			c.program_counter++
-		case 0xad:
-			c.lda(modeAbsolute)
-			c.program_counter += 2
-		case 0xbd:
-			c.lda(modeAbsoluteX)
-			c.program_counter += 2
-		case 0xb9:
-			c.lda(modeAbsoluteY)
-			c.program_counter += 2
-		case 0xa1:
-			c.lda(modeIndirectX)
-			c.program_counter++
-		case 0xb1:
-			c.lda(modeIndirectY)
-			c.program_counter++
-
-		case 0xa2:
-			c.ldx(modeImmediate)
-			c.program_counter++
-		case 0xa6:
-			c.ldx(modeZeroPage)
-			c.program_counter++
-		case 0xae:
-			c.ldx(modeAbsolute)
-			c.program_counter += 2
-		case 0xbe:
-			c.ldx(modeAbsoluteY)
-			c.program_counter += 2
-
-		case 0xa0:
-			c.ldy(modeImmediate)
-			c.program_counter++
-		case 0xa4:
-			c.ldy(modeZeroPage)
-			c.program_counter++
-		case 0xb4:
-			c.ldy(modeZeroPageX)
-			c.program_counter++
-		case 0xac:
-			c.ldy(modeAbsolute)
-			c.program_counter += 2
-		case 0xbc:
-			c.ldy(modeAbsoluteX)
-			c.program_counter += 2
-
-		case 0x4a:
-			c.lsr(modeAccumulator)
-		case 0x46:
-			c.lsr(modeZeroPage)
-			c.program_counter++
-		case 0x56:
-			c.lsr(modeZeroPageX)
-			c.program_counter++
-		case 0x4e:
-			c.lsr(modeAbsolute)
-			c.program_counter += 2
-		case 0x5e:
-			c.lsr(modeAbsoluteX)
-			c.program_counter += 2
-
-		case 0xea:
-			c.nop()
-
-		case 0x09:
-			c.ora(modeImmediate)
-			c.program_counter++
-		case 0x05:
-			c.ora(modeZeroPage)
-			c.program_counter++
-		case 0x015:
-			c.ora(modeZeroPageX)
-			c.program_counter++
-		case 0x0d:
-			c.ora(modeAbsolute)
-			c.program_counter += 2
-		case 0x1d:
-			c.ora(modeAbsoluteX)
-			c.program_counter += 2
-		case 0x19:
-			c.ora(modeAbsoluteY)
-			c.program_counter += 2
-		case 0x01:
-			c.ora(modeIndirectX)
-			c.program_counter++
-		case 0x11:
-			c.ora(modeIndirectY)
-			c.program_counter++
-
-		case 0x48:
-			c.pha()
-		case 0x08:
-			c.php()
-		case 0x68:
-			c.pla()
-		case 0x28:
-			c.plp()
-
-		case 0x2a:
-			c.rol(modeAccumulator)
-		case 0x26:
-			c.rol(modeZeroPage)
-			c.program_counter++
-		case 0x36:
-			c.rol(modeZeroPageX)
-			c.program_counter++
-		case 0x2e:
-			c.rol(modeAbsolute)
-			c.program_counter += 2
-		case 0x3e:
-			c.rol(modeAbsoluteX)
-			c.program_counter += 2
-
-		case 0x6a:
-			c.ror(modeAccumulator)
-		case 0x66:
-			c.ror(modeZeroPage)
-			c.program_counter++
-		case 0x76:
-			c.ror(modeZeroPageX)
-			c.program_counter++
-		case 0x6e:
-			c.ror(modeAbsolute)
-			c.program_counter += 2
-		case 0x7e:
-			c.ror(modeAbsoluteX)
-			c.program_counter += 2
-
-		case 0x40:
-			c.rti()
-		case 0x060:
-			c.rts()
-
-		case 0xe9:
-			c.sbc(modeImmediate)
-			c.program_counter++
-		case 0xe5:
-			c.sbc(modeZeroPage)
-			c.program_counter++
-		case 0xf5:
-			c.sbc(modeZeroPageX)
-			c.program_counter++
-		case 0xed:
-			c.sbc(modeAbsolute)
-			c.program_counter += 2
-		case 0xfd:
-			c.sbc(modeAbsoluteX)
-			c.program_counter += 2
-		case 0xf9:
-			c.sbc(modeAbsoluteY)
-			c.program_counter += 2
-		case 0xe1:
-			c.sbc(modeIndirectX)
-			c.program_counter++
-		case 0xf1:
-			c.sbc(modeIndirectY)
-			c.program_counter++
-
-		case 0x38:
-			c.sec()
-		case 0xf8:
-			c.sed()
-		case 0x78:
-			c.sei()
-
-		case 0x85:
-			c.sta(modeZeroPage)
-			c.program_counter++
-		case 0x95:
-			c.sta(modeZeroPageX)
-			c.program_counter++
-		case 0x8d:
-			c.sta(modeAbsolute)
-			c.program_counter += 2
-		case 0x9d:
-			c.sta(modeAbsoluteX)
-			c.program_counter += 2
-		case 0x99:
-			c.sta(modeAbsoluteY)
-			c.program_counter += 2
-		case 0x81:
-			c.sta(modeIndirectX)
-			c.program_counter++
-		case 0x91:
-			c.sta(modeIndirectY)
-			c.program_counter++
-
-		case 0x86:
-			c.stx(modeZeroPage)
-			c.program_counter++
-		case 0x96:
-			c.stx(modeZeroPageY)
-			c.program_counter++
-		case 0x8e:
-			c.stx(modeAbsolute)
-			c.program_counter += 2
-
-		case 0x84:
-			c.sty(modeZeroPage)
-			c.program_counter++
-		case 0x94:
-			c.sty(modeZeroPageX)
-			c.program_counter++
-		case 0x8c:
-			c.sty(modeAbsolute)
-			c.program_counter++
-
-		case 0xaa:
-			c.tax()
-		case 0xa8:
-			c.tay()
-		case 0xba:
-			c.tsx()
-		case 0x8a:
-			c.txa()
-		case 0x9a:
-			c.txs()
-		case 0x98:
-			c.tya()
-
-		default:
-			fmt.Fprintf(os.Stdout, "UNDEFINED BEHAVIOUR %v at %v", opcode, c.program_counter)
+	for c.halt == nil {
+		c.Step()
+	}
+}
 
+// instructions implements every mnemonic in opcodeTable. Those that take no
+// operand ignore the addressing mode.
+var instructions = map[string]func(c *CPU, mode AddressingMode){
+	"ADC": (*CPU).adc, "AND": (*CPU).and, "ASL": (*CPU).asl, "BIT": (*CPU).bit,
+	"CMP": (*CPU).cmp, "CPX": (*CPU).cpx, "CPY": (*CPU).cpy, "DEC": (*CPU).dec,
+	"EOR": (*CPU).eor, "INC": (*CPU).inc, "JMP": (*CPU).jmp, "LDA": (*CPU).lda,
+	"LDX": (*CPU).ldx, "LDY": (*CPU).ldy, "LSR": (*CPU).lsr, "ORA": (*CPU).ora,
+	"ROL": (*CPU).rol, "ROR": (*CPU).ror, "SBC": (*CPU).sbc, "STA": (*CPU).sta,
+	"STX": (*CPU).stx, "STY": (*CPU).sty,
+
+	"BCC": func(c *CPU, _ AddressingMode) { c.bcc() },
+	"BCS": func(c *CPU, _ AddressingMode) { c.bcs() },
+	"BEQ": func(c *CPU, _ AddressingMode) { c.beq() },
+	"BMI": func(c *CPU, _ AddressingMode) { c.bmi() },
+	"BNE": func(c *CPU, _ AddressingMode) { c.bne() },
+	"BPL": func(c *CPU, _ AddressingMode) { c.bpl() },
+	"BVC": func(c *CPU, _ AddressingMode) { c.bvc() },
+	"BVS": func(c *CPU, _ AddressingMode) { c.bvs() },
+	"BRK": func(c *CPU, _ AddressingMode) { c.brk() },
+	"CLC": func(c *CPU, _ AddressingMode) { c.clc() },
+	"CLD": func(c *CPU, _ AddressingMode) { c.cld() },
+	"CLI": func(c *CPU, _ AddressingMode) { c.cli() },
+	"CLV": func(c *CPU, _ AddressingMode) { c.clv() },
+	"DEX": func(c *CPU, _ AddressingMode) { c.dex() },
+	"DEY": func(c *CPU, _ AddressingMode) { c.dey() },
+	"INX": func(c *CPU, _ AddressingMode) { c.inx() },
+	"INY": func(c *CPU, _ AddressingMode) { c.iny() },
+	"JSR": func(c *CPU, _ AddressingMode) { c.jsr() },
+	"NOP": func(c *CPU, _ AddressingMode) { c.nop() },
+	"PHA": func(c *CPU, _ AddressingMode) { c.pha() },
+	"PHP": func(c *CPU, _ AddressingMode) { c.php() },
+	"PLA": func(c *CPU, _ AddressingMode) { c.pla() },
+	"PLP": func(c *CPU, _ AddressingMode) { c.plp() },
+	"RTI": func(c *CPU, _ AddressingMode) { c.rti() },
+	"RTS": func(c *CPU, _ AddressingMode) { c.rts() },
+	"SEC": func(c *CPU, _ AddressingMode) { c.sec() },
+	"SED": func(c *CPU, _ AddressingMode) { c.sed() },
+	"SEI": func(c *CPU, _ AddressingMode) { c.sei() },
+	"TAX": func(c *CPU, _ AddressingMode) { c.tax() },
+	"TAY": func(c *CPU, _ AddressingMode) { c.tay() },
+	"TSX": func(c *CPU, _ AddressingMode) { c.tsx() },
+	"TXA": func(c *CPU, _ AddressingMode) { c.txa() },
+	"TXS": func(c *CPU, _ AddressingMode) { c.txs() },
+	"TYA": func(c *CPU, _ AddressingMode) { c.tya() },
+}
+
+// read instructions take a cycle longer when indexing crosses a page, the
+// base cycles of stores and read-modify-writes already include it
+var pagePenalty = map[string]bool{
+	"ADC": true, "AND": true, "CMP": true, "EOR": true, "LDA": true,
+	"LDX": true, "LDY": true, "ORA": true, "SBC": true,
+}
+
+// dispatch is opcodeTable resolved to the functions executing each opcode,
+// so the CPU decodes exactly what the disassembler and assembler do.
+// crossPenalty is pagePenalty resolved the same way.
+var dispatch [256]func(c *CPU, mode AddressingMode)
+var crossPenalty [256]bool
+
+func init() {
+	for opcode, op := range opcodeTable {
+		if op.Mnemonic == "" {
+			continue
+		}
+		dispatch[opcode] = instructions[op.Mnemonic]
+		if dispatch[opcode] == nil {
+			panic("no instruction for " + op.Mnemonic)
 		}
+		crossPenalty[opcode] = pagePenalty[op.Mnemonic]
+	}
+}
+
+// execute runs the opcode at program_counter and returns the cycles it
+// took. The program counter is moved past the operand first, instructions
+// that jump overwrite it.
+func (c *CPU) execute(opcode uint8) int {
+	op := opcodeTable[opcode]
+	if dispatch[opcode] == nil {
+		c.halt = &HaltError{Opcode: opcode, Address: c.program_counter}
+		return 2
+	}
+	c.operand = c.program_counter + 1
+	c.program_counter = c.operand + uint16(op.Mode.OperandSize())
+	c.crossed = false
+	c.extra_cycles = 0
+	dispatch[opcode](c, op.Mode)
+
+	cycles := int(opcodeCycles[opcode]) + c.extra_cycles
+	if c.crossed && crossPenalty[opcode] {
+		cycles++
 	}
+	return cycles
 }
 
 func (c *CPU) Load_and_interpret(instructions []uint8) {
@@ -0,0 +1,247 @@
+package hardware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// nestest runs its CPU tests from $C000 when there is no PPU to show a menu
+// https://www.qmtpro.com/~nes/misc/nestest.txt. It leaves the number of the
+// first failing official test in $02 and unofficial test in $03.
+const nestestStart = 0xC000
+
+// where nestest moves on to undocumented opcodes, which halt this CPU, and
+// the registers it has there according to nestest.log
+const nestestFirstUnofficial = 0xC6BD
+
+var nestestUnofficialRegisters = Registers{A: 0xAA, X: 0x97, Y: 0x4E, P: 0xEF, SP: 0xF9, PC: nestestFirstUnofficial}
+
+// the first lines of nestest.log: PC, A, X, Y, P, SP and the cycle count
+// before the instruction runs, which starts at 7 after reset
+var nestestLogStart = `C000 00 00 00 24 FD 7
+C5F5 00 00 00 24 FD 10
+C5F7 00 00 00 26 FD 12
+C5F9 00 00 00 26 FD 15
+C5FB 00 00 00 26 FD 18
+C5FD 00 00 00 26 FD 21
+C72D 00 00 00 26 FB 27`
+
+type traceLine struct {
+	registers Registers
+	cycles    uint64
+}
+
+func (t traceLine) String() string {
+	return fmt.Sprintf("%v CYC:%d", t.registers, t.cycles)
+}
+
+func newNestest(t *testing.T) *Console {
+	t.Helper()
+	rom, err := os.ReadFile("nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := ParseINES(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console := NewConsole(cart)
+	regs := console.cpu.Registers()
+	regs.PC = nestestStart
+	console.cpu.SetRegisters(regs)
+	return console
+}
+
+func (n *Console) trace() traceLine {
+	return traceLine{n.cpu.Registers(), n.cpu.cycles + 7}
+}
+
+// nestestLog reads testdata/nestest.log when it is there, falling back to
+// the first lines of it
+func nestestLog(t *testing.T) []traceLine {
+	t.Helper()
+	var lines []traceLine
+	file, err := os.Open("testdata/nestest.log")
+	if errors.Is(err, os.ErrNotExist) {
+		for _, line := range regexp.MustCompile("\n").Split(nestestLogStart, -1) {
+			var l traceLine
+			r := &l.registers
+			if _, err := fmt.Sscanf(line, "%X %X %X %X %X %X %d", &r.PC, &r.A, &r.X, &r.Y, &r.P, &r.SP, &l.cycles); err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, l)
+		}
+		return lines
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	pattern := regexp.MustCompile(`^([0-9A-F]{4}) .*A:([0-9A-F]{2}) X:([0-9A-F]{2}) Y:([0-9A-F]{2}) P:([0-9A-F]{2}) SP:([0-9A-F]{2}).*CYC:(\d+)`)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		m := pattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			t.Fatalf("unexpected nestest.log line %q", scanner.Text())
+		}
+		hex := func(i int) uint8 {
+			v, _ := strconv.ParseUint(m[i], 16, 8)
+			return uint8(v)
+		}
+		pc, _ := strconv.ParseUint(m[1], 16, 16)
+		cycles, _ := strconv.ParseUint(m[7], 10, 64)
+		lines = append(lines, traceLine{Registers{A: hex(2), X: hex(3), Y: hex(4), P: hex(5), SP: hex(6), PC: uint16(pc)}, cycles})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestNestestTrace(t *testing.T) {
+	console := newNestest(t)
+	for i, want := range nestestLog(t) {
+		if want.registers.PC == nestestFirstUnofficial {
+			break
+		}
+		if got := console.trace(); got != want {
+			t.Fatalf("nestest.log line %d: got %v, want %v", i+1, got, want)
+		}
+		console.Step()
+	}
+}
+
+func TestNestestOfficialOpcodes(t *testing.T) {
+	console := newNestest(t)
+	for i := 0; i < 10000 && console.cpu.Halted() == nil; i++ {
+		console.Step()
+	}
+	var halt *HaltError
+	if err := console.cpu.Halted(); !errors.As(err, &halt) {
+		t.Fatalf("nestest didn't reach an undocumented opcode, registers %v", console.cpu.Registers())
+	}
+	if halt.Address != nestestFirstUnofficial || halt.Opcode != 0x04 {
+		t.Errorf("halted on $%02X at $%04X, want $04 at $%04X", halt.Opcode, halt.Address, nestestFirstUnofficial)
+	}
+	if got := console.cpu.Registers(); got != nestestUnofficialRegisters {
+		t.Errorf("registers %v, want %v", got, nestestUnofficialRegisters)
+	}
+	if code := console.Peek(0x02); code != 0 {
+		t.Errorf("nestest official opcode test $%02X failed", code)
+	}
+}
+
+// runProgram loads a program at $0600 of a bare CPU and steps it until it
+// executes BRK
+func runProgram(t *testing.T, program ...uint8) *CPU {
+	t.Helper()
+	cpu := NewCPU()
+	cpu.Load(0x0600, program)
+	for i := 0; cpu.memory[cpu.program_counter&0x07FF] != 0x00; i++ {
+		if i > 1000 {
+			t.Fatal("program didn't reach BRK")
+		}
+		cpu.Step()
+	}
+	return &cpu
+}
+
+func TestFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint8
+		want    Registers
+	}{
+		{"LDA negative", []uint8{0xA9, 0x80}, Registers{A: 0x80, P: 0xA4}},
+		{"LDA zero", []uint8{0xA9, 0x00}, Registers{P: 0x26}},
+		{"ADC overflow", []uint8{0xA9, 0x7F, 0x69, 0x01}, Registers{A: 0x80, P: 0xE4}},
+		{"ADC carry", []uint8{0xA9, 0xFF, 0x69, 0x01}, Registers{A: 0x00, P: 0x27}},
+		{"SBC borrow", []uint8{0x38, 0xA9, 0x00, 0xE9, 0x01}, Registers{A: 0xFF, P: 0xA4}},
+		{"CMP equal", []uint8{0xA9, 0x10, 0xC9, 0x10}, Registers{A: 0x10, P: 0x27}},
+		{"CMP less", []uint8{0xA9, 0x10, 0xC9, 0x20}, Registers{A: 0x10, P: 0xA4}},
+		{"BIT copies memory bits", []uint8{0xA9, 0xC0, 0x85, 0x10, 0xA9, 0x00, 0x24, 0x10}, Registers{P: 0xE6}},
+		{"TSX", []uint8{0xBA}, Registers{X: STACK_RESET, P: 0xA4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := runProgram(t, append(tt.program, 0x00)...)
+			got := cpu.Registers()
+			tt.want.SP = STACK_RESET
+			tt.want.PC = got.PC
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	// $0600 JSR $0610; LDY #$01; BRK
+	// $0610 LDX #$03; DEX; BNE -3; RTS
+	program := make([]uint8, 0x18)
+	copy(program, []uint8{0x20, 0x10, 0x06, 0xA0, 0x01, 0x00})
+	copy(program[0x10:], []uint8{0xA2, 0x03, 0xCA, 0xD0, 0xFD, 0x60})
+	cpu := runProgram(t, program...)
+	if got := cpu.Registers(); got.PC != 0x0605 || got.X != 0 || got.Y != 1 || got.SP != STACK_RESET {
+		t.Errorf("got %v, want PC:0605 X:00 Y:01 SP:FD", got)
+	}
+}
+
+func TestJSRPushesHighByteFirst(t *testing.T) {
+	cpu := NewCPU()
+	cpu.Load(0x0600, []uint8{0x20, 0x34, 0x12})
+	cpu.Step()
+	if cpu.program_counter != 0x1234 {
+		t.Fatalf("JSR went to $%04X", cpu.program_counter)
+	}
+	// the return address is the last byte of the JSR, $0602
+	if hi, lo := cpu.memory[0x01FD], cpu.memory[0x01FC]; hi != 0x06 || lo != 0x02 {
+		t.Errorf("stack holds $%02X%02X, want $0602", hi, lo)
+	}
+}
+
+func TestJMPIndirectPageWrap(t *testing.T) {
+	cpu := NewCPU()
+	cpu.memory[0x02FF] = 0x34
+	cpu.memory[0x0200] = 0x12
+	cpu.memory[0x0300] = 0xEE
+	cpu.Load(0x0600, []uint8{0x6C, 0xFF, 0x02})
+	cpu.Step()
+	if cpu.program_counter != 0x1234 {
+		t.Errorf("JMP ($02FF) went to $%04X, want $1234", cpu.program_counter)
+	}
+}
+
+func TestRAMMirroring(t *testing.T) {
+	cpu := NewCPU()
+	cpu.Load(0x0600, []uint8{0xA9, 0x42, 0x8D, 0x10, 0x08, 0xAD, 0x10, 0x18})
+	cpu.Step()
+	cpu.Step()
+	if cpu.memory[0x0010] != 0x42 {
+		t.Errorf("write to $0810 didn't reach $0010")
+	}
+	cpu.Step()
+	if cpu.accumulator != 0x42 {
+		t.Errorf("read of $1810 returned $%02X, want $42", cpu.accumulator)
+	}
+}
+
+func TestUndocumentedOpcodeHalts(t *testing.T) {
+	cpu := NewCPU()
+	cpu.Load(0x0600, []uint8{0xEA, 0x02})
+	cpu.Step()
+	cpu.Step()
+	var halt *HaltError
+	if !errors.As(cpu.Halted(), &halt) || halt.Opcode != 0x02 || halt.Address != 0x0601 {
+		t.Fatalf("Halted() = %v, want a halt on $02 at $0601", cpu.Halted())
+	}
+	cpu.Step()
+	if cpu.program_counter != 0x0601 {
+		t.Errorf("a halted CPU moved on to $%04X", cpu.program_counter)
+	}
+}
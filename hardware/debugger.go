@@ -0,0 +1,336 @@
+package hardware
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Debugger controls execution of a console one instruction at a time and
+// stops it on breakpoints and watchpoints. Attaching a debugger hooks every
+// bus access, so it costs speed.
+
+type AccessKind uint8
+
+const (
+	AccessRead AccessKind = 1 << iota
+	AccessWrite
+	AccessExec
+	AccessDMC // comes with AccessRead on DMC sample fetches
+)
+
+func (k AccessKind) String() string {
+	out := []uint8("---")
+	for i, letter := range "rwx" {
+		if k&(1<<i) != 0 {
+			out[i] = uint8(letter)
+		}
+	}
+	return string(out)
+}
+
+// Breakpoint stops execution before the instruction at Address runs,
+// if Condition is nil or evaluates to non zero
+type Breakpoint struct {
+	ID        int
+	Address   uint16
+	Condition *Expr
+	Enabled   bool
+}
+
+// Watchpoint stops execution when an address in [Start, End] is accessed
+// in one of the given ways
+type Watchpoint struct {
+	ID         int
+	Start, End uint16
+	Kind       AccessKind
+	Enabled    bool
+}
+
+type StopReason int
+
+const (
+	StopStep        StopReason = iota // the requested step finished
+	StopBreakpoint                    // a breakpoint was hit
+	StopWatchpoint                    // a watchpoint was hit
+	StopInterrupted                   // Interrupt was called
+	StopLimit                         // the instruction limit ran out
+	StopHalted                        // the CPU halted on an opcode it can't execute
+)
+
+// Stop describes why execution stopped
+type Stop struct {
+	Reason  StopReason
+	ID      int        // breakpoint or watchpoint id
+	Kind    AccessKind // watchpoint access that tripped
+	Address uint16     // watchpoint address that was accessed, or where the CPU halted
+	Value   uint8      // value read or written, or the opcode the CPU halted on
+}
+
+func (s Stop) String() string {
+	switch s.Reason {
+	case StopBreakpoint:
+		return fmt.Sprintf("breakpoint %d", s.ID)
+	case StopWatchpoint:
+		return fmt.Sprintf("watchpoint %d: %v $%04X = $%02X", s.ID, s.Kind, s.Address, s.Value)
+	case StopInterrupted:
+		return "interrupted"
+	case StopLimit:
+		return "instruction limit reached"
+	case StopHalted:
+		return fmt.Sprintf("CPU halted on opcode $%02X at $%04X", s.Value, s.Address)
+	}
+	return "step"
+}
+
+type Debugger struct {
+	console     *Console
+	breakpoints []*Breakpoint
+	watchpoints []*Watchpoint
+	nextID      int
+
+	depth       int   // JSR and interrupt nesting, for step over and step out
+	pending     *Stop // watchpoint tripped by the instruction being executed
+	interrupted atomic.Bool
+	hooks       []HookID
+
+	rewinder *Rewinder // nil until EnableRewind
+	frame    uint64    // PPU frame the rewinder last saw finish
+}
+
+// NewDebugger attaches a debugger to the console
+func NewDebugger(console *Console) *Debugger {
+	d := &Debugger{console: console, nextID: 1}
+	d.hooks = []HookID{
+		console.hooks.OnAccess(AccessRead|AccessWrite, 0x0000, 0xFFFF, d.watch),
+		console.hooks.OnExec(0x0000, 0xFFFF, d.onExec),
+		console.hooks.OnInterrupt(d.onInterrupt),
+	}
+	return d
+}
+
+// Detach stops the CPU reporting accesses to the debugger
+func (d *Debugger) Detach() {
+	for _, id := range d.hooks {
+		d.console.hooks.Remove(id)
+	}
+	d.hooks = nil
+}
+
+func (d *Debugger) Console() *Console {
+	return d.console
+}
+
+// AddBreakpoint sets a breakpoint at address, condition may be empty
+func (d *Debugger) AddBreakpoint(address uint16, condition string) (*Breakpoint, error) {
+	bp := &Breakpoint{Address: address, Enabled: true}
+	if condition != "" {
+		expr, err := ParseExpr(condition)
+		if err != nil {
+			return nil, err
+		}
+		bp.Condition = expr
+	}
+	bp.ID = d.nextID
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp, nil
+}
+
+func (d *Debugger) AddWatchpoint(start, end uint16, kind AccessKind) *Watchpoint {
+	if end < start {
+		start, end = end, start
+	}
+	wp := &Watchpoint{ID: d.nextID, Start: start, End: end, Kind: kind, Enabled: true}
+	d.nextID++
+	d.watchpoints = append(d.watchpoints, wp)
+	return wp
+}
+
+// Delete removes the breakpoint or watchpoint with the given id
+func (d *Debugger) Delete(id int) bool {
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	for i, wp := range d.watchpoints {
+		if wp.ID == id {
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	return d.breakpoints
+}
+
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	return d.watchpoints
+}
+
+// Depth returns the current subroutine and interrupt nesting depth
+func (d *Debugger) Depth() int {
+	return d.depth
+}
+
+// Interrupt asks a running Continue or step to stop, it is safe to call from
+// another goroutine such as a signal handler
+func (d *Debugger) Interrupt() {
+	d.interrupted.Store(true)
+}
+
+// Register reads a register (A, X, Y, P, SP, PC) or flag (C, Z, I, D, V, N)
+func (d *Debugger) Register(name string) (uint16, error) {
+	get, ok := registerGetter(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown register %q", name)
+	}
+	return get(&d.console.cpu), nil
+}
+
+// SetRegister writes a register or flag, flags take any non zero value as set
+func (d *Debugger) SetRegister(name string, value uint16) error {
+	set, ok := registerSetter(name)
+	if !ok {
+		return fmt.Errorf("unknown register %q", name)
+	}
+	set(&d.console.cpu, value)
+	return nil
+}
+
+// hooks called by the CPU
+func (d *Debugger) onExec(kind AccessKind, address uint16, opcode uint8) {
+	switch opcode {
+	case 0x20: // JSR
+		d.depth++
+	case 0x60, 0x40: // RTS, RTI
+		d.depth--
+	}
+}
+
+func (d *Debugger) onInterrupt(kind Interrupt) {
+	if kind == InterruptReset {
+		d.depth = 0
+		return
+	}
+	d.depth++
+}
+
+func (d *Debugger) watch(kind AccessKind, address uint16, data uint8) {
+	if d.pending != nil {
+		return
+	}
+	for _, wp := range d.watchpoints {
+		if wp.Enabled && wp.Kind&kind != 0 && address >= wp.Start && address <= wp.End {
+			d.pending = &Stop{Reason: StopWatchpoint, ID: wp.ID, Kind: kind, Address: address, Value: data}
+			return
+		}
+	}
+}
+
+// checkBefore looks for a breakpoint or exec watchpoint at the current PC
+func (d *Debugger) checkBefore() *Stop {
+	pc := d.console.cpu.program_counter
+	for _, bp := range d.breakpoints {
+		if bp.Enabled && bp.Address == pc && (bp.Condition == nil || bp.Condition.Eval(d.console) != 0) {
+			return &Stop{Reason: StopBreakpoint, ID: bp.ID}
+		}
+	}
+	for _, wp := range d.watchpoints {
+		if wp.Enabled && wp.Kind&AccessExec != 0 && pc >= wp.Start && pc <= wp.End {
+			return &Stop{Reason: StopWatchpoint, ID: wp.ID, Kind: AccessExec, Address: pc, Value: d.console.Peek(pc)}
+		}
+	}
+	return nil
+}
+
+// run executes instructions until done reports true or something stops it.
+// The first instruction always runs so that execution can resume from a
+// breakpoint. limit caps the number of instructions, 0 means no limit.
+func (d *Debugger) run(limit int, done func() bool) Stop {
+	d.interrupted.Store(false)
+	for count := 0; limit == 0 || count < limit; count++ {
+		if count > 0 {
+			if d.interrupted.Load() {
+				return Stop{Reason: StopInterrupted}
+			}
+			if stop := d.checkBefore(); stop != nil {
+				return *stop
+			}
+		}
+		d.pending = nil
+		d.console.cpu.Step()
+		if d.rewinder != nil && d.console.ppu.frame != d.frame {
+			d.frame = d.console.ppu.frame
+			d.rewinder.Push()
+		}
+		if halt := d.console.cpu.halt; halt != nil {
+			return Stop{Reason: StopHalted, Address: halt.Address, Value: halt.Opcode}
+		}
+		if d.pending != nil {
+			stop := *d.pending
+			d.pending = nil
+			return stop
+		}
+		if done() {
+			return Stop{Reason: StopStep}
+		}
+	}
+	return Stop{Reason: StopLimit}
+}
+
+// StepIn executes a single instruction
+func (d *Debugger) StepIn() Stop {
+	return d.run(1, func() bool { return true })
+}
+
+// StepOver executes a single instruction, running a called subroutine to
+// completion when the instruction is a JSR
+func (d *Debugger) StepOver() Stop {
+	depth := d.depth
+	return d.run(0, func() bool { return d.depth <= depth })
+}
+
+// StepOut runs until the current subroutine or interrupt handler returns
+func (d *Debugger) StepOut() Stop {
+	depth := d.depth
+	return d.run(0, func() bool { return d.depth < depth })
+}
+
+// Continue runs until a breakpoint, watchpoint or Interrupt. limit caps the
+// number of instructions, 0 means no limit.
+func (d *Debugger) Continue(limit int) Stop {
+	return d.run(limit, func() bool { return false })
+}
+
+// EnableRewind records the frames the debugger runs so that Rewind can go
+// back to them
+func (d *Debugger) EnableRewind(config RewindConfig) {
+	d.rewinder = NewRewinder(d.console, config)
+	d.frame = d.console.ppu.frame
+}
+
+// Rewind goes back count frames from the end of the last frame that
+// finished, and returns how many it went back, fewer once the history runs
+// out. Hooks see the frames replayed to reach it.
+func (d *Debugger) Rewind(count int) int {
+	if d.rewinder == nil {
+		return 0
+	}
+	done := 0
+	for done < count && d.rewinder.StepBack() {
+		done++
+	}
+	d.frame = d.console.ppu.frame
+	d.pending = nil
+	return done
+}
+
+// ContinueFrame runs until the PPU finishes the current frame
+func (d *Debugger) ContinueFrame() Stop {
+	frame := d.console.ppu.frame
+	return d.run(0, func() bool { return d.console.ppu.frame != frame })
+}
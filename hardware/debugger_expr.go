@@ -0,0 +1,296 @@
+package hardware
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Breakpoint conditions are small C-like expressions over the registers,
+// the flags and memory, e.g.
+//
+//	A == $40 && X > 3
+//	[$0300+Y] != 0 || !C
+//
+// Registers are A, X, Y, P, SP and PC, flags are C, Z, I, D, V and N (0 or 1)
+// and [addr] reads a byte without side effects. Numbers are $hex, 0xhex,
+// %binary or decimal. Operators follow C precedence: || && | ^ & == !=
+// < <= > >= + - and the unary ! - ~.
+
+// Expr is a compiled condition
+type Expr struct {
+	source string
+	eval   func(n *Console) int
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against the console's current state
+func (e *Expr) Eval(n *Console) int {
+	return e.eval(n)
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+// ParseExpr compiles an expression for use as a breakpoint condition
+func ParseExpr(source string) (*Expr, error) {
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	eval, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in expression", p.tokens[p.pos])
+	}
+	return &Expr{source: source, eval: eval}, nil
+}
+
+// longest operators first so "<=" is not split into "<" and "="
+var exprOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "|", "^", "&", "+", "-", "!", "~", "(", ")", "[", "]"}
+
+func tokenizeExpr(source string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(source); {
+		ch := source[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+			continue
+		case isExprWord(ch) || ch == '$' || ch == '%':
+			j := i + 1
+			for j < len(source) && isExprWord(source[j]) {
+				j++
+			}
+			tokens = append(tokens, source[i:j])
+			i = j
+			continue
+		}
+		matched := false
+		for _, op := range exprOperators {
+			if strings.HasPrefix(source[i:], op) {
+				tokens = append(tokens, op)
+				i += len(op)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unexpected %q in expression", ch)
+		}
+	}
+	return tokens, nil
+}
+
+func isExprWord(ch uint8) bool {
+	return ch == '_' || (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// binary operator precedence levels, lowest first
+var exprPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *exprParser) binary(level int) (func(n *Console) int, error) {
+	if level == len(exprPrecedence) {
+		return p.unary()
+	}
+	lhs, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, candidate := range exprPrecedence[level] {
+			found = found || op == candidate
+		}
+		if !found {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		lhs = combineExpr(op, lhs, rhs)
+	}
+}
+
+func combineExpr(op string, lhs, rhs func(n *Console) int) func(n *Console) int {
+	switch op {
+	case "||":
+		return func(n *Console) int { return boolInt(lhs(n) != 0 || rhs(n) != 0) }
+	case "&&":
+		return func(n *Console) int { return boolInt(lhs(n) != 0 && rhs(n) != 0) }
+	case "|":
+		return func(n *Console) int { return lhs(n) | rhs(n) }
+	case "^":
+		return func(n *Console) int { return lhs(n) ^ rhs(n) }
+	case "&":
+		return func(n *Console) int { return lhs(n) & rhs(n) }
+	case "==":
+		return func(n *Console) int { return boolInt(lhs(n) == rhs(n)) }
+	case "!=":
+		return func(n *Console) int { return boolInt(lhs(n) != rhs(n)) }
+	case "<":
+		return func(n *Console) int { return boolInt(lhs(n) < rhs(n)) }
+	case "<=":
+		return func(n *Console) int { return boolInt(lhs(n) <= rhs(n)) }
+	case ">":
+		return func(n *Console) int { return boolInt(lhs(n) > rhs(n)) }
+	case ">=":
+		return func(n *Console) int { return boolInt(lhs(n) >= rhs(n)) }
+	case "+":
+		return func(n *Console) int { return lhs(n) + rhs(n) }
+	default: // "-"
+		return func(n *Console) int { return lhs(n) - rhs(n) }
+	}
+}
+
+func (p *exprParser) unary() (func(n *Console) int, error) {
+	switch p.peek() {
+	case "!", "-", "~":
+		op := p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "!":
+			return func(n *Console) int { return boolInt(operand(n) == 0) }, nil
+		case "-":
+			return func(n *Console) int { return -operand(n) }, nil
+		default:
+			return func(n *Console) int { return ^operand(n) }, nil
+		}
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (func(n *Console) int, error) {
+	tok := p.next()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "(", "[":
+		inner, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		closing := map[string]string{"(": ")", "[": "]"}[tok]
+		if p.next() != closing {
+			return nil, fmt.Errorf("missing %q in expression", closing)
+		}
+		if tok == "(" {
+			return inner, nil
+		}
+		return func(n *Console) int { return int(n.Peek(uint16(inner(n)))) }, nil
+	}
+
+	if value, err := parseExprNumber(tok); err == nil {
+		return func(*Console) int { return value }, nil
+	}
+	if get, ok := registerGetter(tok); ok {
+		return func(n *Console) int { return int(get(&n.cpu)) }, nil
+	}
+	return nil, fmt.Errorf("unknown name %q in expression", tok)
+}
+
+func parseExprNumber(tok string) (int, error) {
+	var value uint64
+	var err error
+	switch {
+	case strings.HasPrefix(tok, "$"):
+		value, err = strconv.ParseUint(tok[1:], 16, 32)
+	case strings.HasPrefix(tok, "0x"), strings.HasPrefix(tok, "0X"):
+		value, err = strconv.ParseUint(tok[2:], 16, 32)
+	case strings.HasPrefix(tok, "%"):
+		value, err = strconv.ParseUint(tok[1:], 2, 32)
+	default:
+		value, err = strconv.ParseUint(tok, 10, 32)
+	}
+	return int(value), err
+}
+
+var flagNames = map[string]Flags{"C": C, "Z": Z, "I": I, "D": D, "V": V, "N": N}
+
+// registerGetter resolves a register or flag name used in expressions and
+// debugger commands
+func registerGetter(name string) (func(c *CPU) uint16, bool) {
+	switch strings.ToUpper(name) {
+	case "A":
+		return func(c *CPU) uint16 { return uint16(c.accumulator) }, true
+	case "X":
+		return func(c *CPU) uint16 { return uint16(c.index_x) }, true
+	case "Y":
+		return func(c *CPU) uint16 { return uint16(c.index_y) }, true
+	case "P":
+		return func(c *CPU) uint16 { return uint16(c.status) }, true
+	case "SP":
+		return func(c *CPU) uint16 { return uint16(c.stack_pointer) }, true
+	case "PC":
+		return func(c *CPU) uint16 { return c.program_counter }, true
+	}
+	if flag, ok := flagNames[strings.ToUpper(name)]; ok {
+		return func(c *CPU) uint16 { return uint16(c.getFlagValue(flag)) }, true
+	}
+	return nil, false
+}
+
+// registerSetter is the writing counterpart of registerGetter
+func registerSetter(name string) (func(c *CPU, value uint16), bool) {
+	switch strings.ToUpper(name) {
+	case "A":
+		return func(c *CPU, value uint16) { c.accumulator = uint8(value) }, true
+	case "X":
+		return func(c *CPU, value uint16) { c.index_x = uint8(value) }, true
+	case "Y":
+		return func(c *CPU, value uint16) { c.index_y = uint8(value) }, true
+	case "P":
+		return func(c *CPU, value uint16) { c.status = uint8(value) }, true
+	case "SP":
+		return func(c *CPU, value uint16) { c.stack_pointer = uint8(value) }, true
+	case "PC":
+		return func(c *CPU, value uint16) { c.program_counter = value }, true
+	}
+	if flag, ok := flagNames[strings.ToUpper(name)]; ok {
+		return func(c *CPU, value uint16) { c.setFlagValue(flag, uint8(boolInt(value != 0))) }, true
+	}
+	return nil, false
+}
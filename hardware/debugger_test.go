@@ -0,0 +1,253 @@
+package hardware
+
+import (
+	"os"
+	"testing"
+)
+
+func newTestDebugger(t *testing.T) *Debugger {
+	t.Helper()
+	rom, err := os.ReadFile("nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := ParseINES(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDebugger(NewConsole(cart))
+}
+
+// debuggerProgram calls a subroutine that calls another, over and over,
+// with NMIs on:
+//
+//	$8000 reset: LDA #$80 ; STA $2000 ; LDX #0
+//	$8007 loop:  JSR sub ; INX ; JMP loop
+//	$8010 sub:   JSR inner ; RTS
+//	$8020 inner: INC $10 ; RTS
+//	$8030 nmi:   INC $11 ; RTI
+var debuggerProgram = map[uint16][]uint8{
+	0x8000: {0xA9, 0x80, 0x8D, 0x00, 0x20, 0xA2, 0x00},
+	0x8007: {0x20, 0x10, 0x80, 0xE8, 0x4C, 0x07, 0x80},
+	0x8010: {0x20, 0x20, 0x80, 0x60},
+	0x8020: {0xE6, 0x10, 0x60},
+	0x8030: {0xE6, 0x11, 0x40},
+	0xFFFA: {0x30, 0x80, 0x00, 0x80, 0x30, 0x80},
+}
+
+func newProgramDebugger(t *testing.T) *Debugger {
+	t.Helper()
+	prg := make([]uint8, PRG_BANK_SIZE)
+	for address, code := range debuggerProgram {
+		copy(prg[address&(PRG_BANK_SIZE-1):], code)
+	}
+	cart, err := NewCartridge(prg, make([]uint8, CHR_BANK_SIZE), 0, MirrorVertical, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDebugger(NewConsole(cart))
+	if pc := d.Console().cpu.program_counter; pc != 0x8000 {
+		t.Fatalf("reset to $%04X", pc)
+	}
+	return d
+}
+
+func TestParseExpr(t *testing.T) {
+	console := newNestest(t)
+	regs := console.cpu.Registers()
+	regs.A, regs.X, regs.Y = 0x40, 3, 2
+	regs.SetFlag(C, true)
+	console.cpu.SetRegisters(regs)
+	console.Poke(0x0302, 7)
+
+	tests := []struct {
+		source string
+		want   int
+	}{
+		{"1 + 2 == 3", 1},
+		{"1 | 2 ^ 3 & 1", 3},
+		{"(1 | 2 ^ 3) & 1", 1},
+		{"2 - 1 - 1", 0},
+		{"1 || 0 && 0", 1},
+		{"(1 || 0) && 0", 0},
+		{"1 < 2 == 1", 1},
+		{"-1 + 2", 1},
+		{"~0 & $FF", 0xFF},
+		{"!!5", 1},
+		{"%1010 + 0x10 + 10", 36},
+		{"A == $40 && X > 3", 0},
+		{"a == 64 && x >= 3", 1},
+		{"[$0300+Y]", 7},
+		{"[$0300+Y] != 0 || !C", 1},
+		{"!C", 0},
+		{"PC", nestestStart},
+		{"SP", int(regs.SP)},
+	}
+	for _, tt := range tests {
+		expr, err := ParseExpr(tt.source)
+		if err != nil {
+			t.Errorf("%q: %v", tt.source, err)
+			continue
+		}
+		if got := expr.Eval(console); got != tt.want {
+			t.Errorf("%q = %d, want %d", tt.source, got, tt.want)
+		}
+		if expr.String() != tt.source {
+			t.Errorf("%q prints as %q", tt.source, expr.String())
+		}
+	}
+	for _, source := range []string{"", "1 +", "(1", "[1", "1 2", "Q", "1 @ 2", "$G", "()"} {
+		if _, err := ParseExpr(source); err == nil {
+			t.Errorf("%q: no error", source)
+		}
+	}
+}
+
+func TestDebuggerSteps(t *testing.T) {
+	d := newProgramDebugger(t)
+	if _, err := d.AddBreakpoint(0x8007, ""); err != nil {
+		t.Fatal(err)
+	}
+	if stop := d.Continue(100); stop.Reason != StopBreakpoint {
+		t.Fatalf("Continue stopped on %v", stop)
+	}
+	d.Delete(1)
+
+	steps := []struct {
+		name  string
+		step  func() Stop
+		pc    uint16
+		depth int
+	}{
+		{"step into sub", d.StepIn, 0x8010, 1},
+		{"step into inner", d.StepIn, 0x8020, 2},
+		{"step out of inner", d.StepOut, 0x8013, 1},
+		{"step out of sub", d.StepOut, 0x800A, 0},
+		{"step over INX", d.StepOver, 0x800B, 0},
+		{"step over JMP", d.StepOver, 0x8007, 0},
+		{"step over JSR", d.StepOver, 0x800A, 0},
+	}
+	for _, tt := range steps {
+		if stop := tt.step(); stop.Reason != StopStep {
+			t.Errorf("%s: stopped on %v", tt.name, stop)
+		}
+		if pc := d.Console().cpu.program_counter; pc != tt.pc || d.Depth() != tt.depth {
+			t.Errorf("%s: at $%04X depth %d, want $%04X depth %d", tt.name, pc, d.Depth(), tt.pc, tt.depth)
+		}
+	}
+	if calls := d.Console().Peek(0x10); calls != 2 {
+		t.Errorf("inner ran %d times, want 2", calls)
+	}
+
+	// a breakpoint inside the subroutine stops a step over it
+	d.StepOver()
+	d.StepOver()
+	bp, err := d.AddBreakpoint(0x8020, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop := d.StepOver(); stop.Reason != StopBreakpoint || stop.ID != bp.ID || d.Console().cpu.program_counter != 0x8020 {
+		t.Errorf("step over a JSR with a breakpoint inside stopped on %v at $%04X", stop, d.Console().cpu.program_counter)
+	}
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	d := newProgramDebugger(t)
+	if _, err := d.AddBreakpoint(0x8020, "[$10] == 5 &&"); err == nil {
+		t.Error("added a breakpoint with a broken condition")
+	}
+	bp, err := d.AddBreakpoint(0x8020, "[$10] == 5 && X == 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop := d.Continue(1000); stop.Reason != StopBreakpoint || stop.ID != bp.ID {
+		t.Fatalf("Continue stopped on %v", stop)
+	}
+	if calls := d.Console().Peek(0x10); calls != 5 {
+		t.Errorf("stopped with $10 = %d, want 5", calls)
+	}
+	// the condition is false from here on
+	if stop := d.Continue(1000); stop.Reason != StopLimit {
+		t.Errorf("Continue stopped on %v with the condition false", stop)
+	}
+	bp.Enabled = false
+	bp.Condition = nil
+	if stop := d.Continue(1000); stop.Reason != StopLimit {
+		t.Errorf("Continue stopped on %v at a disabled breakpoint", stop)
+	}
+}
+
+func TestDebuggerInterruptDepth(t *testing.T) {
+	d := newProgramDebugger(t)
+	bp, err := d.AddBreakpoint(0x8030, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop := d.Continue(0); stop.Reason != StopBreakpoint || stop.ID != bp.ID {
+		t.Fatalf("Continue stopped on %v", stop)
+	}
+	d.Delete(bp.ID)
+	// the NMI counts as a level, wherever in the loop it came
+	depth := d.Depth()
+	if depth < 1 || depth > 3 {
+		t.Fatalf("depth %d in the NMI handler", depth)
+	}
+	if d.StepOver(); d.Console().cpu.program_counter != 0x8032 || d.Depth() != depth {
+		t.Errorf("step over INC went to $%04X depth %d", d.Console().cpu.program_counter, d.Depth())
+	}
+	d.StepIn()
+	if pc := d.Console().cpu.program_counter; pc >= 0x8030 || d.Depth() != depth-1 {
+		t.Errorf("after RTI at $%04X depth %d, want out of the handler at depth %d", pc, d.Depth(), depth-1)
+	}
+
+	// JSR/RTS and NMI/RTI stay balanced over whole frames
+	for i := 0; i < 5; i++ {
+		if stop := d.ContinueFrame(); stop.Reason != StopStep {
+			t.Fatalf("ContinueFrame stopped on %v", stop)
+		}
+		if d.Depth() < 0 || d.Depth() > 3 {
+			t.Fatalf("frame %d: depth %d", i, d.Depth())
+		}
+	}
+	if nmis := d.Console().Peek(0x11); nmis < 5 {
+		t.Errorf("%d NMIs, want one a frame", nmis)
+	}
+
+	// stepping out of the handler
+	bp, _ = d.AddBreakpoint(0x8030, "")
+	d.Continue(0)
+	d.Delete(bp.ID)
+	depth = d.Depth()
+	if stop := d.StepOut(); stop.Reason != StopStep || d.Console().cpu.program_counter >= 0x8030 || d.Depth() != depth-1 {
+		t.Errorf("step out of the NMI handler stopped on %v at $%04X depth %d, want depth %d", stop, d.Console().cpu.program_counter, d.Depth(), depth-1)
+	}
+}
+
+func TestDebuggerRewind(t *testing.T) {
+	d := newTestDebugger(t)
+	if d.Rewind(1) != 0 {
+		t.Error("rewound without EnableRewind")
+	}
+	d.EnableRewind(DefaultRewindConfig)
+	var hashes []uint64
+	for i := 0; i < 5; i++ {
+		d.ContinueFrame()
+		hashes = append(hashes, d.Console().StateHash())
+	}
+	if got := d.Rewind(2); got != 2 || d.Console().StateHash() != hashes[2] {
+		t.Fatalf("Rewind(2) went back %d frames, to the end of another frame", got)
+	}
+	// going forward again records the frames anew
+	d.ContinueFrame()
+	if d.Console().StateHash() != hashes[3] {
+		t.Error("running on after a rewind took a different path")
+	}
+	// from the middle of a frame it counts from the end of the last one
+	d.StepIn()
+	if got := d.Rewind(1); got != 1 || d.Console().StateHash() != hashes[2] {
+		t.Errorf("Rewind(1) mid frame went back %d frames, to the end of another frame", got)
+	}
+	if got := d.Rewind(10); got >= 10 {
+		t.Errorf("Rewind(10) went back %d frames of a 5 frame history", got)
+	}
+}
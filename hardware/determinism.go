@@ -0,0 +1,66 @@
+package hardware
+
+import (
+	"hash/fnv"
+)
+
+// Emulation is deterministic: given the same ROM, power-on RAM policy and
+// input, every run produces the same state on every frame. Nothing inside the
+// machine may depend on wall-clock time, goroutine scheduling or map iteration
+// order, and the only randomness is the seeded power-on RAM fill below.
+
+type RAMFill uint8
+
+const (
+	RAMZeros  RAMFill = iota // all bits clear, the historical behaviour of NewCPU
+	RAMOnes                  // every byte $FF
+	RAMRandom                // pseudo random bytes from Seed
+)
+
+// RAMPolicy decides the contents of internal RAM at power on. Real consoles
+// power up with mostly unpredictable RAM, and games that forget to initialize
+// it behave differently between policies.
+type RAMPolicy struct {
+	Fill RAMFill
+	Seed uint64
+}
+
+// splitmix64 is a tiny PRNG whose output only depends on the seed
+func splitmix64(state *uint64) uint64 {
+	*state += 0x9E3779B97F4A7C15
+	z := *state
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+// fillRAM applies the policy to the 2 KiB of internal RAM
+func (c *CPU) fillRAM(policy RAMPolicy) {
+	ram := c.memory[:0x800]
+	switch policy.Fill {
+	case RAMZeros:
+		clear(ram)
+	case RAMOnes:
+		for i := range ram {
+			ram[i] = 0xFF
+		}
+	case RAMRandom:
+		state := policy.Seed
+		for i := 0; i < len(ram); i += 8 {
+			val := splitmix64(&state)
+			for j := 0; j < 8; j++ {
+				ram[i+j] = uint8(val >> (8 * j))
+			}
+		}
+	}
+}
+
+// StateHash returns a hash of the whole machine state: CPU registers and
+// cycle counter, RAM, PPU memory and latches, mapper registers, banks and
+// RAM, and the controllers. Two consoles with equal hashes will behave
+// identically given the same input.
+func (n *Console) StateHash() uint64 {
+	h := fnv.New64a()
+	h.Write(n.snapshot())
+	return h.Sum64()
+}
@@ -0,0 +1,87 @@
+package hardware_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+	"github.com/tejasdeepakmasne/nesemu-go/headless"
+)
+
+var ramPolicies = map[string]hardware.RAMPolicy{
+	"zeros":  {Fill: hardware.RAMZeros},
+	"ones":   {Fill: hardware.RAMOnes},
+	"random": {Fill: hardware.RAMRandom, Seed: 1},
+	"seed 2": {Fill: hardware.RAMRandom, Seed: 2},
+}
+
+func TestDeterminism(t *testing.T) {
+	rom, err := os.ReadFile("nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := headless.ParseScript(strings.NewReader("10-12 start\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, policy := range ramPolicies {
+		t.Run(name, func(t *testing.T) {
+			cfg := headless.Config{Frames: 40, Input: script, RAM: policy}
+			if err := headless.CheckDeterminism(rom, cfg); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRAMPolicy(t *testing.T) {
+	rom, err := os.ReadFile("nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	powerOn := func(policy hardware.RAMPolicy) uint64 {
+		cart, err := hardware.ParseROM(rom, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		console := hardware.NewConsole(cart)
+		console.SetRAMPolicy(policy)
+		console.PowerCycle()
+		return console.StateHash()
+	}
+	hashes := map[uint64]string{}
+	for name, policy := range ramPolicies {
+		hash := powerOn(policy)
+		if hash != powerOn(policy) {
+			t.Errorf("%s: power on state differs between runs", name)
+		}
+		if other, ok := hashes[hash]; ok {
+			t.Errorf("%s and %s power on to the same state", name, other)
+		}
+		hashes[hash] = name
+	}
+}
+
+func TestPowerCycleBatteryRAM(t *testing.T) {
+	rom, err := os.ReadFile("nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, battery := range []bool{false, true} {
+		cart, err := hardware.ParseROM(rom, func(cart *hardware.Cartridge) { cart.Battery = battery })
+		if err != nil {
+			t.Fatal(err)
+		}
+		console := hardware.NewConsole(cart)
+		console.Poke(0x6123, 0x5A)
+		console.PowerCycle()
+		want := uint8(0)
+		if battery {
+			want = 0x5A
+		}
+		if got := console.Peek(0x6123); got != want {
+			t.Errorf("battery %v: $6123 = $%02X after a power cycle, want $%02X", battery, got, want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package hardware
+
+// Famicom Disk System RAM adapter https://www.nesdev.org/wiki/Family_Computer_Disk_System
+//
+// The adapter holds 32 KiB of PRG-RAM at $6000-$DFFF, 8 KiB of CHR-RAM, the
+// BIOS at $E000-$FFFF, a timer IRQ, the disk drive interface and a wavetable
+// sound channel, see fds_audio.go, that the APU mixes with its own.
+const MAPPER_FDS = 20
+
+// CPU cycles between bytes passing under the drive head, about 96.4 kbit/s
+const fdsByteCycles = 150
+
+// CPU cycles from the motor starting to the first byte reaching the head
+const fdsSpinUp = 50000
+
+type fds struct {
+	cart   *Cartridge
+	disk   *Disk
+	prgRAM [0x8000]uint8
+
+	// $4023
+	diskEnabled  bool
+	soundEnabled bool
+
+	// timer IRQ, $4020-$4022
+	irqReload  uint16
+	irqCounter uint16
+	irqRepeat  bool
+	irqEnabled bool
+	timerIRQ   bool
+
+	// drive control, $4025
+	motorOn        bool
+	resetTransfer  bool
+	readMode       bool
+	crcControl     bool
+	transferStart  bool
+	diskIRQEnabled bool
+
+	// drive state
+	diskIRQ          bool
+	transferComplete bool
+	readData         uint8
+	writeData        uint8
+	position         int
+	delay            int
+	endOfHead        bool
+	scanning         bool
+	gapEnded         bool
+	crc              uint16
+	previousCRC      bool // crcControl during the previous byte
+
+	extOut uint8 // $4026
+	audio  fdsAudio
+}
+
+func newFDS(cart *Cartridge) *fds {
+	return &fds{cart: cart, disk: cart.disk, endOfHead: true, audio: newFDSAudio()}
+}
+
+func (m *fds) readPRG(address uint16) uint8 {
+	if address >= 0xE000 {
+		return m.cart.PRG[address-0xE000]
+	}
+	return m.prgRAM[address-0x6000]
+}
+
+func (m *fds) writePRG(address uint16, data uint8) {
+	if address < 0xE000 {
+		m.prgRAM[address-0x6000] = data
+	}
+}
+
+func (m *fds) prgOffset(address uint16) int {
+	if address < 0xE000 {
+		return -1
+	}
+	return int(address - 0xE000)
+}
+
+func (m *fds) readCHR(address uint16) uint8 {
+	return m.cart.CHR[address]
+}
+
+func (m *fds) writeCHR(address uint16, data uint8) {
+	m.cart.CHR[address] = data
+}
+
+func (m *fds) chrOffset(address uint16) int {
+	return int(address & 0x1FFF)
+}
+
+func (m *fds) irq() bool {
+	return m.timerIRQ || m.diskIRQ
+}
+
+func (m *fds) readRegister(address uint16) (uint8, bool) {
+	switch {
+	case address == 0x4030:
+		var status uint8
+		if m.timerIRQ {
+			status |= 0x01
+		}
+		if m.transferComplete {
+			status |= 0x02
+		}
+		if m.endOfHead {
+			status |= 0x40
+		}
+		// CRC errors (bit 4) are never reported
+		m.transferComplete = false
+		m.timerIRQ, m.diskIRQ = false, false
+		return status, true
+	case address == 0x4031:
+		m.transferComplete = false
+		m.diskIRQ = false
+		return m.readData, true
+	case address == 0x4032:
+		status := uint8(0x40)
+		if m.disk.side < 0 {
+			status |= 0x07 // no disk, not ready, write protected
+		} else if !m.scanning {
+			status |= 0x02
+		}
+		return status, true
+	case address == 0x4033:
+		return 0x80, true // battery good
+	}
+	return m.audio.readRegister(address)
+}
+
+func (m *fds) writeRegister(address uint16, data uint8) {
+	if !m.diskEnabled && address >= 0x4024 && address <= 0x4026 {
+		return
+	}
+	switch {
+	case address == 0x4020:
+		m.irqReload = m.irqReload&0xFF00 | uint16(data)
+	case address == 0x4021:
+		m.irqReload = m.irqReload&0x00FF | uint16(data)<<8
+	case address == 0x4022:
+		m.irqRepeat = data&0x01 != 0
+		m.irqEnabled = data&0x02 != 0 && m.diskEnabled
+		if m.irqEnabled {
+			m.irqCounter = m.irqReload
+		} else {
+			m.timerIRQ = false
+		}
+	case address == 0x4023:
+		m.diskEnabled = data&0x01 != 0
+		m.soundEnabled = data&0x02 != 0
+		if !m.diskEnabled {
+			m.irqEnabled = false
+			m.timerIRQ, m.diskIRQ = false, false
+		}
+	case address == 0x4024:
+		m.writeData = data
+		m.transferComplete = false
+		m.diskIRQ = false
+	case address == 0x4025:
+		m.motorOn = data&0x01 != 0
+		m.resetTransfer = data&0x02 != 0
+		m.readMode = data&0x04 != 0
+		if data&0x08 != 0 {
+			m.cart.Mirroring = MirrorHorizontal
+		} else {
+			m.cart.Mirroring = MirrorVertical
+		}
+		m.crcControl = data&0x10 != 0
+		m.transferStart = data&0x40 != 0
+		m.diskIRQEnabled = data&0x80 != 0
+		m.diskIRQ = false
+	case address == 0x4026:
+		m.extOut = data
+	case address >= 0x4040 && address <= 0x408A && m.soundEnabled:
+		m.audio.writeRegister(address, data)
+	}
+}
+
+func (m *fds) clock(cycles int) {
+	m.disk.clock(cycles)
+	for i := 0; i < cycles; i++ {
+		if m.irqEnabled {
+			if m.irqCounter == 0 {
+				m.timerIRQ = true
+				m.irqCounter = m.irqReload
+				m.irqEnabled = m.irqRepeat
+			} else {
+				m.irqCounter--
+			}
+		}
+		m.stepDrive()
+		if m.soundEnabled {
+			m.audio.clock()
+		}
+	}
+}
+
+// sound returns the level of the wavetable channel for the APU mixer
+func (m *fds) sound() float64 {
+	if !m.soundEnabled {
+		return 0
+	}
+	return m.audio.output()
+}
+
+// stepDrive advances the drive by one CPU cycle. Every fdsByteCycles a byte
+// passes under the head and is read into $4031 or written from $4024.
+func (m *fds) stepDrive() {
+	if m.disk.side < 0 || !m.motorOn {
+		m.endOfHead = true
+		m.scanning = false
+		return
+	}
+	if m.resetTransfer && !m.scanning {
+		return
+	}
+	if m.endOfHead {
+		m.delay = fdsSpinUp - 1
+		m.endOfHead = false
+		m.position = 0
+		m.gapEnded = false
+		return
+	}
+	if m.delay > 0 {
+		m.delay--
+		return
+	}
+
+	m.scanning = true
+	side := m.disk.sides[m.disk.side]
+	if m.readMode {
+		data := side[m.position]
+		if !m.previousCRC {
+			m.crc = fdsCRC(m.crc, data)
+		}
+		switch {
+		case !m.transferStart:
+			m.gapEnded = false
+			m.crc = 0
+		case !m.gapEnded:
+			// the start mark ends the gap, it isn't passed on
+			m.gapEnded = data != 0
+		default:
+			m.transferComplete = true
+			m.readData = data
+			m.diskIRQ = m.diskIRQ || m.diskIRQEnabled
+		}
+	} else {
+		var data uint8
+		if !m.crcControl {
+			m.transferComplete = true
+			data = m.writeData
+			m.diskIRQ = m.diskIRQ || m.diskIRQEnabled
+		}
+		if !m.transferStart {
+			data = 0
+		}
+		if !m.crcControl {
+			m.crc = fdsCRC(m.crc, data)
+		} else {
+			if !m.previousCRC {
+				m.crc = fdsCRC(fdsCRC(m.crc, 0), 0)
+			}
+			data = uint8(m.crc)
+			m.crc >>= 8
+		}
+		if side[m.position] != data {
+			side[m.position] = data
+			m.disk.changed = true
+		}
+		m.gapEnded = false
+	}
+	m.previousCRC = m.crcControl
+
+	m.position++
+	if m.position >= len(side) {
+		m.motorOn = false
+	} else {
+		m.delay = fdsByteCycles - 1
+	}
+}
+
+func (m *fds) saveState(w *stateWriter) {
+	w.bytes(m.prgRAM[:])
+	w.bytes(m.cart.CHR)
+	w.u8(uint8(m.cart.Mirroring))
+	for _, flag := range m.flags() {
+		w.bool(*flag)
+	}
+	w.u16(m.irqReload)
+	w.u16(m.irqCounter)
+	w.u8(m.readData)
+	w.u8(m.writeData)
+	w.u64(uint64(m.position))
+	w.u64(uint64(m.delay))
+	w.u16(m.crc)
+	w.u8(m.extOut)
+	m.audio.saveState(w)
+	m.disk.saveState(w)
+}
+
+func (m *fds) loadState(r *stateReader) {
+	r.bytes(m.prgRAM[:])
+	r.bytes(m.cart.CHR)
+	m.cart.Mirroring = Mirroring(r.u8())
+	for _, flag := range m.flags() {
+		*flag = r.bool()
+	}
+	m.irqReload = r.u16()
+	m.irqCounter = r.u16()
+	m.readData = r.u8()
+	m.writeData = r.u8()
+	m.position = int(r.u64())
+	m.delay = int(r.u64())
+	m.crc = r.u16()
+	m.extOut = r.u8()
+	m.audio.loadState(r)
+	m.disk.loadState(r)
+}
+
+// flags lists the boolean state in save state order
+func (m *fds) flags() []*bool {
+	return []*bool{
+		&m.diskEnabled, &m.soundEnabled,
+		&m.irqRepeat, &m.irqEnabled, &m.timerIRQ,
+		&m.motorOn, &m.resetTransfer, &m.readMode, &m.crcControl, &m.transferStart, &m.diskIRQEnabled,
+		&m.diskIRQ, &m.transferComplete, &m.endOfHead, &m.scanning, &m.gapEnded, &m.previousCRC,
+	}
+}
@@ -0,0 +1,261 @@
+package hardware
+
+// Famicom Disk System sound https://www.nesdev.org/wiki/FDS_audio
+//
+// A single channel steps through a 64 entry wavetable of 6 bit samples. A
+// second table of pitch changes, the modulator, bends its frequency, and
+// each has an envelope: the volume for the wave, the depth for the
+// modulator. Both tables advance one entry each time a 16 bit accumulator
+// the pitch is added to every CPU cycle overflows.
+
+// output of the channel at full volume on the APU mixer's scale, about 2.4
+// times a pulse channel
+const fdsAudioLevel = 0.36
+
+// master volume scales set by $4089
+var fdsMasterVolumes = [4]float64{2.0 / 2, 2.0 / 3, 2.0 / 4, 2.0 / 5}
+
+// counter changes for each modulator table entry, entry 4 resets the counter
+var fdsModSteps = [8]int8{0, 1, 2, 4, 0, -4, -2, -1}
+
+// accumulators count 16 bits of fraction below a 6 bit table index
+const fdsPhaseMask = 1<<22 - 1
+
+type fdsEnvelope struct {
+	gain     uint8 // 0-63, moving between 0 and 32
+	speed    uint8
+	increase bool
+	direct   bool // the gain is set by the register, not the envelope
+	timer    int
+}
+
+// write sets the envelope from $4080 or $4084
+func (e *fdsEnvelope) write(data uint8) {
+	e.direct = data&0x80 != 0
+	e.increase = data&0x40 != 0
+	e.speed = data & 0x3F
+	if e.direct {
+		e.gain = data & 0x3F
+	}
+	e.timer = 0
+}
+
+// clock advances the envelope by a CPU cycle. Its period is a multiple of
+// the master speed in $408A.
+func (e *fdsEnvelope) clock(master uint8) {
+	if e.direct {
+		return
+	}
+	e.timer++
+	if e.timer < 8*(int(e.speed)+1)*int(master) {
+		return
+	}
+	e.timer = 0
+	if e.increase && e.gain < 32 {
+		e.gain++
+	} else if !e.increase && e.gain > 0 {
+		e.gain--
+	}
+}
+
+type fdsAudio struct {
+	wave [64]uint8 // $4040-$407F
+	mod  [64]uint8 // 3 bit entries indexing fdsModSteps
+
+	volume fdsEnvelope // $4080
+	depth  fdsEnvelope // $4084
+
+	wavePitch     uint16 // $4082-$4083, 12 bits
+	haltWave      bool
+	haltEnvelopes bool
+	modPitch      uint16 // $4086-$4087, 12 bits
+	haltMod       bool
+	counter       int8 // $4085, 7 bit signed
+	waveWrite     bool // $4089
+	master        uint8
+	envelopeSpeed uint8 // $408A
+
+	wavePhase uint32
+	modPhase  uint32
+	gain      uint8 // volume gain latched at the start of each wave cycle
+	level     uint8 // wave sample last played, held while the wave is written
+}
+
+func newFDSAudio() fdsAudio {
+	return fdsAudio{envelopeSpeed: 0xE8}
+}
+
+func (a *fdsAudio) readRegister(address uint16) (uint8, bool) {
+	switch {
+	case address >= 0x4040 && address < 0x4080:
+		return a.wave[address-0x4040] | 0x40, true
+	case address == 0x4090:
+		return a.volume.gain | 0x40, true
+	case address == 0x4092:
+		return a.depth.gain | 0x40, true
+	}
+	return 0, false
+}
+
+func (a *fdsAudio) writeRegister(address uint16, data uint8) {
+	switch {
+	case address >= 0x4040 && address < 0x4080:
+		if a.waveWrite {
+			a.wave[address-0x4040] = data & 0x3F
+		}
+	case address == 0x4080:
+		a.volume.write(data)
+	case address == 0x4082:
+		a.wavePitch = a.wavePitch&0x0F00 | uint16(data)
+	case address == 0x4083:
+		a.wavePitch = a.wavePitch&0x00FF | uint16(data&0x0F)<<8
+		a.haltWave = data&0x80 != 0
+		a.haltEnvelopes = data&0x40 != 0
+		if a.haltWave {
+			a.wavePhase = 0
+		}
+	case address == 0x4084:
+		a.depth.write(data)
+	case address == 0x4085:
+		// sign extend the 7 bit counter
+		a.counter = int8(data<<1) >> 1
+	case address == 0x4086:
+		a.modPitch = a.modPitch&0x0F00 | uint16(data)
+	case address == 0x4087:
+		a.modPitch = a.modPitch&0x00FF | uint16(data&0x0F)<<8
+		a.haltMod = data&0x80 != 0
+	case address == 0x4088:
+		// the table is written two entries at a time while halted
+		if a.haltMod {
+			index := a.modPhase >> 16
+			a.mod[index] = data & 0x07
+			a.mod[(index+1)&0x3F] = data & 0x07
+			a.modPhase = (a.modPhase + 2<<16) & fdsPhaseMask
+		}
+	case address == 0x4089:
+		a.waveWrite = data&0x80 != 0
+		a.master = data & 0x03
+	case address == 0x408A:
+		a.envelopeSpeed = data
+	}
+}
+
+// clock advances the channel by a CPU cycle
+func (a *fdsAudio) clock() {
+	if !a.haltEnvelopes && !a.haltWave && a.envelopeSpeed != 0 {
+		a.volume.clock(a.envelopeSpeed)
+		a.depth.clock(a.envelopeSpeed)
+	}
+	if !a.haltMod && a.modPitch != 0 {
+		index := a.modPhase >> 16
+		a.modPhase = (a.modPhase + uint32(a.modPitch)) & fdsPhaseMask
+		if a.modPhase>>16 != index {
+			if entry := a.mod[index]; entry == 4 {
+				a.counter = 0
+			} else {
+				a.counter = int8((a.counter+fdsModSteps[entry])<<1) >> 1
+			}
+		}
+	}
+	if a.haltWave || a.waveWrite {
+		return
+	}
+	index := a.wavePhase >> 16
+	a.wavePhase = (a.wavePhase + uint32(a.pitch())) & fdsPhaseMask
+	if a.wavePhase>>16 < index {
+		a.gain = min(a.volume.gain, 32)
+	}
+	a.level = a.wave[a.wavePhase>>16]
+}
+
+// pitch returns the wave pitch bent by the modulator, with the rounding of
+// the hardware as worked out on the wiki
+func (a *fdsAudio) pitch() int {
+	pitch := int(a.wavePitch)
+	if a.haltMod {
+		return pitch
+	}
+	bend := int(a.counter) * int(a.depth.gain)
+	remainder := bend & 0x0F
+	bend >>= 4
+	if remainder > 0 && bend&0x80 == 0 {
+		if a.counter < 0 {
+			bend--
+		} else {
+			bend += 2
+		}
+	}
+	if bend >= 192 {
+		bend -= 256
+	} else if bend < -64 {
+		bend += 256
+	}
+	bend *= pitch
+	remainder = bend & 0x3F
+	bend >>= 6
+	if remainder >= 32 {
+		bend++
+	}
+	return max(pitch+bend, 0)
+}
+
+// output returns the level of the channel on the APU mixer's scale
+func (a *fdsAudio) output() float64 {
+	return float64(a.level) * float64(a.gain) / (63 * 32) * fdsMasterVolumes[a.master] * fdsAudioLevel
+}
+
+func (e *fdsEnvelope) saveState(w *stateWriter) {
+	w.u8(e.gain)
+	w.u8(e.speed)
+	w.bool(e.increase)
+	w.bool(e.direct)
+	w.u64(uint64(e.timer))
+}
+
+func (e *fdsEnvelope) loadState(r *stateReader) {
+	e.gain = r.u8()
+	e.speed = r.u8()
+	e.increase = r.bool()
+	e.direct = r.bool()
+	e.timer = int(r.u64())
+}
+
+func (a *fdsAudio) saveState(w *stateWriter) {
+	w.bytes(a.wave[:])
+	w.bytes(a.mod[:])
+	a.volume.saveState(w)
+	a.depth.saveState(w)
+	w.u16(a.wavePitch)
+	w.bool(a.haltWave)
+	w.bool(a.haltEnvelopes)
+	w.u16(a.modPitch)
+	w.bool(a.haltMod)
+	w.u8(uint8(a.counter))
+	w.bool(a.waveWrite)
+	w.u8(a.master)
+	w.u8(a.envelopeSpeed)
+	w.u64(uint64(a.wavePhase))
+	w.u64(uint64(a.modPhase))
+	w.u8(a.gain)
+	w.u8(a.level)
+}
+
+func (a *fdsAudio) loadState(r *stateReader) {
+	r.bytes(a.wave[:])
+	r.bytes(a.mod[:])
+	a.volume.loadState(r)
+	a.depth.loadState(r)
+	a.wavePitch = r.u16()
+	a.haltWave = r.bool()
+	a.haltEnvelopes = r.bool()
+	a.modPitch = r.u16()
+	a.haltMod = r.bool()
+	a.counter = int8(r.u8())
+	a.waveWrite = r.bool()
+	a.master = r.u8() & 0x03
+	a.envelopeSpeed = r.u8()
+	a.wavePhase = uint32(r.u64()) & fdsPhaseMask
+	a.modPhase = uint32(r.u64()) & fdsPhaseMask
+	a.gain = r.u8()
+	a.level = r.u8() & 0x3F
+}
@@ -0,0 +1,329 @@
+package hardware
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+)
+
+// FDS disk images https://www.nesdev.org/wiki/FDS_file_format
+//
+// An .fds image is an optional 16 byte fwNES header followed by 65500 bytes
+// per disk side holding the blocks back to back. QD images are raw dumps of
+// the Quick Disk, 65536 bytes per side with a CRC after every block.
+const FDS_HEADER_SIZE = 16
+const FDS_SIDE_SIZE = 65500
+const QD_SIDE_SIZE = 0x10000
+const FDS_BIOS_SIZE = 0x2000
+
+var fdsMagic = [4]uint8{'F', 'D', 'S', 0x1A}
+
+// every side starts with a disk info block holding this
+var fdsVerification = []uint8("*NINTENDO-HVC*")
+
+var ErrNotFDS = errors.New("not an FDS disk image")
+
+// the drive sees each side as a stream of bytes: a long gap of zeros, then
+// every block preceded by a $80 start mark and followed by its CRC and a
+// shorter gap
+const (
+	fdsLeadIn  = 28300 / 8
+	fdsGap     = 976 / 8
+	fdsRawSize = fdsLeadIn + FDS_SIDE_SIZE + 0x3000 // room for the gaps and CRCs
+)
+
+// block types
+const (
+	fdsBlockInfo      = 1
+	fdsBlockFileCount = 2
+	fdsBlockHeader    = 3
+	fdsBlockData      = 4
+)
+
+// IsFDS reports whether data looks like an .fds or QD image
+func IsFDS(data []uint8) bool {
+	if len(data) >= 4 && [4]uint8(data[0:4]) == fdsMagic {
+		return true
+	}
+	return len(data) > len(fdsVerification) && data[0] == fdsBlockInfo && bytes.HasPrefix(data[1:], fdsVerification)
+}
+
+// Disk is the disk in a Famicom Disk System drive. Each side is kept as the
+// stream the drive reads, so the BIOS can rewrite any part of it, and is
+// converted back to the layout of the image it came from by Image.
+type Disk struct {
+	header  []uint8 // fwNES header, nil for a headerless image
+	qd      bool    // QD layout
+	sides   [][]uint8
+	id      [sha1.Size]uint8
+	changed bool
+
+	side        int // inserted side, -1 when the drive is empty
+	pendingSide int // side going in once insertDelay runs out
+	insertDelay int // CPU cycles
+}
+
+// ParseFDS builds a Famicom Disk System from a disk image and the 8 KiB
+// BIOS, disksys.rom. Headerless and QD images are accepted. The first side
+// is inserted.
+func ParseFDS(data []uint8, bios []uint8) (*Cartridge, error) {
+	disk, err := parseDisk(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(bios) != FDS_BIOS_SIZE {
+		return nil, fmt.Errorf("FDS BIOS is %d bytes, want %d", len(bios), FDS_BIOS_SIZE)
+	}
+	cart := &Cartridge{
+		PRG:       bios,
+		CHR:       make([]uint8, CHR_BANK_SIZE),
+		MapperID:  MAPPER_FDS,
+		Mirroring: MirrorHorizontal,
+		chrRAM:    true,
+		disk:      disk,
+	}
+	if err := cart.attachMapper(); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+func parseDisk(data []uint8) (*Disk, error) {
+	disk := &Disk{}
+	if len(data) >= FDS_HEADER_SIZE && [4]uint8(data[0:4]) == fdsMagic {
+		disk.header = data[:FDS_HEADER_SIZE]
+		data = data[FDS_HEADER_SIZE:]
+	}
+	sideSize := FDS_SIDE_SIZE
+	if len(data)%FDS_SIDE_SIZE != 0 && len(data)%QD_SIDE_SIZE == 0 {
+		disk.qd = true
+		sideSize = QD_SIDE_SIZE
+	}
+	if len(data) == 0 || len(data)%sideSize != 0 {
+		return nil, ErrNotFDS
+	}
+
+	h := sha1.New()
+	for offset := 0; offset < len(data); offset += sideSize {
+		blocks := splitBlocks(data[offset:offset+sideSize], disk.qd)
+		if len(blocks) == 0 || !bytes.HasPrefix(blocks[0][1:], fdsVerification) {
+			return nil, fmt.Errorf("%w: side %d has no disk info block", ErrNotFDS, len(disk.sides))
+		}
+		// the info block names the game, disk and side, and the BIOS never
+		// rewrites it, so it identifies the disk however much is saved to it
+		h.Write(blocks[0])
+		disk.sides = append(disk.sides, rawSide(blocks))
+	}
+	copy(disk.id[:], h.Sum(nil))
+	return disk, nil
+}
+
+// nextBlock returns the size of a block of the given type, and false when
+// the type can't follow the previous block. fileSize comes from the last
+// file header.
+func nextBlock(blockType, previous uint8, fileSize int) (int, bool) {
+	switch {
+	case blockType == fdsBlockInfo && previous == 0:
+		return 56, true
+	case blockType == fdsBlockFileCount && previous == fdsBlockInfo:
+		return 2, true
+	case blockType == fdsBlockHeader && (previous == fdsBlockFileCount || previous == fdsBlockData):
+		return 16, true
+	case blockType == fdsBlockData && previous == fdsBlockHeader:
+		return 1 + fileSize, true
+	}
+	return 0, false
+}
+
+func headerFileSize(header []uint8) int {
+	return int(header[13]) | int(header[14])<<8
+}
+
+// splitBlocks cuts a side of an image into its blocks, skipping the CRCs of
+// a QD image
+func splitBlocks(side []uint8, withCRC bool) [][]uint8 {
+	var blocks [][]uint8
+	previous, fileSize := uint8(0), 0
+	for pos := 0; pos < len(side); {
+		size, ok := nextBlock(side[pos], previous, fileSize)
+		if !ok || pos+size > len(side) {
+			break
+		}
+		block := side[pos : pos+size]
+		if block[0] == fdsBlockHeader {
+			fileSize = headerFileSize(block)
+		}
+		blocks = append(blocks, block)
+		previous = block[0]
+		pos += size
+		if withCRC {
+			pos += 2
+		}
+	}
+	return blocks
+}
+
+// fdsCRC updates the CRC the drive keeps over a block, start mark included
+func fdsCRC(crc uint16, value uint8) uint16 {
+	for bit := 0; bit < 8; bit++ {
+		carry := crc & 1
+		crc >>= 1
+		if carry != 0 {
+			crc ^= 0x8408
+		}
+		if value>>bit&1 != 0 {
+			crc ^= 0x8000
+		}
+	}
+	return crc
+}
+
+// blockCRC returns the CRC stored after a block, which brings the drive's
+// CRC back to zero
+func blockCRC(block []uint8) uint16 {
+	crc := fdsCRC(0, 0x80)
+	for _, b := range block {
+		crc = fdsCRC(crc, b)
+	}
+	return fdsCRC(fdsCRC(crc, 0), 0)
+}
+
+func rawSide(blocks [][]uint8) []uint8 {
+	raw := make([]uint8, fdsLeadIn, fdsRawSize)
+	for _, block := range blocks {
+		crc := blockCRC(block)
+		raw = append(raw, 0x80)
+		raw = append(raw, block...)
+		raw = append(raw, uint8(crc), uint8(crc>>8))
+		raw = append(raw, make([]uint8, fdsGap)...)
+	}
+	if len(raw) < fdsRawSize {
+		raw = raw[:fdsRawSize]
+	}
+	return raw
+}
+
+// rawBlocks finds the blocks in the stream of a side, as the BIOS left it
+func rawBlocks(raw []uint8) [][]uint8 {
+	var blocks [][]uint8
+	previous, fileSize := uint8(0), 0
+	for pos := 0; pos < len(raw); pos++ {
+		if raw[pos] != 0x80 || pos+1 >= len(raw) {
+			continue
+		}
+		size, ok := nextBlock(raw[pos+1], previous, fileSize)
+		if !ok {
+			continue
+		}
+		if pos+1+size > len(raw) {
+			break
+		}
+		block := raw[pos+1 : pos+1+size]
+		if block[0] == fdsBlockHeader {
+			fileSize = headerFileSize(block)
+		}
+		blocks = append(blocks, block)
+		previous = block[0]
+		pos += size + 2
+	}
+	return blocks
+}
+
+// Sides returns the number of disk sides in the image
+func (d *Disk) Sides() int {
+	return len(d.sides)
+}
+
+// Side returns the inserted side, or -1 when the drive is empty
+func (d *Disk) Side() int {
+	return d.side
+}
+
+// Eject takes the disk out of the drive
+func (d *Disk) Eject() {
+	d.side = -1
+	d.insertDelay = 0
+}
+
+// fdsInsertDelay is how long a disk change takes, long enough for a game
+// waiting for one to see the drive empty first
+const fdsInsertDelay = CPU_FREQUENCY
+
+// Insert ejects the disk and then inserts the given side, after a delay
+func (d *Disk) Insert(side int) error {
+	if side < 0 || side >= len(d.sides) {
+		return fmt.Errorf("no %s, the image has %d sides", SideName(side), len(d.sides))
+	}
+	d.Eject()
+	d.pendingSide = side
+	d.insertDelay = fdsInsertDelay
+	return nil
+}
+
+func (d *Disk) clock(cycles int) {
+	if d.insertDelay == 0 {
+		return
+	}
+	d.insertDelay -= cycles
+	if d.insertDelay <= 0 {
+		d.insertDelay = 0
+		d.side = d.pendingSide
+	}
+}
+
+// SideName names a side the way the disk labels do, e.g. "disk 1 side B"
+func SideName(side int) string {
+	return fmt.Sprintf("disk %d side %c", side/2+1, 'A'+side%2)
+}
+
+// Changed reports whether anything was written to the disk since it was
+// loaded
+func (d *Disk) Changed() bool {
+	return d.changed
+}
+
+// Image returns the disk's current contents in the layout it was loaded
+// from
+func (d *Disk) Image() ([]uint8, error) {
+	sideSize := FDS_SIDE_SIZE
+	if d.qd {
+		sideSize = QD_SIDE_SIZE
+	}
+	image := append([]uint8(nil), d.header...)
+	for i, raw := range d.sides {
+		side := make([]uint8, 0, sideSize)
+		for _, block := range rawBlocks(raw) {
+			side = append(side, block...)
+			if d.qd {
+				crc := blockCRC(block)
+				side = append(side, uint8(crc), uint8(crc>>8))
+			}
+		}
+		if len(side) > sideSize {
+			return nil, fmt.Errorf("%s holds %d bytes, more than fits in an image", SideName(i), len(side))
+		}
+		image = append(image, side[:sideSize]...)
+	}
+	return image, nil
+}
+
+func (d *Disk) saveState(w *stateWriter) {
+	for _, raw := range d.sides {
+		w.bytes(raw)
+	}
+	w.bool(d.changed)
+	w.u8(uint8(d.side))
+	w.u8(uint8(d.pendingSide))
+	w.u64(uint64(d.insertDelay))
+}
+
+func (d *Disk) loadState(r *stateReader) {
+	for _, raw := range d.sides {
+		r.bytes(raw)
+	}
+	d.changed = r.bool()
+	d.side = int(int8(r.u8()))
+	d.pendingSide = int(r.u8())
+	d.insertDelay = int(r.u64())
+}
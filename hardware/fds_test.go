@@ -0,0 +1,289 @@
+package hardware
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testBlocks returns the blocks of a disk side holding one file
+func testBlocks(file []uint8) [][]uint8 {
+	info := make([]uint8, 56)
+	info[0] = fdsBlockInfo
+	copy(info[1:], fdsVerification)
+	copy(info[16:], "TST")
+	header := make([]uint8, 16)
+	header[0] = fdsBlockHeader
+	copy(header[3:], "FILE0001")
+	header[13], header[14] = uint8(len(file)), uint8(len(file)>>8)
+	return [][]uint8{info, {fdsBlockFileCount, 1}, header, append([]uint8{fdsBlockData}, file...)}
+}
+
+// testSide lays out the blocks of a side as in an .fds image, or a QD image
+// with a CRC after every block
+func testSide(blocks [][]uint8, qd bool) []uint8 {
+	size := FDS_SIDE_SIZE
+	if qd {
+		size = QD_SIDE_SIZE
+	}
+	side := make([]uint8, 0, size)
+	for _, block := range blocks {
+		side = append(side, block...)
+		if qd {
+			crc := blockCRC(block)
+			side = append(side, uint8(crc), uint8(crc>>8))
+		}
+	}
+	return side[:size]
+}
+
+func TestParseDisk(t *testing.T) {
+	sideA := testSide(testBlocks([]uint8("side A")), false)
+	sideB := testSide(testBlocks([]uint8("side B, a longer file")), false)
+	header := []uint8{'F', 'D', 'S', 0x1A, 2, 15: 0}
+	tests := []struct {
+		name  string
+		image []uint8
+		sides int
+		qd    bool
+	}{
+		{"headerless", sideA, 1, false},
+		{"fwNES", append(append(header[:len(header):len(header)], sideA...), sideB...), 2, false},
+		{"QD", append(testSide(testBlocks([]uint8("side A")), true), testSide(testBlocks([]uint8("side B")), true)...), 2, true},
+	}
+	var id [20]uint8
+	for i, tt := range tests {
+		if !IsFDS(tt.image) {
+			t.Errorf("%s: IsFDS is false", tt.name)
+		}
+		disk, err := parseDisk(tt.image)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if disk.Sides() != tt.sides || disk.qd != tt.qd {
+			t.Errorf("%s: %d sides, QD %v, want %d and %v", tt.name, disk.Sides(), disk.qd, tt.sides, tt.qd)
+		}
+		// the blocks survive the trip through the drive's stream
+		if blocks := rawBlocks(disk.sides[0]); len(blocks) != 4 || !bytes.Equal(blocks[3], append([]uint8{fdsBlockData}, "side A"...)) {
+			t.Errorf("%s: side A holds %q", tt.name, blocks)
+		}
+		image, err := disk.Image()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !bytes.Equal(image, tt.image) {
+			t.Errorf("%s: Image differs from the image loaded", tt.name)
+		}
+		// the disk info blocks identify the disk in either layout
+		if i == 1 {
+			id = disk.id
+		} else if i > 1 && disk.id != id {
+			t.Errorf("%s: disk id differs from the fwNES image's", tt.name)
+		}
+	}
+}
+
+func TestParseDiskRejects(t *testing.T) {
+	side := testSide(testBlocks(nil), false)
+	noInfo := append([]uint8(nil), side...)
+	noInfo[1] = 'X'
+	tests := map[string][]uint8{
+		"empty":         nil,
+		"short side":    side[:FDS_SIDE_SIZE-1],
+		"header only":   {'F', 'D', 'S', 0x1A, 1, 15: 0},
+		"no info block": noInfo,
+	}
+	for name, image := range tests {
+		if _, err := parseDisk(image); !errors.Is(err, ErrNotFDS) {
+			t.Errorf("%s: error %v, want ErrNotFDS", name, err)
+		}
+	}
+}
+
+func TestBlockCRC(t *testing.T) {
+	for _, block := range testBlocks([]uint8("some file data")) {
+		crc := blockCRC(block)
+		// the drive's CRC over the start mark, block and stored CRC is zero
+		check := fdsCRC(0, 0x80)
+		for _, b := range append(block, uint8(crc), uint8(crc>>8)) {
+			check = fdsCRC(check, b)
+		}
+		if check != 0 {
+			t.Errorf("block type %d: CRC $%04X leaves $%04X", block[0], crc, check)
+		}
+	}
+	if blockCRC([]uint8{1, 2}) == blockCRC([]uint8{2, 1}) {
+		t.Error("CRC ignores the byte order")
+	}
+}
+
+func newTestFDS(t *testing.T) (*Console, *fds) {
+	t.Helper()
+	cart, err := ParseFDS(testSide(testBlocks([]uint8("file")), false), make([]uint8, FDS_BIOS_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewConsole(cart), cart.mapper.(*fds)
+}
+
+func TestFDSDriveTiming(t *testing.T) {
+	_, m := newTestFDS(t)
+	m.writeRegister(0x4023, 0x01)
+	// motor on, read mode, transfer started, IRQ on each byte
+	m.writeRegister(0x4025, 0xC5)
+
+	type byteRead struct {
+		cycle int
+		data  uint8
+	}
+	var reads []byteRead
+	for cycle := 1; len(reads) < 3; cycle++ {
+		if cycle > fdsSpinUp+(fdsLeadIn+10)*fdsByteCycles {
+			t.Fatalf("read %d bytes", len(reads))
+		}
+		m.clock(1)
+		if m.irq() {
+			status, _ := m.readRegister(0x4030)
+			if status&0x02 == 0 {
+				t.Errorf("cycle %d: disk IRQ without the transfer flag, $4030 = $%02X", cycle, status)
+			}
+			if m.irq() {
+				t.Errorf("cycle %d: reading $4030 left the IRQ raised", cycle)
+			}
+			data, _ := m.readRegister(0x4031)
+			reads = append(reads, byteRead{cycle, data})
+		}
+	}
+	// the lead-in gap and the start mark aren't passed on, the first byte
+	// read is the info block's type
+	first := fdsSpinUp + 1 + (fdsLeadIn+1)*fdsByteCycles
+	want := []byteRead{{first, fdsBlockInfo}, {first + fdsByteCycles, '*'}, {first + 2*fdsByteCycles, 'N'}}
+	for i := range want {
+		if reads[i] != want[i] {
+			t.Errorf("read %d: $%02X at cycle %d, want $%02X at %d", i, reads[i].data, reads[i].cycle, want[i].data, want[i].cycle)
+		}
+	}
+
+	// the head parks when the motor stops
+	m.writeRegister(0x4025, 0x00)
+	m.clock(1)
+	if status, _ := m.readRegister(0x4030); status&0x40 == 0 {
+		t.Errorf("$4030 = $%02X with the motor off, want the end of head flag", status)
+	}
+}
+
+func TestFDSTimerIRQ(t *testing.T) {
+	_, m := newTestFDS(t)
+	m.writeRegister(0x4023, 0x01)
+	m.writeRegister(0x4020, 10)
+	m.writeRegister(0x4021, 0)
+	m.writeRegister(0x4022, 0x03) // enabled, repeating
+	for round := 0; round < 2; round++ {
+		m.clock(10)
+		if m.irq() {
+			t.Fatalf("round %d: IRQ after 10 cycles", round)
+		}
+		m.clock(1)
+		if !m.irq() {
+			t.Fatalf("round %d: no IRQ after 11 cycles", round)
+		}
+		if status, _ := m.readRegister(0x4030); status&0x01 == 0 {
+			t.Errorf("round %d: $4030 = $%02X, want the timer flag", round, status)
+		}
+		if m.irq() {
+			t.Errorf("round %d: reading $4030 left the IRQ raised", round)
+		}
+		if status, _ := m.readRegister(0x4030); status&0x01 != 0 {
+			t.Errorf("round %d: timer flag still set on a second read", round)
+		}
+	}
+
+	// without repeat the timer stops after one IRQ
+	m.writeRegister(0x4022, 0x02)
+	m.clock(11)
+	m.readRegister(0x4030)
+	m.clock(100)
+	if m.irq() {
+		t.Error("a one shot timer fired twice")
+	}
+	// turning the disk registers off stops the timer
+	m.writeRegister(0x4022, 0x03)
+	m.writeRegister(0x4023, 0x00)
+	m.clock(100)
+	if m.irq() {
+		t.Error("timer fired with the disk registers off")
+	}
+}
+
+func TestFDSAudio(t *testing.T) {
+	console, m := newTestFDS(t)
+	m.writeRegister(0x4023, 0x03)
+	// a square wave, written while the wave is halted for writing
+	m.writeRegister(0x4089, 0x80)
+	for i := 0; i < 64; i++ {
+		m.writeRegister(0x4040+uint16(i), uint8(63*(i/32)))
+	}
+	m.writeRegister(0x4089, 0x00)
+	m.writeRegister(0x4080, 0x80|32) // full volume
+	m.writeRegister(0x4087, 0x80)    // no modulation
+	// f = CPU_FREQUENCY * pitch / 2^22, 1031 is 440 Hz
+	m.writeRegister(0x4082, uint8(1031&0xFF))
+	m.writeRegister(0x4083, uint8(1031>>8))
+	if got, _ := m.readRegister(0x4090); got&0x3F != 32 {
+		t.Errorf("$4090 = $%02X, want the volume gain 32", got)
+	}
+
+	console.SetAudioRate(44100)
+	for console.Cycles() < CPU_FREQUENCY {
+		console.Step()
+	}
+	samples := console.ReadAudio()
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 0 && samples[i] >= 0 {
+			crossings++
+		}
+	}
+	if crossings < 435 || crossings > 445 {
+		t.Errorf("%d cycles a second, want 440", crossings)
+	}
+
+	m.writeRegister(0x4023, 0x01)
+	if level := m.sound(); level != 0 {
+		t.Errorf("level %f with sound off", level)
+	}
+}
+
+func TestFDSModulation(t *testing.T) {
+	a := newFDSAudio()
+	a.writeRegister(0x4082, 0x00)
+	a.writeRegister(0x4083, 0x02) // pitch $200
+	a.writeRegister(0x4084, 0x80|0x10)
+	a.writeRegister(0x4087, 0x00)
+	if got := a.pitch(); got != 0x200 {
+		t.Errorf("pitch $%X with the counter at 0, want $200", got)
+	}
+	a.writeRegister(0x4085, 0x10)
+	up := a.pitch()
+	a.writeRegister(0x4085, 0x70) // -16
+	down := a.pitch()
+	if up <= 0x200 || down >= 0x200 {
+		t.Errorf("counter +16 bends to $%X, -16 to $%X, around $200", up, down)
+	}
+
+	// the table is written while the modulator is halted, two entries a write
+	a.writeRegister(0x4087, 0x80)
+	for i := 0; i < 32; i++ {
+		a.writeRegister(0x4088, 1)
+	}
+	a.writeRegister(0x4085, 0)
+	a.writeRegister(0x4086, 0xFF)
+	a.writeRegister(0x4087, 0x0F)
+	// every entry adds 1, one entry every 2^16/pitch cycles
+	for i := 0; i < 1<<16/0xFFF*10+1; i++ {
+		a.clock()
+	}
+	if a.counter != 10 {
+		t.Errorf("counter %d after 10 modulator steps of +1", a.counter)
+	}
+}
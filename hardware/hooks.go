@@ -0,0 +1,242 @@
+package hardware
+
+// Hooks is a registry of observers of the CPU bus. Tools such as the
+// debugger, scripts and loggers subscribe to reads, writes and instruction
+// fetches in an address range, to PPU register accesses, to pattern table
+// reads and to interrupts.
+// While nothing is registered the CPU does not call into the registry at all.
+//
+// Callbacks run on the emulation goroutine in the middle of an instruction.
+// They may register and remove hooks, but should not step the console.
+
+// AccessFunc is called with the kind of access, the address and the value
+// read or written. For AccessExec the value is the opcode.
+type AccessFunc func(kind AccessKind, address uint16, value uint8)
+
+// InterceptFunc sees a value read from the bus before the CPU does and
+// returns the value the CPU gets instead
+type InterceptFunc func(address uint16, value uint8) uint8
+
+// CHRFunc is called when the PPU reads pattern table data at address, drawn
+// tells rendering fetches from CPU reads through $2007
+type CHRFunc func(address uint16, drawn bool)
+
+// InterruptFunc is called when the CPU takes an interrupt, before it jumps
+// to the handler
+type InterruptFunc func(kind Interrupt)
+
+type Interrupt uint8
+
+const (
+	InterruptReset Interrupt = iota
+	InterruptNMI
+	InterruptBRK
+	InterruptIRQ // raised by the cartridge or the APU
+)
+
+func (i Interrupt) String() string {
+	switch i {
+	case InterruptReset:
+		return "reset"
+	case InterruptNMI:
+		return "nmi"
+	case InterruptIRQ:
+		return "irq"
+	}
+	return "brk"
+}
+
+// HookID identifies a registered hook for Remove
+type HookID int
+
+type accessHook struct {
+	id         HookID
+	kind       AccessKind
+	start, end uint16
+	fn         AccessFunc
+}
+
+type chrHook struct {
+	id HookID
+	fn CHRFunc
+}
+
+type interceptHook struct {
+	id         HookID
+	start, end uint16
+	fn         InterceptFunc
+}
+
+type interruptHook struct {
+	id HookID
+	fn InterruptFunc
+}
+
+func (h accessHook) hookID() HookID    { return h.id }
+func (h chrHook) hookID() HookID       { return h.id }
+func (h interceptHook) hookID() HookID { return h.id }
+func (h interruptHook) hookID() HookID { return h.id }
+
+type Hooks struct {
+	cpu        *CPU
+	ppu        *PPU
+	nextID     HookID
+	access     []accessHook
+	registers  []accessHook
+	chr        []chrHook
+	intercepts []interceptHook
+	interrupts []interruptHook
+}
+
+func newHooks(cpu *CPU, ppu *PPU) *Hooks {
+	return &Hooks{cpu: cpu, ppu: ppu, nextID: 1}
+}
+
+// OnAccess calls fn for every access of the given kinds to an address in
+// [start, end]. kind may combine AccessRead, AccessWrite and AccessExec.
+// Reads include DMC sample fetches, AccessDMC alone watches only those.
+func (h *Hooks) OnAccess(kind AccessKind, start, end uint16, fn AccessFunc) HookID {
+	if end < start {
+		start, end = end, start
+	}
+	id := h.id()
+	// the lists are replaced, never changed in place, so that a callback
+	// can register or remove hooks while they are being walked
+	h.access = append(h.access[:len(h.access):len(h.access)], accessHook{id, kind, start, end, fn})
+	h.update()
+	return id
+}
+
+func (h *Hooks) OnRead(start, end uint16, fn AccessFunc) HookID {
+	return h.OnAccess(AccessRead, start, end, fn)
+}
+
+func (h *Hooks) OnWrite(start, end uint16, fn AccessFunc) HookID {
+	return h.OnAccess(AccessWrite, start, end, fn)
+}
+
+// OnExec calls fn before the instruction at an address in [start, end] runs
+func (h *Hooks) OnExec(start, end uint16, fn AccessFunc) HookID {
+	return h.OnAccess(AccessExec, start, end, fn)
+}
+
+// OnPPURegister calls fn for CPU reads and writes of the PPU registers.
+// Mirrors are folded, so the address is always $2000-$2007, or $4014 for
+// OAM DMA writes.
+func (h *Hooks) OnPPURegister(fn AccessFunc) HookID {
+	id := h.id()
+	h.registers = append(h.registers[:len(h.registers):len(h.registers)], accessHook{id: id, fn: fn})
+	h.update()
+	return id
+}
+
+func (h *Hooks) OnCHRRead(fn CHRFunc) HookID {
+	id := h.id()
+	h.chr = append(h.chr[:len(h.chr):len(h.chr)], chrHook{id, fn})
+	h.update()
+	return id
+}
+
+// Intercept passes every CPU read of an address in [start, end] through fn,
+// including opcode fetches, so fn can patch what the program sees. Read hooks
+// observe the patched value. Peek is not intercepted.
+func (h *Hooks) Intercept(start, end uint16, fn InterceptFunc) HookID {
+	if end < start {
+		start, end = end, start
+	}
+	id := h.id()
+	h.intercepts = append(h.intercepts[:len(h.intercepts):len(h.intercepts)], interceptHook{id, start, end, fn})
+	h.update()
+	return id
+}
+
+func (h *Hooks) OnInterrupt(fn InterruptFunc) HookID {
+	id := h.id()
+	h.interrupts = append(h.interrupts[:len(h.interrupts):len(h.interrupts)], interruptHook{id, fn})
+	h.update()
+	return id
+}
+
+// Remove unregisters a hook, it reports false if id is not registered
+func (h *Hooks) Remove(id HookID) bool {
+	var found [5]bool
+	h.access, found[0] = without(h.access, id)
+	h.registers, found[1] = without(h.registers, id)
+	h.chr, found[2] = without(h.chr, id)
+	h.intercepts, found[3] = without(h.intercepts, id)
+	h.interrupts, found[4] = without(h.interrupts, id)
+	h.update()
+	return found != [5]bool{}
+}
+
+// without returns a copy of hooks leaving out the one with id
+func without[T interface{ hookID() HookID }](hooks []T, id HookID) ([]T, bool) {
+	kept := make([]T, 0, len(hooks))
+	for _, hook := range hooks {
+		if hook.hookID() != id {
+			kept = append(kept, hook)
+		}
+	}
+	return kept, len(kept) != len(hooks)
+}
+
+func (h *Hooks) id() HookID {
+	id := h.nextID
+	h.nextID++
+	return id
+}
+
+// update points the CPU and PPU at the registry only while it has hooks,
+// keeping the fast paths down to a nil check
+func (h *Hooks) update() {
+	h.cpu.hooks, h.ppu.hooks = nil, nil
+	if len(h.access) > 0 || len(h.registers) > 0 || len(h.intercepts) > 0 || len(h.interrupts) > 0 {
+		h.cpu.hooks = h
+	}
+	if len(h.chr) > 0 {
+		h.ppu.hooks = h
+	}
+}
+
+// dispatch functions called by the CPU
+func (h *Hooks) onAccess(kind AccessKind, address uint16, value uint8) {
+	for _, hook := range h.access {
+		if hook.kind&kind != 0 && address >= hook.start && address <= hook.end {
+			hook.fn(kind, address, value)
+		}
+	}
+	if len(h.registers) == 0 || kind == AccessExec {
+		return
+	}
+	switch {
+	case address >= 0x2000 && address < 0x4000:
+		address = 0x2000 + address&0x0007
+	case address == 0x4014 && kind == AccessWrite:
+	default:
+		return
+	}
+	for _, hook := range h.registers {
+		hook.fn(kind, address, value)
+	}
+}
+
+func (h *Hooks) intercept(address uint16, value uint8) uint8 {
+	for _, hook := range h.intercepts {
+		if address >= hook.start && address <= hook.end {
+			value = hook.fn(address, value)
+		}
+	}
+	return value
+}
+
+func (h *Hooks) onCHRRead(address uint16, drawn bool) {
+	for _, hook := range h.chr {
+		hook.fn(address, drawn)
+	}
+}
+
+func (h *Hooks) onInterrupt(kind Interrupt) {
+	for _, hook := range h.interrupts {
+		hook.fn(kind)
+	}
+}
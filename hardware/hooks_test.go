@@ -0,0 +1,46 @@
+package hardware
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStackAccessHooks(t *testing.T) {
+	console := newNestest(t)
+	var log []string
+	console.Hooks().OnAccess(AccessRead|AccessWrite, 0x0100, 0x01FF, func(kind AccessKind, address uint16, value uint8) {
+		log = append(log, fmt.Sprintf("%v $%04X=$%02X", kind, address, value))
+	})
+	// JMP $C5F5 ... JSR $C72D at $C5FD, then on into the subroutine
+	for i := 0; i < 6; i++ {
+		console.Step()
+	}
+	want := []string{
+		fmt.Sprintf("%v $01FD=$C5", AccessWrite),
+		fmt.Sprintf("%v $01FC=$FF", AccessWrite),
+	}
+	if fmt.Sprint(log) != fmt.Sprint(want) {
+		t.Errorf("stack accesses %q, want %q", log, want)
+	}
+}
+
+func TestDMAReadHooks(t *testing.T) {
+	console := newNestest(t)
+	// LDA #$02; STA $4014 from RAM at $0300
+	for i, b := range []uint8{0xA9, 0x02, 0x8D, 0x14, 0x40} {
+		console.Poke(0x0300+uint16(i), b)
+	}
+	regs := console.cpu.Registers()
+	regs.PC = 0x0300
+	console.cpu.SetRegisters(regs)
+
+	reads := 0
+	console.Hooks().OnRead(0x0200, 0x02FF, func(kind AccessKind, address uint16, value uint8) {
+		reads++
+	})
+	console.Step()
+	console.Step()
+	if reads != 0x100 {
+		t.Errorf("OAM DMA made %d hooked reads of page 2, want 256", reads)
+	}
+}
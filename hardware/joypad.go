@@ -0,0 +1,53 @@
+package hardware
+
+// Standard controller https://www.nesdev.org/wiki/Standard_controller
+// buttons are reported serially in the order A, B, Select, Start, Up, Down, Left, Right
+type Buttons uint8
+
+const (
+	ButtonA Buttons = 1 << iota
+	ButtonB
+	ButtonSelect
+	ButtonStart
+	ButtonUp
+	ButtonDown
+	ButtonLeft
+	ButtonRight
+)
+
+type Joypad struct {
+	buttons Buttons // buttons currently held
+	strobe  bool    // while set the shift register keeps reloading
+	shift   uint8   // index of the next button to report
+}
+
+func NewJoypad() *Joypad {
+	return &Joypad{}
+}
+
+// SetButtons replaces the set of buttons currently held down
+func (j *Joypad) SetButtons(b Buttons) {
+	j.buttons = b
+}
+
+func (j *Joypad) Buttons() Buttons {
+	return j.buttons
+}
+
+func (j *Joypad) write(data uint8) {
+	j.strobe = data&1 == 1
+	if j.strobe {
+		j.shift = 0
+	}
+}
+
+func (j *Joypad) read() uint8 {
+	if j.shift > 7 {
+		return 1 // official controllers return 1 after all buttons are read
+	}
+	value := uint8(j.buttons>>j.shift) & 1
+	if !j.strobe {
+		j.shift++
+	}
+	return value
+}
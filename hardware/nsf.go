@@ -0,0 +1,339 @@
+package hardware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// NSF music rips https://www.nesdev.org/wiki/NSF and NSFe
+// https://www.nesdev.org/wiki/NSFe
+const NSF_HEADER_SIZE = 0x80
+const NSF_BANK_SIZE = 0x1000
+
+var (
+	nsfMagic  = []uint8("NESM\x1A")
+	nsfeMagic = []uint8("NSFE")
+)
+
+var ErrNotNSF = errors.New("not an NSF or NSFe file")
+
+// expansion sound chips, the bits of NSF.Chips
+const (
+	NSFChipVRC6 = 1 << iota
+	NSFChipVRC7
+	NSFChipFDS
+	NSFChipMMC5
+	NSFChipN163
+	NSFChipSunsoft5B
+)
+
+var nsfChipNames = []string{"VRC6", "VRC7", "FDS", "MMC5", "N163", "Sunsoft 5B"}
+
+// NTSC PLAY period used when a file leaves it out, one frame
+const nsfDefaultSpeed = 16639
+
+// NSF holds the tags and driver addresses of an NSF or NSFe file. Songs
+// count from 1.
+type NSF struct {
+	Songs     int
+	StartSong int
+
+	LoadAddress uint16
+	InitAddress uint16
+	PlayAddress uint16
+	PlaySpeed   uint16 // microseconds between PLAY calls on NTSC
+	PALSpeed    uint16
+	Region      uint8 // bit 0 PAL, bit 1 both
+	Chips       uint8 // NSFChip bits
+	Banks       [8]uint8
+	Data        []uint8
+
+	Title, Artist, Copyright, Ripper string
+
+	// NSFe only, nil when absent
+	TrackNames []string
+	TrackTimes []int // milliseconds, -1 when unknown
+	TrackFades []int // milliseconds, -1 for the player's default
+	Playlist   []int // songs counting from 1
+}
+
+// Banked reports whether the tune uses the $5FF8-$5FFF bank registers
+func (n *NSF) Banked() bool {
+	return n.Banks != [8]uint8{}
+}
+
+// ChipNames lists the expansion sound chips the tune uses
+func (n *NSF) ChipNames() []string {
+	var names []string
+	for i, name := range nsfChipNames {
+		if n.Chips&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// IsNSF reports whether data starts like an NSF or NSFe file
+func IsNSF(data []uint8) bool {
+	return bytes.HasPrefix(data, nsfMagic) || bytes.HasPrefix(data, nsfeMagic)
+}
+
+// ParseNSF builds a cartridge that maps the tune's code and data like an
+// NSF player does, with 8 KiB of RAM at $6000-$7FFF. The tags are returned
+// by Cartridge.NSF.
+func ParseNSF(data []uint8) (*Cartridge, error) {
+	var info *NSF
+	var err error
+	switch {
+	case bytes.HasPrefix(data, nsfMagic):
+		info, err = parseNSFHeader(data)
+	case bytes.HasPrefix(data, nsfeMagic):
+		info, err = parseNSFe(data)
+	default:
+		err = ErrNotNSF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.LoadAddress < 0x8000 {
+		return nil, fmt.Errorf("NSF load address $%04X is below $8000", info.LoadAddress)
+	}
+	if info.PlaySpeed == 0 {
+		info.PlaySpeed = nsfDefaultSpeed
+	}
+	if info.Songs == 0 {
+		return nil, errors.New("NSF has no songs")
+	}
+	if info.StartSong < 1 || info.StartSong > info.Songs {
+		return nil, fmt.Errorf("NSF starts at song %d of %d", info.StartSong, info.Songs)
+	}
+
+	// banked tunes are split into 4 KiB banks from the start of the bank
+	// holding the load address, others are placed at it in a 32 KiB image
+	var prg []uint8
+	if info.Banked() {
+		prg = make([]uint8, int(info.LoadAddress&0x0FFF)+len(info.Data))
+		copy(prg[info.LoadAddress&0x0FFF:], info.Data)
+		prg = append(prg, make([]uint8, (NSF_BANK_SIZE-len(prg)%NSF_BANK_SIZE)%NSF_BANK_SIZE)...)
+	} else {
+		prg = make([]uint8, 0x8000)
+		copy(prg[info.LoadAddress-0x8000:], info.Data)
+	}
+	cart := &Cartridge{
+		PRG:    prg,
+		CHR:    make([]uint8, CHR_BANK_SIZE),
+		chrRAM: true,
+		nsf:    info,
+	}
+	if err := cart.attachMapper(); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// cString reads a NUL padded string
+func cString(b []uint8) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func parseNSFHeader(data []uint8) (*NSF, error) {
+	if len(data) < NSF_HEADER_SIZE {
+		return nil, fmt.Errorf("truncated NSF header: %d bytes", len(data))
+	}
+	le := binary.LittleEndian
+	info := &NSF{
+		Songs:       int(data[0x06]),
+		StartSong:   int(data[0x07]),
+		LoadAddress: le.Uint16(data[0x08:]),
+		InitAddress: le.Uint16(data[0x0A:]),
+		PlayAddress: le.Uint16(data[0x0C:]),
+		Title:       cString(data[0x0E:0x2E]),
+		Artist:      cString(data[0x2E:0x4E]),
+		Copyright:   cString(data[0x4E:0x6E]),
+		PlaySpeed:   le.Uint16(data[0x6E:]),
+		Banks:       [8]uint8(data[0x70:0x78]),
+		PALSpeed:    le.Uint16(data[0x78:]),
+		Region:      data[0x7A] & 0x03,
+		Chips:       data[0x7B],
+		Data:        data[NSF_HEADER_SIZE:],
+	}
+	// NSF2 gives the length of the program data when metadata follows it
+	if data[0x05] >= 2 {
+		if length := int(data[0x7D]) | int(data[0x7E])<<8 | int(data[0x7F])<<16; length != 0 && length <= len(info.Data) {
+			info.Data = info.Data[:length]
+		}
+	}
+	return info, nil
+}
+
+func parseNSFe(data []uint8) (*NSF, error) {
+	info := &NSF{}
+	le := binary.LittleEndian
+	seenInfo, seenData := false, false
+	for pos := len(nsfeMagic); ; {
+		if pos+8 > len(data) {
+			return nil, errors.New("NSFe ends without an NEND chunk")
+		}
+		size := int(le.Uint32(data[pos:]))
+		id := string(data[pos+4 : pos+8])
+		pos += 8
+		if size < 0 || pos+size > len(data) {
+			return nil, fmt.Errorf("NSFe chunk %q is truncated", id)
+		}
+		chunk := data[pos : pos+size]
+		pos += size
+
+		switch id {
+		case "INFO":
+			if len(chunk) < 8 {
+				return nil, errors.New("NSFe INFO chunk is too short")
+			}
+			seenInfo = true
+			info.LoadAddress = le.Uint16(chunk[0:])
+			info.InitAddress = le.Uint16(chunk[2:])
+			info.PlayAddress = le.Uint16(chunk[4:])
+			info.Region = chunk[6] & 0x03
+			info.Chips = chunk[7]
+			info.Songs, info.StartSong = 1, 1
+			if len(chunk) > 8 {
+				info.Songs = int(chunk[8])
+			}
+			if len(chunk) > 9 {
+				info.StartSong = int(chunk[9]) + 1
+			}
+		case "DATA":
+			seenData = true
+			info.Data = chunk
+		case "BANK":
+			copy(info.Banks[:], chunk)
+		case "RATE":
+			if len(chunk) >= 2 {
+				info.PlaySpeed = le.Uint16(chunk)
+			}
+			if len(chunk) >= 4 {
+				info.PALSpeed = le.Uint16(chunk[2:])
+			}
+		case "auth":
+			fields := strings.Split(string(chunk), "\x00")
+			for i, field := range []*string{&info.Title, &info.Artist, &info.Copyright, &info.Ripper} {
+				if i < len(fields) {
+					*field = fields[i]
+				}
+			}
+		case "tlbl":
+			info.TrackNames = strings.Split(strings.TrimSuffix(string(chunk), "\x00"), "\x00")
+		case "time", "fade":
+			times := make([]int, len(chunk)/4)
+			for i := range times {
+				times[i] = int(int32(le.Uint32(chunk[i*4:])))
+			}
+			if id == "time" {
+				info.TrackTimes = times
+			} else {
+				info.TrackFades = times
+			}
+		case "plst":
+			for _, song := range chunk {
+				info.Playlist = append(info.Playlist, int(song)+1)
+			}
+		case "NEND":
+			if !seenInfo || !seenData {
+				return nil, errors.New("NSFe has no INFO or DATA chunk")
+			}
+			return info, nil
+		default:
+			// chunks starting with a capital letter can't be skipped
+			if id[0] >= 'A' && id[0] <= 'Z' {
+				return nil, fmt.Errorf("unsupported NSFe chunk %q", id)
+			}
+		}
+	}
+}
+
+// NSF returns the tags of an NSF cartridge, nil for other cartridges
+func (cart *Cartridge) NSF() *NSF {
+	return cart.nsf
+}
+
+// nsfMapper maps eight 4 KiB banks into $8000-$FFFF, switched by writes to
+// $5FF8-$5FFF
+type nsfMapper struct {
+	cart   *Cartridge
+	banks  [8]uint8
+	prgRAM [0x2000]uint8
+}
+
+func newNSFMapper(cart *Cartridge) *nsfMapper {
+	m := &nsfMapper{cart: cart, banks: cart.nsf.Banks}
+	if !cart.nsf.Banked() {
+		m.banks = [8]uint8{0, 1, 2, 3, 4, 5, 6, 7}
+	}
+	return m
+}
+
+func (m *nsfMapper) prgOffset(address uint16) int {
+	if address < 0x8000 {
+		return -1
+	}
+	bank := int(m.banks[(address-0x8000)/NSF_BANK_SIZE])
+	return (bank*NSF_BANK_SIZE)%len(m.cart.PRG) + int(address&0x0FFF)
+}
+
+func (m *nsfMapper) readPRG(address uint16) uint8 {
+	if address < 0x8000 {
+		return m.prgRAM[address-0x6000]
+	}
+	return m.cart.PRG[m.prgOffset(address)]
+}
+
+func (m *nsfMapper) writePRG(address uint16, data uint8) {
+	if address < 0x8000 {
+		m.prgRAM[address-0x6000] = data
+	}
+}
+
+func (m *nsfMapper) readCHR(address uint16) uint8 {
+	return m.cart.CHR[address]
+}
+
+func (m *nsfMapper) writeCHR(address uint16, data uint8) {
+	m.cart.CHR[address] = data
+}
+
+func (m *nsfMapper) chrOffset(address uint16) int {
+	return int(address & 0x1FFF)
+}
+
+func (m *nsfMapper) readRegister(address uint16) (uint8, bool) {
+	return 0, false
+}
+
+func (m *nsfMapper) writeRegister(address uint16, data uint8) {
+	if address >= 0x5FF8 && address <= 0x5FFF {
+		m.banks[address-0x5FF8] = data
+	}
+}
+
+func (m *nsfMapper) clock(cycles int) {}
+
+func (m *nsfMapper) irq() bool {
+	return false
+}
+
+func (m *nsfMapper) saveState(w *stateWriter) {
+	w.bytes(m.banks[:])
+	w.bytes(m.prgRAM[:])
+	w.bytes(m.cart.CHR)
+}
+
+func (m *nsfMapper) loadState(r *stateReader) {
+	r.bytes(m.banks[:])
+	r.bytes(m.prgRAM[:])
+	r.bytes(m.cart.CHR)
+}
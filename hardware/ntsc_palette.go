@@ -0,0 +1,105 @@
+package hardware
+
+import (
+	"image/color"
+	"math"
+)
+
+// NTSCParams control how GeneratePalette decodes the PPU's composite signal
+type NTSCParams struct {
+	Hue        float64 // phase shift in degrees
+	Saturation float64
+	Contrast   float64
+	Brightness float64
+	Gamma      float64 // display gamma the result is corrected for, the source is taken as 2.2
+}
+
+var DefaultNTSCParams = NTSCParams{Hue: 0, Saturation: 1.2, Contrast: 1, Brightness: 1, Gamma: 1.8}
+
+// signal voltages of the four luma levels, low and high half of the wave
+// https://www.nesdev.org/wiki/NTSC_video
+var (
+	ntscLow  = [4]float64{0.350, 0.518, 0.962, 1.550}
+	ntscHigh = [4]float64{1.094, 1.506, 1.962, 1.962}
+)
+
+const (
+	ntscBlack       = 0.518
+	ntscWhite       = 1.962
+	ntscAttenuation = 0.746
+)
+
+// GeneratePalette builds a palette, emphasis included, by sampling the
+// square wave the PPU outputs for each colour at the 12 phases of the colour
+// subcarrier and decoding it as YIQ
+func GeneratePalette(params NTSCParams) *Palette {
+	pal := &Palette{}
+	for entry := range pal {
+		var y, i, q float64
+		for phase := 0; phase < 12; phase++ {
+			v := params.Signal(uint8(entry), uint8(entry>>6), phase) / 12
+			angle := params.Angle(phase)
+			y += v
+			i += v * math.Cos(angle)
+			q += v * math.Sin(angle)
+		}
+		pal[entry] = params.RGB(y, i, q)
+	}
+	return pal
+}
+
+// Signal returns the PPU's video output for a colour index under emphasis
+// bits at one of the 12 phases of the colour subcarrier, scaled so black is
+// 0 and white is 1, with contrast and brightness applied
+// https://www.nesdev.org/wiki/NTSC_video
+func (params NTSCParams) Signal(index, emphasis uint8, phase int) float64 {
+	hue, level := int(index&0x0F), index>>4&0x03
+	low, high := ntscLow[level], ntscHigh[level]
+	switch {
+	case hue == 0x00:
+		low = high
+	case hue >= 0x0D:
+		high = low
+	}
+	// the wave is high for the half of the 12 phases matching the hue
+	inPhase := func(hue int) bool { return (hue+phase+8)%12 < 6 }
+
+	spot := low
+	if inPhase(hue) {
+		spot = high
+	}
+	// emphasis bits attenuate the signal during the phases of red, green and blue
+	if hue < 0x0E && (emphasis&1 != 0 && inPhase(0) ||
+		emphasis&2 != 0 && inPhase(4) ||
+		emphasis&4 != 0 && inPhase(8)) {
+		spot *= ntscAttenuation
+	}
+	v := (spot - ntscBlack) / (ntscWhite - ntscBlack)
+	return ((v-0.5)*params.Contrast + 0.5) * params.Brightness
+}
+
+// Angle is the subcarrier phase, in radians, a signal sample at phase 0-11
+// is demodulated against
+func (params NTSCParams) Angle(phase int) float64 {
+	return math.Pi / 6 * (float64(phase) + params.Hue/30)
+}
+
+// RGB converts demodulated YIQ to a colour, applying saturation and gamma
+func (params NTSCParams) RGB(y, i, q float64) color.RGBA {
+	i *= params.Saturation
+	q *= params.Saturation
+	return color.RGBA{
+		R: gammaByte(y+0.946882*i+0.623557*q, params.Gamma),
+		G: gammaByte(y-0.274788*i-0.635691*q, params.Gamma),
+		B: gammaByte(y-1.108545*i+1.709007*q, params.Gamma),
+		A: 0xFF,
+	}
+}
+
+func gammaByte(v, gamma float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	v = math.Pow(v, 2.2/gamma)
+	return uint8(math.Min(v*255, 255))
+}
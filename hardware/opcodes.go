@@ -0,0 +1,131 @@
+package hardware
+
+// Exported names of the addressing modes for tools outside the package such
+// as the disassembler and assembler
+const (
+	ModeImmediate      = modeImmediate
+	ModeZeroPage       = modeZeroPage
+	ModeAbsolute       = modeAbsolute
+	ModeZeroPageX      = modeZeroPageX
+	ModeZeroPageY      = modeZeroPageY
+	ModeAbsoluteX      = modeAbsoluteX
+	ModeAbsoluteY      = modeAbsoluteY
+	ModeIndirectX      = modeIndirectX
+	ModeIndirectY      = modeIndirectY
+	ModeRelative       = modeRelative
+	ModeAccumulator    = modeAccumulator
+	ModeIndirect       = modeIndirect
+	ModeNoneAddressing = modeNoneAddressing
+)
+
+// OperandSize returns the number of operand bytes following the opcode
+func (mode AddressingMode) OperandSize() int {
+	switch mode {
+	case modeAccumulator, modeNoneAddressing:
+		return 0
+	case modeAbsolute, modeAbsoluteX, modeAbsoluteY, modeIndirect:
+		return 2
+	}
+	return 1
+}
+
+type Opcode struct {
+	Mnemonic string
+	Mode     AddressingMode
+}
+
+// every official 6502 opcode, the CPU builds its dispatch from this table
+var opcodeTable = [256]Opcode{
+	0x69: {"ADC", modeImmediate}, 0x65: {"ADC", modeZeroPage}, 0x75: {"ADC", modeZeroPageX}, 0x6D: {"ADC", modeAbsolute},
+	0x7D: {"ADC", modeAbsoluteX}, 0x79: {"ADC", modeAbsoluteY}, 0x61: {"ADC", modeIndirectX}, 0x71: {"ADC", modeIndirectY},
+
+	0x29: {"AND", modeImmediate}, 0x25: {"AND", modeZeroPage}, 0x35: {"AND", modeZeroPageX}, 0x2D: {"AND", modeAbsolute},
+	0x3D: {"AND", modeAbsoluteX}, 0x39: {"AND", modeAbsoluteY}, 0x21: {"AND", modeIndirectX}, 0x31: {"AND", modeIndirectY},
+
+	0x0A: {"ASL", modeAccumulator}, 0x06: {"ASL", modeZeroPage}, 0x16: {"ASL", modeZeroPageX}, 0x0E: {"ASL", modeAbsolute},
+	0x1E: {"ASL", modeAbsoluteX},
+
+	0x90: {"BCC", modeRelative}, 0xB0: {"BCS", modeRelative}, 0xF0: {"BEQ", modeRelative}, 0x30: {"BMI", modeRelative},
+	0xD0: {"BNE", modeRelative}, 0x10: {"BPL", modeRelative}, 0x50: {"BVC", modeRelative}, 0x70: {"BVS", modeRelative},
+
+	0x24: {"BIT", modeZeroPage}, 0x2C: {"BIT", modeAbsolute},
+
+	0x00: {"BRK", modeNoneAddressing},
+	0x18: {"CLC", modeNoneAddressing}, 0xD8: {"CLD", modeNoneAddressing}, 0x58: {"CLI", modeNoneAddressing}, 0xB8: {"CLV", modeNoneAddressing},
+
+	0xC9: {"CMP", modeImmediate}, 0xC5: {"CMP", modeZeroPage}, 0xD5: {"CMP", modeZeroPageX}, 0xCD: {"CMP", modeAbsolute},
+	0xDD: {"CMP", modeAbsoluteX}, 0xD9: {"CMP", modeAbsoluteY}, 0xC1: {"CMP", modeIndirectX}, 0xD1: {"CMP", modeIndirectY},
+
+	0xE0: {"CPX", modeImmediate}, 0xE4: {"CPX", modeZeroPage}, 0xEC: {"CPX", modeAbsolute},
+	0xC0: {"CPY", modeImmediate}, 0xC4: {"CPY", modeZeroPage}, 0xCC: {"CPY", modeAbsolute},
+
+	0xC6: {"DEC", modeZeroPage}, 0xD6: {"DEC", modeZeroPageX}, 0xCE: {"DEC", modeAbsolute}, 0xDE: {"DEC", modeAbsoluteX},
+	0xCA: {"DEX", modeNoneAddressing}, 0x88: {"DEY", modeNoneAddressing},
+
+	0x49: {"EOR", modeImmediate}, 0x45: {"EOR", modeZeroPage}, 0x55: {"EOR", modeZeroPageX}, 0x4D: {"EOR", modeAbsolute},
+	0x5D: {"EOR", modeAbsoluteX}, 0x59: {"EOR", modeAbsoluteY}, 0x41: {"EOR", modeIndirectX}, 0x51: {"EOR", modeIndirectY},
+
+	0xE6: {"INC", modeZeroPage}, 0xF6: {"INC", modeZeroPageX}, 0xEE: {"INC", modeAbsolute}, 0xFE: {"INC", modeAbsoluteX},
+	0xE8: {"INX", modeNoneAddressing}, 0xC8: {"INY", modeNoneAddressing},
+
+	0x4C: {"JMP", modeAbsolute}, 0x6C: {"JMP", modeIndirect},
+	0x20: {"JSR", modeAbsolute},
+
+	0xA9: {"LDA", modeImmediate}, 0xA5: {"LDA", modeZeroPage}, 0xB5: {"LDA", modeZeroPageX}, 0xAD: {"LDA", modeAbsolute},
+	0xBD: {"LDA", modeAbsoluteX}, 0xB9: {"LDA", modeAbsoluteY}, 0xA1: {"LDA", modeIndirectX}, 0xB1: {"LDA", modeIndirectY},
+
+	0xA2: {"LDX", modeImmediate}, 0xA6: {"LDX", modeZeroPage}, 0xB6: {"LDX", modeZeroPageY}, 0xAE: {"LDX", modeAbsolute},
+	0xBE: {"LDX", modeAbsoluteY},
+
+	0xA0: {"LDY", modeImmediate}, 0xA4: {"LDY", modeZeroPage}, 0xB4: {"LDY", modeZeroPageX}, 0xAC: {"LDY", modeAbsolute},
+	0xBC: {"LDY", modeAbsoluteX},
+
+	0x4A: {"LSR", modeAccumulator}, 0x46: {"LSR", modeZeroPage}, 0x56: {"LSR", modeZeroPageX}, 0x4E: {"LSR", modeAbsolute},
+	0x5E: {"LSR", modeAbsoluteX},
+
+	0xEA: {"NOP", modeNoneAddressing},
+
+	0x09: {"ORA", modeImmediate}, 0x05: {"ORA", modeZeroPage}, 0x15: {"ORA", modeZeroPageX}, 0x0D: {"ORA", modeAbsolute},
+	0x1D: {"ORA", modeAbsoluteX}, 0x19: {"ORA", modeAbsoluteY}, 0x01: {"ORA", modeIndirectX}, 0x11: {"ORA", modeIndirectY},
+
+	0x48: {"PHA", modeNoneAddressing}, 0x08: {"PHP", modeNoneAddressing}, 0x68: {"PLA", modeNoneAddressing}, 0x28: {"PLP", modeNoneAddressing},
+
+	0x2A: {"ROL", modeAccumulator}, 0x26: {"ROL", modeZeroPage}, 0x36: {"ROL", modeZeroPageX}, 0x2E: {"ROL", modeAbsolute},
+	0x3E: {"ROL", modeAbsoluteX},
+
+	0x6A: {"ROR", modeAccumulator}, 0x66: {"ROR", modeZeroPage}, 0x76: {"ROR", modeZeroPageX}, 0x6E: {"ROR", modeAbsolute},
+	0x7E: {"ROR", modeAbsoluteX},
+
+	0x40: {"RTI", modeNoneAddressing}, 0x60: {"RTS", modeNoneAddressing},
+
+	0xE9: {"SBC", modeImmediate}, 0xE5: {"SBC", modeZeroPage}, 0xF5: {"SBC", modeZeroPageX}, 0xED: {"SBC", modeAbsolute},
+	0xFD: {"SBC", modeAbsoluteX}, 0xF9: {"SBC", modeAbsoluteY}, 0xE1: {"SBC", modeIndirectX}, 0xF1: {"SBC", modeIndirectY},
+
+	0x38: {"SEC", modeNoneAddressing}, 0xF8: {"SED", modeNoneAddressing}, 0x78: {"SEI", modeNoneAddressing},
+
+	0x85: {"STA", modeZeroPage}, 0x95: {"STA", modeZeroPageX}, 0x8D: {"STA", modeAbsolute}, 0x9D: {"STA", modeAbsoluteX},
+	0x99: {"STA", modeAbsoluteY}, 0x81: {"STA", modeIndirectX}, 0x91: {"STA", modeIndirectY},
+
+	0x86: {"STX", modeZeroPage}, 0x96: {"STX", modeZeroPageY}, 0x8E: {"STX", modeAbsolute},
+	0x84: {"STY", modeZeroPage}, 0x94: {"STY", modeZeroPageX}, 0x8C: {"STY", modeAbsolute},
+
+	0xAA: {"TAX", modeNoneAddressing}, 0xA8: {"TAY", modeNoneAddressing}, 0xBA: {"TSX", modeNoneAddressing},
+	0x8A: {"TXA", modeNoneAddressing}, 0x9A: {"TXS", modeNoneAddressing}, 0x98: {"TYA", modeNoneAddressing},
+}
+
+// LookupOpcode returns the mnemonic and addressing mode of an opcode, ok is
+// false for undocumented opcodes
+func LookupOpcode(opcode uint8) (op Opcode, ok bool) {
+	op = opcodeTable[opcode]
+	return op, op.Mnemonic != ""
+}
+
+// FindOpcode is the reverse of LookupOpcode
+func FindOpcode(mnemonic string, mode AddressingMode) (uint8, bool) {
+	for opcode := 0; opcode < 256; opcode++ {
+		if op := opcodeTable[opcode]; op.Mnemonic == mnemonic && op.Mode == mode {
+			return uint8(opcode), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,111 @@
+package hardware
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Palette maps the 64 colour indices produced by the PPU to RGB, once for
+// each combination of the PPUMASK emphasis bits: entry emphasis<<6 | index
+type Palette [512]color.RGBA
+
+// Color returns the RGB value of a colour index under emphasis bits 0-7,
+// PPUMASK >> 5
+func (pal *Palette) Color(index, emphasis uint8) color.RGBA {
+	return pal[uint16(emphasis&0x07)<<6|uint16(index&0x3F)]
+}
+
+func rgb(val uint32) color.RGBA {
+	return color.RGBA{R: uint8(val >> 16), G: uint8(val >> 8), B: uint8(val), A: 0xFF}
+}
+
+// DefaultPalette approximates the colours of an NTSC 2C02
+var DefaultPalette = withEmphasis([64]color.RGBA{
+	rgb(0x666666), rgb(0x002A88), rgb(0x1412A7), rgb(0x3B00A4), rgb(0x5C007E), rgb(0x6E0040), rgb(0x6C0600), rgb(0x561D00),
+	rgb(0x333500), rgb(0x0B4800), rgb(0x005200), rgb(0x004F08), rgb(0x00404D), rgb(0x000000), rgb(0x000000), rgb(0x000000),
+	rgb(0xADADAD), rgb(0x155FD9), rgb(0x4240FF), rgb(0x7527FE), rgb(0xA01ACC), rgb(0xB71E7B), rgb(0xB53120), rgb(0x994E00),
+	rgb(0x6B6D00), rgb(0x388700), rgb(0x0C9300), rgb(0x008F32), rgb(0x007C8D), rgb(0x000000), rgb(0x000000), rgb(0x000000),
+	rgb(0xFFFEFF), rgb(0x64B0FF), rgb(0x9290FF), rgb(0xC676FF), rgb(0xF36AFF), rgb(0xFE6ECC), rgb(0xFE8170), rgb(0xEA9E22),
+	rgb(0xBCBE00), rgb(0x88D800), rgb(0x5CE430), rgb(0x45E082), rgb(0x48CDDE), rgb(0x4F4F4F), rgb(0x000000), rgb(0x000000),
+	rgb(0xFFFEFF), rgb(0xC0DFFF), rgb(0xD3D2FF), rgb(0xE8C8FF), rgb(0xFBC2FF), rgb(0xFEC4EA), rgb(0xFECCC5), rgb(0xF7D8A5),
+	rgb(0xE4E594), rgb(0xCFEF96), rgb(0xBDF4AB), rgb(0xB3F3CC), rgb(0xB5EBF2), rgb(0xB8B8B8), rgb(0x000000), rgb(0x000000),
+})
+
+// emphasis darkens the colour channels that are not emphasised by this much
+const emphasisAttenuation = 0.746
+
+// withEmphasis derives the emphasised colours from the 64 base ones. Each
+// emphasis bit keeps its channel (red, green, blue for bits 0, 1, 2) and
+// attenuates the other two. The blacks in columns $E and $F are unaffected.
+func withEmphasis(base [64]color.RGBA) Palette {
+	var pal Palette
+	for emphasis := 0; emphasis < 8; emphasis++ {
+		factor := [3]float64{1, 1, 1}
+		for bit := 0; bit < 3; bit++ {
+			if emphasis&(1<<bit) == 0 {
+				continue
+			}
+			for channel := range factor {
+				if channel != bit {
+					factor[channel] *= emphasisAttenuation
+				}
+			}
+		}
+		for index, c := range base {
+			f := factor
+			if index&0x0F >= 0x0E {
+				f = [3]float64{1, 1, 1}
+			}
+			pal[emphasis<<6|index] = color.RGBA{
+				R: uint8(float64(c.R) * f[0]),
+				G: uint8(float64(c.G) * f[1]),
+				B: uint8(float64(c.B) * f[2]),
+				A: 0xFF,
+			}
+		}
+	}
+	return pal
+}
+
+// ParsePalette reads a .pal file: 64 RGB triples, or 512 that also cover
+// every combination of emphasis bits
+func ParsePalette(data []uint8) (*Palette, error) {
+	switch len(data) {
+	case 64 * 3:
+		var base [64]color.RGBA
+		for i := range base {
+			base[i] = color.RGBA{R: data[i*3], G: data[i*3+1], B: data[i*3+2], A: 0xFF}
+		}
+		pal := withEmphasis(base)
+		return &pal, nil
+	case 512 * 3:
+		pal := &Palette{}
+		for i := range pal {
+			pal[i] = color.RGBA{R: data[i*3], G: data[i*3+1], B: data[i*3+2], A: 0xFF}
+		}
+		return pal, nil
+	}
+	return nil, fmt.Errorf("palette is %d bytes, want 192 or 1536", len(data))
+}
+
+// Bytes encodes the palette as a 1536 byte .pal file
+func (pal *Palette) Bytes() []uint8 {
+	data := make([]uint8, 0, len(pal)*3)
+	for _, c := range pal {
+		data = append(data, c.R, c.G, c.B)
+	}
+	return data
+}
+
+// Image converts the frame buffer into a paletted image using the given
+// palette, without colour emphasis
+func (f *FrameBuffer) Image(pal *Palette) *image.Paletted {
+	colors := make(color.Palette, 64)
+	for i := range colors {
+		colors[i] = pal[i]
+	}
+	img := image.NewPaletted(image.Rect(0, 0, SCREEN_WIDTH, SCREEN_HEIGHT), colors)
+	copy(img.Pix, f[:])
+	return img
+}
@@ -0,0 +1,110 @@
+package hardware
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParsePalette(t *testing.T) {
+	var base []uint8
+	for _, c := range DefaultPalette[:64] {
+		base = append(base, c.R, c.G, c.B)
+	}
+	pal, err := ParsePalette(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a 64 colour file gets the same emphasis as the built in palette
+	if *pal != DefaultPalette {
+		t.Error("192 byte palette differs from the one it was taken from")
+	}
+	data := pal.Bytes()
+	if len(data) != 1536 {
+		t.Fatalf("Bytes is %d long, want 1536", len(data))
+	}
+	data[(3<<6|0x21)*3] = 0x12
+	full, err := ParsePalette(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := full.Color(0x21, 3); got.R != 0x12 || got.A != 0xFF {
+		t.Errorf("emphasised colour from a 1536 byte file is %v", got)
+	}
+	if full.Color(0x21, 0) != pal.Color(0x21, 0) {
+		t.Error("1536 byte file changed colours it didn't touch")
+	}
+	for _, size := range []int{0, 191, 193, 1535} {
+		if _, err := ParsePalette(make([]uint8, size)); err == nil {
+			t.Errorf("loaded a %d byte palette", size)
+		}
+	}
+}
+
+func TestPaletteEmphasis(t *testing.T) {
+	pal := &DefaultPalette
+	// only the low 6 bits of the index and 3 bits of emphasis count
+	if pal.Color(0x70, 0x09) != pal[1<<6|0x30] {
+		t.Error("Color doesn't mask its arguments")
+	}
+	white, red := pal.Color(0x30, 0), pal.Color(0x30, 1)
+	if red.R != white.R || red.G >= white.G || red.B >= white.B {
+		t.Errorf("red emphasis turns %v into %v", white, red)
+	}
+	for emphasis := uint8(1); emphasis < 8; emphasis++ {
+		if pal.Color(0x0F, emphasis) != pal.Color(0x0F, 0) {
+			t.Errorf("emphasis %d changes black", emphasis)
+		}
+	}
+}
+
+func TestGeneratePalette(t *testing.T) {
+	pal := GeneratePalette(DefaultNTSCParams)
+	if got := pal.Color(0x0F, 0); got != (color.RGBA{A: 0xFF}) {
+		t.Errorf("$0F is %v, want black", got)
+	}
+	if got := pal.Color(0x30, 0); apart(got.R, 0xFF) || apart(got.G, 0xFF) || apart(got.B, 0xFF) {
+		t.Errorf("$30 is %v, want white", got)
+	}
+	// the greys of column 0 carry no colour, and get lighter by row
+	for row, previous := uint8(0), uint8(0); row < 4; row++ {
+		c := pal.Color(row<<4, 0)
+		if apart(c.R, c.G) || apart(c.G, c.B) || c.R < previous {
+			t.Errorf("grey $%02X is %v", row<<4, c)
+		}
+		previous = c.R
+	}
+	hues := []struct {
+		index uint8
+		name  string
+		main  func(c color.RGBA) bool
+	}{
+		{0x12, "blue", func(c color.RGBA) bool { return c.B > c.R && c.B > c.G }},
+		{0x16, "red", func(c color.RGBA) bool { return c.R > c.G && c.R > c.B }},
+		{0x1A, "green", func(c color.RGBA) bool { return c.G > c.R && c.G > c.B }},
+	}
+	for _, h := range hues {
+		if c := pal.Color(h.index, 0); !h.main(c) {
+			t.Errorf("$%02X is %v, want %s", h.index, c, h.name)
+		}
+	}
+	// emphasis darkens and tints
+	if c := pal.Color(0x30, 1); c.R <= c.B || c.G == 0xFF {
+		t.Errorf("white under red emphasis is %v", c)
+	}
+
+	// 30 degrees of hue is one column of the palette
+	params := DefaultNTSCParams
+	params.Hue = 30
+	shifted := GeneratePalette(params)
+	for index := uint8(0x11); index < 0x1C; index++ {
+		got, want := shifted.Color(index+1, 0), pal.Color(index, 0)
+		if apart(got.R, want.R) || apart(got.G, want.G) || apart(got.B, want.B) {
+			t.Errorf("$%02X at a hue of 30 is %v, want $%02X's %v", index+1, got, index, want)
+		}
+	}
+}
+
+// apart reports whether a and b differ by more than rounding
+func apart(a, b uint8) bool {
+	return int(a)-int(b) > 1 || int(b)-int(a) > 1
+}
@@ -0,0 +1,499 @@
+package hardware
+
+// Picture Processing Unit https://www.nesdev.org/wiki/PPU
+// Rendering is done one scanline at a time rather than dot by dot, which is
+// enough for mid-frame scroll splits and sprite zero hits without modelling
+// the individual fetch pipeline.
+
+const SCREEN_WIDTH = 256
+const SCREEN_HEIGHT = 240
+
+const DOTS_PER_SCANLINE = 341
+const SCANLINES_PER_FRAME = 262
+const VBLANK_SCANLINE = 241
+const PRERENDER_SCANLINE = 261
+
+// FrameBuffer holds one picture as 6 bit palette indices, row by row
+type FrameBuffer [SCREEN_WIDTH * SCREEN_HEIGHT]uint8
+
+// PPUCTRL ($2000) bits
+const (
+	ctrlNametableX      = 1 << 0
+	ctrlNametableY      = 1 << 1
+	ctrlIncrement32     = 1 << 2
+	ctrlSpriteTable     = 1 << 3
+	ctrlBackgroundTable = 1 << 4
+	ctrlSprite8x16      = 1 << 5
+	ctrlMasterSlave     = 1 << 6
+	ctrlGenerateNMI     = 1 << 7
+)
+
+// PPUMASK ($2001) bits
+const (
+	maskGreyscale      = 1 << 0
+	maskLeftBackground = 1 << 1
+	maskLeftSprites    = 1 << 2
+	maskBackground     = 1 << 3
+	maskSprites        = 1 << 4
+	maskEmphasizeRed   = 1 << 5
+	maskEmphasizeGreen = 1 << 6
+	maskEmphasizeBlue  = 1 << 7
+)
+
+// PPUSTATUS ($2002) bits
+const (
+	statusSpriteOverflow = 1 << 5
+	statusSpriteZeroHit  = 1 << 6
+	statusVBlank         = 1 << 7
+)
+
+type PPU struct {
+	//registers
+	ctrl    uint8
+	mask    uint8
+	status  uint8
+	oamAddr uint8
+
+	//internal latches https://www.nesdev.org/wiki/PPU_scrolling
+	v          uint16 // current vram address
+	t          uint16 // temporary vram address
+	x          uint8  // fine x scroll
+	w          bool   // first/second write toggle
+	readBuffer uint8  // delayed $2007 read
+	openBus    uint8  // last value written to any register
+
+	//memory
+	vram    [0x1000]uint8 // nametables, sized for four screen boards
+	palette [32]uint8
+	oam     [256]uint8
+
+	cart  *Cartridge
+	hooks *Hooks // nil unless pattern table reads are hooked
+
+	//timing
+	scanline int
+	dot      int
+	frame    uint64
+	nmi      bool // NMI raised and not yet serviced by the CPU
+
+	buffer    FrameBuffer
+	emphasis  [SCREEN_HEIGHT]uint8 // PPUMASK emphasis bits each line was drawn with
+	phase     uint8                // colour subcarrier phase, 0-11, at dot 0 of the current line
+	linePhase [SCREEN_HEIGHT]uint8 // phase each line of the frame started at
+}
+
+func NewPPU(cart *Cartridge) *PPU {
+	return &PPU{cart: cart}
+}
+
+func (p *PPU) renderingEnabled() bool {
+	return p.mask&(maskBackground|maskSprites) != 0
+}
+
+// PPU memory map
+func (p *PPU) mirrorNametable(address uint16) uint16 {
+	address = (address - 0x2000) % 0x1000
+	table := address / 0x400
+	offset := address % 0x400
+	mirroring := MirrorHorizontal
+	if p.cart != nil {
+		mirroring = p.cart.Mirroring
+	}
+	switch mirroring {
+	case MirrorHorizontal:
+		table = table / 2
+	case MirrorVertical:
+		table = table % 2
+	case MirrorSingleLower:
+		table = 0
+	case MirrorSingleUpper:
+		table = 1
+	}
+	return table*0x400 + offset
+}
+
+func paletteIndex(address uint16) uint16 {
+	index := address % 32
+	// sprite backdrop entries mirror the background ones
+	if index >= 16 && index%4 == 0 {
+		index -= 16
+	}
+	return index
+}
+
+func (p *PPU) vram_read(address uint16) uint8 {
+	address &= 0x3FFF
+	switch {
+	case address < 0x2000:
+		if p.cart == nil {
+			return 0
+		}
+		return p.cart.mapper.readCHR(address)
+	case address < 0x3F00:
+		return p.vram[p.mirrorNametable(address)]
+	default:
+		return p.palette[paletteIndex(address)]
+	}
+}
+
+func (p *PPU) vram_write(address uint16, data uint8) {
+	address &= 0x3FFF
+	switch {
+	case address < 0x2000:
+		if p.cart != nil {
+			p.cart.mapper.writeCHR(address, data)
+		}
+	case address < 0x3F00:
+		p.vram[p.mirrorNametable(address)] = data
+	default:
+		p.palette[paletteIndex(address)] = data & 0x3F
+	}
+}
+
+func (p *PPU) incrementAddress() {
+	if p.ctrl&ctrlIncrement32 != 0 {
+		p.v += 32
+	} else {
+		p.v++
+	}
+}
+
+// CPU facing registers, address is already folded into $2000-$2007
+func (p *PPU) readRegister(address uint16) uint8 {
+	switch address {
+	case 0x2002:
+		value := (p.status & 0xE0) | (p.openBus & 0x1F)
+		p.status &^= statusVBlank
+		p.w = false
+		return value
+	case 0x2004:
+		return p.oam[p.oamAddr]
+	case 0x2007:
+		value := p.readBuffer
+		if p.hooks != nil && p.v&0x3FFF < 0x2000 {
+			p.hooks.onCHRRead(p.v&0x3FFF, false)
+		}
+		p.readBuffer = p.vram_read(p.v)
+		// palette reads are not delayed
+		if p.v&0x3FFF >= 0x3F00 {
+			value = p.readBuffer
+			p.readBuffer = p.vram_read(p.v - 0x1000)
+		}
+		p.incrementAddress()
+		return value
+	}
+	return p.openBus
+}
+
+func (p *PPU) writeRegister(address uint16, data uint8) {
+	p.openBus = data
+	switch address {
+	case 0x2000:
+		// enabling NMI in the middle of vblank fires one straight away
+		if p.ctrl&ctrlGenerateNMI == 0 && data&ctrlGenerateNMI != 0 && p.status&statusVBlank != 0 {
+			p.nmi = true
+		}
+		p.ctrl = data
+		p.t = (p.t & 0xF3FF) | (uint16(data&0x03) << 10)
+	case 0x2001:
+		p.mask = data
+	case 0x2003:
+		p.oamAddr = data
+	case 0x2004:
+		p.oam[p.oamAddr] = data
+		p.oamAddr++
+	case 0x2005:
+		if !p.w {
+			p.t = (p.t & 0xFFE0) | uint16(data>>3)
+			p.x = data & 0x07
+		} else {
+			p.t = (p.t & 0x8C1F) | (uint16(data&0x07) << 12) | (uint16(data&0xF8) << 2)
+		}
+		p.w = !p.w
+	case 0x2006:
+		if !p.w {
+			p.t = (p.t & 0x80FF) | (uint16(data&0x3F) << 8)
+		} else {
+			p.t = (p.t & 0xFF00) | uint16(data)
+			p.v = p.t
+		}
+		p.w = !p.w
+	case 0x2007:
+		p.vram_write(p.v, data)
+		p.incrementAddress()
+	}
+}
+
+// writeDMA copies a full page into OAM, as triggered by a write to $4014
+func (p *PPU) writeDMA(page []uint8) {
+	for _, val := range page {
+		p.oam[p.oamAddr] = val
+		p.oamAddr++
+	}
+}
+
+// scroll helpers operating on v, see the loopy register layout
+func (p *PPU) incrementY() {
+	if p.v&0x7000 != 0x7000 {
+		p.v += 0x1000
+		return
+	}
+	p.v &^= 0x7000
+	coarseY := (p.v & 0x03E0) >> 5
+	switch coarseY {
+	case 29:
+		coarseY = 0
+		p.v ^= 0x0800
+	case 31:
+		coarseY = 0
+	default:
+		coarseY++
+	}
+	p.v = (p.v &^ 0x03E0) | (coarseY << 5)
+}
+
+func (p *PPU) copyX() {
+	p.v = (p.v & 0xFBE0) | (p.t & 0x041F)
+}
+
+func (p *PPU) copyY() {
+	p.v = (p.v & 0x841F) | (p.t & 0x7BE0)
+}
+
+// tick advances the PPU by a single dot
+func (p *PPU) tick() {
+	rendering := p.renderingEnabled()
+
+	switch {
+	case p.scanline < SCREEN_HEIGHT:
+		if p.dot == 256 {
+			p.renderScanline()
+			if rendering {
+				p.incrementY()
+			}
+		}
+		if p.dot == 257 && rendering {
+			p.copyX()
+		}
+	case p.scanline == VBLANK_SCANLINE && p.dot == 1:
+		p.status |= statusVBlank
+		p.frame++
+		if p.ctrl&ctrlGenerateNMI != 0 {
+			p.nmi = true
+		}
+	case p.scanline == PRERENDER_SCANLINE:
+		if p.dot == 1 {
+			p.status &^= statusVBlank | statusSpriteZeroHit | statusSpriteOverflow
+		}
+		if rendering && p.dot == 257 {
+			p.copyX()
+		}
+		if rendering && p.dot >= 280 && p.dot <= 304 {
+			p.copyY()
+		}
+		// odd frames are one dot shorter while rendering
+		if rendering && p.dot == 339 && p.frame%2 == 1 {
+			p.dot = 340
+			p.phase = (p.phase + 4) % 12
+		}
+	}
+
+	p.dot++
+	if p.dot == DOTS_PER_SCANLINE {
+		// a dot lasts 8 of the 12 phases, so a line of 341 moves the phase by 4
+		p.dot = 0
+		p.phase = (p.phase + 4) % 12
+		p.scanline++
+		if p.scanline == SCANLINES_PER_FRAME {
+			p.scanline = 0
+		}
+	}
+}
+
+// step runs the PPU for the given number of CPU cycles, three dots each
+func (p *PPU) step(cpuCycles int) {
+	for i := 0; i < cpuCycles*3; i++ {
+		p.tick()
+	}
+}
+
+func (p *PPU) patternRow(table uint16, tile uint8, row uint16) (uint8, uint8) {
+	address := table + uint16(tile)*16 + row
+	if p.hooks != nil {
+		p.hooks.onCHRRead(address, true)
+		p.hooks.onCHRRead(address+8, true)
+	}
+	return p.vram_read(address), p.vram_read(address + 8)
+}
+
+// renderScanline draws the current scanline into the frame buffer
+func (p *PPU) renderScanline() {
+	y := p.scanline
+	var background [SCREEN_WIDTH]uint8 // 2 bit pattern value per pixel
+	var backgroundPalette [SCREEN_WIDTH]uint8
+
+	if p.mask&maskBackground != 0 {
+		nametable := (p.v >> 10) & 0x03
+		coarseX := p.v & 0x1F
+		coarseY := (p.v >> 5) & 0x1F
+		fineY := (p.v >> 12) & 0x07
+		table := uint16(0)
+		if p.ctrl&ctrlBackgroundTable != 0 {
+			table = 0x1000
+		}
+
+		// 33 tiles cover the visible line for any fine x
+		for i := 0; i < 33; i++ {
+			tileX := coarseX + uint16(i)
+			nt := nametable
+			if tileX >= 32 {
+				tileX -= 32
+				nt ^= 0x01
+			}
+			base := 0x2000 | (nt << 10)
+			tile := p.vram_read(base | (coarseY << 5) | tileX)
+			attribute := p.vram_read(base | 0x03C0 | ((coarseY >> 2) << 3) | (tileX >> 2))
+			shift := ((coarseY & 0x02) << 1) | (tileX & 0x02)
+			paletteHigh := (attribute >> shift) & 0x03
+			lo, hi := p.patternRow(table, tile, fineY)
+
+			for bit := 0; bit < 8; bit++ {
+				px := i*8 + bit - int(p.x)
+				if px < 0 || px >= SCREEN_WIDTH {
+					continue
+				}
+				value := (extractBit(hi, uint8(7-bit)) << 1) | extractBit(lo, uint8(7-bit))
+				background[px] = value
+				backgroundPalette[px] = paletteHigh
+			}
+		}
+		if p.mask&maskLeftBackground == 0 {
+			for px := 0; px < 8; px++ {
+				background[px] = 0
+			}
+		}
+	}
+
+	var sprite [SCREEN_WIDTH]uint8 // 2 bit pattern value per pixel
+	var spriteAttribute [SCREEN_WIDTH]uint8
+	var spriteZero [SCREEN_WIDTH]bool
+
+	if p.mask&maskSprites != 0 {
+		height := 8
+		if p.ctrl&ctrlSprite8x16 != 0 {
+			height = 16
+		}
+		count := 0
+		for i := 0; i < 64; i++ {
+			top := int(p.oam[i*4]) + 1
+			row := y - top
+			if row < 0 || row >= height {
+				continue
+			}
+			count++
+			if count > 8 {
+				p.status |= statusSpriteOverflow
+				break
+			}
+			tile := p.oam[i*4+1]
+			attribute := p.oam[i*4+2]
+			left := int(p.oam[i*4+3])
+
+			if attribute&0x80 != 0 {
+				row = height - 1 - row
+			}
+			table := uint16(0)
+			if height == 16 {
+				table = uint16(tile&0x01) * 0x1000
+				tile &= 0xFE
+				if row > 7 {
+					tile++
+					row -= 8
+				}
+			} else if p.ctrl&ctrlSpriteTable != 0 {
+				table = 0x1000
+			}
+			lo, hi := p.patternRow(table, tile, uint16(row))
+
+			for bit := 0; bit < 8; bit++ {
+				px := left + bit
+				if px >= SCREEN_WIDTH {
+					break
+				}
+				shift := uint8(7 - bit)
+				if attribute&0x40 != 0 {
+					shift = uint8(bit)
+				}
+				value := (extractBit(hi, shift) << 1) | extractBit(lo, shift)
+				// lower OAM indices win, so never overwrite an opaque pixel
+				if value == 0 || sprite[px] != 0 {
+					continue
+				}
+				sprite[px] = value
+				spriteAttribute[px] = attribute
+				spriteZero[px] = i == 0
+			}
+		}
+		if p.mask&maskLeftSprites == 0 {
+			for px := 0; px < 8; px++ {
+				sprite[px] = 0
+			}
+		}
+	}
+
+	// greyscale keeps only the luma column of each colour
+	colorMask := uint8(0x3F)
+	if p.mask&maskGreyscale != 0 {
+		colorMask = 0x30
+	}
+	p.emphasis[y] = p.mask >> 5
+	p.linePhase[y] = p.phase
+	row := p.buffer[y*SCREEN_WIDTH : (y+1)*SCREEN_WIDTH]
+	for px := 0; px < SCREEN_WIDTH; px++ {
+		bg := background[px]
+		sp := sprite[px]
+		if spriteZero[px] && bg != 0 && sp != 0 && px != 255 {
+			p.status |= statusSpriteZeroHit
+		}
+
+		var address uint16
+		switch {
+		case sp != 0 && (bg == 0 || spriteAttribute[px]&0x20 == 0):
+			address = 0x10 | uint16(spriteAttribute[px]&0x03)<<2 | uint16(sp)
+		case bg != 0:
+			address = uint16(backgroundPalette[px])<<2 | uint16(bg)
+		}
+		row[px] = p.palette[paletteIndex(address)] & colorMask
+	}
+}
+
+// Frame returns the most recently completed picture
+func (p *PPU) Frame() *FrameBuffer {
+	return &p.buffer
+}
+
+// Emphasis returns the PPUMASK colour emphasis bits, 0-7, that line y of
+// the frame was drawn with
+func (p *PPU) Emphasis(y int) uint8 {
+	return p.emphasis[y]
+}
+
+// Phase returns the colour subcarrier phase, 0-11, at the start of line y
+// of the frame. The pixel at x begins at phase+8*(x+1).
+func (p *PPU) Phase(y int) uint8 {
+	return p.linePhase[y]
+}
+
+// FrameCount is the number of frames that have entered vblank since power on
+func (p *PPU) FrameCount() uint64 {
+	return p.frame
+}
+
+func (p *PPU) reset() {
+	p.ctrl = 0
+	p.mask = 0
+	p.w = false
+	p.readBuffer = 0
+	p.scanline = 0
+	p.dot = 0
+}
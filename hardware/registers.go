@@ -0,0 +1,108 @@
+package hardware
+
+import "fmt"
+
+// Registers is a copy of the CPU registers, as returned by CPU.Registers.
+// Changing it has no effect on the CPU until it is passed to SetRegisters.
+type Registers struct {
+	A  uint8
+	X  uint8
+	Y  uint8
+	P  uint8 // status, bit n holds the flag whose Flags value is n
+	SP uint8 // offset into the stack page at STACK_START
+	PC uint16
+}
+
+// Flag reports whether a status flag is set
+func (r Registers) Flag(flag Flags) bool {
+	return r.P&(1<<flag) != 0
+}
+
+// SetFlag sets or clears a status flag
+func (r *Registers) SetFlag(flag Flags, on bool) {
+	if on {
+		r.P |= 1 << flag
+	} else {
+		r.P &^= 1 << flag
+	}
+}
+
+// String formats the registers the way nestest logs do
+func (r Registers) String() string {
+	return fmt.Sprintf("A:%02X X:%02X Y:%02X P:%02X SP:%02X PC:%04X", r.A, r.X, r.Y, r.P, r.SP, r.PC)
+}
+
+// Registers returns a copy of the CPU registers
+func (c *CPU) Registers() Registers {
+	return Registers{
+		A:  c.accumulator,
+		X:  c.index_x,
+		Y:  c.index_y,
+		P:  c.status,
+		SP: c.stack_pointer,
+		PC: c.program_counter,
+	}
+}
+
+// SetRegisters replaces every CPU register, the usual pattern being
+//
+//	regs := cpu.Registers()
+//	regs.SetFlag(hardware.C, true)
+//	cpu.SetRegisters(regs)
+func (c *CPU) SetRegisters(r Registers) {
+	c.accumulator = r.A
+	c.index_x = r.X
+	c.index_y = r.Y
+	c.status = r.P
+	c.stack_pointer = r.SP
+	c.program_counter = r.PC
+}
+
+// Flag reports whether a status flag is set
+func (c *CPU) Flag(flag Flags) bool {
+	return c.getFlagValue(flag) != 0
+}
+
+// Cycles returns the number of cycles executed since power on
+func (c *CPU) Cycles() uint64 {
+	return c.cycles
+}
+
+// Peek reads CPU address space without side effects: no register latches
+// change, no debugger watchpoints fire. With devices attached their memory
+// mapped registers ($2000-$401F) read back as 0, as does everything else
+// below $6000 outside internal RAM when a cartridge is attached.
+func (c *CPU) Peek(address uint16) uint8 {
+	switch {
+	case address < 0x2000:
+		return c.memory[address&0x07FF]
+	case address >= 0x2000 && address < 0x4020 && c.ppu != nil:
+		return 0
+	case address >= 0x6000 && c.cart != nil:
+		return c.cart.mapper.readPRG(address)
+	case c.cart != nil:
+		return 0
+	}
+	return c.memory[address]
+}
+
+// Poke writes CPU address space without side effects. Writes to memory
+// mapped registers, unmapped addresses and cartridge ROM are ignored,
+// cartridge RAM at $6000-$7FFF is written.
+func (c *CPU) Poke(address uint16, value uint8) {
+	switch {
+	case address < 0x2000:
+		c.memory[address&0x07FF] = value
+		return
+	case address >= 0x2000 && address < 0x4020 && c.ppu != nil:
+		return
+	case address >= 0x8000 && c.cart != nil:
+		return
+	case address >= 0x6000 && c.cart != nil:
+		c.cart.mapper.writePRG(address, value)
+		return
+	case c.cart != nil:
+		return
+	}
+	c.memory[address] = value
+}
@@ -0,0 +1,252 @@
+package hardware
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Rewind keeps a history of machine states in memory. Snapshots are grouped
+// behind a keyframe: the keyframe is stored whole and every following snapshot
+// is stored as its XOR against the keyframe. Consecutive frames differ in few
+// bytes, so the XOR is mostly zeros and a zero run length encoding shrinks it
+// to a small fraction of the raw state at very little cost per frame.
+
+type RewindConfig struct {
+	Interval         int // frames between snapshots
+	KeyframeInterval int // snapshots stored against each keyframe
+	Budget           int // bytes of compressed history to keep
+}
+
+var DefaultRewindConfig = RewindConfig{
+	Interval:         1,
+	KeyframeInterval: 60,
+	Budget:           32 << 20,
+}
+
+type rewindGroup struct {
+	keyframe []uint8   // encoded against zeros
+	deltas   [][]uint8 // encoded against the raw keyframe
+	frames   []int     // frame of the keyframe followed by each delta's
+	size     int
+}
+
+type Rewinder struct {
+	console *Console
+	config  RewindConfig
+
+	groups  []*rewindGroup // oldest first
+	size    int
+	frames  int     // frame being shown, counting frames seen by Push
+	lastKey []uint8 // raw keyframe of the newest group
+
+	current      []uint8 // raw state being rewound from, already taken out of the history
+	currentFrame int     // frame current was taken after
+}
+
+func NewRewinder(console *Console, config RewindConfig) *Rewinder {
+	if config.Interval < 1 {
+		config.Interval = 1
+	}
+	if config.KeyframeInterval < 1 {
+		config.KeyframeInterval = 1
+	}
+	return &Rewinder{console: console, config: config}
+}
+
+// Len returns the number of snapshots held
+func (r *Rewinder) Len() int {
+	count := 0
+	for _, g := range r.groups {
+		count += 1 + len(g.deltas)
+	}
+	if r.current != nil {
+		count++
+	}
+	return count
+}
+
+// Size returns the number of bytes of history held
+func (r *Rewinder) Size() int {
+	return r.size
+}
+
+// Push records the machine state, call it once after every emulated frame
+func (r *Rewinder) Push() {
+	// rewinding stopped, the snapshot it was working from goes back
+	if r.current != nil {
+		r.store(r.current, r.currentFrame)
+		r.current = nil
+	}
+	r.frames++
+	if r.frames%r.config.Interval != 0 {
+		return
+	}
+	r.store(r.console.snapshot(), r.frames)
+}
+
+// store appends a snapshot taken after frame to the history
+func (r *Rewinder) store(raw []uint8, frame int) {
+	newest := len(r.groups) - 1
+	if newest < 0 || len(r.groups[newest].deltas)+1 >= r.config.KeyframeInterval || len(raw) != len(r.lastKey) {
+		g := &rewindGroup{keyframe: encodeDelta(raw, nil), frames: []int{frame}}
+		g.size = len(g.keyframe)
+		r.groups = append(r.groups, g)
+		r.size += g.size
+		r.lastKey = raw
+	} else {
+		g := r.groups[newest]
+		delta := encodeDelta(raw, r.lastKey)
+		g.deltas = append(g.deltas, delta)
+		g.frames = append(g.frames, frame)
+		g.size += len(delta)
+		r.size += len(delta)
+	}
+
+	// the newest group always stays so there is something to go back to
+	for r.size > r.config.Budget && len(r.groups) > 1 {
+		r.size -= r.groups[0].size
+		r.groups[0] = nil
+		r.groups = r.groups[1:]
+	}
+}
+
+// pop removes the newest snapshot and returns it decoded with its frame,
+// nil once the history is empty
+func (r *Rewinder) pop() ([]uint8, int, error) {
+	newest := len(r.groups) - 1
+	if newest < 0 {
+		return nil, 0, nil
+	}
+	g := r.groups[newest]
+	key := r.lastKey
+	frame := g.frames[len(g.frames)-1]
+	g.frames = g.frames[:len(g.frames)-1]
+	if count := len(g.deltas); count > 0 {
+		delta := g.deltas[count-1]
+		g.deltas = g.deltas[:count-1]
+		g.size -= len(delta)
+		r.size -= len(delta)
+		state, err := decodeDelta(delta, key)
+		return state, frame, err
+	}
+
+	r.size -= g.size
+	r.groups = r.groups[:newest]
+	r.lastKey = nil
+	if newest > 0 {
+		var err error
+		if r.lastKey, err = decodeDelta(r.groups[newest-1].keyframe, nil); err != nil {
+			return nil, 0, err
+		}
+	}
+	return key, frame, nil
+}
+
+// StepBack moves one frame back in time, call it once per displayed frame
+// while the rewind key is held. The machine is restored to the newest
+// snapshot from before the frame being shown and run forward to it, so with
+// an Interval above one each displayed frame costs up to Interval frames of
+// emulation. It returns false once the history is exhausted.
+func (r *Rewinder) StepBack() bool {
+	target := r.frames - 1
+	// the frame buffer isn't part of the state, so the snapshot has to be
+	// from at least one frame earlier to redraw the target
+	for r.current == nil || r.currentFrame >= target {
+		state, frame, err := r.pop()
+		if err != nil {
+			r.current = nil
+			return false
+		}
+		if state == nil {
+			return false
+		}
+		r.current, r.currentFrame = state, frame
+	}
+	if err := r.console.restore(r.current); err != nil {
+		return false
+	}
+	for i := r.currentFrame; i < target; i++ {
+		r.console.StepFrame()
+	}
+	r.frames = target
+	return true
+}
+
+// Clear drops the whole history, e.g. after loading a save state
+func (r *Rewinder) Clear() {
+	r.groups = nil
+	r.size = 0
+	r.lastKey = nil
+	r.current = nil
+	r.currentFrame = 0
+}
+
+// encodeDelta XORs state against base (zeros when base is nil) and run length
+// encodes the result as pairs of (zero run, literal run) lengths, each literal
+// run followed by its bytes
+func encodeDelta(state []uint8, base []uint8) []uint8 {
+	out := make([]uint8, 0, 256)
+	at := func(i int) uint8 {
+		if base == nil {
+			return state[i]
+		}
+		return state[i] ^ base[i]
+	}
+	for i := 0; i < len(state); {
+		zeros := i
+		for i < len(state) && at(i) == 0 {
+			i++
+		}
+		start := i
+		// a literal run ends at the first pair of zeros, single zeros are cheaper inline
+		for i < len(state) && !(at(i) == 0 && (i+1 == len(state) || at(i+1) == 0)) {
+			i++
+		}
+		out = binary.AppendUvarint(out, uint64(start-zeros))
+		out = binary.AppendUvarint(out, uint64(i-start))
+		for j := start; j < i; j++ {
+			out = append(out, at(j))
+		}
+	}
+	return out
+}
+
+var errCorruptDelta = errors.New("corrupt rewind delta")
+
+// decodeDelta reverses encodeDelta
+func decodeDelta(delta []uint8, base []uint8) ([]uint8, error) {
+	state := make([]uint8, 0, len(base))
+	for len(delta) > 0 {
+		zeros, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, errCorruptDelta
+		}
+		delta = delta[n:]
+		literals, n := binary.Uvarint(delta)
+		if n <= 0 || uint64(len(delta)-n) < literals {
+			return nil, errCorruptDelta
+		}
+		delta = delta[n:]
+		// runs past the end of base would grow state without bound
+		if base != nil {
+			room := uint64(len(base) - len(state))
+			if zeros > room || literals > room-zeros {
+				return nil, errCorruptDelta
+			}
+		}
+		for i := uint64(0); i < zeros; i++ {
+			state = append(state, 0)
+		}
+		state = append(state, delta[:literals]...)
+		delta = delta[literals:]
+	}
+	if base != nil {
+		if len(state) != len(base) {
+			return nil, errCorruptDelta
+		}
+		for i := range state {
+			state[i] ^= base[i]
+		}
+	}
+	return state, nil
+}
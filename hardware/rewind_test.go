@@ -0,0 +1,59 @@
+package hardware
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRewindStepBack(t *testing.T) {
+	for _, config := range []RewindConfig{
+		{Interval: 1, KeyframeInterval: 4, Budget: 1 << 30},
+		{Interval: 3, KeyframeInterval: 4, Budget: 1 << 30},
+	} {
+		console := newNestest(t)
+		rewinder := NewRewinder(console, config)
+		states := []uint64{0} // state after each frame, frame 1 first
+		for frame := 1; frame <= 40; frame++ {
+			console.StepFrame()
+			rewinder.Push()
+			states = append(states, console.StateHash())
+		}
+
+		// the oldest snapshot is after the first full interval, the frame
+		// after it is the earliest that can be redrawn
+		first := config.Interval + 1
+		for frame := 39; frame >= first; frame-- {
+			if !rewinder.StepBack() {
+				t.Fatalf("interval %d: history ran out before frame %d", config.Interval, frame)
+			}
+			if got := console.StateHash(); got != states[frame] {
+				t.Fatalf("interval %d: stepped back to the wrong state for frame %d", config.Interval, frame)
+			}
+		}
+		if rewinder.StepBack() {
+			t.Errorf("interval %d: stepped back past frame %d", config.Interval, first)
+		}
+
+		// after letting go, time runs forward from where the rewind stopped
+		console.StepFrame()
+		rewinder.Push()
+		if !rewinder.StepBack() || console.StateHash() != states[first] {
+			t.Errorf("interval %d: rewinding after resuming didn't return to frame %d", config.Interval, first)
+		}
+	}
+}
+
+func TestDecodeCorruptDelta(t *testing.T) {
+	base := []uint8{1, 2, 3, 4}
+	delta := encodeDelta([]uint8{1, 2, 0, 4}, base)
+	if state, err := decodeDelta(delta, base); err != nil || state[2] != 0 {
+		t.Fatalf("decodeDelta = %v, %v", state, err)
+	}
+	// a zero run of 2^35 bytes has to fail before it is written out
+	huge := []uint8{0x80, 0x80, 0x80, 0x80, 0x80, 0x01, 0}
+	for _, bad := range [][]uint8{{0x80}, {0, 5, 1}, {5, 0}, {2, 3, 1, 2, 3}, huge, encodeDelta([]uint8{1, 2, 3, 4, 5}, nil)} {
+		if _, err := decodeDelta(bad, base); !errors.Is(err, errCorruptDelta) {
+			t.Errorf("decodeDelta(% x) error %v, want errCorruptDelta", bad, err)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package hardware
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Save state file layout, all integers little endian
+//
+//	magic       [4]uint8  "NESS"
+//	version     uint16
+//	reserved    uint16
+//	rom hash    [20]uint8 SHA-1 of PRG-ROM and CHR-ROM
+//	payload len uint32
+//	checksum    uint32    CRC-32 of the payload
+//	payload     CPU, RAM, PPU, APU, mapper and controller state in that order
+const STATE_VERSION uint16 = 5
+
+var stateMagic = [4]uint8{'N', 'E', 'S', 'S'}
+
+const stateHeaderSize = 4 + 2 + 2 + sha1.Size + 4 + 4
+
+var (
+	ErrNotSaveState     = errors.New("not a save state")
+	ErrStateChecksum    = errors.New("save state checksum mismatch")
+	ErrStateROMMismatch = errors.New("save state was made with a different ROM")
+)
+
+// stateWriter and stateReader serialize fields in a fixed order
+type stateWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *stateWriter) u8(val uint8) { w.buf.WriteByte(val) }
+func (w *stateWriter) u16(val uint16) {
+	w.buf.Write(binary.LittleEndian.AppendUint16(nil, val))
+}
+func (w *stateWriter) u64(val uint64) {
+	w.buf.Write(binary.LittleEndian.AppendUint64(nil, val))
+}
+func (w *stateWriter) bool(val bool) {
+	if val {
+		w.u8(1)
+	} else {
+		w.u8(0)
+	}
+}
+func (w *stateWriter) bytes(val []uint8) { w.buf.Write(val) }
+
+type stateReader struct {
+	data []uint8
+	err  error // sticky, set once the payload runs out
+}
+
+func (r *stateReader) next(n int) []uint8 {
+	if r.err != nil {
+		return make([]uint8, n)
+	}
+	if len(r.data) < n {
+		r.err = io.ErrUnexpectedEOF
+		return make([]uint8, n)
+	}
+	val := r.data[:n]
+	r.data = r.data[n:]
+	return val
+}
+
+func (r *stateReader) u8() uint8         { return r.next(1)[0] }
+func (r *stateReader) u16() uint16       { return binary.LittleEndian.Uint16(r.next(2)) }
+func (r *stateReader) u64() uint64       { return binary.LittleEndian.Uint64(r.next(8)) }
+func (r *stateReader) bool() bool        { return r.u8() != 0 }
+func (r *stateReader) bytes(dst []uint8) { copy(dst, r.next(len(dst))) }
+
+// Hash identifies the ROM contents of a cartridge, CHR-RAM is not included.
+// For the FDS it covers the BIOS and the disk's info blocks, so states stay
+// valid after the game saves to the disk.
+func (cart *Cartridge) Hash() [sha1.Size]uint8 {
+	h := sha1.New()
+	h.Write(cart.PRG)
+	if !cart.chrRAM {
+		h.Write(cart.CHR)
+	}
+	if cart.disk != nil {
+		h.Write(cart.disk.id[:])
+	}
+	var sum [sha1.Size]uint8
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+func (c *CPU) saveState(w *stateWriter) {
+	w.u8(c.accumulator)
+	w.u8(c.index_x)
+	w.u8(c.index_y)
+	w.u8(c.status)
+	w.u16(c.program_counter)
+	w.u8(c.stack_pointer)
+	w.u64(c.cycles)
+	w.u64(uint64(c.stall))
+	w.u8(c.open_bus)
+	var halt HaltError
+	if c.halt != nil {
+		halt = *c.halt
+	}
+	w.bool(c.halt != nil)
+	w.u8(halt.Opcode)
+	w.u16(halt.Address)
+	// with a cartridge attached the only RAM on the CPU bus is the internal
+	// 2 KiB, the rest belongs to the devices saved after the CPU
+	if c.cart != nil {
+		w.bytes(c.memory[:0x800])
+	} else {
+		w.bytes(c.memory)
+	}
+}
+
+func (c *CPU) loadState(r *stateReader) {
+	c.accumulator = r.u8()
+	c.index_x = r.u8()
+	c.index_y = r.u8()
+	c.status = r.u8()
+	c.program_counter = r.u16()
+	c.stack_pointer = r.u8()
+	c.cycles = r.u64()
+	c.stall = int(r.u64())
+	c.open_bus = r.u8()
+	halted := r.bool()
+	halt := HaltError{Opcode: r.u8(), Address: r.u16()}
+	c.halt = nil
+	if halted {
+		c.halt = &halt
+	}
+	if c.cart != nil {
+		r.bytes(c.memory[:0x800])
+	} else {
+		r.bytes(c.memory)
+	}
+}
+
+func (p *PPU) saveState(w *stateWriter) {
+	w.u8(p.ctrl)
+	w.u8(p.mask)
+	w.u8(p.status)
+	w.u8(p.oamAddr)
+	w.u16(p.v)
+	w.u16(p.t)
+	w.u8(p.x)
+	w.bool(p.w)
+	w.u8(p.readBuffer)
+	w.u8(p.openBus)
+	w.bytes(p.vram[:])
+	w.bytes(p.palette[:])
+	w.bytes(p.oam[:])
+	w.u16(uint16(p.scanline))
+	w.u16(uint16(p.dot))
+	w.u64(p.frame)
+	w.bool(p.nmi)
+	w.u8(p.phase)
+}
+
+func (p *PPU) loadState(r *stateReader) {
+	p.ctrl = r.u8()
+	p.mask = r.u8()
+	p.status = r.u8()
+	p.oamAddr = r.u8()
+	p.v = r.u16()
+	p.t = r.u16()
+	p.x = r.u8()
+	p.w = r.bool()
+	p.readBuffer = r.u8()
+	p.openBus = r.u8()
+	r.bytes(p.vram[:])
+	r.bytes(p.palette[:])
+	r.bytes(p.oam[:])
+	p.scanline = int(r.u16())
+	p.dot = int(r.u16())
+	p.frame = r.u64()
+	p.nmi = r.bool()
+	p.phase = r.u8()
+}
+
+func (e *envelope) saveState(w *stateWriter) {
+	w.bool(e.start)
+	w.bool(e.loop)
+	w.bool(e.constant)
+	w.u8(e.period)
+	w.u8(e.divider)
+	w.u8(e.decay)
+}
+
+func (e *envelope) loadState(r *stateReader) {
+	e.start = r.bool()
+	e.loop = r.bool()
+	e.constant = r.bool()
+	e.period = r.u8()
+	e.divider = r.u8()
+	e.decay = r.u8()
+}
+
+// the sample output is not machine state and is left alone
+func (a *APU) saveState(w *stateWriter) {
+	for i := range a.pulse {
+		p := &a.pulse[i]
+		p.envelope.saveState(w)
+		w.bool(p.enabled)
+		w.u8(p.duty)
+		w.u8(p.step)
+		w.u16(p.timer)
+		w.u16(p.period)
+		w.u8(p.length)
+		w.bool(p.sweepEnabled)
+		w.u8(p.sweepPeriod)
+		w.bool(p.sweepNegate)
+		w.u8(p.sweepShift)
+		w.bool(p.sweepReload)
+		w.u8(p.sweepDivider)
+	}
+
+	t := &a.triangle
+	w.bool(t.enabled)
+	w.bool(t.control)
+	w.u8(t.linearPeriod)
+	w.u8(t.linear)
+	w.bool(t.linearReload)
+	w.u16(t.timer)
+	w.u16(t.period)
+	w.u8(t.step)
+	w.u8(t.length)
+
+	n := &a.noise
+	n.envelope.saveState(w)
+	w.bool(n.enabled)
+	w.bool(n.short)
+	w.u16(n.timer)
+	w.u16(n.period)
+	w.u16(n.shift)
+	w.u8(n.length)
+
+	d := &a.dmc
+	w.bool(d.irqEnabled)
+	w.bool(d.loop)
+	w.bool(d.irq)
+	w.u16(d.timer)
+	w.u16(d.period)
+	w.u8(d.level)
+	w.u16(d.sampleAddress)
+	w.u16(d.sampleLength)
+	w.u16(d.address)
+	w.u16(d.remaining)
+	w.u8(d.buffer)
+	w.bool(d.bufferFull)
+	w.u8(d.shift)
+	w.u8(d.bitsLeft)
+	w.bool(d.silent)
+
+	w.bool(a.fiveStep)
+	w.bool(a.irqInhibit)
+	w.bool(a.frameIRQ)
+	w.u16(uint16(a.frameCycle))
+	w.bool(a.odd)
+}
+
+func (a *APU) loadState(r *stateReader) {
+	for i := range a.pulse {
+		p := &a.pulse[i]
+		p.envelope.loadState(r)
+		p.enabled = r.bool()
+		p.duty = r.u8()
+		p.step = r.u8()
+		p.timer = r.u16()
+		p.period = r.u16()
+		p.length = r.u8()
+		p.sweepEnabled = r.bool()
+		p.sweepPeriod = r.u8()
+		p.sweepNegate = r.bool()
+		p.sweepShift = r.u8()
+		p.sweepReload = r.bool()
+		p.sweepDivider = r.u8()
+	}
+
+	t := &a.triangle
+	t.enabled = r.bool()
+	t.control = r.bool()
+	t.linearPeriod = r.u8()
+	t.linear = r.u8()
+	t.linearReload = r.bool()
+	t.timer = r.u16()
+	t.period = r.u16()
+	t.step = r.u8()
+	t.length = r.u8()
+
+	n := &a.noise
+	n.envelope.loadState(r)
+	n.enabled = r.bool()
+	n.short = r.bool()
+	n.timer = r.u16()
+	n.period = r.u16()
+	n.shift = r.u16()
+	n.length = r.u8()
+
+	d := &a.dmc
+	d.irqEnabled = r.bool()
+	d.loop = r.bool()
+	d.irq = r.bool()
+	d.timer = r.u16()
+	d.period = r.u16()
+	d.level = r.u8()
+	d.sampleAddress = r.u16()
+	d.sampleLength = r.u16()
+	d.address = r.u16()
+	d.remaining = r.u16()
+	d.buffer = r.u8()
+	d.bufferFull = r.bool()
+	d.shift = r.u8()
+	d.bitsLeft = r.u8()
+	d.silent = r.bool()
+
+	a.fiveStep = r.bool()
+	a.irqInhibit = r.bool()
+	a.frameIRQ = r.bool()
+	a.frameCycle = int(r.u16())
+	a.odd = r.bool()
+}
+
+func (j *Joypad) saveState(w *stateWriter) {
+	w.u8(uint8(j.buttons))
+	w.bool(j.strobe)
+	w.u8(j.shift)
+}
+
+func (j *Joypad) loadState(r *stateReader) {
+	j.buttons = Buttons(r.u8())
+	j.strobe = r.bool()
+	j.shift = r.u8()
+}
+
+func (m *nrom) saveState(w *stateWriter) {
+	w.bytes(m.prgRAM[:])
+	if m.cart.chrRAM {
+		w.bytes(m.cart.CHR)
+	}
+}
+
+func (m *nrom) loadState(r *stateReader) {
+	r.bytes(m.prgRAM[:])
+	if m.cart.chrRAM {
+		r.bytes(m.cart.CHR)
+	}
+}
+
+// snapshot serializes the machine without the file header
+func (n *Console) snapshot() []uint8 {
+	var w stateWriter
+	n.cpu.saveState(&w)
+	n.ppu.saveState(&w)
+	n.apu.saveState(&w)
+	n.cart.mapper.saveState(&w)
+	for _, j := range n.joypads {
+		j.saveState(&w)
+	}
+	return w.buf.Bytes()
+}
+
+func (n *Console) restore(payload []uint8) error {
+	r := stateReader{data: payload}
+	n.cpu.loadState(&r)
+	n.ppu.loadState(&r)
+	n.apu.loadState(&r)
+	n.cart.mapper.loadState(&r)
+	for _, j := range n.joypads {
+		j.loadState(&r)
+	}
+	if r.err == nil && len(r.data) != 0 {
+		return fmt.Errorf("save state has %d trailing bytes", len(r.data))
+	}
+	return r.err
+}
+
+// SaveState writes the complete machine state to w
+func (n *Console) SaveState(w io.Writer) error {
+	payload := n.snapshot()
+	hash := n.cart.Hash()
+
+	header := make([]uint8, 0, stateHeaderSize)
+	header = append(header, stateMagic[:]...)
+	header = binary.LittleEndian.AppendUint16(header, STATE_VERSION)
+	header = binary.LittleEndian.AppendUint16(header, 0)
+	header = append(header, hash[:]...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(payload)))
+	header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(payload))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// LoadState restores a state written by SaveState. The machine is left
+// untouched if the state is damaged or belongs to another ROM.
+func (n *Console) LoadState(r io.Reader) error {
+	header := make([]uint8, stateHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return ErrNotSaveState
+	}
+	if [4]uint8(header[0:4]) != stateMagic {
+		return ErrNotSaveState
+	}
+	if version := binary.LittleEndian.Uint16(header[4:6]); version != STATE_VERSION {
+		return fmt.Errorf("unsupported save state version %d", version)
+	}
+	if [sha1.Size]uint8(header[8:28]) != n.cart.Hash() {
+		return ErrStateROMMismatch
+	}
+	length := binary.LittleEndian.Uint32(header[28:32])
+	checksum := binary.LittleEndian.Uint32(header[32:36])
+
+	// the layout is fixed per version, so the payload must match our own size.
+	// The backup also means a malformed payload can't leave a half loaded machine
+	backup := n.snapshot()
+	if int(length) != len(backup) {
+		return fmt.Errorf("save state payload is %d bytes, want %d", length, len(backup))
+	}
+	payload := make([]uint8, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return fmt.Errorf("reading save state: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return ErrStateChecksum
+	}
+	if err := n.restore(payload); err != nil {
+		n.restore(backup)
+		return err
+	}
+	return nil
+}
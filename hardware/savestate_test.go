@@ -0,0 +1,90 @@
+package hardware
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	console := newNestest(t)
+	for i := 0; i < 3; i++ {
+		console.StepFrame()
+	}
+	var state bytes.Buffer
+	if err := console.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	saved := console.StateHash()
+	// the CPU's part is its registers and the 2 KiB of internal RAM, not
+	// the whole address space below the cartridge
+	if size := state.Len() - stateHeaderSize; size >= 0x6000 {
+		t.Errorf("save state payload is %d bytes", size)
+	}
+
+	for i := 0; i < 3; i++ {
+		console.StepFrame()
+	}
+	if err := console.LoadState(bytes.NewReader(state.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if console.StateHash() != saved {
+		t.Error("loading the state didn't restore the machine")
+	}
+}
+
+func TestSaveStateHalt(t *testing.T) {
+	console := newNestest(t)
+	for console.cpu.Halted() == nil {
+		console.Step()
+	}
+	var state bytes.Buffer
+	if err := console.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	console.Reset()
+	if err := console.LoadState(&state); err != nil {
+		t.Fatal(err)
+	}
+	var halt *HaltError
+	if !errors.As(console.cpu.Halted(), &halt) || halt.Address != nestestFirstUnofficial {
+		t.Errorf("Halted() = %v after loading a halted state", console.cpu.Halted())
+	}
+}
+
+func TestLoadStateRejects(t *testing.T) {
+	console := newNestest(t)
+	var buf bytes.Buffer
+	if err := console.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	state := buf.Bytes()
+	before := console.StateHash()
+
+	corrupt := func(offset int) []uint8 {
+		out := bytes.Clone(state)
+		out[offset] ^= 0xFF
+		return out
+	}
+	tests := []struct {
+		name  string
+		state []uint8
+		want  error
+	}{
+		{"magic", corrupt(0), ErrNotSaveState},
+		{"short", state[:10], ErrNotSaveState},
+		{"rom", corrupt(8), ErrStateROMMismatch},
+		{"payload", corrupt(stateHeaderSize + 20), ErrStateChecksum},
+	}
+	for _, tt := range tests {
+		if err := console.LoadState(bytes.NewReader(tt.state)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if err := console.LoadState(bytes.NewReader(corrupt(4))); err == nil {
+		t.Error("loaded a state with another version")
+	}
+	if console.StateHash() != before {
+		t.Error("a rejected state changed the machine")
+	}
+}
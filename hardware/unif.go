@@ -0,0 +1,140 @@
+package hardware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UNIF images https://www.nesdev.org/wiki/UNIF name the board instead of
+// giving an iNES mapper number
+const UNIF_HEADER_SIZE = 32
+
+var unifMagic = []uint8("UNIF")
+
+var ErrNotUNIF = errors.New("not a UNIF image")
+
+// unifBoards maps board names, without the NES-, HVC- or UNL- prefix, to the
+// iNES mapper that emulates them
+var unifBoards = map[string]uint8{
+	"NROM": 0, "NROM-128": 0, "NROM-256": 0, "RROM": 0, "RROM-128": 0,
+
+	"SAROM": 1, "SBROM": 1, "SCROM": 1, "SEROM": 1, "SFROM": 1, "SGROM": 1,
+	"SHROM": 1, "SJROM": 1, "SKROM": 1, "SLROM": 1, "SL1ROM": 1, "SNROM": 1,
+	"SOROM": 1, "SUROM": 1, "SXROM": 1,
+
+	"UNROM": 2, "UOROM": 2, "UXROM": 2,
+	"CNROM": 3,
+
+	"TBROM": 4, "TEROM": 4, "TFROM": 4, "TGROM": 4, "TKROM": 4, "TLROM": 4,
+	"TL1ROM": 4, "TR1ROM": 4, "TSROM": 4, "TVROM": 4, "HKROM": 4,
+	"TLSROM": 118, "TKSROM": 118, "TQROM": 119,
+
+	"EKROM": 5, "ELROM": 5, "ETROM": 5, "EWROM": 5,
+	"AMROM": 7, "ANROM": 7, "AN1ROM": 7, "AOROM": 7,
+	"PNROM": 9, "PEEOROM": 9,
+	"FJROM": 10, "FKROM": 10,
+	"CPROM": 13,
+	"BNROM": 34,
+	"GNROM": 66, "MHROM": 66,
+}
+
+// unifMapper looks up a board, first by its full name and then without the
+// manufacturer prefix
+func unifMapper(board string) (uint8, bool) {
+	if id, ok := unifBoards[board]; ok {
+		return id, true
+	}
+	if _, name, found := strings.Cut(board, "-"); found {
+		id, ok := unifBoards[name]
+		return id, ok
+	}
+	return 0, false
+}
+
+// IsUNIF reports whether data starts like a UNIF image
+func IsUNIF(data []uint8) bool {
+	return bytes.HasPrefix(data, unifMagic)
+}
+
+// ParseUNIF builds a cartridge from the contents of a .unf file. PRG0-PRGF
+// and CHR0-CHRF are joined in order, a missing CHR means CHR-RAM.
+func ParseUNIF(data []uint8) (*Cartridge, error) {
+	return parseUNIF(data, nil)
+}
+
+func parseUNIF(data []uint8, fix func(*Cartridge)) (*Cartridge, error) {
+	if len(data) < UNIF_HEADER_SIZE || !IsUNIF(data) {
+		return nil, ErrNotUNIF
+	}
+	var board, name string
+	var prg, chr [16][]uint8
+	mirroring := MirrorHorizontal
+	battery := false
+
+	le := binary.LittleEndian
+	for pos := UNIF_HEADER_SIZE; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, errors.New("truncated UNIF chunk header")
+		}
+		id := string(data[pos : pos+4])
+		size := int(le.Uint32(data[pos+4:]))
+		pos += 8
+		if size < 0 || pos+size > len(data) {
+			return nil, fmt.Errorf("UNIF chunk %q is truncated", id)
+		}
+		chunk := data[pos : pos+size]
+		pos += size
+
+		switch {
+		case id == "MAPR":
+			board = cString(chunk)
+		case id == "NAME":
+			name = cString(chunk)
+		case id == "MIRR" && len(chunk) > 0:
+			switch chunk[0] {
+			case 1:
+				mirroring = MirrorVertical
+			case 2:
+				mirroring = MirrorSingleLower
+			case 3:
+				mirroring = MirrorSingleUpper
+			case 4:
+				mirroring = MirrorFourScreen
+			}
+		case id == "BATR":
+			battery = true
+		case strings.HasPrefix(id, "PRG"), strings.HasPrefix(id, "CHR"):
+			// PRG0-PRGF and CHR0-CHRF, other IDs like PCK0 hold checksums
+			n, err := strconv.ParseUint(id[3:], 16, 4)
+			if err != nil {
+				continue
+			}
+			if id[0] == 'P' {
+				prg[n] = chunk
+			} else {
+				chr[n] = chunk
+			}
+		}
+	}
+
+	if board == "" {
+		return nil, errors.New("UNIF image has no MAPR chunk")
+	}
+	mapperID, ok := unifMapper(board)
+	if !ok {
+		return nil, fmt.Errorf("unknown UNIF board %q", board)
+	}
+	cart, err := newCartridge(bytes.Join(prg[:], nil), bytes.Join(chr[:], nil), mapperID, mirroring, battery)
+	if err != nil {
+		return nil, err
+	}
+	cart.Board, cart.Title = board, name
+	if err := cart.attach(fix); err != nil {
+		return nil, fmt.Errorf("UNIF board %s: %w", board, err)
+	}
+	return cart, nil
+}
@@ -0,0 +1,116 @@
+package hardware
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func unifChunk(id string, data []uint8) []uint8 {
+	chunk := append([]uint8(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	return append(chunk, data...)
+}
+
+// unifImage is a revision 7 header followed by chunks
+func unifImage(chunks ...[]uint8) []uint8 {
+	image := make([]uint8, UNIF_HEADER_SIZE)
+	copy(image, unifMagic)
+	image[4] = 7
+	for _, chunk := range chunks {
+		image = append(image, chunk...)
+	}
+	return image
+}
+
+// unifBank is a bank of size bytes, all holding fill
+func unifBank(size int, fill uint8) []uint8 {
+	bank := make([]uint8, size)
+	for i := range bank {
+		bank[i] = fill
+	}
+	return bank
+}
+
+func TestParseUNIF(t *testing.T) {
+	image := unifImage(
+		unifChunk("MAPR", []uint8("NES-NROM-256\x00")),
+		unifChunk("NAME", []uint8("Test\x00")),
+		// banks are joined by number, not by the order of the chunks
+		unifChunk("PRG1", unifBank(PRG_BANK_SIZE, 0x11)),
+		unifChunk("PRG0", unifBank(PRG_BANK_SIZE, 0x10)),
+		unifChunk("PCK0", []uint8{1, 2, 3, 4}),
+		unifChunk("CHR0", unifBank(CHR_BANK_SIZE, 0x20)),
+		unifChunk("MIRR", []uint8{1}),
+		unifChunk("BATR", []uint8{1}),
+		unifChunk("DINF", make([]uint8, 204)),
+	)
+	if !IsUNIF(image) {
+		t.Fatal("IsUNIF is false")
+	}
+	cart, err := ParseROM(image, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.MapperID != 0 || cart.Board != "NES-NROM-256" || cart.Title != "Test" {
+		t.Errorf("mapper %d, board %q, title %q, want 0, NES-NROM-256 and Test", cart.MapperID, cart.Board, cart.Title)
+	}
+	if len(cart.PRG) != 2*PRG_BANK_SIZE || cart.PRG[0] != 0x10 || cart.PRG[PRG_BANK_SIZE] != 0x11 {
+		t.Errorf("PRG %#x bytes starting $%02X, $%02X at bank 1", len(cart.PRG), cart.PRG[0], cart.PRG[PRG_BANK_SIZE])
+	}
+	if len(cart.CHR) != CHR_BANK_SIZE || cart.CHR[0] != 0x20 || cart.chrRAM {
+		t.Errorf("CHR %#x bytes starting $%02X, CHR-RAM %v", len(cart.CHR), cart.CHR[0], cart.chrRAM)
+	}
+	if cart.Mirroring != MirrorVertical || !cart.Battery {
+		t.Errorf("mirroring %v, battery %v, want vertical and a battery", cart.Mirroring, cart.Battery)
+	}
+
+	// a bare board name, horizontal mirroring and CHR-RAM by default
+	cart, err = ParseUNIF(unifImage(
+		unifChunk("MAPR", []uint8("NROM")),
+		unifChunk("PRG0", unifBank(PRG_BANK_SIZE, 0)),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.MapperID != 0 || cart.Mirroring != MirrorHorizontal || cart.Battery || !cart.chrRAM {
+		t.Errorf("mapper %d, mirroring %v, battery %v, CHR-RAM %v", cart.MapperID, cart.Mirroring, cart.Battery, cart.chrRAM)
+	}
+}
+
+func TestParseUNIFRejects(t *testing.T) {
+	mapr := unifChunk("MAPR", []uint8("NES-NROM-128"))
+	prg := unifChunk("PRG0", unifBank(PRG_BANK_SIZE, 0))
+	oversized := unifChunk("PRG0", nil)
+	binary.LittleEndian.PutUint32(oversized[4:], 0xFFFFFFFF)
+	good := unifImage(mapr, prg)
+
+	notUNIF := map[string][]uint8{
+		"empty":        nil,
+		"short header": good[:UNIF_HEADER_SIZE-1],
+		"iNES":         {'N', 'E', 'S', 0x1A, 31: 0},
+	}
+	for name, image := range notUNIF {
+		if _, err := ParseUNIF(image); !errors.Is(err, ErrNotUNIF) {
+			t.Errorf("%s: error %v, want ErrNotUNIF", name, err)
+		}
+	}
+
+	tests := map[string][]uint8{
+		"truncated chunk header": append(unifImage(mapr, prg), 'C', 'H', 'R', '0', 0),
+		"chunk past the end":     good[:len(good)-1],
+		"oversized chunk":        unifImage(mapr, prg, oversized),
+		"no MAPR":                unifImage(prg),
+		"unknown board":          unifImage(unifChunk("MAPR", []uint8("NES-ZZROM")), prg),
+		"unemulated board":       unifImage(unifChunk("MAPR", []uint8("NES-UNROM")), prg),
+		"no PRG":                 unifImage(mapr),
+	}
+	for name, image := range tests {
+		if _, err := ParseUNIF(image); err == nil || errors.Is(err, ErrNotUNIF) {
+			t.Errorf("%s: error %v", name, err)
+		}
+	}
+	if _, err := ParseUNIF(good); err != nil {
+		t.Errorf("the images above broke a good one: %v", err)
+	}
+}
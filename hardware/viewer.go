@@ -0,0 +1,189 @@
+package hardware
+
+import (
+	"image"
+	"image/color"
+)
+
+// Debug views of PPU memory. They read pattern tables, nametables, OAM and
+// palette RAM without side effects, so they can be drawn at any point.
+
+// Sprite is one OAM entry
+type Sprite struct {
+	Index      int
+	X, Y       uint8 // Y as stored, the sprite starts on line Y+1
+	Tile       uint8
+	Attributes uint8
+}
+
+func (s Sprite) Palette() int { return int(s.Attributes & 0x03) }
+func (s Sprite) Behind() bool { return s.Attributes&0x20 != 0 }
+func (s Sprite) FlipH() bool  { return s.Attributes&0x40 != 0 }
+func (s Sprite) FlipV() bool  { return s.Attributes&0x80 != 0 }
+
+// Sprites returns the 64 OAM entries
+func (n *Console) Sprites() [64]Sprite {
+	var sprites [64]Sprite
+	for i := range sprites {
+		entry := n.ppu.oam[i*4 : i*4+4]
+		sprites[i] = Sprite{Index: i, Y: entry[0], Tile: entry[1], Attributes: entry[2], X: entry[3]}
+	}
+	return sprites
+}
+
+// SpriteHeight is 8, or 16 when PPUCTRL selects 8x16 sprites
+func (n *Console) SpriteHeight() int {
+	if n.ppu.ctrl&ctrlSprite8x16 != 0 {
+		return 16
+	}
+	return 8
+}
+
+// PaletteRAM returns the 32 palette entries, background first
+func (n *Console) PaletteRAM() [32]uint8 {
+	var ram [32]uint8
+	for i := range ram {
+		ram[i] = n.ppu.palette[paletteIndex(uint16(i))] & 0x3F
+	}
+	return ram
+}
+
+// colour of a 2 bit pattern value in one of the eight palettes, 0-3 for the
+// background and 4-7 for sprites. Colour 0 is the backdrop in every palette,
+// as when rendering.
+func (p *PPU) viewColor(pal *Palette, palette int, value uint8) color.RGBA {
+	address := uint16(0)
+	if value != 0 {
+		address = uint16(palette&0x07)<<2 | uint16(value)
+	}
+	return pal[p.palette[paletteIndex(address)]&0x3F]
+}
+
+// drawTile draws an 8x8 tile of a pattern table with its top left at x, y
+func (p *PPU) drawTile(img *image.RGBA, x, y int, table uint16, tile uint8, pal *Palette, palette int, flipH, flipV bool) {
+	for row := 0; row < 8; row++ {
+		address := table + uint16(tile)*16 + uint16(row)
+		lo, hi := p.vram_read(address), p.vram_read(address+8)
+		py := y + row
+		if flipV {
+			py = y + 7 - row
+		}
+		for bit := 0; bit < 8; bit++ {
+			value := (extractBit(hi, uint8(7-bit)) << 1) | extractBit(lo, uint8(7-bit))
+			px := x + bit
+			if flipH {
+				px = x + 7 - bit
+			}
+			img.SetRGBA(px, py, p.viewColor(pal, palette, value))
+		}
+	}
+}
+
+// PatternTables draws both 128x128 pattern tables side by side, coloured
+// with one of the eight palettes
+func (n *Console) PatternTables(pal *Palette, palette int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 128))
+	for table := 0; table < 2; table++ {
+		for tile := 0; tile < 256; tile++ {
+			x, y := table*128+tile%16*8, tile/16*8
+			n.ppu.drawTile(img, x, y, uint16(table)*0x1000, uint8(tile), pal, palette, false, false)
+		}
+	}
+	return img
+}
+
+// ScrollOverlay is the colour Nametables outlines the visible screen in
+var ScrollOverlay = color.RGBA{R: 0xFF, G: 0x20, B: 0xFF, A: 0xFF}
+
+// Nametables draws the four nametables as a 512x480 map, as mirroring lays
+// them out, with the screen at the current scroll outlined
+func (n *Console) Nametables(pal *Palette) *image.RGBA {
+	p := n.ppu
+	img := image.NewRGBA(image.Rect(0, 0, 512, 480))
+	table := uint16(0)
+	if p.ctrl&ctrlBackgroundTable != 0 {
+		table = 0x1000
+	}
+	for nt := uint16(0); nt < 4; nt++ {
+		base := 0x2000 | nt<<10
+		for coarseY := uint16(0); coarseY < 30; coarseY++ {
+			for coarseX := uint16(0); coarseX < 32; coarseX++ {
+				tile := p.vram_read(base | coarseY<<5 | coarseX)
+				attribute := p.vram_read(base | 0x03C0 | (coarseY>>2)<<3 | coarseX>>2)
+				shift := (coarseY&0x02)<<1 | coarseX&0x02
+				x := int(nt&1)*256 + int(coarseX)*8
+				y := int(nt>>1)*240 + int(coarseY)*8
+				p.drawTile(img, x, y, table, tile, pal, int(attribute>>shift&0x03), false, false)
+			}
+		}
+	}
+
+	// the scroll position rendering starts from is held in t during vblank
+	left := int(p.t>>10&1)*256 + int(p.t&0x1F)*8 + int(p.x)
+	top := int(p.t>>11&1)*240 + int(p.t>>5&0x1F)*8 + int(p.t>>12&0x07)
+	for i := 0; i < SCREEN_WIDTH; i++ {
+		img.SetRGBA((left+i)%512, top%480, ScrollOverlay)
+		img.SetRGBA((left+i)%512, (top+SCREEN_HEIGHT-1)%480, ScrollOverlay)
+	}
+	for i := 0; i < SCREEN_HEIGHT; i++ {
+		img.SetRGBA(left%512, (top+i)%480, ScrollOverlay)
+		img.SetRGBA((left+SCREEN_WIDTH-1)%512, (top+i)%480, ScrollOverlay)
+	}
+	return img
+}
+
+// SpriteImage draws an OAM entry as the PPU would, 8 pixels wide and 8 or
+// 16 high. Transparent pixels show the backdrop colour.
+func (n *Console) SpriteImage(pal *Palette, s Sprite) *image.RGBA {
+	p := n.ppu
+	height := n.SpriteHeight()
+	img := image.NewRGBA(image.Rect(0, 0, 8, height))
+	palette := 4 + s.Palette()
+	if height == 8 {
+		table := uint16(0)
+		if p.ctrl&ctrlSpriteTable != 0 {
+			table = 0x1000
+		}
+		p.drawTile(img, 0, 0, table, s.Tile, pal, palette, s.FlipH(), s.FlipV())
+		return img
+	}
+	table := uint16(s.Tile&0x01) * 0x1000
+	top, bottom := 0, 8
+	if s.FlipV() {
+		top, bottom = 8, 0
+	}
+	p.drawTile(img, 0, top, table, s.Tile&0xFE, pal, palette, s.FlipH(), s.FlipV())
+	p.drawTile(img, 0, bottom, table, s.Tile|0x01, pal, palette, s.FlipH(), s.FlipV())
+	return img
+}
+
+// OAMImage draws all 64 sprites in an 8x8 grid in OAM order, each in a 10x18
+// cell
+func (n *Console) OAMImage(pal *Palette) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 8*10, 8*18))
+	for _, s := range n.Sprites() {
+		thumb := n.SpriteImage(pal, s)
+		x, y := s.Index%8*10+1, s.Index/8*18+1
+		for row := 0; row < thumb.Rect.Dy(); row++ {
+			for col := 0; col < 8; col++ {
+				img.SetRGBA(x+col, y+row, thumb.RGBAAt(col, row))
+			}
+		}
+	}
+	return img
+}
+
+// PaletteImage draws the 32 palette entries as 16x16 swatches, background
+// palettes on the top row and sprite palettes below
+func (n *Console) PaletteImage(pal *Palette) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16*16, 2*16))
+	for i, index := range n.PaletteRAM() {
+		col := pal[index]
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				img.SetRGBA(i%16*16+x, i/16*16+y, col)
+			}
+		}
+	}
+	return img
+}
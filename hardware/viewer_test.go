@@ -0,0 +1,139 @@
+package hardware
+
+import (
+	"image/color"
+	"testing"
+)
+
+// newViewerConsole has a few known tiles in CHR-ROM and distinct colours in
+// every palette entry. Tile 1 of the left table has a row each of colour 1,
+// 2 and 3, tile 1 of the right table one pixel of colour 1 in its top left.
+func newViewerConsole(t *testing.T) *Console {
+	t.Helper()
+	chr := make([]uint8, CHR_BANK_SIZE)
+	copy(chr[0x0010:], []uint8{0xFF, 0x00, 0xFF, 0, 0, 0, 0, 0, 0x00, 0xFF, 0xFF})
+	chr[0x1010] = 0x80
+	cart, err := NewCartridge(make([]uint8, PRG_BANK_SIZE), chr, 0, MirrorVertical, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console := NewConsole(cart)
+	for i := uint16(0); i < 32; i++ {
+		console.ppu.palette[paletteIndex(i)] = uint8(i)
+	}
+	console.ppu.palette[paletteIndex(0)] = 0x0F
+	return console
+}
+
+func TestPatternTables(t *testing.T) {
+	console := newViewerConsole(t)
+	pal := &DefaultPalette
+	img := console.PatternTables(pal, 1)
+	if size := img.Rect.Size(); size.X != 256 || size.Y != 128 {
+		t.Fatalf("%v image, want 256x128", size)
+	}
+	pixels := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{8, 0, pal[0x05]},  // palette 1, colour 1
+		{15, 1, pal[0x06]}, // colour 2
+		{12, 2, pal[0x07]}, // colour 3
+		{8, 3, pal[0x0F]},  // colour 0 is the backdrop
+		{0, 0, pal[0x0F]},
+		{128 + 8, 0, pal[0x05]},
+		{128 + 9, 0, pal[0x0F]},
+	}
+	for _, p := range pixels {
+		if got := img.RGBAAt(p.x, p.y); got != p.want {
+			t.Errorf("pixel %d,%d is %v, want %v", p.x, p.y, got, p.want)
+		}
+	}
+}
+
+func TestNametables(t *testing.T) {
+	console := newViewerConsole(t)
+	pal := &DefaultPalette
+	// tile 1 at the second column of $2000, attribute palette 2 for the top
+	// left of the screen
+	console.ppu.vram_write(0x2001, 1)
+	console.ppu.vram_write(0x23C0, 0x02)
+	img := console.Nametables(pal)
+	if size := img.Rect.Size(); size.X != 512 || size.Y != 480 {
+		t.Fatalf("%v image, want 512x480", size)
+	}
+	pixels := []struct {
+		name string
+		x, y int
+		want color.RGBA
+	}{
+		{"tile", 8, 1, pal[0x0A]},
+		{"vertical mirror", 8, 240 + 1, pal[0x0A]},
+		{"second nametable", 256 + 8, 1, pal[0x0F]},
+		{"screen outline", 100, 0, ScrollOverlay},
+		{"screen outline bottom", 100, SCREEN_HEIGHT - 1, ScrollOverlay},
+		{"outside the screen", 300, 0, pal[0x0F]},
+	}
+	for _, p := range pixels {
+		if got := img.RGBAAt(p.x, p.y); got != p.want {
+			t.Errorf("%s: pixel %d,%d is %v, want %v", p.name, p.x, p.y, got, p.want)
+		}
+	}
+}
+
+func TestSpriteImages(t *testing.T) {
+	console := newViewerConsole(t)
+	pal := &DefaultPalette
+	// sprite 3 is tile 1 in palette 6, flipped both ways
+	copy(console.ppu.oam[3*4:], []uint8{0x20, 0x01, 0xC2, 0x40})
+	s := console.Sprites()[3]
+	if s != (Sprite{Index: 3, X: 0x40, Y: 0x20, Tile: 1, Attributes: 0xC2}) || s.Palette() != 2 || !s.FlipH() || !s.FlipV() || s.Behind() {
+		t.Errorf("sprite 3 is %+v", s)
+	}
+
+	img := console.SpriteImage(pal, s)
+	if size := img.Rect.Size(); size.X != 8 || size.Y != 8 {
+		t.Fatalf("%v sprite, want 8x8", size)
+	}
+	if got := img.RGBAAt(7, 7); got != pal[0x19] {
+		t.Errorf("flipped top row is %v, want %v at the bottom", got, pal[0x19])
+	}
+	if got := img.RGBAAt(0, 5); got != pal[0x1B] {
+		t.Errorf("flipped third row is %v, want %v", got, pal[0x1B])
+	}
+	oam := console.OAMImage(pal)
+	if got := oam.RGBAAt(3*10+1+7, 1+7); got != pal[0x19] {
+		t.Errorf("sprite 3 in the OAM grid is %v, want %v", got, pal[0x19])
+	}
+
+	// 8x16 sprites take the table from bit 0 of the tile, the bottom half is
+	// the next tile
+	console.ppu.ctrl |= ctrlSprite8x16
+	img = console.SpriteImage(pal, Sprite{Tile: 0x01})
+	if size := img.Rect.Size(); size.X != 8 || size.Y != 16 {
+		t.Fatalf("%v 8x16 sprite, want 8x16", size)
+	}
+	if got := img.RGBAAt(0, 8); got != pal[0x11] {
+		t.Errorf("bottom half is %v, want %v from tile 1 of the right table", got, pal[0x11])
+	}
+	if got := img.RGBAAt(0, 0); got != pal[0x0F] {
+		t.Errorf("top half is %v, want the backdrop", got)
+	}
+}
+
+func TestPaletteImage(t *testing.T) {
+	console := newViewerConsole(t)
+	pal := &DefaultPalette
+	ram := console.PaletteRAM()
+	// $3F10 mirrors the backdrop
+	if ram[0x00] != 0x0F || ram[0x10] != 0x0F || ram[0x11] != 0x11 {
+		t.Errorf("palette RAM %X", ram)
+	}
+	img := console.PaletteImage(pal)
+	if got := img.RGBAAt(16*1+8, 16+8); got != pal[0x11] {
+		t.Errorf("swatch $11 is %v, want %v", got, pal[0x11])
+	}
+	if got := img.RGBAAt(16*15+15, 15); got != pal[0x0F] {
+		t.Errorf("swatch $0F is %v, want %v", got, pal[0x0F])
+	}
+}
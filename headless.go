@@ -0,0 +1,419 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/audio"
+	"github.com/tejasdeepakmasne/nesemu-go/cheats"
+	"github.com/tejasdeepakmasne/nesemu-go/disasm"
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+	"github.com/tejasdeepakmasne/nesemu-go/headless"
+	"github.com/tejasdeepakmasne/nesemu-go/luascript"
+	"github.com/tejasdeepakmasne/nesemu-go/movie"
+	"github.com/tejasdeepakmasne/nesemu-go/video"
+)
+
+// exit codes of the headless command
+const (
+	exitPass  = 0
+	exitFail  = 1
+	exitError = 2
+)
+
+const headlessUsage = `usage: nesemu-go headless [flags] rom.nes
+
+Runs a ROM without display or audio and exits with status 0 when the run
+passes, 1 when the -until condition was not met or an -expect hash or
+-golden image did not match, and 2 on errors.
+
+The ROM can be an iNES or UNIF image, an FDS disk or a raw PRG image such as
+game.prg, wired up with -chr, -mapper and -mirroring.
+
+`
+
+func runHeadless(args []string) int {
+	fs := flag.NewFlagSet("headless", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), headlessUsage)
+		fs.PrintDefaults()
+	}
+	frames := fs.Int("frames", 600, "number of frames to run")
+	until := fs.String("until", "", "stop once a memory condition holds, e.g. '$00F0==$01'")
+	input := fs.String("input", "", "input script file")
+	shots := fs.String("screenshot", "", "comma separated frames to capture as PNG")
+	expect := fs.String("expect", "", "comma separated frame=hash pairs the frame buffer must match")
+	golden := fs.String("golden", "", "comma separated frame=file.png pairs the rendered frame must match, writing a diff image to -out on failure")
+	hashes := fs.String("hashes", "", "write the frame buffer hash of every frame to this file")
+	out := fs.String("out", ".", "directory for screenshots and diff images")
+	palette := fs.String("palette", "", "screenshot "+paletteFlagHelp)
+	filter := fs.String("filter", "", filterFlagHelp)
+	loadFrom := fs.Int("load-slot", -1, "start from the save state in this slot")
+	saveTo := fs.Int("save-slot", -1, "write a save state to this slot when the run ends")
+	playMovie := fs.String("movie", "", "play back input from an .fm2 movie, failing on desync")
+	recordMovie := fs.String("record-movie", "", "record the run's input to an .fm2 movie")
+	videoPath := fs.String("record", "", "record every frame and the sound to an uncompressed .avi file, or for any other path to a directory of PNGs and audio.wav")
+	ramFill := fs.String("ram", "zeros", "power-on RAM contents: zeros, ones or random")
+	seed := fs.Uint64("seed", 0, "seed for -ram random")
+	cheatFile := fs.String("cheats", "", "apply the Game Genie and freeze codes in a cheat file")
+	cdlPath := fs.String("cdl", "", "log code and data use of the ROM to an FCEUX .cdl file, adding to it if it exists")
+	luaScript := fs.String("lua", "", "run an FCEUX style Lua script alongside the ROM")
+	diskSwaps := fs.String("disk", "", "comma separated frame=side pairs changing the FDS disk, side counting from 1 or eject")
+	determinism := fs.Bool("determinism", false, "run twice and fail if the runs ever differ")
+	romOpts := addROMFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError
+	}
+	if *playMovie != "" && *recordMovie != "" {
+		fmt.Fprintln(os.Stderr, "-movie and -record-movie can't be used together")
+		return exitError
+	}
+
+	cfg := headless.Config{Frames: *frames, OutDir: *out}
+	var err error
+	if *until != "" {
+		if cfg.Until, err = headless.ParseCondition(*until); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		cfg.Input, err = headless.ParseScript(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *input, err)
+			return exitError
+		}
+	}
+	switch *ramFill {
+	case "zeros":
+		cfg.RAM.Fill = hardware.RAMZeros
+	case "ones":
+		cfg.RAM.Fill = hardware.RAMOnes
+	case "random":
+		cfg.RAM.Fill = hardware.RAMRandom
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -ram %q\n", *ramFill)
+		return exitError
+	}
+	cfg.RAM.Seed = *seed
+	if cfg.Palette, err = loadPalette(*palette); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if ntscFilter, err := loadFilter(*filter); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	} else if ntscFilter != nil {
+		cfg.Render = ntscFilter.Render
+	}
+	if cfg.Screenshots, err = parseFrameList(*shots); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if cfg.Expect, err = parseFramePairs(*expect, "hash"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if cfg.Golden, err = parseFramePairs(*golden, "file"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	cfg.Hashes = *hashes != ""
+	swaps, err := parseDiskSwaps(*diskSwaps)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	romPath := fs.Arg(0)
+	rom, err := os.ReadFile(romPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	cfg.Parse = func(rom []uint8) (*hardware.Cartridge, error) { return romOpts.parse(romPath, rom) }
+	cart, err := cfg.Parse(rom)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", romPath, err)
+		return exitError
+	}
+	if *determinism {
+		if err := headless.CheckDeterminism(rom, cfg); err != nil {
+			fmt.Println("FAIL", err)
+			return exitFail
+		}
+		fmt.Printf("deterministic over %d frames\n", cfg.Frames)
+		return exitPass
+	}
+
+	console := hardware.NewConsole(cart)
+	console.SetRAMPolicy(cfg.RAM)
+	console.PowerCycle()
+	if *loadFrom >= 0 {
+		if err := loadSlot(console, romPath, *loadFrom); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+	if *cheatFile != "" {
+		if err := cheats.New(console).LoadFile(*cheatFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+	var logger *disasm.CodeDataLogger
+	if *cdlPath != "" {
+		var cdl *disasm.CodeDataLog
+		if isFile(*cdlPath) {
+			if cdl, err = disasm.ReadCDLFile(*cdlPath, cart); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitError
+			}
+		}
+		logger = disasm.NewCodeDataLogger(console, cdl)
+	}
+	var recorder *movie.Recorder
+	switch {
+	case *playMovie != "":
+		framesSet := false
+		fs.Visit(func(f *flag.Flag) { framesSet = framesSet || f.Name == "frames" })
+		if err := playMovieHooks(&cfg, console, *playMovie, framesSet); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	case *recordMovie != "":
+		m := movie.New(cart, filepath.Base(romPath))
+		if recorder, err = movie.NewRecorder(console, m, *loadFrom >= 0); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		cfg.BeforeFrame = func(int) error { recorder.BeginFrame(0); return nil }
+		cfg.AfterFrame = func(int) error { recorder.EndFrame(); return nil }
+	}
+
+	if len(swaps) > 0 {
+		// before everything else, so a movie records the frame's input after the change
+		cfg.BeforeFrame = chainHooks(func(frame int) error {
+			if side, ok := swaps[frame]; ok {
+				return changeDisk(cart, side)
+			}
+			return nil
+		}, cfg.BeforeFrame)
+	}
+
+	if *luaScript != "" {
+		script := luascript.New(console, os.Stdout)
+		defer script.Close()
+		if err := script.LoadFile(*luaScript); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		// the script's input goes in before a movie's, so a recording captures it
+		cfg.BeforeFrame = chainHooks(script.BeforeFrame, cfg.BeforeFrame)
+		cfg.AfterFrame = chainHooks(script.AfterFrame, cfg.AfterFrame)
+	}
+
+	// after the script, so anything it draws is in the video
+	var recording video.Writer
+	if *videoPath != "" {
+		console.SetAudioRate(audio.SampleRate)
+		cfg.AfterFrame = chainHooks(cfg.AfterFrame, func(int) error {
+			img := renderFrame(console, cfg)
+			if recording == nil {
+				bounds := img.Bounds()
+				if recording, err = video.Create(*videoPath, bounds.Dx(), bounds.Dy(), audio.SampleRate); err != nil {
+					return err
+				}
+			}
+			if err := recording.WriteFrame(img); err != nil {
+				return err
+			}
+			return recording.WriteAudio(console.ReadAudio())
+		})
+	}
+
+	res, err := headless.RunConsole(console, cfg)
+	if recording != nil {
+		if closeErr := recording.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if err := romOpts.saveDisk(romPath, cart); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if logger != nil {
+		if err := logger.Log().WriteFile(*cdlPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+	if recorder != nil {
+		if err := writeMovie(recorder.Movie(), *recordMovie); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+	if *hashes != "" {
+		if err := writeHashes(res.Hashes, *hashes); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+	if *saveTo >= 0 {
+		if err := saveSlot(console, romPath, *saveTo); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+
+	for _, snap := range res.Snapshots {
+		fmt.Printf("frame %d hash %s %s\n", snap.Frame, snap.Hash, snap.Path)
+	}
+	for _, mismatch := range res.Mismatches {
+		fmt.Println("FAIL", mismatch)
+	}
+	if cfg.Until != nil {
+		if res.ConditionMet {
+			fmt.Printf("condition %v met at frame %d\n", cfg.Until, res.Frames)
+		} else {
+			fmt.Printf("FAIL condition %v not met after %d frames\n", cfg.Until, res.Frames)
+		}
+	}
+	if !res.Passed() {
+		return exitFail
+	}
+	return exitPass
+}
+
+// playMovieHooks feeds the movie's input into the run and checks it for desyncs
+func playMovieHooks(cfg *headless.Config, console *hardware.Console, path string, framesSet bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	m, err := movie.Read(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	player, err := movie.NewPlayer(console, m)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if !framesSet {
+		cfg.Frames = len(m.Frames)
+	}
+	cfg.BeforeFrame = func(int) error {
+		if err := player.BeginFrame(); err == io.EOF {
+			return headless.ErrStop
+		}
+		return nil
+	}
+	cfg.AfterFrame = func(int) error { return player.EndFrame() }
+	return nil
+}
+
+// renderFrame draws the console's picture as screenshots are drawn
+func renderFrame(console *hardware.Console, cfg headless.Config) *image.RGBA {
+	if cfg.Render != nil {
+		return cfg.Render(console)
+	}
+	pal := cfg.Palette
+	if pal == nil {
+		pal = &hardware.DefaultPalette
+	}
+	return console.FrameImage(pal)
+}
+
+// chainHooks runs frame hooks in order, either may be nil
+func chainHooks(first, second func(int) error) func(int) error {
+	if second == nil {
+		return first
+	}
+	if first == nil {
+		return second
+	}
+	return func(frame int) error {
+		if err := first(frame); err != nil {
+			return err
+		}
+		return second(frame)
+	}
+}
+
+func writeMovie(m *movie.Movie, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func parseFrameList(list string) ([]int, error) {
+	var frames []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		frame, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("bad frame %q", field)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// writeHashes writes one "frame hash" line per frame, for diffing two runs
+func writeHashes(hashes []string, path string) error {
+	var b strings.Builder
+	for i, hash := range hashes {
+		fmt.Fprintf(&b, "%d %s\n", i+1, hash)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// parseFramePairs parses a list of frame=value pairs, what names the value
+// in errors
+func parseFramePairs(list, what string) (map[int]string, error) {
+	expect := map[int]string{}
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		frame, hash, found := strings.Cut(field, "=")
+		n, err := strconv.Atoi(frame)
+		if !found || err != nil {
+			return nil, fmt.Errorf("bad expectation %q, want frame=%s", field, what)
+		}
+		expect[n] = hash
+	}
+	return expect, nil
+}
@@ -0,0 +1,73 @@
+package headless
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Condition compares a byte of CPU address space against a constant
+type Condition struct {
+	Address uint16
+	Op      string // one of == != < <= > >=
+	Value   uint8
+}
+
+// comparison operators, longest first so that "<=" is not read as "<"
+var conditionOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// ParseCondition parses expressions such as "$00F0==$01" or "0x10 >= 3".
+// Numbers may be written as $hex, 0xhex or decimal.
+func ParseCondition(expr string) (*Condition, error) {
+	for _, op := range conditionOps {
+		lhs, rhs, found := strings.Cut(expr, op)
+		if !found {
+			continue
+		}
+		address, err := parseNumber(lhs, 16)
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %w", expr, err)
+		}
+		value, err := parseNumber(rhs, 8)
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %w", expr, err)
+		}
+		return &Condition{Address: uint16(address), Op: op, Value: uint8(value)}, nil
+	}
+	return nil, fmt.Errorf("condition %q: missing comparison operator", expr)
+}
+
+func parseNumber(s string, bits int) (uint64, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "$"):
+		return strconv.ParseUint(s[1:], 16, bits)
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		return strconv.ParseUint(s[2:], 16, bits)
+	}
+	return strconv.ParseUint(s, 10, bits)
+}
+
+// Eval checks the condition using peek to read memory
+func (c *Condition) Eval(peek func(address uint16) uint8) bool {
+	value := peek(c.Address)
+	switch c.Op {
+	case "==":
+		return value == c.Value
+	case "!=":
+		return value != c.Value
+	case "<":
+		return value < c.Value
+	case "<=":
+		return value <= c.Value
+	case ">":
+		return value > c.Value
+	case ">=":
+		return value >= c.Value
+	}
+	return false
+}
+
+func (c *Condition) String() string {
+	return fmt.Sprintf("$%04X%s$%02X", c.Address, c.Op, c.Value)
+}
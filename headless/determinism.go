@@ -0,0 +1,43 @@
+package headless
+
+import (
+	"fmt"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// CheckDeterminism runs the ROM twice side by side with the same scripted
+// input and compares the machine state and frame buffer after every frame.
+// It returns an error describing the first frame where the runs diverge.
+// Only Frames, Input, RAM and Parse are taken from cfg.
+func CheckDeterminism(rom []uint8, cfg Config) error {
+	var consoles [2]*hardware.Console
+	for i := range consoles {
+		// each run gets its own cartridge so CHR-RAM isn't shared
+		cart, err := cfg.parse(rom)
+		if err != nil {
+			return err
+		}
+		consoles[i] = newConsole(cart, cfg.RAM)
+	}
+
+	for frame := 1; frame <= cfg.Frames; frame++ {
+		var states [2]uint64
+		var pictures [2]string
+		for i, console := range consoles {
+			for port := 0; port < 2; port++ {
+				console.Joypad(port).SetButtons(cfg.Input.Buttons(frame, port))
+			}
+			console.StepFrame()
+			states[i] = console.StateHash()
+			pictures[i] = FrameHash(console.Frame())
+		}
+		if states[0] != states[1] {
+			return fmt.Errorf("frame %d: state hash %016x != %016x", frame, states[0], states[1])
+		}
+		if pictures[0] != pictures[1] {
+			return fmt.Errorf("frame %d: frame buffer hash %s != %s", frame, pictures[0], pictures[1])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package headless
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+)
+
+// colours of the difference panel in a diff image
+var (
+	DiffHighlight = color.RGBA{R: 0xFF, A: 0xFF}
+	DiffOutline   = color.RGBA{R: 0xFF, G: 0xE0, A: 0xFF}
+)
+
+// Diff describes how a rendered picture differs from a golden image
+type Diff struct {
+	Pixels int             // number of pixels that differ
+	Bounds image.Rectangle // smallest rectangle holding them
+	Image  *image.RGBA     // golden, actual and difference side by side
+}
+
+func (d *Diff) String() string {
+	return fmt.Sprintf("%d pixels differ in %v", d.Pixels, d.Bounds)
+}
+
+// MismatchError is returned by CheckGolden when a picture doesn't match
+type MismatchError struct {
+	Golden   string
+	DiffPath string // where the diff image was written, if anywhere
+	Diff     *Diff
+}
+
+func (e *MismatchError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Golden, e.Diff)
+	if e.DiffPath != "" {
+		msg += ", see " + e.DiffPath
+	}
+	return msg
+}
+
+// CompareImages compares two pictures pixel by pixel and returns nil when
+// they are identical. Pictures of different sizes are compared over both,
+// pixels only one of them has count as different.
+//
+// The diff image has the golden picture on the left, the actual one in the
+// middle and on the right the actual picture dimmed to grey with changed
+// pixels in DiffHighlight and their bounds outlined in DiffOutline.
+func CompareImages(got, want image.Image) *Diff {
+	gb, wb := got.Bounds(), want.Bounds()
+	width, height := max(gb.Dx(), wb.Dx()), max(gb.Dy(), wb.Dy())
+	d := &Diff{Image: image.NewRGBA(image.Rect(0, 0, 3*width, height))}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			g, gok := pixelAt(got, x, y)
+			w, wok := pixelAt(want, x, y)
+			d.Image.SetRGBA(x, y, w)
+			d.Image.SetRGBA(width+x, y, g)
+			if gok && wok && g == w {
+				grey := uint8((int(g.R)*299 + int(g.G)*587 + int(g.B)*114) / 3000)
+				d.Image.SetRGBA(2*width+x, y, color.RGBA{grey, grey, grey, 0xFF})
+				continue
+			}
+			d.Image.SetRGBA(2*width+x, y, DiffHighlight)
+			d.Pixels++
+			d.Bounds = d.Bounds.Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+	if d.Pixels == 0 {
+		return nil
+	}
+
+	// the outline goes just outside the changed area where there is room
+	outline := d.Bounds.Inset(-1).Intersect(image.Rect(0, 0, width, height))
+	for x := outline.Min.X; x < outline.Max.X; x++ {
+		d.Image.SetRGBA(2*width+x, outline.Min.Y, DiffOutline)
+		d.Image.SetRGBA(2*width+x, outline.Max.Y-1, DiffOutline)
+	}
+	for y := outline.Min.Y; y < outline.Max.Y; y++ {
+		d.Image.SetRGBA(2*width+outline.Min.X, y, DiffOutline)
+		d.Image.SetRGBA(2*width+outline.Max.X-1, y, DiffOutline)
+	}
+	return d
+}
+
+// pixelAt returns the colour at x, y counted from the top left of img, and
+// false outside it
+func pixelAt(img image.Image, x, y int) (color.RGBA, bool) {
+	b := img.Bounds()
+	if x >= b.Dx() || y >= b.Dy() {
+		return color.RGBA{}, false
+	}
+	r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+	return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(bl >> 8), uint8(a >> 8)}, true
+}
+
+func readPNG(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return img, nil
+}
+
+// CheckGolden compares a picture with the golden PNG at path. On a mismatch
+// it returns a *MismatchError, after writing the diff image to diffPath
+// unless that is empty.
+func CheckGolden(img image.Image, golden, diffPath string) error {
+	want, err := readPNG(golden)
+	if err != nil {
+		return err
+	}
+	d := CompareImages(img, want)
+	if d == nil {
+		return nil
+	}
+	if diffPath != "" {
+		file, err := os.Create(diffPath)
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(file, d.Image); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return &MismatchError{Golden: golden, DiffPath: diffPath, Diff: d}
+}
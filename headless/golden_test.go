@@ -0,0 +1,122 @@
+package headless
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPicture is a 16x8 gradient
+func testPicture() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 16), uint8(y * 32), 0x80, 0xFF})
+		}
+	}
+	return img
+}
+
+func writeGolden(t *testing.T, img image.Image) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "golden.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckGolden(t *testing.T) {
+	golden := writeGolden(t, testPicture())
+	diffPath := filepath.Join(t.TempDir(), "diff.png")
+	if err := CheckGolden(testPicture(), golden, diffPath); err != nil {
+		t.Fatalf("identical picture: %v", err)
+	}
+	if _, err := os.Stat(diffPath); !os.IsNotExist(err) {
+		t.Error("a diff image was written for a match")
+	}
+	// the picture may sit anywhere in its own coordinates
+	shifted := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			shifted.SetRGBA(x+4, y+2, testPicture().RGBAAt(x, y))
+		}
+	}
+	if err := CheckGolden(shifted.SubImage(image.Rect(4, 2, 20, 10)), golden, ""); err != nil {
+		t.Errorf("offset sub image: %v", err)
+	}
+
+	img := testPicture()
+	img.SetRGBA(3, 2, color.RGBA{A: 0xFF})
+	img.SetRGBA(6, 4, color.RGBA{A: 0xFF})
+	err := CheckGolden(img, golden, diffPath)
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("changed picture: error %v, want a MismatchError", err)
+	}
+	if mismatch.Diff.Pixels != 2 || mismatch.Diff.Bounds != image.Rect(3, 2, 7, 5) {
+		t.Errorf("diff %v, want 2 pixels in (3,2)-(7,5)", mismatch.Diff)
+	}
+	if mismatch.Golden != golden || mismatch.DiffPath != diffPath {
+		t.Errorf("error names %s and %s", mismatch.Golden, mismatch.DiffPath)
+	}
+
+	diff, err := readPNG(diffPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := diff.Bounds().Size(); size.X != 3*16 || size.Y != 8 {
+		t.Fatalf("%v diff image, want 48x8", size)
+	}
+	pixels := []struct {
+		name string
+		x, y int
+		want color.RGBA
+	}{
+		{"golden", 3, 2, testPicture().RGBAAt(3, 2)},
+		{"actual", 16 + 3, 2, color.RGBA{A: 0xFF}},
+		{"changed", 32 + 6, 4, DiffHighlight},
+		{"outline", 32 + 2, 1, DiffOutline},
+		{"outline corner", 32 + 7, 5, DiffOutline},
+		{"unchanged, dimmed", 32 + 12, 7, color.RGBA{67, 67, 67, 0xFF}},
+	}
+	for _, p := range pixels {
+		if got, _ := pixelAt(diff, p.x, p.y); got != p.want {
+			t.Errorf("%s: diff pixel %d,%d is %v, want %v", p.name, p.x, p.y, got, p.want)
+		}
+	}
+}
+
+func TestCheckGoldenSize(t *testing.T) {
+	golden := writeGolden(t, testPicture())
+	wider := image.NewRGBA(image.Rect(0, 0, 18, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			wider.SetRGBA(x, y, testPicture().RGBAAt(x, y))
+		}
+	}
+	var mismatch *MismatchError
+	if err := CheckGolden(wider, golden, ""); !errors.As(err, &mismatch) {
+		t.Fatalf("wider picture: error %v, want a MismatchError", err)
+	}
+	// the extra columns count, even where they are transparent black
+	if mismatch.Diff.Pixels != 2*8 || mismatch.Diff.Bounds != image.Rect(16, 0, 18, 8) {
+		t.Errorf("diff %v, want 16 pixels in (16,0)-(18,8)", mismatch.Diff)
+	}
+	if mismatch.DiffPath != "" {
+		t.Errorf("diff written to %s without a path", mismatch.DiffPath)
+	}
+
+	if err := CheckGolden(wider, filepath.Join(t.TempDir(), "missing.png"), ""); err == nil || errors.As(err, &mismatch) {
+		t.Errorf("missing golden: error %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+// Package headless runs ROMs without a display or audio device, for use in
+// CI jobs and snapshot tests. A run is frame exact: the same ROM, input
+// script and frame count always produce the same pictures.
+package headless
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// Config describes a single headless run
+type Config struct {
+	Frames      int            // number of frames to run
+	Until       *Condition     // stop early once this holds, nil runs every frame
+	Input       Script         // scripted controller input
+	Screenshots []int          // frames to capture
+	Expect      map[int]string // expected frame buffer hash keyed by frame
+	Golden      map[int]string // PNG the rendered frame must match keyed by frame, see CheckGolden
+	Hashes      bool           // record the frame buffer hash of every frame
+	OutDir      string         // where screenshots are written as PNG, empty keeps them in memory
+	Palette     *hardware.Palette
+	Render      func(*hardware.Console) *image.RGBA            // draws screenshots, e.g. through a filter, nil for plain pixels
+	RAM         hardware.RAMPolicy                             // power-on RAM contents, used by Run
+	Parse       func(rom []uint8) (*hardware.Cartridge, error) // builds the cartridge in Run and CheckDeterminism, nil for ParseROM
+
+	// optional hooks around every frame, e.g. for movie playback. Returning
+	// ErrStop ends the run normally, any other error fails it.
+	BeforeFrame func(frame int) error
+	AfterFrame  func(frame int) error
+}
+
+var ErrStop = errors.New("stop run")
+
+// Snapshot is the picture captured at the end of a frame
+type Snapshot struct {
+	Frame int
+	Hash  string
+	Image *image.RGBA
+	Path  string // file the PNG was written to, if any
+}
+
+type Result struct {
+	Frames       int  // frames actually run
+	ConditionMet bool // the Until condition held when the run stopped
+	Snapshots    []Snapshot
+	Hashes       []string // frame buffer hash after each frame when Config.Hashes is set, frame 1 first
+	Mismatches   []string // descriptions of failed hash and golden image expectations, hooks and a CPU halt
+	until        bool
+}
+
+// Passed reports whether the Until condition, if any, was met and every
+// expected hash and golden image matched
+func (r *Result) Passed() bool {
+	if r.until && !r.ConditionMet {
+		return false
+	}
+	return len(r.Mismatches) == 0
+}
+
+// FrameHash returns a stable hash of the palette indices in a frame. It
+// doesn't depend on the palette or filter, but it also doesn't see colour
+// emphasis, which golden images do.
+func FrameHash(f *hardware.FrameBuffer) string {
+	h := fnv.New64a()
+	h.Write(f[:])
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func (cfg *Config) parse(rom []uint8) (*hardware.Cartridge, error) {
+	if cfg.Parse != nil {
+		return cfg.Parse(rom)
+	}
+	return hardware.ParseROM(rom, nil)
+}
+
+// Run loads a ROM image and runs it according to cfg
+func Run(rom []uint8, cfg Config) (*Result, error) {
+	cart, err := cfg.parse(rom)
+	if err != nil {
+		return nil, err
+	}
+	return RunConsole(newConsole(cart, cfg.RAM), cfg)
+}
+
+func newConsole(cart *hardware.Cartridge, policy hardware.RAMPolicy) *hardware.Console {
+	console := hardware.NewConsole(cart)
+	console.SetRAMPolicy(policy)
+	console.PowerCycle()
+	return console
+}
+
+// RunConsole runs an already constructed console according to cfg
+func RunConsole(console *hardware.Console, cfg Config) (*Result, error) {
+	render := cfg.Render
+	if render == nil {
+		pal := cfg.Palette
+		if pal == nil {
+			pal = &hardware.DefaultPalette
+		}
+		render = func(console *hardware.Console) *image.RGBA { return console.FrameImage(pal) }
+	}
+	capture := map[int]bool{}
+	for _, frame := range cfg.Screenshots {
+		capture[frame] = true
+	}
+	for frame := range cfg.Expect {
+		capture[frame] = true
+	}
+	for frame := range cfg.Golden {
+		capture[frame] = true
+	}
+	if cfg.OutDir != "" {
+		if err := os.MkdirAll(cfg.OutDir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &Result{until: cfg.Until != nil}
+	for frame := 1; frame <= cfg.Frames; frame++ {
+		for port := 0; port < 2; port++ {
+			console.Joypad(port).SetButtons(cfg.Input.Buttons(frame, port))
+		}
+		if stop := res.hook(cfg.BeforeFrame, frame); stop {
+			break
+		}
+		console.StepFrame()
+		res.Frames = frame
+		if cfg.Hashes {
+			res.Hashes = append(res.Hashes, FrameHash(console.Frame()))
+		}
+		if err := console.CPU().Halted(); err != nil {
+			res.Mismatches = append(res.Mismatches, fmt.Sprintf("frame %d: %v", frame, err))
+			break
+		}
+		if stop := res.hook(cfg.AfterFrame, frame); stop {
+			break
+		}
+
+		if capture[frame] {
+			snap, err := takeSnapshot(console, frame, render, cfg.OutDir)
+			if err != nil {
+				return res, err
+			}
+			res.Snapshots = append(res.Snapshots, snap)
+			if golden, ok := cfg.Golden[frame]; ok {
+				if err := res.checkGolden(snap, golden, cfg.OutDir); err != nil {
+					return res, err
+				}
+			}
+		}
+		if cfg.Until != nil && cfg.Until.Eval(console.Peek) {
+			res.ConditionMet = true
+			break
+		}
+	}
+
+	frames := make([]int, 0, len(cfg.Golden)+len(cfg.Expect))
+	for frame := range cfg.Golden {
+		frames = append(frames, frame)
+	}
+	sort.Ints(frames)
+	for _, frame := range frames {
+		if frame > res.Frames {
+			res.Mismatches = append(res.Mismatches, fmt.Sprintf("frame %d: not reached, want %s", frame, cfg.Golden[frame]))
+		}
+	}
+
+	frames = frames[:0]
+	for frame := range cfg.Expect {
+		frames = append(frames, frame)
+	}
+	sort.Ints(frames)
+	for _, frame := range frames {
+		want := cfg.Expect[frame]
+		got := ""
+		for _, snap := range res.Snapshots {
+			if snap.Frame == frame {
+				got = snap.Hash
+			}
+		}
+		switch {
+		case got == "":
+			res.Mismatches = append(res.Mismatches, fmt.Sprintf("frame %d: not reached, want hash %s", frame, want))
+		case got != want:
+			res.Mismatches = append(res.Mismatches, fmt.Sprintf("frame %d: hash %s, want %s", frame, got, want))
+		}
+	}
+	return res, nil
+}
+
+// hook runs an optional frame hook and reports whether the run should stop
+func (r *Result) hook(fn func(frame int) error, frame int) bool {
+	if fn == nil {
+		return false
+	}
+	err := fn(frame)
+	if err != nil && !errors.Is(err, ErrStop) {
+		r.Mismatches = append(r.Mismatches, err.Error())
+	}
+	return err != nil
+}
+
+// checkGolden compares a snapshot with its golden image, recording a
+// mismatch and writing the diff image next to the screenshots
+func (r *Result) checkGolden(snap Snapshot, golden, dir string) error {
+	diffPath := ""
+	if dir != "" {
+		diffPath = filepath.Join(dir, fmt.Sprintf("frame%06d-diff.png", snap.Frame))
+	}
+	err := CheckGolden(snap.Image, golden, diffPath)
+	var mismatch *MismatchError
+	if errors.As(err, &mismatch) {
+		r.Mismatches = append(r.Mismatches, fmt.Sprintf("frame %d: %v", snap.Frame, mismatch))
+		return nil
+	}
+	return err
+}
+
+func takeSnapshot(console *hardware.Console, frame int, render func(*hardware.Console) *image.RGBA, dir string) (Snapshot, error) {
+	fb := console.Frame()
+	snap := Snapshot{
+		Frame: frame,
+		Hash:  FrameHash(fb),
+		Image: render(console),
+	}
+	if dir == "" {
+		return snap, nil
+	}
+	snap.Path = filepath.Join(dir, fmt.Sprintf("frame%06d.png", frame))
+	file, err := os.Create(snap.Path)
+	if err != nil {
+		return snap, err
+	}
+	defer file.Close()
+	if err := png.Encode(file, snap.Image); err != nil {
+		return snap, err
+	}
+	return snap, file.Close()
+}
@@ -0,0 +1,155 @@
+package headless
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// nestest shows a menu after a few frames, pressing start runs its official
+// opcode tests which finish by frame 25 and leave the result screen up.
+// $0001 is set when the tests start, $0002 holds the first failure.
+const (
+	menuHash   = "1783cf0898b58e81"
+	resultHash = "996c39af884243c1"
+)
+
+func readNestest(t *testing.T) []uint8 {
+	t.Helper()
+	rom, err := os.ReadFile("../hardware/nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rom
+}
+
+func pressStart(t *testing.T) Script {
+	t.Helper()
+	script, err := ParseScript(strings.NewReader("# start the tests\n10-12 start\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func mustCondition(t *testing.T, expr string) *Condition {
+	t.Helper()
+	cond, err := ParseCondition(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cond
+}
+
+func TestRunFrames(t *testing.T) {
+	res, err := Run(readNestest(t), Config{Frames: 30, Input: pressStart(t), Hashes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Frames != 30 || len(res.Hashes) != 30 {
+		t.Fatalf("ran %d frames with %d hashes, want 30", res.Frames, len(res.Hashes))
+	}
+	if !res.Passed() {
+		t.Errorf("run failed: %v", res.Mismatches)
+	}
+	if res.Hashes[4] != menuHash {
+		t.Errorf("frame 5 hash %s, want the menu %s", res.Hashes[4], menuHash)
+	}
+	if res.Hashes[29] != resultHash {
+		t.Errorf("frame 30 hash %s, want the result screen %s", res.Hashes[29], resultHash)
+	}
+}
+
+func TestRunExpect(t *testing.T) {
+	cfg := Config{
+		Frames: 30,
+		Input:  pressStart(t),
+		Expect: map[int]string{5: menuHash, 30: resultHash},
+	}
+	res, err := Run(readNestest(t), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Passed() || len(res.Snapshots) != 2 {
+		t.Fatalf("got %d snapshots, mismatches %v", len(res.Snapshots), res.Mismatches)
+	}
+
+	cfg.Expect = map[int]string{5: resultHash, 40: resultHash}
+	res, err = Run(readNestest(t), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"frame 5: hash " + menuHash + ", want " + resultHash,
+		"frame 40: not reached, want hash " + resultHash,
+	}
+	if res.Passed() || strings.Join(res.Mismatches, "\n") != strings.Join(want, "\n") {
+		t.Errorf("mismatches %q, want %q", res.Mismatches, want)
+	}
+}
+
+func TestRunUntil(t *testing.T) {
+	res, err := Run(readNestest(t), Config{Frames: 60, Input: pressStart(t), Until: mustCondition(t, "$0001 == $FF")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.ConditionMet || !res.Passed() || res.Frames != 12 {
+		t.Errorf("stopped after %d frames, condition met %v, want frame 12", res.Frames, res.ConditionMet)
+	}
+
+	// without start the tests never run
+	res, err = Run(readNestest(t), Config{Frames: 20, Until: mustCondition(t, "$0001 == $FF")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ConditionMet || res.Passed() || res.Frames != 20 {
+		t.Errorf("ran %d frames, condition met %v, want 20 frames and a failure", res.Frames, res.ConditionMet)
+	}
+}
+
+func TestRunStop(t *testing.T) {
+	res, err := Run(readNestest(t), Config{Frames: 30, AfterFrame: func(frame int) error {
+		if frame == 7 {
+			return ErrStop
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Frames != 7 || !res.Passed() {
+		t.Errorf("stopped after %d frames, mismatches %v, want 7 frames", res.Frames, res.Mismatches)
+	}
+
+	res, err = Run(readNestest(t), Config{Frames: 30, BeforeFrame: func(frame int) error {
+		return errors.New("boom")
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Frames != 0 || res.Passed() {
+		t.Errorf("ran %d frames, mismatches %v, want a failed run", res.Frames, res.Mismatches)
+	}
+}
+
+func TestRunHalt(t *testing.T) {
+	// a 16 KiB NROM whose reset handler is the undocumented opcode $02
+	prg := make([]uint8, 0x4000)
+	prg[0x3FFC], prg[0x3FFD] = 0x00, 0xC0
+	prg[0], prg[1] = 0xEA, 0x02
+	cart, err := hardware.NewCartridge(prg, nil, 0, hardware.MirrorHorizontal, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := RunConsole(hardware.NewConsole(cart), Config{Frames: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "frame 1: CPU halted on undocumented opcode $02 at $C001"
+	if res.Frames != 1 || len(res.Mismatches) != 1 || res.Mismatches[0] != want {
+		t.Errorf("ran %d frames, mismatches %q, want %q", res.Frames, res.Mismatches, want)
+	}
+}
@@ -0,0 +1,117 @@
+package headless
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// InputEvent holds buttons down on one controller for a range of frames
+type InputEvent struct {
+	First   int // first frame, counting from 1
+	Last    int // last frame, inclusive
+	Port    int // controller port, 0 or 1
+	Buttons hardware.Buttons
+}
+
+// Script is a list of scripted controller inputs. Events may overlap, in
+// which case their buttons are combined.
+type Script []InputEvent
+
+var buttonNames = map[string]hardware.Buttons{
+	"a":      hardware.ButtonA,
+	"b":      hardware.ButtonB,
+	"select": hardware.ButtonSelect,
+	"start":  hardware.ButtonStart,
+	"up":     hardware.ButtonUp,
+	"down":   hardware.ButtonDown,
+	"left":   hardware.ButtonLeft,
+	"right":  hardware.ButtonRight,
+}
+
+// Buttons returns the buttons held on port during frame
+func (s Script) Buttons(frame int, port int) hardware.Buttons {
+	var held hardware.Buttons
+	for _, ev := range s {
+		if ev.Port == port && frame >= ev.First && frame <= ev.Last {
+			held |= ev.Buttons
+		}
+	}
+	return held
+}
+
+// ParseScript reads an input script. Each line names a frame or an inclusive
+// frame range followed by the buttons to hold, e.g.
+//
+//	# wait for the title screen, then start
+//	60-65 start
+//	120-200 p2 right b
+//
+// Buttons go to controller 1 unless the line contains p2.
+func ParseScript(r io.Reader) (Script, error) {
+	var script Script
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == '+'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+
+		ev, err := parseFrames(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(name)
+			switch name {
+			case "p1":
+				ev.Port = 0
+				continue
+			case "p2":
+				ev.Port = 1
+				continue
+			}
+			button, ok := buttonNames[name]
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown button %q", lineNo, name)
+			}
+			ev.Buttons |= button
+		}
+		script = append(script, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+func parseFrames(field string) (InputEvent, error) {
+	var ev InputEvent
+	first, last, isRange := strings.Cut(field, "-")
+	var err error
+	if ev.First, err = strconv.Atoi(first); err != nil {
+		return ev, fmt.Errorf("bad frame %q", field)
+	}
+	ev.Last = ev.First
+	if isRange {
+		if ev.Last, err = strconv.Atoi(last); err != nil {
+			return ev, fmt.Errorf("bad frame %q", field)
+		}
+	}
+	if ev.First < 1 || ev.Last < ev.First {
+		return ev, fmt.Errorf("bad frame range %q", field)
+	}
+	return ev, nil
+}
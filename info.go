@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+	"github.com/tejasdeepakmasne/nesemu-go/romdb"
+)
+
+const infoUsage = `usage: nesemu-go info [flags] rom.nes
+
+Shows what a ROM is: its format, title, board, mirroring and sizes, and its
+CRC32 and SHA-1 as the ROM database keys them, after the database has
+corrected the header.
+
+`
+
+func runInfo(args []string) int {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), infoUsage)
+		fs.PrintDefaults()
+	}
+	romOpts := addROMFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError
+	}
+	path := fs.Arg(0)
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	cart, err := romOpts.parse(path, rom)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return exitError
+	}
+
+	format := "iNES"
+	switch {
+	case romOpts.raw(path):
+		format = "raw PRG and CHR"
+	case hardware.IsNSF(rom):
+		printNSF(cart.NSF())
+		return exitPass
+	case hardware.IsFDS(rom):
+		format = "Famicom Disk System"
+	case hardware.IsUNIF(rom):
+		format = "UNIF"
+	case len(rom) > 7 && rom[7]&0x0C == 0x08:
+		format = "NES 2.0"
+	}
+	field := func(name, format string, args ...interface{}) {
+		fmt.Printf("%-10s "+format+"\n", append([]interface{}{name}, args...)...)
+	}
+	field("format", "%s", format)
+	if files, _ := romOpts.patchFiles(path); len(files) > 0 {
+		field("patched", "%s", strings.Join(files, ", "))
+	}
+	if cart.Title != "" {
+		field("title", "%s", cart.Title)
+	}
+	if disk := cart.Disk(); disk != nil {
+		field("sides", "%d", disk.Sides())
+		return exitPass
+	}
+
+	if cart.Board != "" {
+		field("board", "%s", cart.Board)
+	}
+	field("mapper", "%d, submapper %d", cart.MapperID, cart.Submapper)
+	field("mirroring", "%s", cart.Mirroring)
+	battery := "no"
+	if cart.Battery {
+		battery = "yes"
+	}
+	field("battery", "%s", battery)
+	field("PRG-ROM", "%d KiB", len(cart.PRG)/1024)
+	if cart.HasCHRRAM() {
+		field("CHR-RAM", "%d KiB", len(cart.CHR)/1024)
+	} else {
+		field("CHR-ROM", "%d KiB", len(cart.CHR)/1024)
+	}
+	field("region", "%s", cart.Region)
+	field("input", "%s", romdb.InputName(cart.Input))
+	crc, sum := romdb.Sums(cart)
+	field("crc32", "%08X", crc)
+	field("sha1", "%X", sum)
+
+	database := "not looked up"
+	if romOpts.database && !romOpts.raw(path) {
+		db, err := loadROMDB()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		switch game := db.Lookup(cart); {
+		case game == nil:
+			database = fmt.Sprintf("unknown dump, %d known", db.Len())
+		case bytes.Equal(game.SHA1[:], sum[:]):
+			database = "matched by SHA-1"
+		default:
+			database = "matched by CRC32"
+		}
+	}
+	field("database", "%s", database)
+	return exitPass
+}
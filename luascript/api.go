@@ -0,0 +1,379 @@
+package luascript
+
+import (
+	"bytes"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// joypad table keys in FCEUX order, matching the bits of hardware.Buttons
+var buttonNames = []string{"A", "B", "select", "start", "up", "down", "left", "right"}
+
+const stateTypeName = "savestate"
+
+func (s *Script) register() {
+	L := s.L
+	L.SetGlobal("print", L.NewFunction(s.print))
+
+	module := func(name string, funcs map[string]lua.LGFunction) {
+		L.SetGlobal(name, L.SetFuncs(L.NewTable(), funcs))
+	}
+	module("memory", map[string]lua.LGFunction{
+		"readbyte":         s.readByte,
+		"readbyteunsigned": s.readByte,
+		"readbytesigned":   s.readByteSigned,
+		"readword":         s.readWord,
+		"readwordunsigned": s.readWord,
+		"readwordsigned":   s.readWordSigned,
+		"readbyterange":    s.readByteRange,
+		"writebyte":        s.writeByte,
+		"getregister":      s.getRegister,
+		"setregister":      s.setRegister,
+		"registerread":     s.registerCallback(hardware.AccessRead),
+		"registerwrite":    s.registerCallback(hardware.AccessWrite),
+		"registerexec":     s.registerCallback(hardware.AccessExec),
+		"registerexecute":  s.registerCallback(hardware.AccessExec),
+	})
+	module("emu", map[string]lua.LGFunction{
+		"frameadvance":   func(L *lua.LState) int { return L.Yield() },
+		"framecount":     func(L *lua.LState) int { L.Push(lua.LNumber(s.console.FrameCount())); return 1 },
+		"emulating":      func(L *lua.LState) int { L.Push(lua.LTrue); return 1 },
+		"softreset":      func(L *lua.LState) int { s.console.Reset(); return 0 },
+		"poweron":        func(L *lua.LState) int { s.console.PowerCycle(); return 0 },
+		"print":          s.print,
+		"message":        s.print,
+		"registerbefore": func(L *lua.LState) int { s.before = L.OptFunction(1, nil); return 0 },
+		"registerafter":  func(L *lua.LState) int { s.after = L.OptFunction(1, nil); return 0 },
+	})
+	module("joypad", map[string]lua.LGFunction{
+		"get":   s.joypadGet,
+		"read":  s.joypadGet,
+		"set":   s.joypadSet,
+		"write": s.joypadSet,
+	})
+	module("savestate", map[string]lua.LGFunction{
+		"create": s.stateCreate,
+		"object": s.stateCreate,
+		"save":   s.stateSave,
+		"load":   s.stateLoad,
+	})
+	module("gui", map[string]lua.LGFunction{
+		"text":      s.guiText,
+		"drawtext":  s.guiText,
+		"box":       s.guiBox,
+		"drawbox":   s.guiBox,
+		"rect":      s.guiBox,
+		"drawrect":  s.guiBox,
+		"pixel":     s.guiPixel,
+		"drawpixel": s.guiPixel,
+		"setpixel":  s.guiPixel,
+		"line":      s.guiLine,
+		"drawline":  s.guiLine,
+		"register":  func(L *lua.LState) int { s.gui = L.OptFunction(1, nil); return 0 },
+	})
+	s.registerBitops()
+
+	L.SetField(L.NewTypeMetatable(stateTypeName), "__index", L.NewTable())
+}
+
+func (s *Script) address(L *lua.LState, n int) uint16 {
+	return uint16(L.CheckInt(n))
+}
+
+func (s *Script) readByte(L *lua.LState) int {
+	L.Push(lua.LNumber(s.console.Peek(s.address(L, 1))))
+	return 1
+}
+
+func (s *Script) readByteSigned(L *lua.LState) int {
+	L.Push(lua.LNumber(int8(s.console.Peek(s.address(L, 1)))))
+	return 1
+}
+
+// word reads a little endian word, FCEUX allows the high byte to come from
+// a separate address
+func (s *Script) word(L *lua.LState) uint16 {
+	low := s.address(L, 1)
+	high := low + 1
+	if L.GetTop() >= 2 {
+		high = s.address(L, 2)
+	}
+	return uint16(s.console.Peek(low)) | uint16(s.console.Peek(high))<<8
+}
+
+func (s *Script) readWord(L *lua.LState) int {
+	L.Push(lua.LNumber(s.word(L)))
+	return 1
+}
+
+func (s *Script) readWordSigned(L *lua.LState) int {
+	L.Push(lua.LNumber(int16(s.word(L))))
+	return 1
+}
+
+func (s *Script) readByteRange(L *lua.LState) int {
+	address, length := s.address(L, 1), L.CheckInt(2)
+	if length < 0 {
+		L.ArgError(2, "length must not be negative")
+	}
+	data := make([]uint8, 0, length)
+	for i := 0; i < length; i++ {
+		data = append(data, s.console.Peek(address+uint16(i)))
+	}
+	L.Push(lua.LString(data))
+	return 1
+}
+
+func (s *Script) writeByte(L *lua.LState) int {
+	s.console.Poke(s.address(L, 1), uint8(L.CheckInt(2)))
+	return 0
+}
+
+func (s *Script) getRegister(L *lua.LState) int {
+	r := s.console.CPU().Registers()
+	var value int
+	switch strings.ToLower(L.CheckString(1)) {
+	case "a":
+		value = int(r.A)
+	case "x":
+		value = int(r.X)
+	case "y":
+		value = int(r.Y)
+	case "p":
+		value = int(r.P)
+	case "s", "sp":
+		value = int(r.SP)
+	case "pc":
+		value = int(r.PC)
+	default:
+		L.ArgError(1, "unknown register")
+	}
+	L.Push(lua.LNumber(value))
+	return 1
+}
+
+func (s *Script) setRegister(L *lua.LState) int {
+	cpu := s.console.CPU()
+	r := cpu.Registers()
+	value := L.CheckInt(2)
+	switch strings.ToLower(L.CheckString(1)) {
+	case "a":
+		r.A = uint8(value)
+	case "x":
+		r.X = uint8(value)
+	case "y":
+		r.Y = uint8(value)
+	case "p":
+		r.P = uint8(value)
+	case "s", "sp":
+		r.SP = uint8(value)
+	case "pc":
+		r.PC = uint16(value)
+	default:
+		L.ArgError(1, "unknown register")
+	}
+	cpu.SetRegisters(r)
+	return 0
+}
+
+// registerCallback implements memory.register*(address, [size,] func),
+// passing func nil removes the callback
+func (s *Script) registerCallback(kind hardware.AccessKind) lua.LGFunction {
+	return func(L *lua.LState) int {
+		start := s.address(L, 1)
+		size, fnArg := 1, 2
+		if L.GetTop() >= 3 {
+			size, fnArg = L.CheckInt(2), 3
+		}
+		if size < 1 {
+			L.ArgError(2, "size must be positive")
+		}
+		end := start + uint16(size-1)
+		if end < start {
+			end = 0xFFFF
+		}
+		s.setCallback(kind, start, end, L.OptFunction(fnArg, nil))
+		return 0
+	}
+}
+
+func (s *Script) port(L *lua.LState) int {
+	port := L.CheckInt(1)
+	if port < 1 || port > 2 {
+		L.ArgError(1, "port must be 1 or 2")
+	}
+	return port - 1
+}
+
+func (s *Script) joypadGet(L *lua.LState) int {
+	buttons := s.console.Joypad(s.port(L)).Buttons()
+	t := L.NewTable()
+	for i, name := range buttonNames {
+		t.RawSetString(name, lua.LBool(buttons&(1<<i) != 0))
+	}
+	L.Push(t)
+	return 1
+}
+
+// joypadSet overrides buttons for the next frame. true presses, false
+// releases and missing buttons are left to other input sources.
+func (s *Script) joypadSet(L *lua.LState) int {
+	port := s.port(L)
+	t := L.CheckTable(2)
+	for i, name := range buttonNames {
+		value := t.RawGetString(name)
+		if value == lua.LNil {
+			value = t.RawGetString(strings.ToLower(name))
+		}
+		if value == lua.LNil {
+			continue
+		}
+		bit := hardware.Buttons(1 << i)
+		s.inputMask[port] |= bit
+		if lua.LVAsBool(value) {
+			s.inputValue[port] |= bit
+		} else {
+			s.inputValue[port] &^= bit
+		}
+	}
+	return 0
+}
+
+// savestate objects keep the state in memory, the slot argument of
+// savestate.create is accepted for compatibility and ignored
+func (s *Script) stateCreate(L *lua.LState) int {
+	ud := L.NewUserData()
+	ud.Value = &bytes.Buffer{}
+	L.SetMetatable(ud, L.GetTypeMetatable(stateTypeName))
+	L.Push(ud)
+	return 1
+}
+
+func (s *Script) checkState(L *lua.LState) *bytes.Buffer {
+	ud := L.CheckUserData(1)
+	buf, ok := ud.Value.(*bytes.Buffer)
+	if !ok {
+		L.ArgError(1, "savestate object expected")
+	}
+	return buf
+}
+
+func (s *Script) stateSave(L *lua.LState) int {
+	buf := s.checkState(L)
+	buf.Reset()
+	if err := s.console.SaveState(buf); err != nil {
+		L.RaiseError("savestate.save: %v", err)
+	}
+	return 0
+}
+
+func (s *Script) stateLoad(L *lua.LState) int {
+	buf := s.checkState(L)
+	if buf.Len() == 0 {
+		L.RaiseError("savestate.load: nothing saved in this object")
+	}
+	if err := s.console.LoadState(bytes.NewReader(buf.Bytes())); err != nil {
+		L.RaiseError("savestate.load: %v", err)
+	}
+	return 0
+}
+
+// optColor reads an optional colour argument
+func optColor(L *lua.LState, n int, def string) lua.LValue {
+	if L.GetTop() < n || L.Get(n) == lua.LNil {
+		return lua.LString(def)
+	}
+	return L.Get(n)
+}
+
+func (s *Script) guiText(L *lua.LState) int {
+	fg, _ := parseColor(optColor(L, 4, "white"))
+	bg, _ := parseColor(optColor(L, 5, "black"))
+	s.canvas.text(L.CheckInt(1), L.CheckInt(2), L.ToStringMeta(L.Get(3)).String(), fg, bg)
+	return 0
+}
+
+func (s *Script) guiBox(L *lua.LState) int {
+	fill, err := parseColor(optColor(L, 5, "clear"))
+	if err != nil {
+		L.ArgError(5, err.Error())
+	}
+	outline, err := parseColor(optColor(L, 6, "white"))
+	if err != nil {
+		L.ArgError(6, err.Error())
+	}
+	s.canvas.box(L.CheckInt(1), L.CheckInt(2), L.CheckInt(3), L.CheckInt(4), fill, outline)
+	return 0
+}
+
+func (s *Script) guiPixel(L *lua.LState) int {
+	c, err := parseColor(optColor(L, 3, "white"))
+	if err != nil {
+		L.ArgError(3, err.Error())
+	}
+	s.canvas.pixel(L.CheckInt(1), L.CheckInt(2), c)
+	return 0
+}
+
+func (s *Script) guiLine(L *lua.LState) int {
+	c, err := parseColor(optColor(L, 5, "white"))
+	if err != nil {
+		L.ArgError(5, err.Error())
+	}
+	s.canvas.line(L.CheckInt(1), L.CheckInt(2), L.CheckInt(3), L.CheckInt(4), c)
+	return 0
+}
+
+// registerBitops adds FCEUX's global AND, OR, XOR, SHIFT and BIT and a
+// LuaBitOp style bit table, as Lua 5.1 has no bitwise operators
+func (s *Script) registerBitops() {
+	L := s.L
+	fold := func(op func(a, b uint32) uint32) lua.LGFunction {
+		return func(L *lua.LState) int {
+			result := uint32(L.CheckInt64(1))
+			for i := 2; i <= L.GetTop(); i++ {
+				result = op(result, uint32(L.CheckInt64(i)))
+			}
+			L.Push(lua.LNumber(result))
+			return 1
+		}
+	}
+	and := fold(func(a, b uint32) uint32 { return a & b })
+	or := fold(func(a, b uint32) uint32 { return a | b })
+	xor := fold(func(a, b uint32) uint32 { return a ^ b })
+	shift := func(left bool) lua.LGFunction {
+		return func(L *lua.LState) int {
+			n, by := uint32(L.CheckInt64(1)), L.CheckInt(2)
+			if left == (by >= 0) {
+				L.Push(lua.LNumber(n << uint(abs(by))))
+			} else {
+				L.Push(lua.LNumber(n >> uint(abs(by))))
+			}
+			return 1
+		}
+	}
+
+	L.SetGlobal("AND", L.NewFunction(and))
+	L.SetGlobal("OR", L.NewFunction(or))
+	L.SetGlobal("XOR", L.NewFunction(xor))
+	// SHIFT(n, by) shifts right for positive by
+	L.SetGlobal("SHIFT", L.NewFunction(shift(false)))
+	L.SetGlobal("BIT", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LNumber(uint32(1) << uint(L.CheckInt(1))))
+		return 1
+	}))
+	L.SetGlobal("bit", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"band":   and,
+		"bor":    or,
+		"bxor":   xor,
+		"lshift": shift(true),
+		"rshift": shift(false),
+		"bnot": func(L *lua.LState) int {
+			L.Push(lua.LNumber(^uint32(L.CheckInt64(1))))
+			return 1
+		},
+	}))
+}
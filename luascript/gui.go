@@ -0,0 +1,190 @@
+package luascript
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// font3x5 has a 3x5 glyph for each printable ASCII character from space,
+// bit y*3+x set for a lit pixel. Lower case shares the upper case glyphs.
+var font3x5 = [95]uint16{
+	0x0000, 0x2092, 0x002D, 0x5F7D, 0x3C9E, 0x42A1, 0x6AAA, 0x0012, //  !"#$%&'
+	0x4494, 0x1491, 0x0AA8, 0x05D0, 0x1400, 0x01C0, 0x2000, 0x12A4, // ()*+,-./
+	0x7B6F, 0x749A, 0x73E7, 0x79A7, 0x49ED, 0x79CF, 0x7BCF, 0x2527, // 01234567
+	0x7BEF, 0x79EF, 0x0410, 0x1410, 0x4454, 0x0E38, 0x1511, 0x20A7, // 89:;<=>?
+	0x736F, 0x5BEA, 0x3AEB, 0x624E, 0x3B6B, 0x72CF, 0x12CF, 0x6B4E, // @ABCDEFG
+	0x5BED, 0x7497, 0x2B24, 0x5AED, 0x7249, 0x5BFD, 0x5B6B, 0x2B6A, // HIJKLMNO
+	0x12EB, 0x676A, 0x5AEB, 0x388E, 0x2497, 0x7B6D, 0x2B6D, 0x5FED, // PQRSTUVW
+	0x5AAD, 0x24AD, 0x72A7, 0x324B, 0x4889, 0x6926, 0x002A, 0x7000, // XYZ[\]^_
+	0x0011, 0x5BEA, 0x3AEB, 0x624E, 0x3B6B, 0x72CF, 0x12CF, 0x6B4E, // `abcdefg
+	0x5BED, 0x7497, 0x2B24, 0x5AED, 0x7249, 0x5BFD, 0x5B6B, 0x2B6A, // hijklmno
+	0x12EB, 0x676A, 0x5AEB, 0x388E, 0x2497, 0x7B6D, 0x2B6D, 0x5FED, // pqrstuvw
+	0x5AAD, 0x24AD, 0x72A7, 0x44D4, 0x2492, 0x1591, 0x0118, // xyz{|}~
+}
+
+// glyph cells are one pixel wider and taller than the glyphs
+const (
+	GLYPH_WIDTH  = 4
+	GLYPH_HEIGHT = 6
+)
+
+var colorNames = map[string]color.RGBA{
+	"white":  {0xFF, 0xFF, 0xFF, 0xFF},
+	"black":  {0x00, 0x00, 0x00, 0xFF},
+	"gray":   {0x80, 0x80, 0x80, 0xFF},
+	"grey":   {0x80, 0x80, 0x80, 0xFF},
+	"red":    {0xFF, 0x00, 0x00, 0xFF},
+	"green":  {0x00, 0xFF, 0x00, 0xFF},
+	"blue":   {0x00, 0x00, 0xFF, 0xFF},
+	"yellow": {0xFF, 0xFF, 0x00, 0xFF},
+	"orange": {0xFF, 0x80, 0x00, 0xFF},
+	"purple": {0x80, 0x00, 0xFF, 0xFF},
+	"clear":  {},
+}
+
+// canvas draws on the console's frame buffer. The buffer holds palette
+// indices, so colours are matched to the closest palette entry and anything
+// not fully opaque is treated as transparent.
+type canvas struct {
+	console *hardware.Console
+	palette *hardware.Palette
+	nearest map[color.RGBA]uint8
+}
+
+func newCanvas(console *hardware.Console) *canvas {
+	return &canvas{console: console, palette: &hardware.DefaultPalette, nearest: map[color.RGBA]uint8{}}
+}
+
+// parseColor reads an FCEUX colour: a name, "#RRGGBB", "#RRGGBBAA" or a
+// number 0xRRGGBBAA
+func parseColor(value lua.LValue) (color.RGBA, error) {
+	switch v := value.(type) {
+	case lua.LNumber:
+		n := uint32(v)
+		return color.RGBA{uint8(n >> 24), uint8(n >> 16), uint8(n >> 8), uint8(n)}, nil
+	case lua.LString:
+		text := strings.ToLower(string(v))
+		if c, ok := colorNames[text]; ok {
+			return c, nil
+		}
+		if strings.HasPrefix(text, "#") && (len(text) == 7 || len(text) == 9) {
+			n, err := strconv.ParseUint(text[1:], 16, 32)
+			if err == nil {
+				if len(text) == 7 {
+					n = n<<8 | 0xFF
+				}
+				return color.RGBA{uint8(n >> 24), uint8(n >> 16), uint8(n >> 8), uint8(n)}, nil
+			}
+		}
+	}
+	return color.RGBA{}, fmt.Errorf("bad colour %v", value)
+}
+
+func (cv *canvas) index(c color.RGBA) uint8 {
+	if i, ok := cv.nearest[c]; ok {
+		return i
+	}
+	best, bestDistance := 0, -1
+	for i, p := range cv.palette[:64] {
+		dr, dg, db := int(p.R)-int(c.R), int(p.G)-int(c.G), int(p.B)-int(c.B)
+		if d := dr*dr + dg*dg + db*db; bestDistance < 0 || d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	cv.nearest[c] = uint8(best)
+	return uint8(best)
+}
+
+func (cv *canvas) pixel(x, y int, c color.RGBA) {
+	if c.A != 0xFF || x < 0 || y < 0 || x >= hardware.SCREEN_WIDTH || y >= hardware.SCREEN_HEIGHT {
+		return
+	}
+	cv.console.Frame()[y*hardware.SCREEN_WIDTH+x] = cv.index(c)
+}
+
+func (cv *canvas) box(x1, y1, x2, y2 int, fill, outline color.RGBA) {
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+	if y1 > y2 {
+		y1, y2 = y2, y1
+	}
+	for y := y1; y <= y2; y++ {
+		for x := x1; x <= x2; x++ {
+			if x == x1 || x == x2 || y == y1 || y == y2 {
+				cv.pixel(x, y, outline)
+			} else {
+				cv.pixel(x, y, fill)
+			}
+		}
+	}
+}
+
+// line uses Bresenham's algorithm
+func (cv *canvas) line(x1, y1, x2, y2 int, c color.RGBA) {
+	dx, dy := abs(x2-x1), -abs(y2-y1)
+	sx, sy := sign(x2-x1), sign(y2-y1)
+	err := dx + dy
+	for {
+		cv.pixel(x1, y1, c)
+		if x1 == x2 && y1 == y2 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x1 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y1 += sy
+		}
+	}
+}
+
+// text draws a string on a background box, as FCEUX does
+func (cv *canvas) text(x, y int, text string, fg, bg color.RGBA) {
+	left := x
+	lines := strings.Split(text, "\n")
+	for _, line := range lines {
+		if len(line) > 0 {
+			cv.box(x-1, y-1, x+len(line)*GLYPH_WIDTH-1, y+GLYPH_HEIGHT-1, bg, bg)
+		}
+		for _, ch := range []uint8(line) {
+			if ch >= ' ' && ch <= '~' {
+				bits := font3x5[ch-' ']
+				for i := 0; i < 15; i++ {
+					if bits&(1<<i) != 0 {
+						cv.pixel(x+i%3, y+i/3, fg)
+					}
+				}
+			}
+			x += GLYPH_WIDTH
+		}
+		x = left
+		y += GLYPH_HEIGHT + 1
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,185 @@
+// Package luascript runs Lua scripts against a console with an API that
+// follows FCEUX closely enough for most bot, RAM watch and testing scripts:
+// memory, emu, joypad, savestate and gui, plus the AND/OR/XOR/SHIFT/BIT
+// helpers and a bit library. https://fceux.com/web/help/LuaFunctionsList.html
+//
+// A script's main chunk runs as a coroutine that yields at every
+// emu.frameadvance. The host advances frames and resumes it through
+// BeforeFrame and AfterFrame, which fit the headless runner's hooks.
+package luascript
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// callback identifies a memory.register* registration, a new function for
+// the same kind and range replaces the old one as in FCEUX
+type callback struct {
+	kind       hardware.AccessKind
+	start, end uint16
+}
+
+// Script is a loaded Lua script bound to a console
+type Script struct {
+	L       *lua.LState
+	console *hardware.Console
+	output  io.Writer
+	canvas  *canvas
+
+	thread  *lua.LState
+	main    *lua.LFunction
+	started bool
+	done    bool
+
+	// joypad.set overrides for the coming frame, per port
+	inputMask  [2]hardware.Buttons
+	inputValue [2]hardware.Buttons
+
+	callbacks map[callback]hardware.HookID
+	before    *lua.LFunction // emu.registerbefore
+	after     *lua.LFunction // emu.registerafter
+	gui       *lua.LFunction // gui.register
+	err       error          // first error raised by a callback
+}
+
+// New creates a script environment for console. print and emu.print write
+// to output.
+func New(console *hardware.Console, output io.Writer) *Script {
+	s := &Script{
+		L:       lua.NewState(),
+		console: console,
+		output:  output,
+		canvas:  newCanvas(console),
+
+		callbacks: map[callback]hardware.HookID{},
+	}
+	s.register()
+	return s
+}
+
+// Close frees the Lua state and detaches the script from the console
+func (s *Script) Close() {
+	for _, id := range s.callbacks {
+		s.console.Hooks().Remove(id)
+	}
+	s.L.Close()
+}
+
+// Load compiles a script, name is used in error messages
+func (s *Script) Load(name, source string) error {
+	fn, err := s.L.Load(strings.NewReader(source), name)
+	if err != nil {
+		return err
+	}
+	s.main = fn
+	s.thread, _ = s.L.NewThread()
+	return nil
+}
+
+// LoadFile compiles a script file
+func (s *Script) LoadFile(path string) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return s.Load(path, string(source))
+}
+
+// Done reports whether the main chunk has returned
+func (s *Script) Done() bool {
+	return s.done
+}
+
+// BeforeFrame runs before the console emulates a frame. The first call
+// starts the script, which runs up to its first emu.frameadvance.
+func (s *Script) BeforeFrame(frame int) error {
+	if !s.started {
+		s.started = true
+		if err := s.resume(); err != nil {
+			return err
+		}
+	}
+	if err := s.call(s.before); err != nil {
+		return err
+	}
+	for port := 0; port < 2; port++ {
+		joypad := s.console.Joypad(port)
+		joypad.SetButtons(joypad.Buttons()&^s.inputMask[port] | s.inputValue[port]&s.inputMask[port])
+	}
+	return nil
+}
+
+// AfterFrame runs once a frame is complete: the script continues from
+// emu.frameadvance and may draw on the finished frame
+func (s *Script) AfterFrame(frame int) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.inputMask = [2]hardware.Buttons{}
+	if err := s.call(s.after); err != nil {
+		return err
+	}
+	if err := s.resume(); err != nil {
+		return err
+	}
+	return s.call(s.gui)
+}
+
+func (s *Script) resume() error {
+	if s.done || s.main == nil {
+		return nil
+	}
+	state, err, _ := s.L.Resume(s.thread, s.main)
+	switch state {
+	case lua.ResumeError:
+		s.done = true
+		return err
+	case lua.ResumeOK:
+		s.done = true
+	}
+	return nil
+}
+
+// call runs a registered function, nil is allowed
+func (s *Script) call(fn *lua.LFunction, args ...lua.LValue) error {
+	if fn == nil {
+		return nil
+	}
+	return s.L.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, args...)
+}
+
+// setCallback replaces the callback of a kind on a range, nil fn removes it
+func (s *Script) setCallback(kind hardware.AccessKind, start, end uint16, fn *lua.LFunction) {
+	key := callback{kind, start, end}
+	if id, ok := s.callbacks[key]; ok {
+		s.console.Hooks().Remove(id)
+		delete(s.callbacks, key)
+	}
+	if fn == nil {
+		return
+	}
+	s.callbacks[key] = s.console.Hooks().OnAccess(kind, start, end, func(kind hardware.AccessKind, address uint16, value uint8) {
+		err := s.call(fn, lua.LNumber(address), lua.LNumber(1), lua.LNumber(value))
+		if err != nil && s.err == nil {
+			s.err = err
+		}
+	})
+}
+
+func (s *Script) print(L *lua.LState) int {
+	for i := 1; i <= L.GetTop(); i++ {
+		if i > 1 {
+			fmt.Fprint(s.output, "\t")
+		}
+		fmt.Fprint(s.output, L.ToStringMeta(L.Get(i)).String())
+	}
+	fmt.Fprintln(s.output)
+	return 0
+}
@@ -0,0 +1,267 @@
+package luascript
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/tejasdeepakmasne/nesemu-go/asm"
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// scriptProgram stores $42 at $0300 and then copies $0301 to $0302 forever
+const scriptProgram = `
+.org $C000
+reset:	LDA #$42 ; STA $0300
+loop:	LDA $0301 ; STA $0302
+	JMP loop
+.org $FFFA
+	.word loop, reset, loop
+`
+
+// runScript runs source against scriptProgram, one frame in already, for
+// up to frames frames or until it returns, and gives back what it printed
+func runScript(t *testing.T, source string, frames int) (*Script, string, error) {
+	t.Helper()
+	p := asm.MustAssemble(scriptProgram)
+	prg := make([]uint8, 0x4000)
+	copy(prg[p.Origin-0xC000:], p.Code)
+	cart, err := hardware.NewCartridge(prg, make([]uint8, 0x2000), 0, hardware.MirrorVertical, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console := hardware.NewConsole(cart)
+	console.StepFrame()
+
+	var out strings.Builder
+	s := New(console, &out)
+	t.Cleanup(s.Close)
+	if err := s.Load("test.lua", source); err != nil {
+		t.Fatal(err)
+	}
+	for frame := 1; frame <= frames && !s.Done(); frame++ {
+		if err := s.BeforeFrame(frame); err != nil {
+			return s, out.String(), err
+		}
+		console.StepFrame()
+		if err := s.AfterFrame(frame); err != nil {
+			return s, out.String(), err
+		}
+	}
+	return s, out.String(), nil
+}
+
+func TestMemoryAndEmu(t *testing.T) {
+	s, out, err := runScript(t, `
+print(memory.readbyte(0x300), emu.framecount())
+memory.writebyte(0x301, 0xFE)
+print(memory.readbytesigned(0x301), memory.readword(0x300), memory.readwordsigned(0x300, 0x301))
+print(memory.readbyterange(0x300, 2) == "\066\254")
+memory.setregister("x", 7)
+print(memory.getregister("X"), memory.getregister("pc") >= 0xC000)
+emu.frameadvance()
+print(emu.framecount(), memory.readbyte(0x302))
+`, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "66\t1\n-2\t65090\t-446\ntrue\n7\ttrue\n2\t254\n"
+	if out != want {
+		t.Errorf("printed %q, want %q", out, want)
+	}
+	if !s.Done() {
+		t.Error("script not done after its main chunk returned")
+	}
+}
+
+func TestMemoryCallbacks(t *testing.T) {
+	_, out, err := runScript(t, `
+writes, reads, value = 0, 0, nil
+memory.registerwrite(0x302, function(address, size, v) writes = writes + 1; value = v end)
+memory.registerread(0x301, 1, function() reads = reads + 1 end)
+memory.writebyte(0x301, 9)
+emu.frameadvance()
+print(writes > 100, math.abs(writes - reads) <= 1, value)
+-- nil removes a callback
+memory.registerwrite(0x302, nil)
+local before = writes
+emu.frameadvance()
+print(writes == before, reads > before)
+memory.registerexec(0xC005, function() error("boom") end)
+emu.frameadvance()
+print("not reached")
+`, 10)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("error %v from a failing callback, want boom", err)
+	}
+	if want := "true\ttrue\t9\ntrue\ttrue\n"; out != want {
+		t.Errorf("printed %q, want %q", out, want)
+	}
+}
+
+func TestJoypad(t *testing.T) {
+	s, out, err := runScript(t, `
+joypad.set(1, {A=true, start=true, B=false})
+emu.frameadvance()
+local j = joypad.get(1)
+print(j.A, j.start, j.B, j.up)
+print(pcall(joypad.get, 3))
+`, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out, "\n")
+	if lines[0] != "true\ttrue\tfalse\tfalse" {
+		t.Errorf("joypad.get after joypad.set is %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "false") {
+		t.Errorf("joypad.get(3) gave %q, want an error", lines[1])
+	}
+	// the override only lasts the frame, other input sources keep theirs
+	if buttons := s.console.Joypad(0).Buttons(); buttons != hardware.ButtonA|hardware.ButtonStart {
+		t.Errorf("buttons %08b after the frame", buttons)
+	}
+}
+
+func TestJoypadRelease(t *testing.T) {
+	s, _, err := runScript(t, `joypad.set(1, {B=false}); emu.frameadvance()`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joypad := s.console.Joypad(0)
+	joypad.SetButtons(hardware.ButtonB | hardware.ButtonUp)
+	if err := s.BeforeFrame(1); err != nil {
+		t.Fatal(err)
+	}
+	if buttons := joypad.Buttons(); buttons != hardware.ButtonUp {
+		t.Errorf("buttons %08b, want B released and up left held", buttons)
+	}
+}
+
+func TestSavestate(t *testing.T) {
+	_, out, err := runScript(t, `
+local s = savestate.create()
+savestate.save(s)
+memory.writebyte(0x300, 0x11)
+savestate.load(s)
+print(memory.readbyte(0x300))
+print(pcall(savestate.load, savestate.create()))
+`, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out, "\n")
+	if lines[0] != "66" {
+		t.Errorf("$0300 is %s after loading the state, want 66", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "false") || !strings.Contains(lines[1], "nothing saved") {
+		t.Errorf("loading an empty state gave %q", lines[1])
+	}
+}
+
+func TestBitops(t *testing.T) {
+	_, out, err := runScript(t, `
+print(AND(0xF0, 0x3C, 0xFF), OR(1, 2, 4), XOR(3, 1), SHIFT(8, 2), SHIFT(1, -3), BIT(4))
+print(bit.band(6, 3), bit.bor(6, 3), bit.bxor(6, 3), bit.lshift(1, 4), bit.rshift(16, 4), bit.bnot(0))
+`, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "48\t7\t2\t2\t8\t16\n2\t7\t5\t16\t1\t4294967295\n"; out != want {
+		t.Errorf("printed %q, want %q", out, want)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		value lua.LValue
+		want  color.RGBA
+	}{
+		{lua.LString("Red"), color.RGBA{0xFF, 0, 0, 0xFF}},
+		{lua.LString("clear"), color.RGBA{}},
+		{lua.LString("#102030"), color.RGBA{0x10, 0x20, 0x30, 0xFF}},
+		{lua.LString("#10203080"), color.RGBA{0x10, 0x20, 0x30, 0x80}},
+		{lua.LNumber(0x102030FF), color.RGBA{0x10, 0x20, 0x30, 0xFF}},
+	}
+	for _, tt := range tests {
+		if got, err := parseColor(tt.value); err != nil || got != tt.want {
+			t.Errorf("%v: %v %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []lua.LValue{lua.LString("bogus"), lua.LString("#12345"), lua.LString("#GGGGGG"), lua.LTrue} {
+		if _, err := parseColor(value); err == nil {
+			t.Errorf("%v: no error", value)
+		}
+	}
+}
+
+func TestGUI(t *testing.T) {
+	s, _, err := runScript(t, `
+emu.frameadvance()
+gui.pixel(10, 10, "red")
+gui.pixel(11, 10, "#FF000080")
+gui.box(20, 20, 24, 24, "blue", "white")
+gui.line(30, 30, 33, 33, "#00FF00")
+gui.text(40, 40, "I")
+`, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := s.canvas.index
+	red, blue, white, green, black := index(colorNames["red"]), index(colorNames["blue"]), index(colorNames["white"]), index(colorNames["green"]), index(colorNames["black"])
+	frame := s.console.Frame()
+	at := func(x, y int) uint8 { return frame[y*hardware.SCREEN_WIDTH+x] }
+	pixels := []struct {
+		name string
+		x, y int
+		want uint8
+	}{
+		{"pixel", 10, 10, red},
+		{"box outline", 20, 20, white},
+		{"box outline corner", 24, 24, white},
+		{"box fill", 22, 22, blue},
+		{"line", 31, 31, green},
+		{"line end", 33, 33, green},
+		{"text", 41, 40, white},
+		{"text background", 43, 40, black},
+	}
+	for _, p := range pixels {
+		if got := at(p.x, p.y); got != p.want {
+			t.Errorf("%s: pixel %d,%d is $%02X, want $%02X", p.name, p.x, p.y, got, p.want)
+		}
+	}
+	// translucent colours aren't drawn, and a line only steps diagonally
+	if at(11, 10) == red {
+		t.Error("a translucent pixel was drawn")
+	}
+	if at(31, 30) == green || at(30, 31) == green {
+		t.Error("a diagonal line was drawn as a staircase")
+	}
+}
+
+func TestGUIRegister(t *testing.T) {
+	s, _, err := runScript(t, `
+frames = 0
+gui.register(function() frames = frames + 1; gui.pixel(0, 0, "red") end)
+emu.registerbefore(function() memory.writebyte(0x301, frames) end)
+while true do emu.frameadvance() end
+`, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Done() {
+		t.Error("a script in an endless loop is done")
+	}
+	if got := s.console.Peek(0x301); got != 2 {
+		t.Errorf("registerbefore wrote %d, want the 2 frames drawn before the third", got)
+	}
+	if got := s.console.Frame()[0]; got != s.canvas.index(colorNames["red"]) {
+		t.Errorf("gui.register didn't draw on the frame, pixel 0 is $%02X", got)
+	}
+
+	if _, _, err := runScript(t, `gui.pixel(0, 0, "nope")`, 1); err == nil || !strings.Contains(err.Error(), "bad colour") {
+		t.Errorf("error %v for a bad colour", err)
+	}
+}
@@ -8,7 +8,27 @@ import (
 )
 
 func main() {
-	//progArgs := os.Args
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "headless":
+			os.Exit(runHeadless(os.Args[2:]))
+		case "debug":
+			os.Exit(runDebug(os.Args[2:]))
+		case "dap":
+			os.Exit(runDAP(os.Args[2:]))
+		case "disasm":
+			os.Exit(runDisasm(os.Args[2:]))
+		case "ppuview":
+			os.Exit(runPPUView(os.Args[2:]))
+		case "info":
+			os.Exit(runInfo(os.Args[2:]))
+		case "patch":
+			os.Exit(runPatch(os.Args[2:]))
+		case "nsf":
+			os.Exit(runNSF(os.Args[2:]))
+		}
+	}
+
 	file, err := os.Open("./hardware/nestest.nes")
 	if err != nil {
 		panic(err)
@@ -0,0 +1,316 @@
+// Package movie records and plays back per-frame controller input using the
+// FCEUX .fm2 text format https://fceux.com/web/FM2.html
+package movie
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// Command is the first field of an input record
+type Command uint8
+
+const (
+	CommandSoftReset Command = 1 << iota
+	CommandHardReset
+	CommandFDSInsert
+	CommandFDSSelect
+	CommandVSCoin
+)
+
+// port types from the header, only gamepads are supported
+const (
+	PortNone    = 0
+	PortGamepad = 1
+)
+
+// Frame is one input record
+type Frame struct {
+	Commands Command
+	Ports    [2]hardware.Buttons
+}
+
+// Checkpoint records a hash of RAM at the end of a frame, used to detect
+// desyncs on playback. FCEUX ignores header keys it doesn't know about, so
+// checkpoints are stored as extra "checkpoint <frame> <hash>" header lines.
+type Checkpoint struct {
+	Frame   int // frame number, counting from 1
+	RAMHash uint32
+}
+
+type Movie struct {
+	Version       int
+	EmuVersion    int
+	RerecordCount int
+	PAL           bool
+	ROMFilename   string
+	ROMChecksum   [md5.Size]uint8
+	GUID          string
+	Ports         [3]int
+	FDS           bool
+	NewPPU        bool
+	Comments      []string
+
+	// SaveState is set when the movie starts from a save state instead of
+	// power on. It holds a state in this emulator's format, not FCEUX's.
+	SaveState []uint8
+
+	Frames      []Frame
+	Checkpoints []Checkpoint
+}
+
+// ROMChecksum computes the checksum FCEUX stores in romChecksum, an MD5 of
+// the ROM data without the iNES header
+func ROMChecksum(cart *hardware.Cartridge) [md5.Size]uint8 {
+	h := md5.New()
+	h.Write(cart.PRG)
+	if !cart.HasCHRRAM() {
+		h.Write(cart.CHR)
+	}
+	var sum [md5.Size]uint8
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// New creates an empty movie for cart with two gamepads plugged in
+func New(cart *hardware.Cartridge, romFilename string) *Movie {
+	return &Movie{
+		Version:     3,
+		ROMFilename: romFilename,
+		ROMChecksum: ROMChecksum(cart),
+		GUID:        newGUID(),
+		Ports:       [3]int{PortGamepad, PortGamepad, PortNone},
+	}
+}
+
+func newGUID() string {
+	var b [16]uint8
+	rand.Read(b[:])
+	return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// gamepad buttons as they appear in an input record, left to right
+var fm2Buttons = [8]hardware.Buttons{
+	hardware.ButtonRight,
+	hardware.ButtonLeft,
+	hardware.ButtonDown,
+	hardware.ButtonUp,
+	hardware.ButtonStart,
+	hardware.ButtonSelect,
+	hardware.ButtonB,
+	hardware.ButtonA,
+}
+
+const fm2ButtonLetters = "RLDUTSBA"
+
+func parseBool(value string) bool {
+	return value != "" && value != "0"
+}
+
+// Read parses a text .fm2 movie
+func Read(r io.Reader) (*Movie, error) {
+	m := &Movie{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]uint8, 0, 64*1024), 16*1024*1024) // savestate lines can be long
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if line[0] == '|' {
+			frame, err := parseRecord(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			m.Frames = append(m.Frames, frame)
+			continue
+		}
+		if err := m.parseHeader(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if m.Version == 0 {
+		return nil, errors.New("not an fm2 movie: missing version")
+	}
+	return m, nil
+}
+
+func (m *Movie) parseHeader(line string) error {
+	key, value, _ := strings.Cut(line, " ")
+	var err error
+	switch key {
+	case "version":
+		m.Version, err = strconv.Atoi(value)
+	case "emuVersion":
+		m.EmuVersion, err = strconv.Atoi(value)
+	case "rerecordCount":
+		m.RerecordCount, err = strconv.Atoi(value)
+	case "palFlag":
+		m.PAL = parseBool(value)
+	case "romFilename":
+		m.ROMFilename = value
+	case "romChecksum":
+		var sum []uint8
+		sum, err = decodeBase64(value)
+		if err == nil && len(sum) != md5.Size {
+			err = errors.New("romChecksum is not an MD5")
+		}
+		copy(m.ROMChecksum[:], sum)
+	case "guid":
+		m.GUID = value
+	case "port0", "port1", "port2":
+		m.Ports[key[4]-'0'], err = strconv.Atoi(value)
+	case "FDS":
+		m.FDS = parseBool(value)
+	case "NewPPU":
+		m.NewPPU = parseBool(value)
+	case "comment":
+		m.Comments = append(m.Comments, value)
+	case "savestate":
+		m.SaveState, err = decodeBase64(value)
+	case "binary":
+		if parseBool(value) {
+			err = errors.New("binary fm2 movies are not supported")
+		}
+	case "fourscore", "microphone":
+		if parseBool(value) {
+			err = fmt.Errorf("%s is not supported", key)
+		}
+	case "checkpoint":
+		var cp Checkpoint
+		if _, err = fmt.Sscanf(value, "%d %x", &cp.Frame, &cp.RAMHash); err == nil {
+			m.Checkpoints = append(m.Checkpoints, cp)
+		}
+	}
+	// unknown keys are ignored, as FCEUX does
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// FCEUX writes binary blobs either as base64:... or as 0x... hex
+func decodeBase64(value string) ([]uint8, error) {
+	if rest, ok := strings.CutPrefix(value, "base64:"); ok {
+		return base64.StdEncoding.DecodeString(rest)
+	}
+	if rest, ok := strings.CutPrefix(value, "0x"); ok {
+		out := make([]uint8, len(rest)/2)
+		for i := range out {
+			b, err := strconv.ParseUint(rest[i*2:i*2+2], 16, 8)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = uint8(b)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q", value)
+}
+
+func parseRecord(line string) (Frame, error) {
+	var frame Frame
+	fields := strings.Split(line, "|")
+	// a record looks like |commands|port0|port1|port2| so splitting gives empty ends
+	if len(fields) < 5 {
+		return frame, fmt.Errorf("malformed input record %q", line)
+	}
+	commands, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return frame, fmt.Errorf("malformed commands in %q", line)
+	}
+	frame.Commands = Command(commands)
+	for port := 0; port < 2; port++ {
+		field := fields[2+port]
+		if field == "" {
+			continue
+		}
+		if len(field) != len(fm2Buttons) {
+			return frame, fmt.Errorf("malformed gamepad field %q", field)
+		}
+		for i, ch := range []uint8(field) {
+			if ch != '.' && ch != ' ' {
+				frame.Ports[port] |= fm2Buttons[i]
+			}
+		}
+	}
+	return frame, nil
+}
+
+func formatGamepad(b hardware.Buttons) string {
+	var out [8]uint8
+	for i, button := range fm2Buttons {
+		out[i] = '.'
+		if b&button != 0 {
+			out[i] = fm2ButtonLetters[i]
+		}
+	}
+	return string(out[:])
+}
+
+func formatBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Write encodes the movie in the text .fm2 format
+func (m *Movie) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "version %d\n", m.Version)
+	fmt.Fprintf(bw, "emuVersion %d\n", m.EmuVersion)
+	fmt.Fprintf(bw, "rerecordCount %d\n", m.RerecordCount)
+	fmt.Fprintf(bw, "palFlag %d\n", formatBool(m.PAL))
+	fmt.Fprintf(bw, "romFilename %s\n", m.ROMFilename)
+	fmt.Fprintf(bw, "romChecksum base64:%s\n", base64.StdEncoding.EncodeToString(m.ROMChecksum[:]))
+	fmt.Fprintf(bw, "guid %s\n", m.GUID)
+	fmt.Fprintf(bw, "fourscore 0\n")
+	fmt.Fprintf(bw, "microphone 0\n")
+	for i, port := range m.Ports {
+		fmt.Fprintf(bw, "port%d %d\n", i, port)
+	}
+	fmt.Fprintf(bw, "FDS %d\n", formatBool(m.FDS))
+	fmt.Fprintf(bw, "NewPPU %d\n", formatBool(m.NewPPU))
+	for _, comment := range m.Comments {
+		fmt.Fprintf(bw, "comment %s\n", comment)
+	}
+	if m.SaveState != nil {
+		fmt.Fprintf(bw, "savestate base64:%s\n", base64.StdEncoding.EncodeToString(m.SaveState))
+	}
+	// writing must not reorder the caller's movie, sort a copy
+	checkpoints := append([]Checkpoint(nil), m.Checkpoints...)
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Frame < checkpoints[j].Frame
+	})
+	for _, cp := range checkpoints {
+		fmt.Fprintf(bw, "checkpoint %d %08x\n", cp.Frame, cp.RAMHash)
+	}
+
+	for _, frame := range m.Frames {
+		fmt.Fprintf(bw, "|%d|", frame.Commands)
+		for port := 0; port < 2; port++ {
+			if m.Ports[port] == PortGamepad {
+				bw.WriteString(formatGamepad(frame.Ports[port]))
+			}
+			bw.WriteString("|")
+		}
+		bw.WriteString("|\n")
+	}
+	return bw.Flush()
+}
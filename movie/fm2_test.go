@@ -0,0 +1,49 @@
+package movie
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+func TestWriteRead(t *testing.T) {
+	m := &Movie{
+		Version:     3,
+		EmuVersion:  22020,
+		ROMFilename: "nestest",
+		GUID:        "452DE2C3-EF43-2FA9-77AC-0677FC51543B",
+		Ports:       [3]int{PortGamepad, PortGamepad, 0},
+		Comments:    []string{"author nobody"},
+		Frames: []Frame{
+			{Commands: CommandHardReset},
+			{Ports: [2]hardware.Buttons{hardware.ButtonStart, 0}},
+			{Ports: [2]hardware.Buttons{hardware.ButtonA | hardware.ButtonRight, hardware.ButtonB}},
+		},
+		Checkpoints: []Checkpoint{{Frame: 3, RAMHash: 0xDEADBEEF}, {Frame: 1, RAMHash: 0x01020304}},
+	}
+	original := append([]Checkpoint(nil), m.Checkpoints...)
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Checkpoints, original) {
+		t.Errorf("Write reordered the movie's checkpoints to %v", m.Checkpoints)
+	}
+
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Checkpoint{original[1], original[0]}; !reflect.DeepEqual(got.Checkpoints, want) {
+		t.Errorf("checkpoints read back as %v, want %v", got.Checkpoints, want)
+	}
+	if !reflect.DeepEqual(got.Frames, m.Frames) {
+		t.Errorf("frames read back as %v, want %v", got.Frames, m.Frames)
+	}
+	if got.GUID != m.GUID || got.ROMFilename != m.ROMFilename || !reflect.DeepEqual(got.Comments, m.Comments) {
+		t.Errorf("header read back as %+v", got)
+	}
+}
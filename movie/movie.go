@@ -0,0 +1,195 @@
+package movie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// DefaultCheckpointInterval is how often, in frames, a recording stores a RAM hash
+const DefaultCheckpointInterval = 60
+
+var ErrROMMismatch = errors.New("movie was recorded with a different ROM")
+
+// DesyncError reports that playback diverged from the recording
+type DesyncError struct {
+	Frame     int
+	Want, Got uint32
+}
+
+func (e *DesyncError) Error() string {
+	return fmt.Sprintf("desync at frame %d: RAM hash %08x, recorded %08x", e.Frame, e.Got, e.Want)
+}
+
+// RAMHash hashes the 2 KiB of internal RAM
+func RAMHash(console *hardware.Console) uint32 {
+	var ram [0x800]uint8
+	for i := range ram {
+		ram[i] = console.Peek(uint16(i))
+	}
+	return crc32.ChecksumIEEE(ram[:])
+}
+
+// apply carries out the commands of a frame on the console
+func apply(console *hardware.Console, cmd Command) {
+	switch {
+	case cmd&CommandHardReset != 0:
+		console.PowerCycle()
+	case cmd&CommandSoftReset != 0:
+		console.Reset()
+	}
+}
+
+// Recorder captures input as the console runs. Each frame call BeginFrame,
+// emulate the frame, then call EndFrame, or use Step to do all three.
+type Recorder struct {
+	console *hardware.Console
+	movie   *Movie
+
+	// CheckpointInterval sets how often a RAM hash is recorded, 0 disables them
+	CheckpointInterval int
+}
+
+// NewRecorder starts a recording. With fromState the movie begins at the
+// console's current state, otherwise the console is power cycled first.
+func NewRecorder(console *hardware.Console, movie *Movie, fromState bool) (*Recorder, error) {
+	if fromState {
+		var buf bytes.Buffer
+		if err := console.SaveState(&buf); err != nil {
+			return nil, err
+		}
+		movie.SaveState = buf.Bytes()
+	} else {
+		movie.SaveState = nil
+		console.PowerCycle()
+	}
+	movie.Frames = movie.Frames[:0]
+	movie.Checkpoints = movie.Checkpoints[:0]
+	return &Recorder{console: console, movie: movie, CheckpointInterval: DefaultCheckpointInterval}, nil
+}
+
+func (r *Recorder) Movie() *Movie {
+	return r.movie
+}
+
+// BeginFrame performs cmd and records the buttons currently held on both
+// controllers as the input of the next frame
+func (r *Recorder) BeginFrame(cmd Command) {
+	frame := Frame{Commands: cmd}
+	for port := range frame.Ports {
+		frame.Ports[port] = r.console.Joypad(port).Buttons()
+	}
+	// a power cycle releases every button, hold them again as recorded
+	apply(r.console, cmd)
+	for port, buttons := range frame.Ports {
+		r.console.Joypad(port).SetButtons(buttons)
+	}
+	r.movie.Frames = append(r.movie.Frames, frame)
+}
+
+// EndFrame stores a checkpoint if one is due
+func (r *Recorder) EndFrame() {
+	frame := len(r.movie.Frames)
+	if r.CheckpointInterval > 0 && frame%r.CheckpointInterval == 0 {
+		r.movie.Checkpoints = append(r.movie.Checkpoints, Checkpoint{Frame: frame, RAMHash: RAMHash(r.console)})
+	}
+}
+
+func (r *Recorder) Step(cmd Command) {
+	r.BeginFrame(cmd)
+	r.console.StepFrame()
+	r.EndFrame()
+}
+
+// Rerecord rewinds the recording to the given number of frames, for use
+// after loading a save state made at that point, and bumps the rerecord count
+func (r *Recorder) Rerecord(frames int) {
+	if frames < len(r.movie.Frames) {
+		r.movie.Frames = r.movie.Frames[:frames]
+	}
+	kept := r.movie.Checkpoints[:0]
+	for _, cp := range r.movie.Checkpoints {
+		if cp.Frame <= frames {
+			kept = append(kept, cp)
+		}
+	}
+	r.movie.Checkpoints = kept
+	r.movie.RerecordCount++
+}
+
+// Player feeds a movie's input into the console
+type Player struct {
+	console     *hardware.Console
+	movie       *Movie
+	frame       int // frames played so far
+	checkpoints map[int]uint32
+}
+
+// NewPlayer checks that the movie belongs to the console's ROM and puts the
+// console in the movie's starting state
+func NewPlayer(console *hardware.Console, movie *Movie) (*Player, error) {
+	if ROMChecksum(console.Cartridge()) != movie.ROMChecksum {
+		return nil, ErrROMMismatch
+	}
+	if movie.SaveState != nil {
+		if err := console.LoadState(bytes.NewReader(movie.SaveState)); err != nil {
+			return nil, fmt.Errorf("movie save state: %w", err)
+		}
+	} else {
+		console.PowerCycle()
+	}
+	p := &Player{console: console, movie: movie, checkpoints: map[int]uint32{}}
+	for _, cp := range movie.Checkpoints {
+		p.checkpoints[cp.Frame] = cp.RAMHash
+	}
+	return p, nil
+}
+
+// Done reports whether every frame of the movie has been played
+func (p *Player) Done() bool {
+	return p.frame >= len(p.movie.Frames)
+}
+
+// Frame returns the number of frames played so far
+func (p *Player) Frame() int {
+	return p.frame
+}
+
+// BeginFrame applies the commands and input of the next frame, it returns
+// io.EOF once the movie is over
+func (p *Player) BeginFrame() error {
+	if p.Done() {
+		return io.EOF
+	}
+	frame := p.movie.Frames[p.frame]
+	apply(p.console, frame.Commands)
+	for port, buttons := range frame.Ports {
+		p.console.Joypad(port).SetButtons(buttons)
+	}
+	p.frame++
+	return nil
+}
+
+// EndFrame compares RAM against the checkpoint for the frame just played
+func (p *Player) EndFrame() error {
+	want, ok := p.checkpoints[p.frame]
+	if !ok {
+		return nil
+	}
+	if got := RAMHash(p.console); got != want {
+		return &DesyncError{Frame: p.frame, Want: want, Got: got}
+	}
+	return nil
+}
+
+func (p *Player) Step() error {
+	if err := p.BeginFrame(); err != nil {
+		return err
+	}
+	p.console.StepFrame()
+	return p.EndFrame()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tejasdeepakmasne/nesemu-go/audio"
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+	"github.com/tejasdeepakmasne/nesemu-go/nsf"
+)
+
+const nsfUsage = `usage: nesemu-go nsf [flags] file.nsf
+
+Shows the tags of an NSF or NSFe rip and plays a track: INIT is called with
+the track number, then PLAY at the rate the file gives. -out renders the
+track through the APU to a 16 bit mono WAV file. Expansion chips are not
+synthesized, their channels are missing from the WAV, but -log records every
+write to the sound registers, theirs included, as a "cycle address value"
+line, for checking a rip or feeding another synthesizer.
+
+`
+
+func runNSF(args []string) int {
+	fs := flag.NewFlagSet("nsf", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), nsfUsage)
+		fs.PrintDefaults()
+	}
+	track := fs.Int("track", 0, "track to play, counting from 1 (default the file's first track)")
+	seconds := fs.Float64("seconds", 120, "how long to play")
+	out := fs.String("out", "", "render the track to this .wav file")
+	logPath := fs.String("log", "", "write the sound register writes to this file")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError
+	}
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	cart, err := hardware.ParseNSF(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return exitError
+	}
+	info := cart.NSF()
+	printNSF(info)
+	if *track == 0 {
+		*track = info.StartSong
+	}
+
+	player, err := nsf.NewPlayer(cart)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	writes := 0
+	var log *bufio.Writer
+	if *logPath != "" {
+		file, err := os.Create(*logPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		defer file.Close()
+		log = bufio.NewWriter(file)
+	}
+	player.Console().Hooks().OnWrite(0x4000, 0xFFFF, func(kind hardware.AccessKind, address uint16, value uint8) {
+		if !nsf.SoundRegister(address, info.Chips) {
+			return
+		}
+		writes++
+		if log != nil {
+			fmt.Fprintf(log, "%d $%04X $%02X\n", player.Cycle(), address, value)
+		}
+	})
+
+	var wav *audio.WAVWriter
+	if *out != "" {
+		if wav, err = audio.CreateWAV(*out, audio.SampleRate); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		player.Console().SetAudioRate(audio.SampleRate)
+	}
+
+	err = player.Start(*track)
+	// play a second at a time so the samples don't pile up
+	for second := 1.0; err == nil; second++ {
+		err = player.Play(min(second, *seconds))
+		if wav != nil && err == nil {
+			err = wav.WriteSamples(player.Console().ReadAudio())
+		}
+		if second >= *seconds {
+			break
+		}
+	}
+	if wav != nil {
+		if closeErr := wav.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if log != nil {
+		if err := log.Flush(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+	fmt.Printf("played track %d for %.1f seconds, %d sound register writes\n", *track, player.Seconds(), writes)
+	return exitPass
+}
+
+func printNSF(info *hardware.NSF) {
+	for _, tag := range []struct{ name, value string }{
+		{"title", info.Title},
+		{"artist", info.Artist},
+		{"copyright", info.Copyright},
+		{"ripper", info.Ripper},
+	} {
+		if tag.value != "" {
+			fmt.Printf("%-10s %s\n", tag.name, tag.value)
+		}
+	}
+	fmt.Printf("%-10s %d, starting at %d\n", "tracks", info.Songs, info.StartSong)
+	chips := strings.Join(info.ChipNames(), ", ")
+	if chips == "" {
+		chips = "none"
+	}
+	fmt.Printf("%-10s %s\n", "chips", chips)
+	banking := "not banked"
+	if info.Banked() {
+		banking = fmt.Sprintf("banks % X", info.Banks[:])
+	}
+	fmt.Printf("%-10s load $%04X init $%04X play $%04X at %.2f Hz, %s\n", "driver",
+		info.LoadAddress, info.InitAddress, info.PlayAddress, 1e6/float64(info.PlaySpeed), banking)
+
+	for i, name := range info.TrackNames {
+		length := ""
+		if i < len(info.TrackTimes) && info.TrackTimes[i] >= 0 {
+			length = (time.Duration(info.TrackTimes[i]) * time.Millisecond).String()
+		}
+		fmt.Println(strings.TrimRight(fmt.Sprintf("%3d %-40s %s", i+1, name, length), " "))
+	}
+	if len(info.Playlist) > 0 {
+		fmt.Printf("%-10s %v\n", "playlist", info.Playlist)
+	}
+}
@@ -0,0 +1,130 @@
+// Package nsf plays NSF and NSFe music rips. The Player stands in for the
+// small driver an NSF player runs on the console: it calls the tune's INIT
+// routine once with the song number, then its PLAY routine at the rate the
+// file asks for. Between calls the CPU idles while the APU keeps playing.
+package nsf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+var ErrNotNSF = errors.New("not an NSF cartridge")
+
+// a routine that runs for longer than this many CPU cycles, about two
+// seconds, is taken to be stuck
+const maxCallCycles = 4_000_000
+
+// where INIT and PLAY return to. Nothing is executed there, the player stops
+// as soon as the routine's RTS lands on it.
+const returnAddress = 0x4100
+
+type Player struct {
+	console *hardware.Console
+	info    *hardware.NSF
+	song    int
+	period  uint64 // CPU cycles between PLAY calls
+	start   uint64 // console cycles when the song started
+}
+
+func NewPlayer(cart *hardware.Cartridge) (*Player, error) {
+	info := cart.NSF()
+	if info == nil {
+		return nil, ErrNotNSF
+	}
+	return &Player{
+		console: hardware.NewConsole(cart),
+		info:    info,
+		period:  uint64(info.PlaySpeed) * hardware.CPU_FREQUENCY / 1_000_000,
+	}, nil
+}
+
+// Console is the machine the tune runs on, e.g. for registering hooks
+func (p *Player) Console() *hardware.Console {
+	return p.console
+}
+
+// Cycle returns how far playback is, in NTSC CPU cycles since Start
+func (p *Player) Cycle() uint64 {
+	return p.console.Cycles() - p.start
+}
+
+// Seconds returns how far playback is in seconds
+func (p *Player) Seconds() float64 {
+	return float64(p.Cycle()) / hardware.CPU_FREQUENCY
+}
+
+// Song returns the song being played, counting from 1
+func (p *Player) Song() int {
+	return p.song
+}
+
+// Start resets the console and runs INIT for a song, counting from 1
+func (p *Player) Start(song int) error {
+	if song < 1 || song > p.info.Songs {
+		return fmt.Errorf("no song %d, the file has %d", song, p.info.Songs)
+	}
+	// power cycling clears RAM and sets the banks back to their initial values
+	p.console.PowerCycle()
+	p.song = song
+	p.start = p.console.Cycles()
+	// X selects NTSC
+	return p.call(p.info.InitAddress, uint8(song-1), 0)
+}
+
+// Play runs PLAY at the tune's rate until playback reaches the given number
+// of seconds
+func (p *Player) Play(seconds float64) error {
+	if p.song == 0 {
+		return errors.New("no song started")
+	}
+	end := uint64(seconds * hardware.CPU_FREQUENCY)
+	for p.Cycle() < end {
+		// PLAY starts on every period boundary, a call that runs past one
+		// misses it as it would on the console
+		next := (p.Cycle()/p.period + 1) * p.period
+		if next >= end {
+			p.console.Idle(int(end - p.Cycle()))
+			break
+		}
+		p.console.Idle(int(next - p.Cycle()))
+		if err := p.call(p.info.PlayAddress, 0, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call runs a routine as if the driver had JSRed to it
+func (p *Player) call(address uint16, a, x uint8) error {
+	cpu := p.console.CPU()
+	regs := cpu.Registers()
+	regs.A, regs.X, regs.Y = a, x, 0
+	regs.SetFlag(hardware.I, true)
+	regs.SP = 0xFD
+	p.console.Poke(hardware.STACK_START+uint16(regs.SP), uint8((returnAddress-1)>>8))
+	regs.SP--
+	p.console.Poke(hardware.STACK_START+uint16(regs.SP), uint8((returnAddress-1)&0xFF))
+	regs.SP--
+	regs.PC = address
+	cpu.SetRegisters(regs)
+
+	// the routine has returned once its RTS pops the return address, a
+	// routine that only pulls from the stack is still running
+	start := p.console.Cycles()
+	for {
+		regs := cpu.Registers()
+		if regs.PC == returnAddress && regs.SP == 0xFD {
+			return nil
+		}
+		if err := cpu.Halted(); err != nil {
+			return err
+		}
+		if p.console.Cycles()-start > maxCallCycles {
+			return fmt.Errorf("routine at $%04X didn't return", address)
+		}
+		p.console.Step()
+	}
+}
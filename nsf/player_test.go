@@ -0,0 +1,123 @@
+package nsf
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/asm"
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// tune is an NSF whose INIT starts a square wave on pulse 1 and whose PLAY
+// counts its calls at $00. INIT takes its return address off the stack and
+// puts it back, which must not end the call early.
+const tune = `
+init:	PLA
+	TAX
+	PLA
+	TAY
+	LDA #$01
+	STA $4015
+	STA $01
+	LDA #$BF	// duty 2, length halted, constant volume 15
+	STA $4000
+	LDA #$FD	// 440 Hz
+	STA $4002
+	LDA #$F8
+	STA $4003
+	TYA
+	PHA
+	TXA
+	PHA
+	RTS
+play:	INC $00
+	RTS
+`
+
+// tuneNSF builds the NSF file of tune with a song count and start song
+func tuneNSF(songs, start uint8) []uint8 {
+	p := asm.MustAssemble(tune)
+	header := make([]uint8, hardware.NSF_HEADER_SIZE)
+	copy(header, "NESM\x1A\x01")
+	header[0x06], header[0x07] = songs, start
+	binary.LittleEndian.PutUint16(header[0x08:], p.Origin)
+	binary.LittleEndian.PutUint16(header[0x0A:], p.Symbols["init"])
+	binary.LittleEndian.PutUint16(header[0x0C:], p.Symbols["play"])
+	binary.LittleEndian.PutUint16(header[0x6E:], 16639)
+	return append(header, p.Code...)
+}
+
+func newTune(t *testing.T) *Player {
+	t.Helper()
+	cart, err := hardware.ParseNSF(tuneNSF(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	player, err := NewPlayer(cart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return player
+}
+
+func TestPlay(t *testing.T) {
+	player := newTune(t)
+	player.Console().SetAudioRate(44100)
+	if err := player.Start(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := player.Console().Peek(0x01); got != 1 {
+		t.Fatalf("INIT returned early, $01 = %d", got)
+	}
+	if err := player.Play(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := player.Seconds(); got < 1 || got > 1.001 {
+		t.Errorf("played %f seconds, want 1", got)
+	}
+	// PLAY runs on every period boundary within the second
+	if got := player.Console().Peek(0x00); got != 60 {
+		t.Errorf("PLAY called %d times, want 60", got)
+	}
+
+	samples := player.Console().ReadAudio()
+	if len(samples) < 44090 || len(samples) > 44110 {
+		t.Fatalf("%d samples, want 44100", len(samples))
+	}
+	// count the rising zero crossings of the square wave
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 0 && samples[i] >= 0 {
+			crossings++
+		}
+	}
+	if crossings < 435 || crossings > 445 {
+		t.Errorf("%d cycles a second, want 440", crossings)
+	}
+}
+
+func TestStartBadSong(t *testing.T) {
+	if err := newTune(t).Start(2); err == nil {
+		t.Error("started song 2 of 1")
+	}
+}
+
+func TestParseSongCount(t *testing.T) {
+	tests := []struct {
+		songs, start uint8
+		ok           bool
+	}{
+		{1, 1, true},
+		{3, 3, true},
+		{0, 1, false},
+		{0, 0, false},
+		{1, 0, false},
+		{2, 3, false},
+	}
+	for _, tt := range tests {
+		_, err := hardware.ParseNSF(tuneNSF(tt.songs, tt.start))
+		if (err == nil) != tt.ok {
+			t.Errorf("song %d of %d: error %v", tt.start, tt.songs, err)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package nsf
+
+import "github.com/tejasdeepakmasne/nesemu-go/hardware"
+
+// SoundRegister reports whether a CPU write to address goes to the APU or
+// to one of the expansion chips a tune uses
+func SoundRegister(address uint16, chips uint8) bool {
+	switch {
+	case address >= 0x4000 && address <= 0x4013, address == 0x4015, address == 0x4017:
+		return true
+	case chips&hardware.NSFChipFDS != 0 && address >= 0x4040 && address <= 0x408A:
+		return true
+	case chips&hardware.NSFChipMMC5 != 0 && address >= 0x5000 && address <= 0x5015:
+		return true
+	case chips&hardware.NSFChipN163 != 0 && (address == 0x4800 || address == 0xF800):
+		return true
+	case chips&hardware.NSFChipVRC7 != 0 && (address == 0x9010 || address == 0x9030):
+		return true
+	case chips&hardware.NSFChipSunsoft5B != 0 && (address == 0xC000 || address == 0xE000):
+		return true
+	case chips&hardware.NSFChipVRC6 != 0:
+		return address >= 0x9000 && address <= 0x9003 || address >= 0xA000 && address <= 0xA002 || address >= 0xB000 && address <= 0xB002
+	}
+	return false
+}
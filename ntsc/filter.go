@@ -0,0 +1,190 @@
+// Package ntsc simulates the NES's composite video output in software, in
+// the spirit of blargg's nes_ntsc. Each line is turned into the signal the
+// PPU would send, 8 samples per pixel at 12 samples per colour subcarrier
+// cycle, and decoded again by a simple TV. Luma that is not fully separated
+// from chroma gives the artifacts and dot crawl, chroma that picks up luma
+// edges gives the fringing.
+//
+// Everything runs on the CPU. The kernels are computed once by New, so a
+// frame costs a few multiply-adds per sample.
+package ntsc
+
+import (
+	"image"
+	"math"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// OutputWidth is the width of a filtered picture, the 256 pixels of a line
+// at the aspect ratio of a TV
+const OutputWidth = 602
+
+// samples of the composite signal per pixel and per subcarrier cycle
+const (
+	samplesPerPixel = 8
+	samplesPerCycle = 12
+	lineSamples     = hardware.SCREEN_WIDTH * samplesPerPixel
+	margin          = 3 * samplesPerCycle
+)
+
+// Params tune the filter. Sharpness, Artifacts and Fringing range from -1
+// to 1, 0 being an ordinary composite connection. The colour settings are
+// those of the NTSC palette generator.
+type Params struct {
+	hardware.NTSCParams
+	Sharpness float64 // luma detail, negative blurs
+	Artifacts float64 // how much chroma leaks into luma, -1 removes it
+	Fringing  float64 // how much luma leaks into chroma, -1 removes it
+}
+
+var (
+	Composite = Params{NTSCParams: hardware.DefaultNTSCParams}
+	SVideo    = Params{NTSCParams: hardware.DefaultNTSCParams, Sharpness: 0.2, Artifacts: -1, Fringing: -1}
+)
+
+// kernel weights the samples around an output pixel
+type kernel struct {
+	start   int // first sample, may be outside the line
+	weights []float64
+}
+
+type Filter struct {
+	params Params
+	luma   [OutputWidth]kernel
+	chroma [OutputWidth]kernel
+	cos    [samplesPerCycle]float64
+	sin    [samplesPerCycle]float64
+
+	// signal levels by colour index, emphasis and phase
+	levels [512][samplesPerCycle]float64
+	// part of each colour's luma taken out of the signal before chroma is
+	// demodulated
+	chromaOffset [512]float64
+}
+
+func New(params Params) *Filter {
+	f := &Filter{params: params}
+	for phase := 0; phase < samplesPerCycle; phase++ {
+		angle := params.Angle(phase)
+		f.cos[phase], f.sin[phase] = math.Cos(angle), math.Sin(angle)
+	}
+	// Below 0, Fringing takes the luma of each colour out of the signal
+	// chroma is taken from, as an S-Video cable carries it apart, so that
+	// at -1 no luma edge shows up as colour.
+	separation := 1 - min(max(params.Fringing+1, 0), 1)
+	for entry := range f.levels {
+		luma := 0.0
+		for phase := range f.levels[entry] {
+			f.levels[entry][phase] = params.Signal(uint8(entry), uint8(entry>>6), phase)
+			luma += f.levels[entry][phase] / samplesPerCycle
+		}
+		f.chromaOffset[entry] = separation * luma
+	}
+
+	// A box over one whole cycle cancels the subcarrier and leaves clean
+	// luma. Artifacts mixes in a narrow kernel that lets some chroma through,
+	// sharpness sets its width. Chroma is averaged over one to two cycles,
+	// the shorter the window the more a luma edge shows up as colour.
+	artifacts := (min(max(params.Artifacts, -1), 1) + 1) / 2
+	narrow := 6 - 3*min(max(params.Sharpness, -1), 1)
+	chromaWidth := samplesPerCycle * (2 - (min(max(params.Fringing, -1), 1)+1)/2)
+	for x := range f.luma {
+		center := (float64(x) + 0.5) * lineSamples / OutputWidth
+		wide := triangle(center, samplesPerCycle)
+		f.luma[x] = mix(wide, triangle(center, narrow), artifacts)
+		f.chroma[x] = triangle(center, chromaWidth)
+	}
+	return f
+}
+
+// triangle builds a normalised triangular kernel of the given half width
+// around a sample position
+func triangle(center, halfWidth float64) kernel {
+	halfWidth = math.Max(halfWidth, 1)
+	k := kernel{start: int(math.Ceil(center - halfWidth))}
+	total := 0.0
+	for s := k.start; float64(s) < center+halfWidth; s++ {
+		w := 1 - math.Abs(float64(s)+0.5-center)/halfWidth
+		if w < 0 {
+			w = 0
+		}
+		k.weights = append(k.weights, w)
+		total += w
+	}
+	for i := range k.weights {
+		k.weights[i] /= total
+	}
+	return k
+}
+
+// mix blends two kernels, amount 0 gives a and 1 gives b
+func mix(a, b kernel, amount float64) kernel {
+	start := min(a.start, b.start)
+	end := max(a.start+len(a.weights), b.start+len(b.weights))
+	k := kernel{start: start, weights: make([]float64, end-start)}
+	for i, w := range a.weights {
+		k.weights[a.start-start+i] += w * (1 - amount)
+	}
+	for i, w := range b.weights {
+		k.weights[b.start-start+i] += w * amount
+	}
+	return k
+}
+
+// Render filters the console's most recent frame into an OutputWidth by
+// 240 picture
+func (f *Filter) Render(console *hardware.Console) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, OutputWidth, hardware.SCREEN_HEIGHT))
+	frame := console.Frame()
+	for y := 0; y < hardware.SCREEN_HEIGHT; y++ {
+		pixels := frame[y*hardware.SCREEN_WIDTH : (y+1)*hardware.SCREEN_WIDTH]
+		row := img.Pix[y*img.Stride : (y+1)*img.Stride]
+		f.RenderLine(row, pixels, console.LineEmphasis(y), console.LinePhase(y))
+	}
+	return img
+}
+
+// RenderLine filters one line of colour indices drawn with the given
+// emphasis bits and starting subcarrier phase into OutputWidth RGBA pixels
+func (f *Filter) RenderLine(out []uint8, pixels []uint8, emphasis, phase uint8) {
+	// the signal runs on past both ends of the line in the edge colours, far
+	// enough for the widest kernel
+	var signal, chroma [lineSamples + 2*margin]float64
+	var phases [lineSamples + 2*margin]uint8
+	for n := range signal {
+		s := n - margin
+		x := min(max(s/samplesPerPixel, 0), len(pixels)-1)
+		entry := uint16(emphasis&0x07)<<6 | uint16(pixels[x]&0x3F)
+		// pixel 0 is output during dot 1
+		p := (int(phase) + samplesPerPixel + s%samplesPerCycle + samplesPerCycle) % samplesPerCycle
+		phases[n] = uint8(p)
+		signal[n] = f.levels[entry][p]
+		chroma[n] = signal[n] - f.chromaOffset[entry]
+	}
+
+	for x := 0; x < OutputWidth; x++ {
+		var y, i, q float64
+		k := f.luma[x]
+		for n, w := range k.weights {
+			y += w * signal[k.start+n+margin]
+		}
+		// the window's average is taken out first, a window that isn't
+		// whole subcarrier cycles would otherwise turn a flat level into
+		// colour
+		var mean, cos, sin float64
+		k = f.chroma[x]
+		for n, w := range k.weights {
+			v, p := chroma[k.start+n+margin], phases[k.start+n+margin]
+			mean += w * v
+			cos += w * f.cos[p]
+			sin += w * f.sin[p]
+			i += w * v * f.cos[p]
+			q += w * v * f.sin[p]
+		}
+		i -= mean * cos
+		q -= mean * sin
+		c := f.params.RGB(y, i, q)
+		out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = c.R, c.G, c.B, c.A
+	}
+}
@@ -0,0 +1,99 @@
+package ntsc
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// line fills a line with one colour, or alternates between two
+func line(colors ...uint8) []uint8 {
+	pixels := make([]uint8, hardware.SCREEN_WIDTH)
+	for x := range pixels {
+		pixels[x] = colors[x%len(colors)]
+	}
+	return pixels
+}
+
+func render(f *Filter, pixels []uint8, emphasis, phase uint8) []uint8 {
+	out := make([]uint8, OutputWidth*4)
+	f.RenderLine(out, pixels, emphasis, phase)
+	return out
+}
+
+func pixelAt(out []uint8, x int) color.RGBA {
+	return color.RGBA{out[x*4], out[x*4+1], out[x*4+2], out[x*4+3]}
+}
+
+// similar reports whether two colours differ by at most tolerance per channel
+func similar(a, b color.RGBA, tolerance int) bool {
+	diff := func(x, y uint8) bool { return int(x)-int(y) > tolerance || int(y)-int(x) > tolerance }
+	return !diff(a.R, b.R) && !diff(a.G, b.G) && !diff(a.B, b.B) && a.A == b.A
+}
+
+func TestFlatColors(t *testing.T) {
+	f := New(SVideo)
+	pal := hardware.GeneratePalette(SVideo.NTSCParams)
+	// with luma and chroma kept apart, a flat line decodes to the palette
+	// colour all the way across, the edges included
+	for _, index := range []uint8{0x0F, 0x10, 0x30, 0x12, 0x16, 0x1A, 0x27} {
+		for _, emphasis := range []uint8{0, 1, 6} {
+			out := render(f, line(index), emphasis, 4)
+			want := pal.Color(index, emphasis)
+			for _, x := range []int{0, OutputWidth / 2, OutputWidth - 1} {
+				if got := pixelAt(out, x); !similar(got, want, 2) {
+					t.Errorf("$%02X emphasis %d: pixel %d is %v, want %v", index, emphasis, x, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	composite, svideo := New(Composite), New(SVideo)
+	// a grey has no chroma for luma to be mixed up with
+	grey := line(0x10)
+	for phase := uint8(0); phase < 12; phase += 4 {
+		if a, b := render(composite, grey, 0, phase), render(svideo, grey, 0, phase); !similar(pixelAt(a, 300), pixelAt(b, 300), 2) {
+			t.Errorf("phase %d: grey is %v composite, %v over S-Video", phase, pixelAt(a, 300), pixelAt(b, 300))
+		}
+	}
+
+	// fine black and white stripes turn to colour over composite, and the
+	// colour follows the subcarrier phase the line starts on
+	stripes := line(0x30, 0x0F)
+	colorful := func(c color.RGBA) bool {
+		return max(c.R, c.G, c.B)-min(c.R, c.G, c.B) > 16
+	}
+	a, b := render(composite, stripes, 0, 0), render(composite, stripes, 0, 4)
+	if c := pixelAt(a, 300); !colorful(c) {
+		t.Errorf("stripes over composite are %v, want artifact colour", c)
+	}
+	if pixelAt(a, 300) == pixelAt(b, 300) {
+		t.Error("artifact colour doesn't change with the phase")
+	}
+	if c := pixelAt(render(svideo, stripes, 0, 0), 300); colorful(c) {
+		t.Errorf("stripes over S-Video are %v, want grey", c)
+	}
+}
+
+func TestRender(t *testing.T) {
+	cart, err := hardware.NewCartridge(make([]uint8, hardware.PRG_BANK_SIZE), make([]uint8, hardware.CHR_BANK_SIZE), 0, hardware.MirrorVertical, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	console := hardware.NewConsole(cart)
+	console.StepFrame()
+	img := New(Composite).Render(console)
+	if size := img.Rect.Size(); size.X != OutputWidth || size.Y != hardware.SCREEN_HEIGHT {
+		t.Fatalf("%v picture, want %dx%d", size, OutputWidth, hardware.SCREEN_HEIGHT)
+	}
+	// a blank frame is the backdrop, colour 0 of palette RAM, throughout
+	want := pixelAt(render(New(Composite), line(console.PaletteRAM()[0]), 0, console.LinePhase(0)), 0)
+	for _, p := range [][2]int{{0, 0}, {OutputWidth - 1, hardware.SCREEN_HEIGHT - 1}} {
+		if got := img.RGBAAt(p[0], p[1]); !similar(got, want, 2) {
+			t.Errorf("pixel %v is %v, want the backdrop %v", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+	"github.com/tejasdeepakmasne/nesemu-go/ntsc"
+)
+
+const paletteFlagHelp = "palette: a 192 or 1536 byte .pal file, or ntsc[:hue=0,saturation=1.2,contrast=1,brightness=1,gamma=1.8] to generate one (default built in)"
+
+// loadPalette reads a -palette flag, see paletteFlagHelp
+func loadPalette(spec string) (*hardware.Palette, error) {
+	if spec == "" {
+		return &hardware.DefaultPalette, nil
+	}
+	if spec != "ntsc" && !strings.HasPrefix(spec, "ntsc:") {
+		data, err := os.ReadFile(spec)
+		if err != nil {
+			return nil, err
+		}
+		pal, err := hardware.ParsePalette(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", spec, err)
+		}
+		return pal, nil
+	}
+
+	params := hardware.DefaultNTSCParams
+	_, settings, _ := strings.Cut(spec, ":")
+	if err := applySettings(settings, map[string]*float64{
+		"hue":        &params.Hue,
+		"saturation": &params.Saturation,
+		"contrast":   &params.Contrast,
+		"brightness": &params.Brightness,
+		"gamma":      &params.Gamma,
+	}); err != nil {
+		return nil, err
+	}
+	return hardware.GeneratePalette(params), nil
+}
+
+const filterFlagHelp = "render screenshots through an NTSC filter: composite or svideo, optionally followed by :sharpness=0,artifacts=0,fringing=0 and palette colour settings"
+
+// loadFilter reads a -filter flag, see filterFlagHelp. An empty spec gives
+// nil.
+func loadFilter(spec string) (*ntsc.Filter, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	preset, settings, _ := strings.Cut(spec, ":")
+	var params ntsc.Params
+	switch preset {
+	case "composite":
+		params = ntsc.Composite
+	case "svideo":
+		params = ntsc.SVideo
+	default:
+		return nil, fmt.Errorf("unknown filter %q, want composite or svideo", preset)
+	}
+	if err := applySettings(settings, map[string]*float64{
+		"hue":        &params.Hue,
+		"saturation": &params.Saturation,
+		"contrast":   &params.Contrast,
+		"brightness": &params.Brightness,
+		"gamma":      &params.Gamma,
+		"sharpness":  &params.Sharpness,
+		"artifacts":  &params.Artifacts,
+		"fringing":   &params.Fringing,
+	}); err != nil {
+		return nil, err
+	}
+	return ntsc.New(params), nil
+}
+
+// applySettings parses comma separated name=value pairs into fields
+func applySettings(settings string, fields map[string]*float64) error {
+	for _, setting := range strings.Split(settings, ",") {
+		if setting == "" {
+			continue
+		}
+		name, text, _ := strings.Cut(setting, "=")
+		field, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("bad value in setting %q", setting)
+		}
+		*field = value
+	}
+	return nil
+}
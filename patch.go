@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tejasdeepakmasne/nesemu-go/patch"
+)
+
+const patchUsage = `usage: nesemu-go patch original.nes modified.nes out.bps
+       nesemu-go patch -apply rom.nes patch.bps out.nes
+
+Creates a patch turning original.nes into modified.nes, in the IPS, BPS or
+UPS format as the extension of out says. With -apply a patch is applied
+instead and the patched ROM written out. Patches don't need to be applied
+beforehand to be played: a game.ips, game.bps or game.ups next to game.nes
+is applied when it is loaded, as are those given with -patch.
+
+`
+
+func runPatch(args []string) int {
+	fs := flag.NewFlagSet("patch", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), patchUsage)
+		fs.PrintDefaults()
+	}
+	apply := fs.Bool("apply", false, "apply a patch rather than create one")
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return exitError
+	}
+	first, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	second, err := os.ReadFile(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	var out []uint8
+	if *apply {
+		if out, err = patch.Apply(first, second); err != nil {
+			err = fmt.Errorf("%s: %w", fs.Arg(1), err)
+		}
+	} else {
+		out, err = patch.Create(fs.Arg(2), first, second)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if err := os.WriteFile(fs.Arg(2), out, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitPass
+}
@@ -0,0 +1,215 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// BPS patches https://www.romhacking.net/documents/746/ describe the target
+// as a list of actions reading from the source, the patch or the target
+// written so far, with CRC32s of all three at the end
+//
+//	"BPS1" sourceSize targetSize metadataSize metadata
+//	action*
+//	sourceCRC [4]uint8, targetCRC [4]uint8, patchCRC [4]uint8
+//
+// Numbers are variable length, see readNumber.
+var bpsMagic = []uint8("BPS1")
+
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+// BPS and UPS end with three CRC32s
+const checksumsSize = 12
+
+var ErrNotBPS = errors.New("not a BPS patch")
+
+// numberReader reads the variable length numbers of BPS and UPS: 7 bits a
+// byte, least significant first, the top bit set on the last byte
+type numberReader struct {
+	data []uint8
+	pos  int
+	end  int // where the numbers and data stop and the checksums start
+	err  error
+}
+
+func (r *numberReader) byte() uint8 {
+	if r.pos >= r.end {
+		if r.err == nil {
+			r.err = fmt.Errorf("patch truncated at offset %d", r.pos)
+		}
+		return 0
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *numberReader) number() int {
+	n, shift := 0, 1
+	for i := 0; ; i++ {
+		x := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		if i >= 8 {
+			r.err = fmt.Errorf("number too large at offset %d", r.pos)
+			return 0
+		}
+		n += int(x&0x7F) * shift
+		if x&0x80 != 0 {
+			return n
+		}
+		shift <<= 7
+		n += shift
+	}
+}
+
+func appendNumber(b []uint8, n int) []uint8 {
+	for {
+		x := uint8(n & 0x7F)
+		n >>= 7
+		if n == 0 {
+			return append(b, 0x80|x)
+		}
+		b = append(b, x)
+		n--
+	}
+}
+
+// checkPatchCRC verifies the CRC32 a BPS or UPS patch ends with, which
+// covers everything before it
+func checkPatchCRC(patch []uint8) error {
+	end := len(patch) - 4
+	if crc32.ChecksumIEEE(patch[:end]) != binary.LittleEndian.Uint32(patch[end:]) {
+		return errors.New("the patch is corrupt, its CRC32 doesn't match")
+	}
+	return nil
+}
+
+// ApplyBPS returns the target a BPS patch builds from data. The CRC32s of
+// the data, the result and the patch must all match the patch.
+func ApplyBPS(data, bps []uint8) ([]uint8, error) {
+	if !bytes.HasPrefix(bps, bpsMagic) {
+		return nil, ErrNotBPS
+	}
+	if len(bps) < len(bpsMagic)+checksumsSize {
+		return nil, errors.New("BPS patch is truncated")
+	}
+	if err := checkPatchCRC(bps); err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	footer := bps[len(bps)-checksumsSize:]
+	if crc32.ChecksumIEEE(data) != le.Uint32(footer) {
+		return nil, errors.New("the BPS patch is for a different ROM, the CRC32 doesn't match")
+	}
+
+	r := &numberReader{data: bps, pos: len(bpsMagic), end: len(bps) - checksumsSize}
+	sourceSize := r.number()
+	targetSize := r.number()
+	r.pos += r.number() // metadata
+	if r.err != nil {
+		return nil, r.err
+	}
+	if sourceSize != len(data) {
+		return nil, fmt.Errorf("the BPS patch is for a %d byte ROM, not %d", sourceSize, len(data))
+	}
+	out := make([]uint8, 0, targetSize)
+	sourceOffset, targetOffset := 0, 0
+	for r.pos < r.end {
+		action := r.number()
+		kind, length := action&3, action>>2+1
+		if r.err != nil {
+			return nil, r.err
+		}
+		if len(out)+length > targetSize {
+			return nil, fmt.Errorf("BPS action at offset %d writes past the target", r.pos)
+		}
+		switch kind {
+		case bpsSourceRead:
+			if len(out)+length > len(data) {
+				return nil, fmt.Errorf("BPS action at offset %d reads past the source", r.pos)
+			}
+			out = append(out, data[len(out):len(out)+length]...)
+		case bpsTargetRead:
+			if r.pos+length > r.end {
+				return nil, fmt.Errorf("patch truncated at offset %d", r.pos)
+			}
+			out = append(out, bps[r.pos:r.pos+length]...)
+			r.pos += length
+		case bpsSourceCopy, bpsTargetCopy:
+			delta := r.number()
+			if delta&1 != 0 {
+				delta = -(delta >> 1)
+			} else {
+				delta >>= 1
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+			if kind == bpsSourceCopy {
+				sourceOffset += delta
+				if sourceOffset < 0 || sourceOffset+length > len(data) {
+					return nil, fmt.Errorf("BPS action at offset %d reads past the source", r.pos)
+				}
+				out = append(out, data[sourceOffset:sourceOffset+length]...)
+				sourceOffset += length
+				continue
+			}
+			targetOffset += delta
+			if targetOffset < 0 || targetOffset >= len(out) {
+				return nil, fmt.Errorf("BPS action at offset %d reads past the target", r.pos)
+			}
+			// the copy may overlap what it writes, repeating a pattern
+			for i := 0; i < length; i++ {
+				out = append(out, out[targetOffset])
+				targetOffset++
+			}
+		}
+	}
+	if len(out) != targetSize {
+		return nil, fmt.Errorf("BPS patch builds %d bytes instead of %d", len(out), targetSize)
+	}
+	if crc32.ChecksumIEEE(out) != le.Uint32(footer[4:]) {
+		return nil, errors.New("the BPS patch result doesn't match its CRC32")
+	}
+	return out, nil
+}
+
+// CreateBPS returns a BPS patch turning original into modified. Bytes that
+// are unchanged in place are read from the source, everything else is
+// stored in the patch.
+func CreateBPS(original, modified []uint8) ([]uint8, error) {
+	bps := append([]uint8(nil), bpsMagic...)
+	bps = appendNumber(bps, len(original))
+	bps = appendNumber(bps, len(modified))
+	bps = appendNumber(bps, 0)
+	same := func(i int) bool {
+		return i < len(original) && original[i] == modified[i]
+	}
+	for start := 0; start < len(modified); {
+		end := start + 1
+		for end < len(modified) && same(end) == same(start) {
+			end++
+		}
+		kind := bpsTargetRead
+		if same(start) {
+			kind = bpsSourceRead
+		}
+		bps = appendNumber(bps, (end-start-1)<<2|kind)
+		if kind == bpsTargetRead {
+			bps = append(bps, modified[start:end]...)
+		}
+		start = end
+	}
+	bps = binary.LittleEndian.AppendUint32(bps, crc32.ChecksumIEEE(original))
+	bps = binary.LittleEndian.AppendUint32(bps, crc32.ChecksumIEEE(modified))
+	return binary.LittleEndian.AppendUint32(bps, crc32.ChecksumIEEE(bps)), nil
+}
@@ -0,0 +1,113 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// withChecksums ends a BPS or UPS patch body with the CRC32s of source,
+// target and the patch
+func withChecksums(body, source, target []uint8) []uint8 {
+	p := append([]uint8(nil), body...)
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(source))
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(target))
+	return binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(p))
+}
+
+// bps builds a BPS patch from its sizes and actions, each a number followed
+// by data or a further number
+func bps(sourceSize, targetSize int, actions ...[]uint8) []uint8 {
+	p := append([]uint8(nil), bpsMagic...)
+	p = appendNumber(p, sourceSize)
+	p = appendNumber(p, targetSize)
+	p = appendNumber(p, 0)
+	for _, a := range actions {
+		p = append(p, a...)
+	}
+	return p
+}
+
+func action(kind, length int, rest ...uint8) []uint8 {
+	return append(appendNumber(nil, (length-1)<<2|kind), rest...)
+}
+
+func TestApplyBPS(t *testing.T) {
+	source := []uint8("ABCDEFGH")
+	tests := []struct {
+		name    string
+		actions [][]uint8
+		want    string
+	}{
+		{"source read", [][]uint8{action(bpsSourceRead, 8)}, "ABCDEFGH"},
+		{"target read", [][]uint8{action(bpsSourceRead, 2), action(bpsTargetRead, 3, 'x', 'y', 'z')}, "ABxyz"},
+		// deltas are signed, the low bit is the sign
+		{"source copy", [][]uint8{action(bpsSourceCopy, 3, appendNumber(nil, 5<<1)...), action(bpsSourceCopy, 2, appendNumber(nil, 6<<1|1)...)}, "FGHCD"},
+		// a target copy overlapping what it writes repeats a pattern
+		{"target copy", [][]uint8{action(bpsTargetRead, 2, 'a', 'b'), action(bpsTargetCopy, 5, appendNumber(nil, 0)...)}, "abababa"},
+	}
+	for _, tt := range tests {
+		want := []uint8(tt.want)
+		p := withChecksums(bps(len(source), len(want), tt.actions...), source, want)
+		got, err := ApplyBPS(source, p)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestApplyBPSErrors(t *testing.T) {
+	source, target := []uint8("ABCDEFGH"), []uint8("ABxyz")
+	actions := [][]uint8{action(bpsSourceRead, 2), action(bpsTargetRead, 3, 'x', 'y', 'z')}
+	body := bps(len(source), len(target), actions...)
+	good := withChecksums(body, source, target)
+	if _, err := ApplyBPS(source, good); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]uint8(nil), good...)
+	corrupt[len(body)-1] ^= 0xFF
+
+	tests := map[string][]uint8{
+		"not BPS":          append([]uint8("BPS2"), good[4:]...),
+		"too short":        good[:10],
+		"bad patch CRC":    corrupt,
+		"truncated":        append(good[:len(body)-2:len(body)-2], good[len(body):]...),
+		"different source": withChecksums(body, []uint8("ABCDEFGX"), target),
+		"bad target CRC":   withChecksums(body, source, []uint8("ABxyZ")),
+		"source size":      withChecksums(bps(len(source)+1, len(target), actions...), source, target),
+		"target size":      withChecksums(bps(len(source), len(target)+1, actions...), source, target),
+		"truncated data": withChecksums(bps(len(source), len(target),
+			action(bpsSourceRead, 2), action(bpsTargetRead, 3, 'x', 'y')), source, target),
+		"past the target": withChecksums(bps(len(source), 2, action(bpsSourceRead, 3)), source, target[:2]),
+		"past the source": withChecksums(bps(len(source), 9, action(bpsSourceRead, 9)), source, []uint8("ABCDEFGHI")),
+		"source copy before the start": withChecksums(bps(len(source), 1,
+			action(bpsSourceCopy, 1, appendNumber(nil, 1<<1|1)...)), source, target[:1]),
+		"target copy of nothing": withChecksums(bps(len(source), 1,
+			action(bpsTargetCopy, 1, appendNumber(nil, 0)...)), source, target[:1]),
+	}
+	for name, p := range tests {
+		if got, err := ApplyBPS(source, p); err == nil {
+			t.Errorf("%s: applied, got %q", name, got)
+		}
+	}
+}
+
+func TestBPSRoundTrip(t *testing.T) {
+	for i, tt := range resizes {
+		original, modified := pair(int64(i), tt.length, tt.size)
+		p, err := Create("game.bps", original, modified)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := Apply(original, p)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !bytes.Equal(got, modified) {
+			t.Errorf("%s: patched data differs", tt.name)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package patch applies and creates ROM patches in the IPS, BPS and UPS
+// formats
+package patch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// IPS patches are a list of records, each replacing bytes at a 24 bit offset
+// or filling a run with one value https://zerosoft.zophar.net/ips.php
+//
+//	"PATCH"
+//	offset [3]uint8, size [2]uint8, data [size]uint8
+//	offset [3]uint8, 0 [2]uint8, run [2]uint8, value uint8
+//	"EOF"
+//	size [3]uint8, optional, the length to truncate the data to
+var (
+	ipsMagic = []uint8("PATCH")
+	ipsEOF   = []uint8("EOF")
+)
+
+// IPS offsets are 24 bits, and an offset reading "EOF" would end the patch
+const ipsMaxOffset = 0x454F46
+
+var ErrNotIPS = errors.New("not an IPS patch")
+
+// ApplyIPS returns a copy of data with an IPS patch applied. The data grows
+// when a record writes past its end, and shrinks when the patch ends with
+// a truncation size.
+func ApplyIPS(data, ips []uint8) ([]uint8, error) {
+	if !bytes.HasPrefix(ips, ipsMagic) {
+		return nil, ErrNotIPS
+	}
+	out := append([]uint8(nil), data...)
+	pos := len(ipsMagic)
+	need := func(n int) error {
+		if pos+n > len(ips) {
+			return fmt.Errorf("IPS patch truncated at offset %d", pos)
+		}
+		return nil
+	}
+	for {
+		if err := need(3); err != nil {
+			return nil, err
+		}
+		if bytes.Equal(ips[pos:pos+3], ipsEOF) {
+			pos += 3
+			if pos+3 <= len(ips) {
+				size := int(ips[pos])<<16 | int(ips[pos+1])<<8 | int(ips[pos+2])
+				if size < len(out) {
+					out = out[:size]
+				}
+			}
+			return out, nil
+		}
+		offset := int(ips[pos])<<16 | int(ips[pos+1])<<8 | int(ips[pos+2])
+		pos += 3
+		if err := need(2); err != nil {
+			return nil, err
+		}
+		size := int(ips[pos])<<8 | int(ips[pos+1])
+		pos += 2
+
+		var record []uint8
+		if size == 0 {
+			// run length encoded
+			if err := need(3); err != nil {
+				return nil, err
+			}
+			size = int(ips[pos])<<8 | int(ips[pos+1])
+			record = bytes.Repeat(ips[pos+2:pos+3], size)
+			pos += 3
+		} else {
+			if err := need(size); err != nil {
+				return nil, err
+			}
+			record = ips[pos : pos+size]
+			pos += size
+		}
+		if offset+size > len(out) {
+			out = append(out, make([]uint8, offset+size-len(out))...)
+		}
+		copy(out[offset:], record)
+	}
+}
+
+// CreateIPS returns an IPS patch turning original into modified, using the
+// truncation extension when modified is shorter
+func CreateIPS(original, modified []uint8) ([]uint8, error) {
+	if len(modified) > ipsMaxOffset {
+		return nil, fmt.Errorf("IPS can't address %d bytes", len(modified))
+	}
+	ips := append([]uint8(nil), ipsMagic...)
+	differs := func(i int) bool {
+		return i >= len(original) || original[i] != modified[i]
+	}
+	for start := 0; start < len(modified); {
+		if !differs(start) {
+			start++
+			continue
+		}
+		// a record ends at a run of equal bytes long enough to be worth a
+		// new record header
+		end := start
+		for same := 0; end < len(modified) && end-start < 0xFFFF && same <= 5; end++ {
+			if differs(end) {
+				same = 0
+			} else {
+				same++
+			}
+		}
+		for !differs(end - 1) {
+			end--
+		}
+		ips = append(ips, uint8(start>>16), uint8(start>>8), uint8(start))
+		ips = append(ips, uint8((end-start)>>8), uint8(end-start))
+		ips = append(ips, modified[start:end]...)
+		start = end
+	}
+	ips = append(ips, ipsEOF...)
+	if len(modified) < len(original) {
+		ips = append(ips, uint8(len(modified)>>16), uint8(len(modified)>>8), uint8(len(modified)))
+	}
+	return ips, nil
+}
@@ -0,0 +1,116 @@
+package patch
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// ips builds an IPS patch from records written out by hand
+func ips(records ...string) []uint8 {
+	return []uint8("PATCH" + strings.Join(records, "") + "EOF")
+}
+
+func TestApplyIPS(t *testing.T) {
+	data := []uint8{0, 1, 2, 3}
+	tests := []struct {
+		name  string
+		patch []uint8
+		want  []uint8
+	}{
+		{"empty", ips(), []uint8{0, 1, 2, 3}},
+		{"record", ips("\x00\x00\x01\x00\x02\xAA\xBB"), []uint8{0, 0xAA, 0xBB, 3}},
+		{"two records", ips("\x00\x00\x00\x00\x01\xAA", "\x00\x00\x03\x00\x01\xBB"), []uint8{0xAA, 1, 2, 0xBB}},
+		{"grows", ips("\x00\x00\x03\x00\x03\xAA\xBB\xCC"), []uint8{0, 1, 2, 0xAA, 0xBB, 0xCC}},
+		{"RLE", ips("\x00\x00\x01\x00\x00\x00\x02\xEE"), []uint8{0, 0xEE, 0xEE, 3}},
+		{"RLE grows", ips("\x00\x00\x02\x00\x00\x00\x04\xEE"), []uint8{0, 1, 0xEE, 0xEE, 0xEE, 0xEE}},
+		{"truncation", append(ips(), 0, 0, 2), []uint8{0, 1}},
+		{"truncation after a record", append(ips("\x00\x00\x00\x00\x01\xAA"), 0, 0, 1), []uint8{0xAA}},
+		{"truncation past the end", append(ips(), 0, 0, 9), []uint8{0, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		got, err := ApplyIPS(data, tt.patch)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % X, want % X", tt.name, got, tt.want)
+		}
+	}
+	if !bytes.Equal(data, []uint8{0, 1, 2, 3}) {
+		t.Errorf("patching changed the input to % X", data)
+	}
+}
+
+func TestApplyIPSErrors(t *testing.T) {
+	tests := map[string][]uint8{
+		"not IPS":          []uint8("PATCX\x00\x00\x00\x00\x01\xAAEOF"),
+		"no EOF":           []uint8("PATCH\x00\x00\x00\x00\x01\xAA"),
+		"truncated offset": []uint8("PATCH\x00\x00"),
+		"truncated size":   []uint8("PATCH\x00\x00\x00\x00"),
+		"truncated data":   []uint8("PATCH\x00\x00\x00\x00\x04\xAA\xBB"),
+		"truncated RLE":    []uint8("PATCH\x00\x00\x00\x00\x00\x00\x04"),
+	}
+	for name, patch := range tests {
+		if got, err := ApplyIPS([]uint8{0, 1, 2, 3}, patch); err == nil {
+			t.Errorf("%s: applied, got % X", name, got)
+		}
+	}
+}
+
+// pair returns random data and a copy with some bytes changed, resized to
+// size
+func pair(seed int64, length, size int) ([]uint8, []uint8) {
+	rnd := rand.New(rand.NewSource(seed))
+	original := make([]uint8, length)
+	rnd.Read(original)
+	modified := make([]uint8, size)
+	copy(modified, original)
+	for i := length; i < size; i++ {
+		modified[i] = uint8(rnd.Intn(256))
+	}
+	for i := 0; i < size/16; i++ {
+		modified[rnd.Intn(size)] ^= 0xFF
+	}
+	// a long run of changes, longer than an IPS record holds
+	if size > 0x18000 {
+		for i := 0x1000; i < 0x18000; i++ {
+			modified[i] = ^original[i]
+		}
+	}
+	return original, modified
+}
+
+var resizes = []struct {
+	name         string
+	length, size int
+}{
+	{"same size", 0x6000, 0x6000},
+	{"grown", 0x6000, 0x8000},
+	{"shrunk", 0x8000, 0x6000},
+	{"long run", 0x20000, 0x20000},
+	{"empty", 0, 0},
+}
+
+func TestIPSRoundTrip(t *testing.T) {
+	for i, tt := range resizes {
+		original, modified := pair(int64(i), tt.length, tt.size)
+		p, err := CreateIPS(original, modified)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := Apply(original, p)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !bytes.Equal(got, modified) {
+			t.Errorf("%s: patched data differs", tt.name)
+		}
+	}
+}
+
+func TestCreateIPSTooLarge(t *testing.T) {
+	if _, err := CreateIPS(nil, make([]uint8, ipsMaxOffset+1)); err == nil {
+		t.Error("created a patch past the 24 bit offsets")
+	}
+}
@@ -0,0 +1,41 @@
+package patch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions lists the file extensions of the patch formats, in the order
+// patches next to a ROM are looked for
+var Extensions = []string{".ips", ".bps", ".ups"}
+
+// Apply applies an IPS, BPS or UPS patch to data, telling the format by its
+// magic number
+func Apply(data, patch []uint8) ([]uint8, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return ApplyIPS(data, patch)
+	case bytes.HasPrefix(patch, bpsMagic):
+		return ApplyBPS(data, patch)
+	case bytes.HasPrefix(patch, upsMagic):
+		return ApplyUPS(data, patch)
+	}
+	return nil, errors.New("not an IPS, BPS or UPS patch")
+}
+
+// Create makes a patch turning original into modified, in the format the
+// file name's extension gives
+func Create(name string, original, modified []uint8) ([]uint8, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".ips":
+		return CreateIPS(original, modified)
+	case ".bps":
+		return CreateBPS(original, modified)
+	case ".ups":
+		return CreateUPS(original, modified)
+	}
+	return nil, fmt.Errorf("%s: patches are .ips, .bps or .ups", name)
+}
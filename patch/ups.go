@@ -0,0 +1,117 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// UPS patches XOR runs of bytes into the data, so the same patch also turns
+// the target back into the source
+//
+//	"UPS1" sourceSize targetSize
+//	skip xor [n]uint8 0, repeated
+//	sourceCRC [4]uint8, targetCRC [4]uint8, patchCRC [4]uint8
+//
+// skip counts the bytes left alone since the last run's terminating 0.
+// Numbers are variable length as in BPS.
+var upsMagic = []uint8("UPS1")
+
+var ErrNotUPS = errors.New("not a UPS patch")
+
+// ApplyUPS returns data with a UPS patch applied. Data matching the
+// patch's target is turned back into its source.
+func ApplyUPS(data, ups []uint8) ([]uint8, error) {
+	if !bytes.HasPrefix(ups, upsMagic) {
+		return nil, ErrNotUPS
+	}
+	if len(ups) < len(upsMagic)+checksumsSize {
+		return nil, errors.New("UPS patch is truncated")
+	}
+	if err := checkPatchCRC(ups); err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	footer := ups[len(ups)-checksumsSize:]
+	sourceCRC, targetCRC := le.Uint32(footer), le.Uint32(footer[4:])
+
+	r := &numberReader{data: ups, pos: len(upsMagic), end: len(ups) - checksumsSize}
+	sourceSize := r.number()
+	targetSize := r.number()
+	if r.err != nil {
+		return nil, r.err
+	}
+	crc := crc32.ChecksumIEEE(data)
+	switch {
+	case len(data) == sourceSize && crc == sourceCRC:
+	case len(data) == targetSize && crc == targetCRC:
+		sourceSize, targetSize = targetSize, sourceSize
+		sourceCRC, targetCRC = targetCRC, sourceCRC
+	default:
+		return nil, errors.New("the UPS patch is for a different ROM, the CRC32 doesn't match")
+	}
+
+	out := make([]uint8, max(sourceSize, targetSize))
+	copy(out, data)
+	for pos := 0; r.pos < r.end; {
+		pos += r.number()
+		for {
+			x := r.byte()
+			if r.err != nil {
+				return nil, r.err
+			}
+			if pos < len(out) {
+				out[pos] ^= x
+			} else if x != 0 {
+				return nil, fmt.Errorf("UPS patch writes past the end at offset %d", r.pos)
+			}
+			pos++
+			if x == 0 {
+				break
+			}
+		}
+	}
+	out = out[:targetSize]
+	if crc32.ChecksumIEEE(out) != targetCRC {
+		return nil, errors.New("the UPS patch result doesn't match its CRC32")
+	}
+	return out, nil
+}
+
+// CreateUPS returns a UPS patch turning original into modified
+func CreateUPS(original, modified []uint8) ([]uint8, error) {
+	ups := append([]uint8(nil), upsMagic...)
+	ups = appendNumber(ups, len(original))
+	ups = appendNumber(ups, len(modified))
+	// bytes past the end of either read as 0
+	xor := func(i int) uint8 {
+		var a, b uint8
+		if i < len(original) {
+			a = original[i]
+		}
+		if i < len(modified) {
+			b = modified[i]
+		}
+		return a ^ b
+	}
+	size := max(len(original), len(modified))
+	for pos, i := 0, 0; i < size; {
+		if xor(i) == 0 {
+			i++
+			continue
+		}
+		ups = appendNumber(ups, i-pos)
+		for ; i < size && xor(i) != 0; i++ {
+			ups = append(ups, xor(i))
+		}
+		ups = append(ups, 0)
+		// the terminating 0 stands for the unchanged byte after the run
+		i++
+		pos = i
+	}
+	ups = binary.LittleEndian.AppendUint32(ups, crc32.ChecksumIEEE(original))
+	ups = binary.LittleEndian.AppendUint32(ups, crc32.ChecksumIEEE(modified))
+	return binary.LittleEndian.AppendUint32(ups, crc32.ChecksumIEEE(ups)), nil
+}
@@ -0,0 +1,104 @@
+package patch
+
+import (
+	"bytes"
+	"testing"
+)
+
+// ups builds a UPS patch from its sizes and hunks of skip count, XOR bytes
+// and terminating 0
+func ups(sourceSize, targetSize int, hunks ...[]uint8) []uint8 {
+	p := append([]uint8(nil), upsMagic...)
+	p = appendNumber(p, sourceSize)
+	p = appendNumber(p, targetSize)
+	for _, h := range hunks {
+		p = append(p, h...)
+	}
+	return p
+}
+
+func hunk(skip int, xor ...uint8) []uint8 {
+	return append(appendNumber(nil, skip), xor...)
+}
+
+func TestApplyUPS(t *testing.T) {
+	source := []uint8("ABCDEFGH")
+	tests := []struct {
+		name   string
+		target string
+		hunks  [][]uint8
+	}{
+		{"unchanged", "ABCDEFGH", nil},
+		// the skip counts from after the previous hunk's terminating 0
+		{"two hunks", "aBCdEFGH", [][]uint8{hunk(0, 0x20, 0), hunk(1, 0x20, 0)}},
+		{"grown", "ABCDEFGHIJ", [][]uint8{hunk(8, 'I', 'J', 0)}},
+		{"shrunk", "ABCDEF", [][]uint8{hunk(6, 'G', 'H', 0)}},
+	}
+	for _, tt := range tests {
+		target := []uint8(tt.target)
+		p := withChecksums(ups(len(source), len(target), tt.hunks...), source, target)
+		got, err := ApplyUPS(source, p)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, target) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, target)
+		}
+		// the patch works backwards too
+		got, err = ApplyUPS(target, p)
+		if err != nil {
+			t.Errorf("%s backwards: %v", tt.name, err)
+		} else if !bytes.Equal(got, source) {
+			t.Errorf("%s backwards: got %q, want %q", tt.name, got, source)
+		}
+	}
+}
+
+func TestApplyUPSErrors(t *testing.T) {
+	source, target := []uint8("ABCDEFGH"), []uint8("aBCDEFGH")
+	hunks := [][]uint8{hunk(0, 0x20, 0)}
+	body := ups(len(source), len(target), hunks...)
+	good := withChecksums(body, source, target)
+	if _, err := ApplyUPS(source, good); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]uint8(nil), good...)
+	corrupt[len(body)-1] ^= 0xFF
+
+	tests := map[string][]uint8{
+		"not UPS":          append([]uint8("UPS2"), good[4:]...),
+		"too short":        good[:10],
+		"bad patch CRC":    corrupt,
+		"different source": withChecksums(body, []uint8("ABCDEFGX"), target),
+		"source size":      withChecksums(ups(len(source)+1, len(target), hunks...), source, target),
+		"bad target CRC":   withChecksums(body, source, []uint8("aBCDEFGX")),
+		"no terminating 0": withChecksums(ups(len(source), len(target), hunk(0, 0x20)), source, target),
+		"past the end":     withChecksums(ups(len(source), len(target), hunk(9, 0x20, 0)), source, target),
+	}
+	for name, p := range tests {
+		if got, err := ApplyUPS(source, p); err == nil {
+			t.Errorf("%s: applied, got %q", name, got)
+		}
+	}
+}
+
+func TestUPSRoundTrip(t *testing.T) {
+	for i, tt := range resizes {
+		original, modified := pair(int64(i), tt.length, tt.size)
+		p, err := Create("game.ups", original, modified)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := Apply(original, p)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !bytes.Equal(got, modified) {
+			t.Errorf("%s: patched data differs", tt.name)
+		}
+		if got, err := Apply(modified, p); err != nil || !bytes.Equal(got, original) {
+			t.Errorf("%s: patching back failed: %v", tt.name, err)
+		}
+	}
+}
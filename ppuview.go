@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+const ppuviewUsage = `usage: nesemu-go ppuview [flags] rom.nes
+
+Runs a ROM for a number of frames, then saves what is in PPU memory as PNG
+files in the output directory: patterns.png (both pattern tables),
+nametables.png (all four, with the visible screen outlined), oam.png (all 64
+sprites) and palette.png (palette RAM). The sprite list is printed.
+
+`
+
+func runPPUView(args []string) int {
+	fs := flag.NewFlagSet("ppuview", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), ppuviewUsage)
+		fs.PrintDefaults()
+	}
+	frames := fs.Int("frames", 60, "number of frames to run first")
+	loadFrom := fs.Int("load-slot", -1, "start from the save state in this slot")
+	palette := fs.Int("pattern-palette", 0, "palette to colour the pattern tables with, 0-3 background, 4-7 sprites")
+	palFile := fs.String("palette", "", paletteFlagHelp)
+	out := fs.String("out", ".", "directory for the images")
+	romOpts := addROMFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitError
+	}
+	if fs.NArg() != 1 || *palette < 0 || *palette > 7 {
+		fs.Usage()
+		return exitError
+	}
+	pal, err := loadPalette(*palFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	romPath := fs.Arg(0)
+	cart, err := romOpts.load(romPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	console := hardware.NewConsole(cart)
+	if *loadFrom >= 0 {
+		if err := loadSlot(console, romPath, *loadFrom); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+	for i := 0; i < *frames; i++ {
+		console.StepFrame()
+	}
+
+	images := []struct {
+		name string
+		img  image.Image
+	}{
+		{"patterns.png", console.PatternTables(pal, *palette)},
+		{"nametables.png", console.Nametables(pal)},
+		{"oam.png", console.OAMImage(pal)},
+		{"palette.png", console.PaletteImage(pal)},
+	}
+	for _, view := range images {
+		if err := writePNG(filepath.Join(*out, view.name), view.img); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+
+	fmt.Printf("sprites are 8x%d\n", console.SpriteHeight())
+	fmt.Println("  #   X   Y  tile  pal  flags")
+	for _, s := range console.Sprites() {
+		// entries parked below the screen are unused
+		if s.Y >= 0xEF {
+			continue
+		}
+		flags := ""
+		if s.Behind() {
+			flags += " behind"
+		}
+		if s.FlipH() {
+			flags += " flip-h"
+		}
+		if s.FlipV() {
+			flags += " flip-v"
+		}
+		fmt.Printf(" %2d %3d %3d   $%02X    %d %s\n", s.Index, s.X, s.Y, s.Tile, s.Palette(), flags)
+	}
+	return exitPass
+}
+
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+	"github.com/tejasdeepakmasne/nesemu-go/patch"
+	"github.com/tejasdeepakmasne/nesemu-go/romdb"
+)
+
+// romOptions are the flags of the commands that load a ROM: the patches to
+// apply, the ROM database, and how a raw PRG image, e.g. what a homebrew
+// linker writes, is wired as it has no header to say
+type romOptions struct {
+	patches   string
+	chr       string
+	mapper    int
+	mirroring string
+	battery   bool
+	database  bool
+}
+
+func addROMFlags(fs *flag.FlagSet) *romOptions {
+	o := &romOptions{}
+	fs.StringVar(&o.patches, "patch", "", "comma separated .ips, .bps or .ups patches to apply in order, \"none\" for none (default the ROM's own patch if there is one)")
+	fs.StringVar(&o.chr, "chr", "", "raw CHR-ROM image to go with a raw .prg, CHR-RAM when left out")
+	fs.IntVar(&o.mapper, "mapper", -1, "load the ROM as a raw PRG image on this iNES mapper (default 0 for .prg files)")
+	fs.StringVar(&o.mirroring, "mirroring", "horizontal", "nametable mirroring of a raw PRG image: horizontal, vertical, single0, single1 or four")
+	fs.BoolVar(&o.battery, "battery", false, "a raw PRG image has battery backed PRG-RAM")
+	fs.BoolVar(&o.database, "romdb", true, "correct the header from the ROM database")
+	return o
+}
+
+// raw reports whether the ROM is loaded as a raw PRG image, which .prg
+// files and any ROM given -chr or -mapper are
+func (o *romOptions) raw(path string) bool {
+	return o.chr != "" || o.mapper >= 0 || strings.EqualFold(filepath.Ext(path), ".prg")
+}
+
+var mirroringNames = map[string]hardware.Mirroring{
+	"horizontal": hardware.MirrorHorizontal, "h": hardware.MirrorHorizontal,
+	"vertical": hardware.MirrorVertical, "v": hardware.MirrorVertical,
+	"single0": hardware.MirrorSingleLower,
+	"single1": hardware.MirrorSingleUpper,
+	"four":    hardware.MirrorFourScreen, "4": hardware.MirrorFourScreen,
+}
+
+func (o *romOptions) parseRaw(prg []uint8) (*hardware.Cartridge, error) {
+	mirroring, ok := mirroringNames[strings.ToLower(o.mirroring)]
+	if !ok {
+		return nil, fmt.Errorf("unknown -mirroring %q", o.mirroring)
+	}
+	if o.mapper > 255 {
+		return nil, fmt.Errorf("bad -mapper %d", o.mapper)
+	}
+	mapperID := uint8(0)
+	if o.mapper >= 0 {
+		mapperID = uint8(o.mapper)
+	}
+	var chr []uint8
+	if o.chr != "" {
+		var err error
+		if chr, err = os.ReadFile(o.chr); err != nil {
+			return nil, err
+		}
+	}
+	return hardware.NewCartridge(prg, chr, mapperID, mirroring, o.battery)
+}
+
+// patchFiles returns the patches for the ROM at path: those given with
+// -patch, or else a game.ips, game.bps or game.ups next to game.nes. FDS
+// saves are game.save.ips and never taken for one.
+func (o *romOptions) patchFiles(path string) ([]string, error) {
+	switch o.patches {
+	case "none":
+		return nil, nil
+	case "":
+	default:
+		return strings.Split(o.patches, ","), nil
+	}
+	var found []string
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range patch.Extensions {
+		if isFile(base + ext) {
+			found = append(found, base+ext)
+		}
+	}
+	if len(found) > 1 {
+		return nil, fmt.Errorf("there are several patches for %s, choose with -patch: %s", path, strings.Join(found, ", "))
+	}
+	return found, nil
+}
+
+// applyPatches returns the ROM at path with its patches applied
+func (o *romOptions) applyPatches(path string, rom []uint8) ([]uint8, error) {
+	files, err := o.patchFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if rom, err = patch.Apply(rom, data); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return rom, nil
+}
+
+// read returns the contents of the ROM at path, patched
+func (o *romOptions) read(path string) ([]uint8, error) {
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return o.applyPatches(path, rom)
+}
+
+// parse builds a cartridge from the contents of the file at path, after
+// applying its patches: a raw PRG image, an iNES or UNIF image corrected by
+// the ROM database, an NSF rip or an FDS disk with its saved writes applied
+func (o *romOptions) parse(path string, rom []uint8) (*hardware.Cartridge, error) {
+	rom, err := o.applyPatches(path, rom)
+	if err != nil {
+		return nil, err
+	}
+	if o.raw(path) {
+		return o.parseRaw(rom)
+	}
+	if !hardware.IsFDS(rom) {
+		if !o.database {
+			return hardware.ParseROM(rom, nil)
+		}
+		db, err := loadROMDB()
+		if err != nil {
+			return nil, err
+		}
+		return hardware.ParseROM(rom, db.Fix)
+	}
+	bios, err := findFDSBIOS(path)
+	if err != nil {
+		return nil, err
+	}
+	if save, err := os.ReadFile(diskSavePath(path)); err == nil {
+		if rom, err = patch.ApplyIPS(rom, save); err != nil {
+			return nil, fmt.Errorf("%s: %w", diskSavePath(path), err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return hardware.ParseFDS(rom, bios)
+}
+
+// the ROM database is the built in one plus nes20db.xml, e.g. the full
+// database from nesdev, in $NESEMU_ROMDB or the user config directory
+const romDBName = "nes20db.xml"
+
+var (
+	romDB     *romdb.Database
+	romDBErr  error
+	romDBOnce sync.Once
+)
+
+func loadROMDB() (*romdb.Database, error) {
+	romDBOnce.Do(func() {
+		romDB = romdb.Builtin()
+		candidates := []string{os.Getenv("NESEMU_ROMDB")}
+		if dir, err := os.UserConfigDir(); err == nil {
+			candidates = append(candidates, filepath.Join(dir, "nesemu-go", romDBName))
+		}
+		for _, path := range candidates {
+			if path == "" || !isFile(path) {
+				continue
+			}
+			file, err := os.Open(path)
+			if err == nil {
+				err = romDB.Read(file)
+				file.Close()
+			}
+			if err != nil {
+				romDBErr = fmt.Errorf("%s: %w", path, err)
+			}
+			return
+		}
+	})
+	return romDB, romDBErr
+}
+
+func (o *romOptions) load(path string) (*hardware.Cartridge, error) {
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cart, err := o.parse(path, rom)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cart, nil
+}
@@ -0,0 +1,262 @@
+// Package romdb looks games up in a database in the NES 2.0 XML format
+// https://www.nesdev.org/wiki/NES_2.0_XML_Database by the CRC32 or SHA-1 of
+// their PRG and CHR, to fix headers that give the wrong board and to fill in
+// the submapper, region and input device iNES can't express.
+package romdb
+
+import (
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+//go:embed nes20db.xml
+var embedded []uint8
+
+// Game is what the database knows about one dump
+type Game struct {
+	Title     string
+	CRC32     uint32
+	SHA1      [sha1.Size]uint8
+	Mapper    uint8
+	Submapper uint8
+	Mirroring hardware.Mirroring
+	// false when the mirroring attribute is missing or the mapper controls it
+	FixedMirroring bool
+	Battery        bool
+	Region         hardware.Region
+	Input          uint8 // NES 2.0 default expansion device
+}
+
+type Database struct {
+	bySHA1  map[[sha1.Size]uint8]*Game
+	byCRC32 map[uint32]*Game
+}
+
+func New() *Database {
+	return &Database{bySHA1: map[[sha1.Size]uint8]*Game{}, byCRC32: map[uint32]*Game{}}
+}
+
+var (
+	builtin     *Database
+	builtinOnce sync.Once
+)
+
+// Builtin returns the database compiled into the program
+func Builtin() *Database {
+	builtinOnce.Do(func() {
+		builtin = New()
+		if err := builtin.Read(bytes.NewReader(embedded)); err != nil {
+			panic(fmt.Sprintf("romdb: built in database: %v", err))
+		}
+	})
+	return builtin
+}
+
+type xmlHash struct {
+	Size  int    `xml:"size,attr"`
+	CRC32 string `xml:"crc32,attr"`
+	SHA1  string `xml:"sha1,attr"`
+}
+
+type xmlGame struct {
+	Comment string   `xml:",comment"`
+	PRG     *xmlHash `xml:"prgrom"`
+	CHR     *xmlHash `xml:"chrrom"`
+	ROM     *xmlHash `xml:"rom"`
+	PCB     struct {
+		Mapper    int    `xml:"mapper,attr"`
+		Submapper int    `xml:"submapper,attr"`
+		Mirroring string `xml:"mirroring,attr"`
+		Battery   int    `xml:"battery,attr"`
+	} `xml:"pcb"`
+	Console struct {
+		Region int `xml:"region,attr"`
+	} `xml:"console"`
+	Expansion struct {
+		Type int `xml:"type,attr"`
+	} `xml:"expansion"`
+}
+
+// Read adds the games in an NES 2.0 XML database, replacing dumps already
+// known. The title is taken from the comment each game starts with. Games on
+// boards numbered above 255, which iNES can't name, are skipped.
+func (db *Database) Read(r io.Reader) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "game" {
+			continue
+		}
+		var g xmlGame
+		if err := decoder.DecodeElement(&g, &start); err != nil {
+			return err
+		}
+		if g.PCB.Mapper > 255 {
+			continue
+		}
+		game, err := g.game()
+		if err != nil {
+			line, _ := decoder.InputPos()
+			return fmt.Errorf("game ending on line %d: %w", line, err)
+		}
+		db.bySHA1[game.SHA1] = game
+		db.byCRC32[game.CRC32] = game
+	}
+}
+
+func (g *xmlGame) game() (*Game, error) {
+	// <rom> hashes PRG and CHR together, games with CHR-RAM may leave it out
+	rom := g.ROM
+	if rom == nil && g.CHR == nil {
+		rom = g.PRG
+	}
+	if rom == nil {
+		return nil, errors.New("no rom element")
+	}
+	if g.PCB.Mapper < 0 || g.PCB.Mapper > 255 {
+		return nil, fmt.Errorf("unsupported mapper %d", g.PCB.Mapper)
+	}
+	game := &Game{
+		Title:     strings.TrimSuffix(strings.TrimSpace(g.Comment), ".nes"),
+		Mapper:    uint8(g.PCB.Mapper),
+		Submapper: uint8(g.PCB.Submapper),
+		Battery:   g.PCB.Battery != 0,
+		Region:    hardware.Region(g.Console.Region & 0x03),
+		Input:     uint8(g.Expansion.Type),
+	}
+	crc, err := strconv.ParseUint(rom.CRC32, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad crc32 %q", rom.CRC32)
+	}
+	game.CRC32 = uint32(crc)
+	sum, err := hex.DecodeString(rom.SHA1)
+	if err != nil || len(sum) != sha1.Size {
+		return nil, fmt.Errorf("bad sha1 %q", rom.SHA1)
+	}
+	copy(game.SHA1[:], sum)
+	game.FixedMirroring = true
+	switch g.PCB.Mirroring {
+	case "H":
+		game.Mirroring = hardware.MirrorHorizontal
+	case "V":
+		game.Mirroring = hardware.MirrorVertical
+	case "4":
+		game.Mirroring = hardware.MirrorFourScreen
+	default:
+		game.FixedMirroring = false
+	}
+	return game, nil
+}
+
+// Sums returns the CRC32 and SHA-1 the database keys a cartridge by, those
+// of its PRG-ROM followed by its CHR-ROM
+func Sums(cart *hardware.Cartridge) (uint32, [sha1.Size]uint8) {
+	rom := cart.PRG
+	if !cart.HasCHRRAM() {
+		rom = append(rom[:len(rom):len(rom)], cart.CHR...)
+	}
+	return crc32.ChecksumIEEE(rom), sha1.Sum(rom)
+}
+
+// Fix is a fix function for hardware.ParseROM that applies what the
+// database knows about the dump
+func (db *Database) Fix(cart *hardware.Cartridge) {
+	if game := db.Lookup(cart); game != nil {
+		game.Apply(cart)
+	}
+}
+
+// Lookup finds a cartridge's dump, preferring a SHA-1 match. It returns nil
+// for unknown dumps.
+func (db *Database) Lookup(cart *hardware.Cartridge) *Game {
+	crc, sum := Sums(cart)
+	if game, ok := db.bySHA1[sum]; ok {
+		return game
+	}
+	return db.byCRC32[crc]
+}
+
+// Len returns the number of dumps in the database
+func (db *Database) Len() int {
+	return len(db.bySHA1)
+}
+
+// Apply overrides what the header said about the board with what the
+// database knows. It is meant for the fix function of hardware.ParseROM, the
+// board has to be attached afterwards.
+func (g *Game) Apply(cart *hardware.Cartridge) {
+	cart.MapperID = g.Mapper
+	if g.FixedMirroring {
+		cart.Mirroring = g.Mirroring
+	}
+	cart.Battery = g.Battery
+	cart.Submapper = g.Submapper
+	cart.Region = g.Region
+	cart.Input = g.Input
+	cart.Title = g.Title
+}
+
+// InputName describes an NES 2.0 default expansion device number
+func InputName(input uint8) string {
+	if int(input) < len(inputNames) && inputNames[input] != "" {
+		return inputNames[input]
+	}
+	return fmt.Sprintf("device %d", input)
+}
+
+var inputNames = []string{
+	0x00: "unspecified",
+	0x01: "standard controllers",
+	0x02: "Four Score",
+	0x03: "Famicom four players adapter",
+	0x04: "Vs. System",
+	0x05: "Vs. System, reversed",
+	0x07: "Vs. Zapper",
+	0x08: "Zapper",
+	0x09: "two Zappers",
+	0x0A: "Bandai Hyper Shot",
+	0x0B: "Power Pad side A",
+	0x0C: "Power Pad side B",
+	0x0D: "Family Trainer side A",
+	0x0E: "Family Trainer side B",
+	0x0F: "Arkanoid Vaus (NES)",
+	0x10: "Arkanoid Vaus (Famicom)",
+	0x11: "two Vaus and a data recorder",
+	0x12: "Konami Hyper Shot",
+	0x13: "Coconuts Pachinko",
+	0x14: "Exciting Boxing punching bag",
+	0x15: "Jissen Mahjong",
+	0x16: "Party Tap",
+	0x17: "Oeka Kids tablet",
+	0x18: "Barcode Battler",
+	0x19: "Miracle Piano",
+	0x1A: "Pokkun Moguraa",
+	0x1B: "Top Rider",
+	0x1C: "Double Fisted",
+	0x1D: "Famicom 3D System",
+	0x1E: "Doremikko keyboard",
+	0x1F: "R.O.B. Gyro Set",
+	0x20: "Famicom data recorder",
+	0x21: "ASCII Turbo File",
+	0x22: "IGS Storage Battle Box",
+	0x23: "Family BASIC keyboard and data recorder",
+}
@@ -0,0 +1,137 @@
+package romdb
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// image is a 16 KiB PRG, 8 KiB CHR iNES file with header flags 6 and 7
+func image(flags6, flags7 uint8) []uint8 {
+	data := make([]uint8, 16+0x4000+0x2000)
+	copy(data, "NES\x1A\x01\x01")
+	data[6], data[7] = flags6, flags7
+	for i := range data[16:] {
+		data[16+i] = uint8(i * 7)
+	}
+	return data
+}
+
+// entry is a database game for rom with the attributes of its pcb element
+func entry(title string, crc uint32, sum [sha1.Size]uint8, pcb string) string {
+	return fmt.Sprintf(`<game>
+		<!-- %s.nes -->
+		<rom size="24576" crc32="%08X" sha1="%X"/>
+		<pcb %s/>
+		<console type="0" region="1"/>
+		<expansion type="8"/>
+	</game>`, title, crc, sum, pcb)
+}
+
+func readDB(t *testing.T, games ...string) *Database {
+	t.Helper()
+	db := New()
+	if err := db.Read(strings.NewReader("<nes20db>" + strings.Join(games, "\n") + "</nes20db>")); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func romSums(data []uint8) (uint32, [sha1.Size]uint8) {
+	return crc32.ChecksumIEEE(data[16:]), sha1.Sum(data[16:])
+}
+
+func TestLookup(t *testing.T) {
+	data := image(0, 0)
+	crc, sum := romSums(data)
+	cart, err := hardware.ParseINES(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(Sums(cart)), fmt.Sprint(crc, sum); got != want {
+		t.Errorf("Sums = %s, want %s", got, want)
+	}
+
+	db := readDB(t, entry("by sha1", crc+1, sum, `mapper="0"`))
+	if game := db.Lookup(cart); game == nil || game.Title != "by sha1" {
+		t.Errorf("SHA-1 lookup found %+v", game)
+	}
+	db = readDB(t, entry("by crc", crc, [sha1.Size]uint8{}, `mapper="0"`))
+	if game := db.Lookup(cart); game == nil || game.Title != "by crc" {
+		t.Errorf("CRC32 lookup found %+v", game)
+	}
+	// a SHA-1 match wins over a CRC32 match
+	db = readDB(t,
+		entry("by crc", crc, [sha1.Size]uint8{}, `mapper="0"`),
+		entry("by sha1", crc+1, sum, `mapper="0"`))
+	if game := db.Lookup(cart); game == nil || game.Title != "by sha1" {
+		t.Errorf("lookup found %+v, want the SHA-1 match", game)
+	}
+	if game := readDB(t).Lookup(cart); game != nil {
+		t.Errorf("empty database found %+v", game)
+	}
+}
+
+func TestFix(t *testing.T) {
+	// the header says mapper 3, vertical mirroring and no battery
+	data := image(0x31, 0x00)
+	crc, sum := romSums(data)
+	if _, err := hardware.ParseROM(data, nil); err == nil {
+		t.Fatal("loaded mapper 3 without the database")
+	}
+
+	db := readDB(t, entry("Fixed", crc, sum, `mapper="0" submapper="2" mirroring="H" battery="1"`))
+	cart, err := hardware.ParseROM(data, db.Fix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.MapperID != 0 || cart.Submapper != 2 || cart.Mirroring != hardware.MirrorHorizontal || !cart.Battery {
+		t.Errorf("mapper %d.%d, %v mirroring, battery %v", cart.MapperID, cart.Submapper, cart.Mirroring, cart.Battery)
+	}
+	if cart.Region != hardware.RegionPAL || cart.Input != 8 || cart.Title != "Fixed" {
+		t.Errorf("region %v, input %d, title %q", cart.Region, cart.Input, cart.Title)
+	}
+
+	// mirroring the mapper controls leaves the header's
+	db = readDB(t, entry("Fixed", crc, sum, `mapper="0" battery="0"`))
+	cart, err = hardware.ParseROM(image(0x01, 0x00), db.Fix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.Mirroring != hardware.MirrorVertical {
+		t.Errorf("%v mirroring, want the header's vertical", cart.Mirroring)
+	}
+}
+
+func TestReadSkipsWideMappers(t *testing.T) {
+	data := image(0, 0)
+	crc, sum := romSums(data)
+	db := readDB(t,
+		entry("wide", 0x12345678, [sha1.Size]uint8{1}, `mapper="256"`),
+		entry("narrow", crc, sum, `mapper="0"`))
+	if db.Len() != 1 {
+		t.Errorf("%d games, want 1", db.Len())
+	}
+}
+
+func TestReadRejects(t *testing.T) {
+	for name, game := range map[string]string{
+		"no rom":    `<game><pcb mapper="0"/></game>`,
+		"bad crc":   `<game><rom crc32="XYZ" sha1="` + strings.Repeat("00", 20) + `"/></game>`,
+		"short sha": `<game><rom crc32="00000000" sha1="00"/></game>`,
+	} {
+		if err := New().Read(strings.NewReader("<nes20db>" + game + "</nes20db>")); err == nil {
+			t.Errorf("%s: read", name)
+		}
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	if Builtin().Len() == 0 {
+		t.Error("built in database is empty")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tejasdeepakmasne/nesemu-go/hardware"
+)
+
+// save states live next to the ROM in numbered slots, game.nes -> game.ss0 .. game.ss9
+const STATE_SLOTS = 10
+
+func slotPath(romPath string, slot int) (string, error) {
+	if slot < 0 || slot >= STATE_SLOTS {
+		return "", fmt.Errorf("save slot %d out of range 0-%d", slot, STATE_SLOTS-1)
+	}
+	base := strings.TrimSuffix(romPath, filepath.Ext(romPath))
+	return fmt.Sprintf("%s.ss%d", base, slot), nil
+}
+
+func saveSlot(console *hardware.Console, romPath string, slot int) error {
+	path, err := slotPath(romPath, slot)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := console.SaveState(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func loadSlot(console *hardware.Console, romPath string, slot int) error {
+	path, err := slotPath(romPath, slot)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := console.LoadState(file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+// Package video writes sequences of frames and the sound going with them to
+// disk, as an uncompressed AVI or as numbered PNG files beside a WAV file, at
+// the NES frame rate.
+package video
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Writer is implemented by AVIWriter and PNGSequence. WriteAudio takes the
+// mono samples played during the last frame written.
+type Writer interface {
+	WriteFrame(img image.Image) error
+	WriteAudio(samples []float32) error
+	Frames() int
+	Close() error
+}
+
+// Create writes an AVI when path ends in .avi and a PNG sequence into the
+// directory path otherwise. A sample rate of 0 leaves the sound out.
+func Create(path string, width, height, sampleRate int) (Writer, error) {
+	if strings.EqualFold(filepath.Ext(path), ".avi") {
+		return CreateAVI(path, width, height, sampleRate)
+	}
+	return CreatePNGSequence(path, sampleRate)
+}
+
+// The NTSC NES runs at 39375000/655171 frames a second, about 60.0988
+const (
+	FrameRateNum   = 39375000
+	FrameRateDenom = 655171
+)
+
+// AVI 1.0 stores sizes in 32 bits and many players stop at 2 GiB
+const maxAVISize = 1 << 31
+
+var ErrTooLarge = errors.New("AVI file would exceed 2 GiB")
+
+// AVIWriter writes 24 bit uncompressed video and, when given a sample rate,
+// a 16 bit mono PCM sound stream. Sizes in the headers are filled in by
+// Close.
+type AVIWriter struct {
+	file          io.WriteSeeker
+	width, height int
+	frameSize     int // bytes of a frame, rows padded to 4 bytes
+	frames        int
+	sampleRate    int
+	samples       int
+	moviStart     int64 // offset of the "movi" list type, chunk offsets count from here
+	offset        int64 // end of the data written so far
+	index         []uint8
+	frame         []uint8
+}
+
+// CreateAVI creates an AVI file for frames of the given size, with sound at
+// sampleRate unless it is 0
+func CreateAVI(path string, width, height, sampleRate int) (*AVIWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewAVIWriter(file, width, height, sampleRate)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func NewAVIWriter(file io.WriteSeeker, width, height, sampleRate int) (*AVIWriter, error) {
+	stride := (width*3 + 3) &^ 3
+	w := &AVIWriter{file: file, width: width, height: height, frameSize: stride * height, sampleRate: sampleRate}
+	if err := w.writeHeaders(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func le32(b []uint8, values ...uint32) []uint8 {
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func le16(b []uint8, values ...uint16) []uint8 {
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+// headers builds everything up to the start of the movi data. The sizes and
+// frame counts that are only known at the end come from w.
+func (w *AVIWriter) headers() []uint8 {
+	microseconds := uint32(uint64(1000000) * FrameRateDenom / FrameRateNum)
+	streams := uint32(1)
+	if w.sampleRate > 0 {
+		streams = 2
+	}
+
+	avih := le32(nil,
+		microseconds,
+		uint32(uint64(w.frameSize)*FrameRateNum/FrameRateDenom+uint64(w.sampleRate*2)), // max bytes a second
+		0,    // padding granularity
+		0x10, // AVIF_HASINDEX
+		uint32(w.frames),
+		0, // initial frames
+		streams,
+		uint32(w.frameSize),
+		uint32(w.width), uint32(w.height),
+		0, 0, 0, 0)
+
+	strh := []uint8("vidsDIB ")
+	strh = le32(strh, 0) // flags
+	strh = le16(strh, 0, 0)
+	strh = le32(strh,
+		0, // initial frames
+		FrameRateDenom, FrameRateNum,
+		0, // start
+		uint32(w.frames),
+		uint32(w.frameSize),
+		0xFFFFFFFF, // quality
+		0)          // sample size
+	strh = le16(strh, 0, 0, uint16(w.width), uint16(w.height))
+
+	strf := le32(nil, 40, uint32(w.width), uint32(w.height))
+	strf = le16(strf, 1, 24)
+	strf = le32(strf, 0, uint32(w.frameSize), 0, 0, 0, 0)
+
+	strl := append([]uint8("strl"), chunk("strh", strh)...)
+	strl = append(strl, chunk("strf", strf)...)
+	hdrl := append([]uint8("hdrl"), chunk("avih", avih)...)
+	hdrl = append(hdrl, chunk("LIST", strl)...)
+	if w.sampleRate > 0 {
+		hdrl = append(hdrl, chunk("LIST", w.audioHeaders())...)
+	}
+
+	// every chunk in the movi list has an index entry
+	moviSize := 4 + len(w.index)/16*8 + w.frames*w.frameSize + w.samples*2
+	riffSize := 4 + 8 + len(hdrl) + 8 + moviSize + 8 + len(w.index)
+	b := append([]uint8("RIFF"), le32(nil, uint32(riffSize))...)
+	b = append(b, "AVI "...)
+	b = append(b, chunk("LIST", hdrl)...)
+	b = append(b, "LIST"...)
+	b = le32(b, uint32(moviSize))
+	return append(b, "movi"...)
+}
+
+// audioHeaders is the stream list of the sound stream, 16 bit mono PCM
+func (w *AVIWriter) audioHeaders() []uint8 {
+	strh := []uint8("auds")
+	strh = le32(strh, 0, 0) // handler, flags
+	strh = le16(strh, 0, 0)
+	strh = le32(strh,
+		0,                         // initial frames
+		2, uint32(w.sampleRate*2), // scale and rate, a sample a tick
+		0,                         // start
+		uint32(w.samples),         // length
+		uint32(w.sampleRate*2/10), // suggested buffer size
+		0xFFFFFFFF,                // quality
+		2)                         // sample size
+	strh = le16(strh, 0, 0, 0, 0)
+
+	strf := le16(nil, 1, 1) // PCM, mono
+	strf = le32(strf, uint32(w.sampleRate), uint32(w.sampleRate*2))
+	strf = le16(strf, 2, 16)
+
+	strl := append([]uint8("strl"), chunk("strh", strh)...)
+	return append(strl, chunk("strf", strf)...)
+}
+
+func chunk(id string, data []uint8) []uint8 {
+	b := append([]uint8(id), le32(nil, uint32(len(data)))...)
+	return append(b, data...)
+}
+
+func (w *AVIWriter) writeHeaders() error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	headers := w.headers()
+	if _, err := w.file.Write(headers); err != nil {
+		return err
+	}
+	w.moviStart = int64(len(headers)) - 4
+	if w.offset == 0 {
+		w.offset = int64(len(headers))
+	}
+	return nil
+}
+
+// WriteFrame appends a frame, which must have the size given at creation
+func (w *AVIWriter) WriteFrame(img image.Image) error {
+	bounds := img.Bounds()
+	if bounds.Dx() != w.width || bounds.Dy() != w.height {
+		return fmt.Errorf("frame is %dx%d, the video is %dx%d", bounds.Dx(), bounds.Dy(), w.width, w.height)
+	}
+	if w.offset+int64(8+w.frameSize+len(w.index)+16+8) > maxAVISize {
+		return ErrTooLarge
+	}
+	w.index = append(w.index, "00db"...)
+	w.index = le32(w.index, 0x10, uint32(w.offset-w.moviStart), uint32(w.frameSize))
+	w.frame = le32(append(w.frame[:0], "00db"...), uint32(w.frameSize))
+	stride := w.frameSize / w.height
+	rgba, _ := img.(*image.RGBA)
+	// DIBs are stored bottom up in BGR order
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		row := len(w.frame)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if rgba != nil {
+				c := rgba.RGBAAt(x, y)
+				w.frame = append(w.frame, c.B, c.G, c.R)
+				continue
+			}
+			r, g, b, _ := img.At(x, y).RGBA()
+			w.frame = append(w.frame, uint8(b>>8), uint8(g>>8), uint8(r>>8))
+		}
+		for len(w.frame)-row < stride {
+			w.frame = append(w.frame, 0)
+		}
+	}
+	if _, err := w.file.Write(w.frame); err != nil {
+		return err
+	}
+	w.offset += int64(8 + w.frameSize)
+	w.frames++
+	return nil
+}
+
+// WriteAudio appends samples between -1 and 1 to the sound stream, louder
+// ones are clipped. It does nothing when the AVI has no sound.
+func (w *AVIWriter) WriteAudio(samples []float32) error {
+	if w.sampleRate == 0 || len(samples) == 0 {
+		return nil
+	}
+	size := len(samples) * 2
+	if w.offset+int64(8+size+len(w.index)+16+8) > maxAVISize {
+		return ErrTooLarge
+	}
+	w.index = append(w.index, "01wb"...)
+	w.index = le32(w.index, 0x10, uint32(w.offset-w.moviStart), uint32(size))
+	w.frame = le32(append(w.frame[:0], "01wb"...), uint32(size))
+	for _, s := range samples {
+		s = max(-1, min(1, s))
+		w.frame = le16(w.frame, uint16(int16(s*32767)))
+	}
+	if _, err := w.file.Write(w.frame); err != nil {
+		return err
+	}
+	w.offset += int64(8 + size)
+	w.samples += len(samples)
+	return nil
+}
+
+// Frames returns the number of frames written
+func (w *AVIWriter) Frames() int {
+	return w.frames
+}
+
+// Close writes the index and the final sizes and closes the file if it is
+// an io.Closer
+func (w *AVIWriter) Close() error {
+	err := w.finish()
+	if closer, ok := w.file.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (w *AVIWriter) finish() error {
+	if _, err := w.file.Write(chunk("idx1", w.index)); err != nil {
+		return err
+	}
+	return w.writeHeaders()
+}
@@ -0,0 +1,87 @@
+package video
+
+import (
+	"encoding/binary"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chunks lists the ids of the chunks in a RIFF list, checking that their
+// sizes add up to the list's
+func chunks(t *testing.T, data []uint8) []string {
+	t.Helper()
+	var ids []string
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("%d stray bytes", len(data))
+		}
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:]))
+		if 8+size > len(data) {
+			t.Fatalf("%s chunk of %d bytes overruns its list", id, size)
+		}
+		if id == "LIST" {
+			id += " " + string(data[8:12])
+			for _, sub := range chunks(t, data[12:8+size]) {
+				ids = append(ids, id+"/"+sub)
+			}
+		} else {
+			ids = append(ids, id)
+		}
+		data = data[8+size+size&1:]
+	}
+	return ids
+}
+
+func TestAVIAudio(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.avi")
+	w, err := CreateAVI(path, 16, 8, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for i := 0; i < 3; i++ {
+		if err := w.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteAudio(make([]float32, 735)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != "RIFF" || string(data[8:12]) != "AVI " {
+		t.Fatalf("header % X", data[:12])
+	}
+	if size := int(binary.LittleEndian.Uint32(data[4:])); size != len(data)-8 {
+		t.Fatalf("RIFF size %d, file has %d bytes after it", size, len(data)-8)
+	}
+	count := map[string]int{}
+	for _, id := range chunks(t, data[12:]) {
+		count[id]++
+	}
+	want := map[string]int{
+		"LIST hdrl/avih":           1,
+		"LIST hdrl/LIST strl/strh": 2,
+		"LIST hdrl/LIST strl/strf": 2,
+		"LIST movi/00db":           3,
+		"LIST movi/01wb":           3,
+		"idx1":                     1,
+	}
+	for id, n := range want {
+		if count[id] != n {
+			t.Errorf("%d %s chunks, want %d", count[id], id, n)
+		}
+	}
+	if samples := w.samples; samples != 3*735 {
+		t.Errorf("%d samples, want %d", samples, 3*735)
+	}
+}
@@ -0,0 +1,67 @@
+package video
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/tejasdeepakmasne/nesemu-go/audio"
+)
+
+// PNGSequence writes each frame to its own numbered PNG file in a
+// directory, frame000000.png onwards, and the sound to audio.wav beside
+// them. The frame rate is not stored, see FrameRateNum and FrameRateDenom.
+type PNGSequence struct {
+	dir    string
+	frames int
+	wav    *audio.WAVWriter // nil without sound
+}
+
+// CreatePNGSequence creates the directory dir for the frames, with sound at
+// sampleRate unless it is 0
+func CreatePNGSequence(dir string, sampleRate int) (*PNGSequence, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &PNGSequence{dir: dir}
+	if sampleRate > 0 {
+		var err error
+		if s.wav, err = audio.CreateWAV(filepath.Join(dir, "audio.wav"), sampleRate); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *PNGSequence) WriteFrame(img image.Image) error {
+	file, err := os.Create(filepath.Join(s.dir, fmt.Sprintf("frame%06d.png", s.frames)))
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	s.frames++
+	return file.Close()
+}
+
+func (s *PNGSequence) WriteAudio(samples []float32) error {
+	if s.wav == nil {
+		return nil
+	}
+	return s.wav.WriteSamples(samples)
+}
+
+func (s *PNGSequence) Frames() int {
+	return s.frames
+}
+
+func (s *PNGSequence) Close() error {
+	if s.wav == nil {
+		return nil
+	}
+	return s.wav.Close()
+}